@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/audit"
+	"github.com/MattDevy/CQRS-example/pkg/query"
+	"github.com/google/uuid"
+)
+
+const usage = `Print who changed a reservation, and when
+
+Usage:
+  audit [-url URL] RESERVATION_ID
+`
+
+func main() {
+	baseURL := flag.String("url", "http://localhost:8080", "URL the query API is served on")
+	flag.Usage = func() { fmt.Fprint(flag.CommandLine.Output(), usage) }
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	id, err := uuid.Parse(flag.Arg(0))
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(*baseURL + query.ReservationsPath + "/" + id.String() + query.HistorySuffix)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Fatalf("query API returned %s\n", resp.Status)
+	}
+	timeline := &audit.Timeline{}
+	if err := json.NewDecoder(resp.Body).Decode(timeline); err != nil {
+		log.Fatalln(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tTIME\tEVENT\tACTOR\tBEFORE\tAFTER\tMESSAGE")
+	for _, e := range timeline.Entries {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			e.Version,
+			e.Timestamp.Format(time.RFC3339),
+			e.EventType,
+			e.Actor,
+			formatPeriod(e.Before),
+			formatPeriod(e.After),
+			e.Message,
+		)
+	}
+	w.Flush()
+}
+
+func formatPeriod(p *audit.Period) string {
+	if p == nil {
+		return "-"
+	}
+	return p.StartTime.Format(time.RFC3339) + "/" + p.EndTime.Format(time.RFC3339)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+
+	"github.com/MattDevy/CQRS-example/pkg/calendar"
+	"github.com/MattDevy/CQRS-example/pkg/signing"
+)
+
+const usage = `Print the subscription URL of a calendar feed
+
+Usage:
+  calendar [-dev] -user USER
+  calendar [-dev] -room ROOM_ID
+`
+
+func main() {
+	user := flag.String("user", "", "print the feed of the user's reservations")
+	room := flag.Int("room", 0, "print the feed of the room's reservations")
+	baseURL := flag.String("url", "http://localhost:8080", "URL the example is served on")
+	dev := flag.Bool("dev", false, "use the insecure development secret if CALENDAR_SECRET is unset")
+	flag.Usage = func() { fmt.Fprint(flag.CommandLine.Output(), usage) }
+	flag.Parse()
+
+	// Shared secret the feed tokens are derived from
+	calendarSecret, err := signing.SecretFromEnv("CALENDAR_SECRET", *dev)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	tokens := calendar.NewTokens(calendarSecret)
+
+	switch {
+	case *user != "":
+		fmt.Printf("%s%susers/%s.ics?%s=%s\n", *baseURL, calendar.Path, url.PathEscape(*user), calendar.TokenParam, tokens.UserToken(*user))
+	case *room != 0:
+		fmt.Printf("%s%srooms/%d.ics?%s=%s\n", *baseURL, calendar.Path, *room, calendar.TokenParam, tokens.RoomToken(*room))
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	"github.com/MattDevy/CQRS-example/pkg/signing"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+)
+
+const usage = `Inspect and replay dead-lettered commands
+
+Usage:
+  deadletter inspect
+  deadletter replay [-dev] [-id MESSAGE_ID] [-type COMMAND_TYPE]
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Print(usage)
+		os.Exit(2)
+	}
+
+	options := []option.ClientOption{
+		option.WithEndpoint("localhost:8085"),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithInsecure()),
+	}
+	ctx := context.Background()
+	client, err := pubsub.NewClient(ctx, "test", options...)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	deadLetters, err := reservations.NewDeadLetterQueue(ctx, client)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	switch os.Args[1] {
+	case "inspect":
+		letters, err := deadLetters.Inspect(ctx, 5*time.Second)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		for _, d := range letters {
+			printDeadLetter(d)
+		}
+		fmt.Printf("%v dead-lettered commands\n", len(letters))
+	case "replay":
+		flags := flag.NewFlagSet("replay", flag.ExitOnError)
+		id := flags.String("id", "", "only replay the message with this ID")
+		commandType := flags.String("type", "", "only replay commands of this type")
+		dev := flags.Bool("dev", false, "use the insecure development secret if COMMAND_SIGNING_SECRET is unset")
+		flags.Parse(os.Args[2:])
+
+		// Shared secret used to sign replayed commands
+		signingSecret, err := signing.SecretFromEnv("COMMAND_SIGNING_SECRET", *dev)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		commandClient, err := reservations.NewClient("test", options...)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer commandClient.Close()
+		commandClient.SetSigner("deadletter", signing.NewHS256Signer("dev", signingSecret))
+
+		replayed, err := deadLetters.Replay(ctx, commandClient, 5*time.Second, func(d *reservations.DeadLetter) bool {
+			return (*id == "" || d.MessageID == *id) && (*commandType == "" || d.CommandType == *commandType)
+		})
+		for _, d := range replayed {
+			printDeadLetter(d)
+		}
+		fmt.Printf("%v commands replayed\n", len(replayed))
+		if err != nil {
+			log.Fatalln(err)
+		}
+	default:
+		fmt.Print(usage)
+		os.Exit(2)
+	}
+}
+
+// printDeadLetter prints a dead-lettered command and why it failed
+func printDeadLetter(d *reservations.DeadLetter) {
+	fmt.Printf("%v %v (attempts: %v, verified: %v)\n  reason: %v\n  data: %s\n", d.MessageID, d.CommandType, d.Attempts, d.Verified, d.Reason, d.Data)
+}
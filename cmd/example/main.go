@@ -2,11 +2,13 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
 	"os"
-	"sync"
+	"time"
 
 	"cloud.google.com/go/pubsub"
 	eh "github.com/looplab/eventhorizon"
@@ -20,13 +22,50 @@ import (
 	tracingRepo "github.com/looplab/eventhorizon/repo/tracing"
 	"github.com/looplab/eventhorizon/repo/version"
 
+	"github.com/MattDevy/CQRS-example/pkg/audit"
+	"github.com/MattDevy/CQRS-example/pkg/availability"
 	"github.com/MattDevy/CQRS-example/pkg/billing"
+	"github.com/MattDevy/CQRS-example/pkg/calendar"
+	"github.com/MattDevy/CQRS-example/pkg/dispatch"
+	"github.com/MattDevy/CQRS-example/pkg/gateway"
+	"github.com/MattDevy/CQRS-example/pkg/graph"
+	"github.com/MattDevy/CQRS-example/pkg/invoicing"
+	"github.com/MattDevy/CQRS-example/pkg/live"
+	"github.com/MattDevy/CQRS-example/pkg/payments"
+	"github.com/MattDevy/CQRS-example/pkg/pricing"
+	"github.com/MattDevy/CQRS-example/pkg/promotions"
+	"github.com/MattDevy/CQRS-example/pkg/query"
 	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	"github.com/MattDevy/CQRS-example/pkg/rpc"
+	"github.com/MattDevy/CQRS-example/pkg/signing"
+	"github.com/MattDevy/CQRS-example/pkg/temporal"
 	"github.com/MattDevy/CQRS-example/pkg/tracing"
+	"github.com/MattDevy/CQRS-example/pkg/watch"
 	ctracing "github.com/looplab/eventhorizon/middleware/commandhandler/tracing"
+	"google.golang.org/grpc"
+)
+
+const (
+	// CommandSigningKeyID is the key ID commands must be signed with
+	CommandSigningKeyID = "dev"
+	// CommandMaxAge is how long after signing a command is still accepted
+	CommandMaxAge = 10 * time.Minute
+	// CommandWorkers is how many aggregates have their commands handled in parallel
+	CommandWorkers = 8
+	// CommandQueueDepth is how many commands can wait for each worker
+	CommandQueueDepth = 64
+	// CommandSubmitTimeout is how long a command waits for room on a full worker before it is nacked for redelivery
+	CommandSubmitTimeout = 5 * time.Second
+	// InvoiceCloseInterval is how often the bills of ended months are checked for invoicing
+	InvoiceCloseInterval = time.Hour
+	// NoShowInterval is how often ended reservations nobody checked in to are marked as no-shows
+	NoShowInterval = 5 * time.Minute
 )
 
 func main() {
+	dev := flag.Bool("dev", false, "use the insecure development secrets in place of unset ones")
+	flag.Parse()
+
 	// Connect to localhost if not running inside docker
 	if os.Getenv("PUBSUB_EMULATOR_HOST") == "" {
 		os.Setenv("PUBSUB_EMULATOR_HOST", "localhost:8085")
@@ -38,6 +77,65 @@ func main() {
 		tracingURL = "localhost"
 	}
 
+	// Address the HTTP gateway is served on
+	httpAddr := os.Getenv("HTTP_ADDR")
+	if httpAddr == "" {
+		httpAddr = ":8080"
+	}
+
+	// Address the gRPC services are served on
+	grpcAddr := os.Getenv("GRPC_ADDR")
+	if grpcAddr == "" {
+		grpcAddr = ":9090"
+	}
+
+	// Shared secret used to verify command signatures
+	signingSecret, err := signing.SecretFromEnv("COMMAND_SIGNING_SECRET", *dev)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Shared secret the calendar feed tokens are derived from
+	calendarSecret, err := signing.SecretFromEnv("CALENDAR_SECRET", *dev)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Time zone reservations are billed to months in
+	billingLocation, err := time.LoadLocation(os.Getenv("BILLING_TIMEZONE"))
+	if err != nil {
+		log.Fatal("could not load billing time zone: ", err)
+	}
+
+	// Versioned rates reservations are priced with, the fees of cancelling them and whether they are billed by usage,
+	// a flat rate for the minutes booked and no fees if not set
+	pricingPolicy, feePolicy, usagePolicy := billing.DefaultPricingPolicy, billing.DefaultFeePolicy, billing.DefaultUsagePolicy
+	if path := os.Getenv("PRICING_CONFIG"); path != "" {
+		schedule, err := pricing.LoadFile(path)
+		if err != nil {
+			log.Fatal("could not load pricing config: ", err)
+		}
+		pricingPolicy, feePolicy, usagePolicy = schedule, schedule, schedule
+	}
+
+	// Payment provider issued invoices are charged through, an in-process fake if not set
+	var paymentGateway payments.PaymentGateway = payments.NewFake()
+	var paymentWebhooks http.Handler
+	if providerURL := os.Getenv("PAYMENT_PROVIDER_URL"); providerURL != "" {
+		webhookSecret, err := signing.SecretFromEnv("PAYMENT_WEBHOOK_SECRET", *dev)
+		if err != nil {
+			log.Fatal(err)
+		}
+		webhookGateway := payments.NewWebhookGateway(providerURL, http.DefaultClient, webhookSecret)
+		paymentGateway, paymentWebhooks = webhookGateway, webhookGateway.Handler()
+	}
+
+	// Payment method every user's invoices are charged to
+	paymentMethod := os.Getenv("PAYMENT_METHOD")
+	if paymentMethod == "" {
+		paymentMethod = "default"
+	}
+
 	// Set up tracing
 	tracing.InitOpenCensus(tracingURL, "receiver")
 	traceCloser, err := tracing.NewTracer("reservations", tracingURL)
@@ -67,6 +165,8 @@ func main() {
 	// Create the event store.
 	eventStore := NewMongoEventStore(eventBus, MongoURL, MongoDB)
 	eventStore = tracingEventStore.NewEventStore(eventStore)
+	// Record the versions of the aggregates commands are handled for, to reply with
+	eventStore = reservations.NewVersionStore(eventStore)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -82,8 +182,11 @@ func main() {
 	}
 
 	// Create mongo projection repos
-	reservationRepo := NewMongoRepo(MongoURL, MongoDB, "reservations")
+	reservationRepo := watch.NewRepo(NewMongoRepo(MongoURL, MongoDB, "reservations"))
 	billingRepo := NewMongoRepo(MongoURL, MongoDB, "billing")
+	availabilityRepo := NewMongoRepo(MongoURL, MongoDB, "availability")
+	timelineRepo := NewMongoRepo(MongoURL, MongoDB, "timelines")
+	invoiceRepo := NewMongoRepo(MongoURL, MongoDB, "invoices")
 
 	// Create the command bus to handle all commands
 	commandBus := bus.NewCommandHandler()
@@ -94,18 +197,71 @@ func main() {
 	)
 
 	// Set up models, commands etc....
-	billing.Setup(ctx, eventStore, eventBus, commandBus, billingRepo)
+	billing.Setup(ctx, eventStore, eventBus, commandBus, billingRepo, billingLocation, pricingPolicy, feePolicy, usagePolicy)
 	reservations.Setup(ctx, eventStore, eventBus, commandBus, reservationRepo)
+	availability.Setup(ctx, eventBus, availabilityRepo)
+	audit.Setup(ctx, eventBus, timelineRepo)
+	payments.Setup(ctx, eventStore, commandBus)
+	promotions.Setup(ctx, eventStore, eventBus, commandBus)
+	invoicing.Setup(ctx, eventStore, eventBus, commandBus, invoiceRepo,
+		paymentGateway, payments.StaticMethods{Default: paymentMethod})
+
+	// Commands are verified with the same keys whether they are sent over PubSub, HTTP or gRPC
+	keys := signing.NewKeySet(CommandMaxAge)
+	keys.AddHS256(CommandSigningKeyID, signingSecret)
+
+	// Serve the HTTP gateway and APIs, commands are handled by the same command handler as PubSub commands
+	mux := http.NewServeMux()
+	mux.Handle(gateway.CommandsPath, gateway.CommandHandler(commandHandler, keys))
+	query.NewHandler(reservationRepo, billingRepo, timelineRepo).Register(mux)
+	mux.Handle(availability.Path, availability.Handler(availabilityRepo))
+	mux.Handle(availability.Path+"/", availability.Handler(availabilityRepo))
+	mux.Handle(temporal.Path, temporal.Handler(eventStore, reservationRepo))
+	mux.Handle(invoicing.Path, invoicing.Handler(invoiceRepo))
+	if paymentWebhooks != nil {
+		mux.Handle(payments.WebhookPath, paymentWebhooks)
+	}
+	calendarTokens := calendar.NewTokens(calendarSecret)
+	mux.Handle(calendar.Path, calendar.Handler(reservationRepo, calendarTokens))
 
-	wg := sync.WaitGroup{}
+	// Stream live reservation updates once this host's projector has saved them, over SSE, WebSockets and GraphQL
+	// A user's or room's updates need the token of its calendar feed
+	hub := live.NewHub(ctx, reservationRepo, calendarTokens, live.DefaultBufferSize)
+	hub.Register(mux)
+
+	// Serve GraphQL
+	graphServer, err := graph.NewServer(commandHandler, reservationRepo, billingRepo, availabilityRepo, hub, keys)
+	if err != nil {
+		log.Fatal("could not create graphql server: ", err)
+	}
+	mux.Handle(graph.Path, graphServer)
+	go ServeHTTP(httpAddr, mux)
+
+	// Serve the gRPC services
+	go ServeGRPC(grpcAddr,
+		rpc.NewCommandServer(commandHandler, keys),
+		rpc.NewQueryServer(reservationRepo, billingRepo),
+	)
 
 	// Handle incoming commands
-	commandChan := NewCommandChannel(&wg, commandHandler)
-	GetCommandsFromPubSub(ctx, GCPProject, GCPAppID, PubSubCommandTopic, commandChan)
+	pubsubClient := NewPubSubClient(GCPProject)
+	responder := reservations.NewResponder(pubsubClient)
+	defer responder.Stop()
+	pool := dispatch.NewPool(CommandWorkers, CommandQueueDepth, CommandSubmitTimeout)
+	go LogPoolStats(ctx, pool, time.Minute)
+
+	// Invoice each month's bills once it has ended, and correct the invoices of bills changed since
+	closer, err := invoicing.NewCloser(commandHandler, eventStore, billingRepo, billingLocation)
+	if err != nil {
+		log.Fatal("could not create invoice closer: ", err)
+	}
+	go closer.Run(ctx, InvoiceCloseInterval)
+	go reservations.NewNoShows(commandHandler, reservationRepo).Run(ctx, NoShowInterval)
+	defer pool.Close()
+	GetCommandsFromPubSub(ctx, pubsubClient, PubSubCommandTopic, keys, NewCommandDispatcher(commandHandler, responder, pool))
 
 	// Wait for everything to complete
 	eventBus.Wait()
-	wg.Done()
 
 }
 
@@ -140,26 +296,82 @@ func NewMongoRepo(url, database, colleciton string) eh.ReadWriteRepo {
 	return tracingRepo.NewRepo(version.NewRepo(repo))
 }
 
-func NewCommandChannel(wg *sync.WaitGroup, commandHandler eh.CommandHandler) chan<- eh.Command {
-	commandChan := make(chan eh.Command, 300)
-	go func() {
-		wg.Add(1)
-		defer wg.Done()
-		for cmd := range commandChan {
-			if err := commandHandler.HandleCommand(context.Background(), cmd); err != nil {
-				fmt.Printf("Error: %v\n", err)
-			}
-		}
-	}()
-	return commandChan
+func ServeHTTP(addr string, handler http.Handler) {
+	log.Printf("Serving HTTP on %v\n", addr)
+	if err := http.ListenAndServe(addr, handler); err != nil {
+		log.Fatal("could not serve HTTP: ", err)
+	}
+}
+
+func ServeGRPC(addr string, commands *rpc.CommandServer, queries *rpc.QueryServer) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal("could not listen for gRPC: ", err)
+	}
+	s := grpc.NewServer()
+	rpc.Register(s, commands, queries)
+	log.Printf("Serving gRPC on %v\n", addr)
+	if err := s.Serve(lis); err != nil {
+		log.Fatal("could not serve gRPC: ", err)
+	}
 }
 
-func GetCommandsFromPubSub(ctx context.Context, project, appID, topicName string, commandChan chan<- eh.Command) {
+func NewPubSubClient(project string) *pubsub.Client {
 	client, err := pubsub.NewClient(context.Background(), project)
 	if err != nil {
 		log.Fatal(err)
 	}
+	return client
+}
 
+// NewCommandDispatcher returns a receive func queueing each command on its aggregate's pool partition
+// A command that can't be queued because the partition stayed full is nacked, PubSub redelivers it ahead of the
+// aggregate's later commands as they share its ordering key.
+// Commands are retried on the partition's worker, keeping the aggregate's later commands waiting behind them.
+// Once the pool is closed, commands still queued are nacked instead of handled.
+func NewCommandDispatcher(commandHandler eh.CommandHandler, responder *reservations.Responder, pool *dispatch.Pool) func(context.Context, *reservations.Delivery) {
+	return func(ctx context.Context, d *reservations.Delivery) {
+		err := pool.Submit(ctx, d.Command.AggregateID(), func(ctx context.Context) {
+			if ctx.Err() != nil {
+				d.Requeue()
+				return
+			}
+			redelivered, err := d.Handle(ctx, commandHandler)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+			}
+			if redelivered {
+				return
+			}
+			if err := responder.Reply(ctx, d, err); err != nil {
+				fmt.Printf("Could not reply to command: %v\n", err)
+			}
+		})
+		if err != nil {
+			fmt.Printf("Requeueing command: %v\n", err)
+			d.Requeue()
+		}
+	}
+}
+
+// LogPoolStats periodically logs the metrics of each command pool partition
+func LogPoolStats(ctx context.Context, pool *dispatch.Pool, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, s := range pool.Stats() {
+				log.Printf("POOL: partition %v queued %v processed %v rejected %v blocked %v busy %v\n",
+					s.Partition, s.Queued, s.Processed, s.Rejected, s.Blocked, s.Busy)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func GetCommandsFromPubSub(ctx context.Context, client *pubsub.Client, topicName string, keys *signing.KeySet, handle func(context.Context, *reservations.Delivery)) {
 	topic := client.Topic(topicName)
 	if exists, err := topic.Exists(context.Background()); err != nil {
 		log.Fatal(err)
@@ -171,34 +383,29 @@ func GetCommandsFromPubSub(ctx context.Context, project, appID, topicName string
 	}
 
 	sub := client.Subscription("test")
-	if exists, err := sub.Exists(context.Background()); !exists && err == nil {
+	if exists, err := sub.Exists(context.Background()); err != nil {
+		log.Fatalln(err)
+	} else if !exists {
 		sub, err = client.CreateSubscription(ctx, "test", pubsub.SubscriptionConfig{
-			Topic: topic,
+			Topic:                 topic,
+			EnableMessageOrdering: true,
 		})
+		if err != nil {
+			log.Fatalln(err)
+		}
 	}
+
+	deadLetters, err := reservations.NewDeadLetterQueue(ctx, client)
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	sub.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
-		commandType, ok := msg.Attributes["CommandType"]
-		if !ok {
-			fmt.Println("No command type set")
-			return
-		}
-		cmd, err := eh.CreateCommand(eh.CommandType(commandType))
-		if err != nil {
-			fmt.Println("unknown command type")
-			return
-		}
-
-		if err := json.Unmarshal(msg.Data, cmd); err != nil {
-			fmt.Println("Bad command")
-			return
-		}
-
-		commandChan <- cmd
-	})
+	receiver := reservations.NewReceiver(sub, keys)
+	receiver.SetDeadLetterQueue(deadLetters, reservations.DefaultMaxAttempts)
+	// Fails if the subscription was created without message ordering, commands would be handled out of order
+	if err := receiver.ReceiveFunc(ctx, handle); err != nil {
+		log.Fatalln(err)
+	}
 }
 
 // EventLogger is a simple event handler for logging all events.
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/availability"
+	"github.com/MattDevy/CQRS-example/pkg/importer"
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	"github.com/MattDevy/CQRS-example/pkg/signing"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+)
+
+const usage = `Import reservations from a CSV or iCalendar file
+
+Checks every row against the file and the existing reservations first, only
+rows without conflicts are submitted, and only with -submit. Submitted rows
+are reported as confirmed or declined once the conflict saga decides them.
+Re-running an import is safe, rows that were already imported are reported
+as existing.
+
+Usage:
+  import [-submit [-dev] [-wait DURATION]] [-report REPORT.csv] [-user USER] [-tz ZONE] FILE.csv|FILE.ics
+`
+
+func main() {
+	submit := flag.Bool("submit", false, "submit the rows without conflicts, the default is a dry run")
+	reportPath := flag.String("report", "", "write the per-row results as CSV to this file instead of stdout")
+	user := flag.String("user", "", "user to book iCalendar events as when they have no ORGANIZER")
+	tz := flag.String("tz", "UTC", "time zone of iCalendar times without one")
+	baseURL := flag.String("url", "http://localhost:8080", "URL the query API and live updates are served on")
+	wait := flag.Duration("wait", 30*time.Second, "how long to wait for the submitted rows to be confirmed or declined")
+	dev := flag.Bool("dev", false, "use the insecure development secret if COMMAND_SIGNING_SECRET is unset")
+	flag.Usage = func() { fmt.Fprint(flag.CommandLine.Output(), usage) }
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// Shared secret used to sign commands, only needed to submit
+	var signingSecret []byte
+	if *submit {
+		secret, err := signing.SecretFromEnv("COMMAND_SIGNING_SECRET", *dev)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		signingSecret = secret
+	}
+
+	rows, err := parse(flag.Arg(0), *user, *tz)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	ctx := context.Background()
+	lister := importer.NewQueryLister(*baseURL, &http.Client{Timeout: 10 * time.Second})
+	if err := importer.Check(ctx, rows, lister, availability.DefaultRooms); err != nil {
+		log.Fatalln(err)
+	}
+
+	if *submit {
+		options := []option.ClientOption{
+			option.WithEndpoint("localhost:8085"),
+			option.WithoutAuthentication(),
+			option.WithGRPCDialOption(grpc.WithInsecure()),
+		}
+		client, err := reservations.NewClient("test", options...)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer client.Close()
+		client.SetSigner("import", signing.NewHS256Signer("dev", signingSecret))
+		importer.Submit(ctx, client, rows)
+
+		// The decisions are streamed, so the client has no timeout of its own
+		waitCtx, cancel := context.WithTimeout(ctx, *wait)
+		importer.AwaitDecisions(waitCtx, importer.NewLiveDecider(*baseURL, &http.Client{}), rows)
+		cancel()
+	}
+
+	var report io.Writer = os.Stdout
+	if *reportPath != "" {
+		f, err := os.Create(*reportPath)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer f.Close()
+		report = f
+	}
+	if err := importer.WriteReport(report, rows); err != nil {
+		log.Fatalln(err)
+	}
+	printSummary(rows)
+}
+
+// parse reads the rows of the file by its extension
+func parse(path, user, tz string) ([]*importer.Row, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return importer.ParseCSV(f)
+	case ".ics":
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return nil, err
+		}
+		return importer.ParseICS(f, user, loc)
+	default:
+		return nil, fmt.Errorf("unsupported file %v, expected .csv or .ics", path)
+	}
+}
+
+// printSummary prints the number of rows with each result to stderr, so it is not mixed into the report
+func printSummary(rows []*importer.Row) {
+	counts := importer.Count(rows)
+	results := make([]string, 0, len(counts))
+	for result := range counts {
+		results = append(results, result)
+	}
+	sort.Strings(results)
+	fmt.Fprintf(os.Stderr, "%v rows:", len(rows))
+	for _, result := range results {
+		fmt.Fprintf(os.Stderr, " %v %v", counts[result], result)
+	}
+	fmt.Fprintln(os.Stderr)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/MattDevy/CQRS-example/pkg/billing"
+	eh "github.com/looplab/eventhorizon"
+	mongoRepo "github.com/looplab/eventhorizon/repo/mongodb"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const usage = `Migrate read model documents saved by older versions
+
+Usage:
+  migrate money [-mongo URL] [-db DATABASE]
+    converts the float totals of the billing collection to exact amounts
+  migrate histories [-mongo URL] [-db DATABASE]
+    merges the billing histories saved under random IDs into one per user,
+    converting their totals first
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Print(usage)
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	mongoURL := flags.String("mongo", "mongodb://localhost:27017", "MongoDB URL")
+	db := flags.String("db", "reservations", "database of the read models")
+	flags.Parse(os.Args[2:])
+
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(*mongoURL))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer client.Disconnect(ctx)
+
+	switch os.Args[1] {
+	case "money":
+		migrated, err := billing.MigrateMoneyCollection(ctx, client.Database(*db).Collection("billing"), billing.PricePerMinute)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		fmt.Printf("%v billing histories migrated\n", migrated)
+	case "histories":
+		if _, err := billing.MigrateMoneyCollection(ctx, client.Database(*db).Collection("billing"), billing.PricePerMinute); err != nil {
+			log.Fatalln(err)
+		}
+		repo, err := mongoRepo.NewRepoWithClient(client, *db, "billing")
+		if err != nil {
+			log.Fatalln(err)
+		}
+		repo.SetEntityFactory(func() eh.Entity { return &billing.BillingHistory{Bills: make(map[string]*billing.Bill)} })
+		merged, err := billing.MigrateHistoryIDs(ctx, repo)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		fmt.Printf("%v billing histories merged\n", merged)
+	default:
+		fmt.Print(usage)
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/pricing"
+	"github.com/MattDevy/CQRS-example/pkg/rebuild"
+	mongoRepo "github.com/looplab/eventhorizon/repo/mongodb"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const usage = `Rebuild read model collections by replaying every event
+
+Each projection is rebuilt into a shadow collection, diffed against the live
+collection, and then swapped in atomically. Stop ./cmd/example first, events
+saved during a rebuild are not replayed.
+
+Usage:
+  rebuild [-dry-run] [-rate EVENTS_PER_SECOND] [-projections reservations,billing]
+`
+
+func main() {
+	projections := flag.String("projections", "reservations,billing", "comma separated collections to rebuild, any of "+strings.Join(rebuild.Names(), ", "))
+	dryRun := flag.Bool("dry-run", false, "only diff the rebuilt collections against the live ones, without swapping")
+	rate := flag.Int("rate", 0, "limit the events replayed per second, 0 is unlimited")
+	mongoURL := flag.String("mongo", "mongodb://localhost:27017", "MongoDB URL")
+	db := flag.String("db", "reservations", "database of the event store and read models")
+	billingTimezone := flag.String("billing-tz", os.Getenv("BILLING_TIMEZONE"), "time zone reservations are billed to months in, defaults to BILLING_TIMEZONE")
+	pricingConfig := flag.String("pricing", os.Getenv("PRICING_CONFIG"), "versioned rates to price billing with, defaults to PRICING_CONFIG or a flat rate")
+	flag.Usage = func() { fmt.Fprint(flag.CommandLine.Output(), usage) }
+	flag.Parse()
+
+	loc, err := time.LoadLocation(*billingTimezone)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	rebuild.BillingLocation = loc
+	if *pricingConfig != "" {
+		schedule, err := pricing.LoadFile(*pricingConfig)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		rebuild.BillingPricing = schedule
+		rebuild.BillingFees = schedule
+		rebuild.BillingUsage = schedule
+	}
+
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(*mongoURL))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer client.Disconnect(ctx)
+
+	var targets []*rebuild.Target
+	for _, name := range strings.Split(*projections, ",") {
+		projection, ok := rebuild.Projections[name]
+		if !ok {
+			log.Fatalf("unknown projection %q, expected any of %v\n", name, strings.Join(rebuild.Names(), ", "))
+		}
+		shadow := shadowName(projection)
+		if err := rebuild.Drop(ctx, client, *db, shadow); err != nil {
+			log.Fatalln(err)
+		}
+		repo, err := mongoRepo.NewRepoWithClient(client, *db, shadow)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		repo.SetEntityFactory(projection.NewEntity)
+		targets = append(targets, rebuild.NewTarget(projection, repo))
+	}
+
+	_, err = rebuild.Run(ctx, rebuild.NewMongoSource(client, *db), targets, rebuild.Options{
+		Rate:     *rate,
+		Progress: func(p rebuild.Progress) { fmt.Println(p) },
+	})
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	for _, t := range targets {
+		live, err := mongoRepo.NewRepoWithClient(client, *db, t.Projection.Collection)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		live.SetEntityFactory(t.Projection.NewEntity)
+		d, err := rebuild.Compare(ctx, t.Projection, live, t.Repo)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		printDiff(t.Projection.Collection, d)
+
+		shadow := shadowName(t.Projection)
+		if *dryRun {
+			if err := rebuild.Drop(ctx, client, *db, shadow); err != nil {
+				log.Fatalln(err)
+			}
+			continue
+		}
+		if err := rebuild.Swap(ctx, client, *db, shadow, t.Projection.Collection); err != nil {
+			log.Fatalln(err)
+		}
+		fmt.Printf("%v: swapped in the rebuilt collection\n", t.Projection.Collection)
+	}
+}
+
+// shadowName returns the collection the projection is rebuilt into
+func shadowName(projection *rebuild.Projection) string {
+	return projection.Collection + "_rebuild"
+}
+
+func printDiff(collection string, d *rebuild.Diff) {
+	fmt.Printf("%v: %d added, %d removed, %d changed, %d unchanged\n", collection, len(d.Added), len(d.Removed), len(d.Changed), d.Unchanged)
+	for _, key := range d.Added {
+		fmt.Printf("  + %v\n", key)
+	}
+	for _, key := range d.Removed {
+		fmt.Printf("  - %v\n", key)
+	}
+	for _, key := range d.Changed {
+		fmt.Printf("  ~ %v\n", key)
+	}
+}
@@ -2,12 +2,15 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
 	"time"
 
 	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	"github.com/MattDevy/CQRS-example/pkg/signing"
 	"github.com/MattDevy/CQRS-example/pkg/tracing"
 	"github.com/google/uuid"
 	eh "github.com/looplab/eventhorizon"
@@ -16,12 +19,21 @@ import (
 )
 
 func main() {
+	dev := flag.Bool("dev", false, "use the insecure development secret if COMMAND_SIGNING_SECRET is unset")
+	flag.Parse()
+
 	// Connect to localhost if not running inside docker
 	tracingURL := os.Getenv("TRACING_URL")
 	if tracingURL == "" {
 		tracingURL = "localhost"
 	}
 
+	// Shared secret used to sign commands
+	signingSecret, err := signing.SecretFromEnv("COMMAND_SIGNING_SECRET", *dev)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	tracing.InitOpenCensus(tracingURL, "writer")
 
 	options := []option.ClientOption{
@@ -33,6 +45,8 @@ func main() {
 	if err != nil {
 		log.Fatalln(err)
 	}
+	defer client.Close()
+	client.SetSigner("writer", signing.NewHS256Signer("dev", signingSecret))
 
 	waitEnter()
 
@@ -48,9 +62,7 @@ func main() {
 		StartTime: time.Now().Add(30 * time.Minute),
 		EndTime:   time.Now().Add(1 * time.Hour),
 	}
-	if err := client.SendCommand(context.Background(), cmd); err != nil {
-		log.Fatalln(err)
-	}
+	sendCommand(client, cmd)
 
 	waitEnter()
 	startTime := time.Now().Add(1 * time.Hour)
@@ -63,9 +75,7 @@ func main() {
 		StartTime: startTime,
 		EndTime:   endTime,
 	}
-	if err := client.SendCommand(context.Background(), cmd); err != nil {
-		log.Fatalln(err)
-	}
+	sendCommand(client, cmd)
 
 	waitEnter()
 
@@ -78,9 +88,7 @@ func main() {
 		StartTime: startTime,
 		EndTime:   endTime,
 	}
-	if err := client.SendCommand(context.Background(), cmd); err != nil {
-		log.Fatalln(err)
-	}
+	sendCommand(client, cmd)
 
 	waitEnter()
 
@@ -89,10 +97,21 @@ func main() {
 		ID:   mattReservationID,
 		User: "Matt",
 	}
-	if err := client.SendCommand(context.Background(), cmd); err != nil {
+	sendCommand(client, cmd)
+
+}
+
+// sendCommand sends the command and prints whether the server applied it
+func sendCommand(client *reservations.Client, cmd eh.Command) {
+	result, err := client.SendCommandAndWait(context.Background(), cmd)
+	var cmdErr *reservations.CommandError
+	if errors.As(err, &cmdErr) {
+		fmt.Printf("Command rejected: %v (%v)\n", cmdErr.Message, cmdErr.Code)
+		return
+	} else if err != nil {
 		log.Fatalln(err)
 	}
-
+	fmt.Printf("Command applied, reservation is now at version %v\n", result.Version)
 }
 
 // waitEnter will wait until the user presses the enter key
@@ -0,0 +1,35 @@
+package audit
+
+import (
+	"context"
+
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/repo/memory"
+	"github.com/looplab/eventhorizon/repo/mongodb"
+)
+
+// Setup will initialize and register the audit timeline projector
+func Setup(
+	ctx context.Context,
+	eventBus eh.EventBus,
+	timelineRepo eh.ReadWriteRepo,
+) {
+	if memoryRepo := memory.IntoRepo(ctx, timelineRepo); memoryRepo != nil {
+		memoryRepo.SetEntityFactory(func() eh.Entity { return &Timeline{} })
+	}
+	if mongoRepo := mongodb.IntoRepo(ctx, timelineRepo); mongoRepo != nil {
+		mongoRepo.SetEntityFactory(func() eh.Entity { return &Timeline{} })
+	}
+
+	eventBus.AddHandler(ctx, eh.MatchEvents{
+		reservations.ReservationCreatedEvent,
+		reservations.ReservationConfirmedEvent,
+		reservations.ReservationDeclinedEvent,
+		reservations.ReservationTimeChangedEvent,
+		reservations.ReservationCancelledEvent,
+		reservations.ReservationCheckedInEvent,
+		reservations.ReservationCheckedOutEvent,
+		reservations.ReservationNoShowEvent,
+	}, NewTimelineProjector(timelineRepo))
+}
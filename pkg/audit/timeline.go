@@ -0,0 +1,135 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// Period is the time a reservation was booked for
+type Period struct {
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+// Entry is a single change to a reservation
+type Entry struct {
+	Version   int
+	EventType eh.EventType
+	// Actor is the user the event was applied for
+	Actor     string
+	Timestamp time.Time
+	// Before is the booked time before the event, nil for the creation
+	Before *Period `json:",omitempty"`
+	After  *Period
+	// Message is why the reservation was declined
+	Message string `json:",omitempty"`
+}
+
+// Timeline is the read model of every change to a reservation, ordered by version
+type Timeline struct {
+	// ID is the ID of the reservation
+	ID      uuid.UUID
+	Version int
+	Entries []*Entry
+}
+
+func (t *Timeline) EntityID() uuid.UUID {
+	return t.ID
+}
+
+func (t *Timeline) AggregateVersion() int {
+	return t.Version
+}
+
+// period returns the booked time after the last entry
+func (t *Timeline) period() *Period {
+	if len(t.Entries) == 0 {
+		return nil
+	}
+	return t.Entries[len(t.Entries)-1].After
+}
+
+// TimelineProjector appends an Entry to the reservation's Timeline for every event
+type TimelineProjector struct {
+	repo eh.ReadWriteRepo
+}
+
+// NewTimelineProjector returns an initialized TimelineProjector
+func NewTimelineProjector(repo eh.ReadWriteRepo) *TimelineProjector {
+	return &TimelineProjector{repo: repo}
+}
+
+// HandlerType returns the EventHandlerType of the Projector
+func (p *TimelineProjector) HandlerType() eh.EventHandlerType {
+	return eh.EventHandlerType("AuditTimeline")
+}
+
+// HandleEvent appends the event to the reservation's timeline
+// Events at or below the timeline's version are ignored, so redeliveries and replays are safe
+func (p *TimelineProjector) HandleEvent(ctx context.Context, event eh.Event) error {
+	t, err := p.load(ctx, event.AggregateID())
+	if err != nil {
+		return err
+	}
+	if event.Version() <= t.Version {
+		return nil
+	}
+
+	entry := &Entry{
+		Version:   event.Version(),
+		EventType: event.EventType(),
+		Timestamp: event.Timestamp(),
+		Before:    t.period(),
+		After:     t.period(),
+	}
+	switch data := event.Data().(type) {
+	case *reservations.ReservationCreatedData:
+		entry.Actor = data.User
+		entry.After = &Period{StartTime: data.StartTime, EndTime: data.EndTime}
+	case *reservations.ReservationConfirmedData:
+		entry.Actor = data.User
+	case *reservations.ReservationDeclinedData:
+		entry.Actor = data.User
+		entry.Message = data.Message
+	case *reservations.ReservationTimeChangeData:
+		entry.Actor = data.User
+		entry.After = &Period{StartTime: data.StartTime, EndTime: data.EndTime}
+	case *reservations.ReservationCancelledData:
+		entry.Actor = data.User
+	case *reservations.ReservationCheckedInData:
+		entry.Actor = data.User
+	case *reservations.ReservationCheckedOutData:
+		entry.Actor = data.User
+	case *reservations.ReservationNoShowData:
+		entry.Actor = data.User
+	default:
+		return fmt.Errorf("projector: invalid event data type: %v", event.Data())
+	}
+
+	t.Entries = append(t.Entries, entry)
+	t.Version = event.Version()
+	if err := p.repo.Save(ctx, t); err != nil {
+		return fmt.Errorf("projector: could not save: %w", err)
+	}
+	return nil
+}
+
+func (p *TimelineProjector) load(ctx context.Context, id uuid.UUID) (*Timeline, error) {
+	e, err := p.repo.Find(ctx, id)
+	if errors.Is(err, eh.ErrEntityNotFound) {
+		return &Timeline{ID: id}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	t, ok := e.(*Timeline)
+	if !ok {
+		return nil, errors.New("projector: incorrect entity type")
+	}
+	return t, nil
+}
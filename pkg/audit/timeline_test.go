@@ -0,0 +1,63 @@
+package audit
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	memoryRepo "github.com/looplab/eventhorizon/repo/memory"
+)
+
+func TestTimelineProjector(t *testing.T) {
+	repo := memoryRepo.NewRepo()
+	repo.SetEntityFactory(func() eh.Entity { return &Timeline{} })
+	p := NewTimelineProjector(repo)
+
+	id := uuid.New()
+	start := time.Date(2021, time.July, 1, 9, 0, 0, 0, time.UTC)
+	booked := &Period{StartTime: start, EndTime: start.Add(time.Hour)}
+	moved := &Period{StartTime: start.Add(2 * time.Hour), EndTime: start.Add(3 * time.Hour)}
+	events := []struct {
+		eventType eh.EventType
+		data      eh.EventData
+	}{
+		{reservations.ReservationCreatedEvent, &reservations.ReservationCreatedData{RoomID: 1, Name: "Meeting", User: "Matt", StartTime: booked.StartTime, EndTime: booked.EndTime}},
+		{reservations.ReservationDeclinedEvent, &reservations.ReservationDeclinedData{User: "saga", Message: "room is booked"}},
+		{reservations.ReservationTimeChangedEvent, &reservations.ReservationTimeChangeData{User: "Matt", StartTime: moved.StartTime, EndTime: moved.EndTime}},
+		{reservations.ReservationConfirmedEvent, &reservations.ReservationConfirmedData{User: "saga"}},
+		{reservations.ReservationCancelledEvent, &reservations.ReservationCancelledData{User: "Matt"}},
+	}
+	for i, e := range events {
+		event := eh.NewEvent(e.eventType, e.data, start.Add(time.Duration(i)*time.Minute), eh.ForAggregate(reservations.ReservationAggregateType, id, i+1))
+		if err := p.HandleEvent(context.Background(), event); err != nil {
+			t.Fatal(err)
+		}
+		// Redeliveries are ignored
+		if err := p.HandleEvent(context.Background(), event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e, err := repo.Find(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeline := e.(*Timeline)
+	want := []*Entry{
+		{Version: 1, EventType: reservations.ReservationCreatedEvent, Actor: "Matt", Timestamp: start, After: booked},
+		{Version: 2, EventType: reservations.ReservationDeclinedEvent, Actor: "saga", Timestamp: start.Add(time.Minute), Before: booked, After: booked, Message: "room is booked"},
+		{Version: 3, EventType: reservations.ReservationTimeChangedEvent, Actor: "Matt", Timestamp: start.Add(2 * time.Minute), Before: booked, After: moved},
+		{Version: 4, EventType: reservations.ReservationConfirmedEvent, Actor: "saga", Timestamp: start.Add(3 * time.Minute), Before: moved, After: moved},
+		{Version: 5, EventType: reservations.ReservationCancelledEvent, Actor: "Matt", Timestamp: start.Add(4 * time.Minute), Before: moved, After: moved},
+	}
+	if timeline.Version != 5 || !reflect.DeepEqual(timeline.Entries, want) {
+		t.Errorf("timeline = version %d", timeline.Version)
+		for _, entry := range timeline.Entries {
+			t.Errorf("%+v", entry)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package availability
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+const (
+	// Path returns the free intervals of rooms, Path + "/rooms" returns the rooms free for the whole range
+	Path = "/availability"
+	// MaxRange is the longest range that can be queried at once
+	MaxRange = 31 * 24 * time.Hour
+)
+
+// Handler serves the availability queries
+// Both take from and to (RFC 3339) and optionally rooms (comma separated, defaults to DefaultRooms),
+// Path also takes min, the shortest free interval to return (eg 30m)
+func Handler(repo eh.ReadRepo) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "unsupported method: "+r.Method, http.StatusMethodNotAllowed)
+			return
+		}
+		rooms, from, to, err := parseRange(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var resp interface{}
+		switch r.URL.Path {
+		case Path:
+			var minDuration time.Duration
+			if min := r.URL.Query().Get("min"); min != "" {
+				if minDuration, err = time.ParseDuration(min); err != nil {
+					http.Error(w, fmt.Sprintf("invalid min %q", min), http.StatusBadRequest)
+					return
+				}
+			}
+			resp, err = FreeIntervals(r.Context(), repo, rooms, from, to, minDuration)
+		case Path + "/rooms":
+			resp, err = FreeRooms(r.Context(), repo, rooms, from, to)
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			fmt.Printf("Could not write response: %v\n", err)
+		}
+	})
+}
+
+func parseRange(values url.Values) ([]int, time.Time, time.Time, error) {
+	from, err := time.Parse(time.RFC3339, values.Get("from"))
+	if err != nil {
+		return nil, from, from, fmt.Errorf("invalid from %q, expected RFC 3339", values.Get("from"))
+	}
+	to, err := time.Parse(time.RFC3339, values.Get("to"))
+	if err != nil {
+		return nil, from, to, fmt.Errorf("invalid to %q, expected RFC 3339", values.Get("to"))
+	}
+	if !to.After(from) || to.Sub(from) > MaxRange {
+		return nil, from, to, fmt.Errorf("to must be after from, and at most %v later", MaxRange)
+	}
+
+	rooms := DefaultRooms
+	if rs := values.Get("rooms"); rs != "" {
+		rooms = nil
+		for _, room := range strings.Split(rs, ",") {
+			roomID, err := strconv.Atoi(room)
+			if err != nil {
+				return nil, from, to, fmt.Errorf("invalid room %q", room)
+			}
+			rooms = append(rooms, roomID)
+		}
+	}
+	return rooms, from, to, nil
+}
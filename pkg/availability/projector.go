@@ -0,0 +1,312 @@
+package availability
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// DayFormat is the format of RoomDay.Day, days are in UTC
+const DayFormat = "2006-01-02"
+
+// Namespace is the UUID namespace RoomDay IDs are generated in
+var Namespace = uuid.MustParse("6c1e3bd4-2f7a-4b8e-9d53-0a8f4c2e7b19")
+
+// DefaultRooms are the rooms the conflict saga accepts bookings for
+var DefaultRooms = []int{1, 2, 3, 4, 5, 6}
+
+// Booking is the part of a reservation that falls on a RoomDay
+type Booking struct {
+	ReservationID uuid.UUID
+	// Version is the version of the last event applied to the booking
+	Version   int
+	StartTime time.Time
+	EndTime   time.Time
+	Status    reservations.ReservationStatus
+}
+
+// Busy returns true if the booking blocks the room
+func (b *Booking) Busy() bool {
+	return b.Status == reservations.StatusPending || b.Status == reservations.StatusConfirmed
+}
+
+// RoomDay is the read model of a room's bookings on one day
+// Declined and cancelled bookings are kept so a later time change can find them, but are not busy
+type RoomDay struct {
+	ID        uuid.UUID
+	Version   int
+	RoomID    int
+	Day       string
+	Bookings  []*Booking
+	Confirmed int
+	Pending   int
+}
+
+func (d *RoomDay) EntityID() uuid.UUID {
+	return d.ID
+}
+
+func (d *RoomDay) AggregateVersion() int {
+	return d.Version
+}
+
+// booking returns the reservation's booking on the day, or nil
+func (d *RoomDay) booking(id uuid.UUID) *Booking {
+	for _, b := range d.Bookings {
+		if b.ReservationID == id {
+			return b
+		}
+	}
+	return nil
+}
+
+// count recounts the confirmed and pending bookings and keeps the bookings ordered
+func (d *RoomDay) count() {
+	d.Confirmed, d.Pending = 0, 0
+	for _, b := range d.Bookings {
+		switch b.Status {
+		case reservations.StatusConfirmed:
+			d.Confirmed++
+		case reservations.StatusPending:
+			d.Pending++
+		}
+	}
+	sort.Slice(d.Bookings, func(i, j int) bool { return d.Bookings[i].StartTime.Before(d.Bookings[j].StartTime) })
+}
+
+// DayID returns the ID of the RoomDay for the room on the day t is in
+func DayID(roomID int, t time.Time) uuid.UUID {
+	return uuid.NewSHA1(Namespace, []byte(fmt.Sprintf("%d/%s", roomID, t.UTC().Format(DayFormat))))
+}
+
+// days returns the start of each UTC day between start and end
+func days(start, end time.Time) []time.Time {
+	year, month, day := start.UTC().Date()
+	var ds []time.Time
+	for d := time.Date(year, month, day, 0, 0, 0, 0, time.UTC); d.Before(end); d = d.AddDate(0, 0, 1) {
+		ds = append(ds, d)
+	}
+	return ds
+}
+
+// location is where a reservation is booked
+type location struct {
+	roomID    int
+	startTime time.Time
+	endTime   time.Time
+	status    reservations.ReservationStatus
+	version   int
+}
+
+// RoomDayProjector projects the reservation events onto the days of each room they fall on
+type RoomDayProjector struct {
+	repo eh.ReadWriteRepo
+	// locations caches where reservations are booked, on a miss the repo is scanned
+	locations map[uuid.UUID]*location
+	mu        sync.Mutex
+}
+
+// NewRoomDayProjector returns an initialized RoomDayProjector
+func NewRoomDayProjector(repo eh.ReadWriteRepo) *RoomDayProjector {
+	return &RoomDayProjector{
+		repo:      repo,
+		locations: make(map[uuid.UUID]*location),
+	}
+}
+
+// HandlerType returns the EventHandlerType of the Projector
+func (p *RoomDayProjector) HandlerType() eh.EventHandlerType {
+	return eh.EventHandlerType("RoomAvailability")
+}
+
+// HandleEvent moves the reservation's bookings between days and updates their status
+// Events at or below the version already applied are ignored, so redeliveries and replays are safe
+func (p *RoomDayProjector) HandleEvent(ctx context.Context, event eh.Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	id := event.AggregateID()
+	var loc *location
+	if event.EventType() == reservations.ReservationCreatedEvent {
+		data, ok := event.Data().(*reservations.ReservationCreatedData)
+		if !ok {
+			return fmt.Errorf("projector: invalid event data type: %v", event.Data())
+		}
+		loc = &location{
+			roomID:    data.RoomID,
+			startTime: data.StartTime,
+			endTime:   data.EndTime,
+			status:    reservations.StatusPending,
+		}
+		if existing, err := p.find(ctx, id); err != nil {
+			return err
+		} else if existing != nil {
+			loc = existing
+		}
+	} else {
+		var err error
+		if loc, err = p.find(ctx, id); err != nil {
+			return err
+		} else if loc == nil {
+			return fmt.Errorf("projector: reservation %v not found", id)
+		}
+	}
+	if event.Version() <= loc.version {
+		return nil
+	}
+
+	next := *loc
+	next.version = event.Version()
+	switch event.EventType() {
+	case reservations.ReservationCreatedEvent:
+	case reservations.ReservationConfirmedEvent:
+		next.status = reservations.StatusConfirmed
+	case reservations.ReservationDeclinedEvent:
+		next.status = reservations.StatusDeclined
+	case reservations.ReservationCancelledEvent:
+		next.status = reservations.StatusCancelled
+	case reservations.ReservationTimeChangedEvent:
+		data, ok := event.Data().(*reservations.ReservationTimeChangeData)
+		if !ok {
+			return fmt.Errorf("projector: invalid event data type: %v", event.Data())
+		}
+		next.startTime = data.StartTime
+		next.endTime = data.EndTime
+		next.status = reservations.StatusPending
+	default:
+		return fmt.Errorf("could not handle event: %s", event)
+	}
+
+	// Bookings are saved on every day they are on, so ones longer than a reservation can be are not projected
+	if next.endTime.Sub(next.startTime) > reservations.MaxDuration {
+		return fmt.Errorf("projector: reservation %v: %w", id, reservations.ErrReservationTooLong)
+	}
+	if err := p.move(ctx, id, loc, &next); err != nil {
+		return err
+	}
+	p.locations[id] = &next
+	return nil
+}
+
+// move removes the booking from the days it is no longer on, and saves it on every day it is on
+func (p *RoomDayProjector) move(ctx context.Context, id uuid.UUID, from, to *location) error {
+	onDay := make(map[uuid.UUID]bool)
+	for _, day := range days(to.startTime, to.endTime) {
+		onDay[DayID(to.roomID, day)] = true
+		d, err := p.load(ctx, to.roomID, day)
+		if err != nil {
+			return err
+		}
+		b := d.booking(id)
+		if b == nil {
+			b = &Booking{ReservationID: id}
+			d.Bookings = append(d.Bookings, b)
+		}
+		// Clip the booking to the day
+		b.Version, b.Status = to.version, to.status
+		b.StartTime, b.EndTime = to.startTime, to.endTime
+		if b.StartTime.Before(day) {
+			b.StartTime = day
+		}
+		if next := day.AddDate(0, 0, 1); b.EndTime.After(next) {
+			b.EndTime = next
+		}
+		if err := p.save(ctx, d); err != nil {
+			return err
+		}
+	}
+
+	if from.version == 0 {
+		return nil
+	}
+	for _, day := range days(from.startTime, from.endTime) {
+		if onDay[DayID(from.roomID, day)] {
+			continue
+		}
+		d, err := p.load(ctx, from.roomID, day)
+		if err != nil {
+			return err
+		}
+		for i, b := range d.Bookings {
+			if b.ReservationID == id {
+				d.Bookings = append(d.Bookings[:i], d.Bookings[i+1:]...)
+				break
+			}
+		}
+		if err := p.save(ctx, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *RoomDayProjector) load(ctx context.Context, roomID int, day time.Time) (*RoomDay, error) {
+	e, err := p.repo.Find(ctx, DayID(roomID, day))
+	if errors.Is(err, eh.ErrEntityNotFound) {
+		return &RoomDay{
+			ID:     DayID(roomID, day),
+			RoomID: roomID,
+			Day:    day.Format(DayFormat),
+		}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	d, ok := e.(*RoomDay)
+	if !ok {
+		return nil, errors.New("projector: incorrect entity type")
+	}
+	return d, nil
+}
+
+func (p *RoomDayProjector) save(ctx context.Context, d *RoomDay) error {
+	d.count()
+	d.Version++
+	if err := p.repo.Save(ctx, d); err != nil {
+		return fmt.Errorf("projector: could not save: %w", err)
+	}
+	return nil
+}
+
+// find returns where the reservation is booked, or nil if it has never been projected
+// The whole booking is rebuilt from the days it falls on, as each day only has its part
+func (p *RoomDayProjector) find(ctx context.Context, id uuid.UUID) (*location, error) {
+	if loc, ok := p.locations[id]; ok {
+		return loc, nil
+	}
+	entities, err := p.repo.FindAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var loc *location
+	for _, e := range entities {
+		d, ok := e.(*RoomDay)
+		if !ok {
+			continue
+		}
+		b := d.booking(id)
+		if b == nil {
+			continue
+		}
+		if loc == nil {
+			loc = &location{roomID: d.RoomID, startTime: b.StartTime, endTime: b.EndTime, status: b.Status, version: b.Version}
+			continue
+		}
+		if b.StartTime.Before(loc.startTime) {
+			loc.startTime = b.StartTime
+		}
+		if b.EndTime.After(loc.endTime) {
+			loc.endTime = b.EndTime
+		}
+	}
+	if loc != nil {
+		p.locations[id] = loc
+	}
+	return loc, nil
+}
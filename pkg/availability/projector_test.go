@@ -0,0 +1,228 @@
+package availability
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	memoryRepo "github.com/looplab/eventhorizon/repo/memory"
+)
+
+var day = time.Date(2021, time.July, 1, 0, 0, 0, 0, time.UTC)
+
+func at(hour int) time.Time {
+	return day.Add(time.Duration(hour) * time.Hour)
+}
+
+func newTestRepo() *memoryRepo.Repo {
+	repo := memoryRepo.NewRepo()
+	repo.SetEntityFactory(func() eh.Entity { return &RoomDay{} })
+	return repo
+}
+
+func event(t *testing.T, p *RoomDayProjector, id uuid.UUID, version int, eventType eh.EventType, data eh.EventData) {
+	e := eh.NewEvent(eventType, data, time.Now(), eh.ForAggregate(reservations.ReservationAggregateType, id, version))
+	if err := p.HandleEvent(context.Background(), e); err != nil {
+		t.Fatalf("HandleEvent(%v) error = %v", eventType, err)
+	}
+}
+
+func create(t *testing.T, p *RoomDayProjector, roomID int, start, end time.Time) uuid.UUID {
+	id := uuid.New()
+	event(t, p, id, 1, reservations.ReservationCreatedEvent, &reservations.ReservationCreatedData{
+		RoomID:    roomID,
+		Name:      "Meeting",
+		User:      "Matt",
+		StartTime: start,
+		EndTime:   end,
+	})
+	return id
+}
+
+func roomDay(t *testing.T, repo eh.ReadRepo, roomID int, d time.Time) *RoomDay {
+	e, err := repo.Find(context.Background(), DayID(roomID, d))
+	if err != nil {
+		t.Fatalf("Find(room %d, %v) error = %v", roomID, d, err)
+	}
+	return e.(*RoomDay)
+}
+
+func TestRoomDayProjector(t *testing.T) {
+	repo := newTestRepo()
+	p := NewRoomDayProjector(repo)
+
+	confirmed := create(t, p, 1, at(9), at(10))
+	event(t, p, confirmed, 2, reservations.ReservationConfirmedEvent, &reservations.ReservationConfirmedData{User: "Scheduler"})
+	create(t, p, 1, at(14), at(15))
+	// Spans midnight, so is on both days
+	overnight := create(t, p, 1, at(22), at(26))
+
+	d := roomDay(t, repo, 1, day)
+	if d.Confirmed != 1 || d.Pending != 2 || len(d.Bookings) != 3 {
+		t.Errorf("RoomDay = %d confirmed, %d pending, %d bookings, want 1, 2, 3", d.Confirmed, d.Pending, len(d.Bookings))
+	}
+	next := roomDay(t, repo, 1, day.AddDate(0, 0, 1))
+	if next.Pending != 1 || !next.Bookings[0].StartTime.Equal(at(24)) || !next.Bookings[0].EndTime.Equal(at(26)) {
+		t.Errorf("next RoomDay = %+v, want the overnight booking from midnight", next.Bookings[0])
+	}
+
+	// Moving the overnight booking off the next day removes it from there
+	event(t, p, overnight, 2, reservations.ReservationTimeChangedEvent, &reservations.ReservationTimeChangeData{
+		User:      "Matt",
+		StartTime: at(18),
+		EndTime:   at(19),
+	})
+	if next := roomDay(t, repo, 1, day.AddDate(0, 0, 1)); len(next.Bookings) != 0 {
+		t.Errorf("next RoomDay bookings = %d, want 0", len(next.Bookings))
+	}
+
+	// Cancelled bookings are kept, but no longer counted
+	event(t, p, confirmed, 3, reservations.ReservationCancelledEvent, &reservations.ReservationCancelledData{User: "Matt"})
+	// Redelivered events are ignored
+	event(t, p, confirmed, 2, reservations.ReservationConfirmedEvent, &reservations.ReservationConfirmedData{User: "Scheduler"})
+	d = roomDay(t, repo, 1, day)
+	if d.Confirmed != 0 || d.Pending != 2 || len(d.Bookings) != 3 {
+		t.Errorf("RoomDay = %d confirmed, %d pending, %d bookings, want 0, 2, 3", d.Confirmed, d.Pending, len(d.Bookings))
+	}
+
+	// A restarted projector finds the booking in the repo
+	restarted := NewRoomDayProjector(repo)
+	event(t, restarted, confirmed, 4, reservations.ReservationTimeChangedEvent, &reservations.ReservationTimeChangeData{
+		User:      "Matt",
+		StartTime: at(11),
+		EndTime:   at(12),
+	})
+	d = roomDay(t, repo, 1, day)
+	if b := d.booking(confirmed); b.Status != reservations.StatusPending || !b.StartTime.Equal(at(11)) {
+		t.Errorf("booking = %+v, want pending at 11:00", b)
+	}
+}
+
+func TestRoomDayProjector_TooLong(t *testing.T) {
+	repo := newTestRepo()
+	p := NewRoomDayProjector(repo)
+	ctx := context.Background()
+	tooLong := day.Add(reservations.MaxDuration + time.Hour)
+
+	e := eh.NewEvent(reservations.ReservationCreatedEvent, &reservations.ReservationCreatedData{
+		RoomID: 1, Name: "Sabbatical", User: "Matt", StartTime: day, EndTime: tooLong,
+	}, time.Now(), eh.ForAggregate(reservations.ReservationAggregateType, uuid.New(), 1))
+	if err := p.HandleEvent(ctx, e); !errors.Is(err, reservations.ErrReservationTooLong) {
+		t.Errorf("HandleEvent() error = %v, want %v", err, reservations.ErrReservationTooLong)
+	}
+	if days, _ := repo.FindAll(ctx); len(days) != 0 {
+		t.Errorf("HandleEvent() saved %v days, want 0", len(days))
+	}
+
+	// Nor can a booking be moved to be too long
+	id := create(t, p, 1, at(9), at(10))
+	e = eh.NewEvent(reservations.ReservationTimeChangedEvent, &reservations.ReservationTimeChangeData{
+		User: "Matt", StartTime: day, EndTime: tooLong,
+	}, time.Now(), eh.ForAggregate(reservations.ReservationAggregateType, id, 2))
+	if err := p.HandleEvent(ctx, e); !errors.Is(err, reservations.ErrReservationTooLong) {
+		t.Errorf("HandleEvent() error = %v, want %v", err, reservations.ErrReservationTooLong)
+	}
+	if days, _ := repo.FindAll(ctx); len(days) != 1 || !roomDay(t, repo, 1, day).booking(id).StartTime.Equal(at(9)) {
+		t.Errorf("HandleEvent() saved %v days, want the booking left on its 1 day", len(days))
+	}
+}
+
+func TestFreeIntervals(t *testing.T) {
+	repo := newTestRepo()
+	p := NewRoomDayProjector(repo)
+	create(t, p, 1, at(9), at(10))
+	create(t, p, 1, at(9).Add(30*time.Minute), at(11))
+	create(t, p, 1, at(13), at(14))
+	declined := create(t, p, 2, at(9), at(17))
+	event(t, p, declined, 2, reservations.ReservationDeclinedEvent, &reservations.ReservationDeclinedData{User: "Scheduler"})
+	create(t, p, 3, at(12), at(13))
+
+	tests := []struct {
+		name        string
+		from, to    time.Time
+		minDuration time.Duration
+		want        map[int][]Interval
+	}{
+		{
+			name: "busy intervals are merged",
+			from: at(8),
+			to:   at(17),
+			want: map[int][]Interval{
+				1: {{at(8), at(9)}, {at(11), at(13)}, {at(14), at(17)}},
+				2: {{at(8), at(17)}},
+			},
+		},
+		{
+			name:        "min duration",
+			from:        at(8),
+			to:          at(17),
+			minDuration: 2 * time.Hour,
+			want: map[int][]Interval{
+				1: {{at(11), at(13)}, {at(14), at(17)}},
+				2: {{at(8), at(17)}},
+			},
+		},
+		{
+			name: "inside a booking",
+			from: at(9).Add(45 * time.Minute),
+			to:   at(10).Add(15 * time.Minute),
+			want: map[int][]Interval{
+				1: {},
+				2: {{at(9).Add(45 * time.Minute), at(10).Add(15 * time.Minute)}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FreeIntervals(context.Background(), repo, []int{1, 2}, tt.from, tt.to, tt.minDuration)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FreeIntervals() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	rooms, err := FreeRooms(context.Background(), repo, []int{1, 2, 3}, at(12), at(13))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rooms, []int{1, 2}) {
+		t.Errorf("FreeRooms() = %v, want [1 2]", rooms)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	repo := newTestRepo()
+	srv := httptest.NewServer(Handler(repo))
+	defer srv.Close()
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{Path + "?from=2021-07-01T09:00:00Z&to=2021-07-01T17:00:00Z&min=30m", http.StatusOK},
+		{Path + "/rooms?from=2021-07-01T09:00:00Z&to=2021-07-01T17:00:00Z&rooms=1,2", http.StatusOK},
+		{Path + "?from=2021-07-01T17:00:00Z&to=2021-07-01T09:00:00Z", http.StatusBadRequest},
+		{Path + "?from=2021-07-01T09:00:00Z&to=2021-09-01T09:00:00Z", http.StatusBadRequest},
+		{Path + "/rooms?from=2021-07-01T09:00:00Z&to=2021-07-01T17:00:00Z&rooms=one", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		resp, err := http.Get(srv.URL + tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("GET %v = %v, want %v", tt.path, resp.StatusCode, tt.want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package availability
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// Interval is a free or busy period of a room
+type Interval struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Duration returns the length of the interval
+func (i Interval) Duration() time.Duration {
+	return i.End.Sub(i.Start)
+}
+
+// Busy returns the merged busy intervals of the room between from and to
+func Busy(ctx context.Context, repo eh.ReadRepo, roomID int, from, to time.Time) ([]Interval, error) {
+	var busy []Interval
+	for _, day := range days(from, to) {
+		e, err := repo.Find(ctx, DayID(roomID, day))
+		if errors.Is(err, eh.ErrEntityNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		d, ok := e.(*RoomDay)
+		if !ok {
+			return nil, errors.New("incorrect entity type")
+		}
+		for _, b := range d.Bookings {
+			if !b.Busy() || !b.EndTime.After(from) || !b.StartTime.Before(to) {
+				continue
+			}
+			i := Interval{Start: b.StartTime, End: b.EndTime}
+			if i.Start.Before(from) {
+				i.Start = from
+			}
+			if i.End.After(to) {
+				i.End = to
+			}
+			busy = append(busy, i)
+		}
+	}
+
+	sort.Slice(busy, func(i, j int) bool { return busy[i].Start.Before(busy[j].Start) })
+	var merged []Interval
+	for _, i := range busy {
+		if n := len(merged); n > 0 && !i.Start.After(merged[n-1].End) {
+			if i.End.After(merged[n-1].End) {
+				merged[n-1].End = i.End
+			}
+			continue
+		}
+		merged = append(merged, i)
+	}
+	return merged, nil
+}
+
+// FreeIntervals returns the free intervals of each room between from and to, that are at least minDuration long
+func FreeIntervals(ctx context.Context, repo eh.ReadRepo, rooms []int, from, to time.Time, minDuration time.Duration) (map[int][]Interval, error) {
+	free := make(map[int][]Interval, len(rooms))
+	for _, roomID := range rooms {
+		busy, err := Busy(ctx, repo, roomID, from, to)
+		if err != nil {
+			return nil, err
+		}
+		intervals := []Interval{}
+		start := from
+		for _, b := range append(busy, Interval{Start: to, End: to}) {
+			if b.Start.After(start) {
+				if i := (Interval{Start: start, End: b.Start}); i.Duration() >= minDuration && i.Duration() > 0 {
+					intervals = append(intervals, i)
+				}
+			}
+			if b.End.After(start) {
+				start = b.End
+			}
+		}
+		free[roomID] = intervals
+	}
+	return free, nil
+}
+
+// FreeRooms returns the rooms that are free for the whole of from to to
+func FreeRooms(ctx context.Context, repo eh.ReadRepo, rooms []int, from, to time.Time) ([]int, error) {
+	free, err := FreeIntervals(ctx, repo, rooms, from, to, to.Sub(from))
+	if err != nil {
+		return nil, err
+	}
+	freeRooms := []int{}
+	for _, roomID := range rooms {
+		if len(free[roomID]) > 0 {
+			freeRooms = append(freeRooms, roomID)
+		}
+	}
+	return freeRooms, nil
+}
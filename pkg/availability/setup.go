@@ -0,0 +1,32 @@
+package availability
+
+import (
+	"context"
+
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/repo/memory"
+	"github.com/looplab/eventhorizon/repo/mongodb"
+)
+
+// Setup will initialize and register the room availability projector
+func Setup(
+	ctx context.Context,
+	eventBus eh.EventBus,
+	availabilityRepo eh.ReadWriteRepo,
+) {
+	if memoryRepo := memory.IntoRepo(ctx, availabilityRepo); memoryRepo != nil {
+		memoryRepo.SetEntityFactory(func() eh.Entity { return &RoomDay{} })
+	}
+	if mongoRepo := mongodb.IntoRepo(ctx, availabilityRepo); mongoRepo != nil {
+		mongoRepo.SetEntityFactory(func() eh.Entity { return &RoomDay{} })
+	}
+
+	eventBus.AddHandler(ctx, eh.MatchEvents{
+		reservations.ReservationCreatedEvent,
+		reservations.ReservationConfirmedEvent,
+		reservations.ReservationDeclinedEvent,
+		reservations.ReservationTimeChangedEvent,
+		reservations.ReservationCancelledEvent,
+	}, NewRoomDayProjector(availabilityRepo))
+}
@@ -0,0 +1,43 @@
+package billing
+
+import (
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/money"
+)
+
+// Change is a confirmed booking being cancelled or moved, which may be charged a fee
+type Change struct {
+	RoomID int
+	// StartTime and EndTime are the booking as it was confirmed
+	StartTime time.Time
+	EndTime   time.Time
+	// At is when the booking was cancelled or moved
+	At time.Time
+	// Charged is what the booking was charged
+	Charged money.Money
+}
+
+// Fee is what a Change costs
+type Fee struct {
+	Amount money.Money
+	// Policy is the name of the policy the fee was worked out with
+	Policy string
+	// RateVersion is the version of the rates the policy is from
+	RateVersion string
+}
+
+// FeePolicy works out the fees of cancelling and moving bookings, it must be deterministic so replays produce the same bills
+type FeePolicy interface {
+	Fee(c Change) Fee
+}
+
+// NoFees never charges a fee
+type NoFees struct{}
+
+func (NoFees) Fee(c Change) Fee {
+	return Fee{Amount: money.Money{Currency: c.Charged.Currency}}
+}
+
+// DefaultFeePolicy lets bookings be cancelled and moved for free
+var DefaultFeePolicy FeePolicy = NoFees{}
@@ -0,0 +1,45 @@
+package billing
+
+import (
+	"sort"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/money"
+)
+
+// Invoice is a user's bill for a month, with its lines in time order
+type Invoice struct {
+	User string
+	// Month is yyyy-mm
+	Month   string
+	Version int
+	Minutes int
+	Total   money.Money
+	Lines   []*Line
+}
+
+// Invoice returns the bill of the month of t as an Invoice, false if there is no bill
+func (h *BillingHistory) Invoice(t time.Time) (*Invoice, bool) {
+	bill, ok := h.Bills[MonthKey(t)]
+	if !ok {
+		return nil, false
+	}
+	lines := make([]*Line, 0, len(bill.Lines))
+	for _, line := range bill.Lines {
+		lines = append(lines, line)
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		if !lines[i].StartTime.Equal(lines[j].StartTime) {
+			return lines[i].StartTime.Before(lines[j].StartTime)
+		}
+		return lines[i].Key() < lines[j].Key()
+	})
+	return &Invoice{
+		User:    h.User,
+		Month:   t.Format("2006-01"),
+		Version: bill.Version,
+		Minutes: bill.Minutes,
+		Total:   bill.Total,
+		Lines:   lines,
+	}, true
+}
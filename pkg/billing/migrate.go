@@ -0,0 +1,170 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/MattDevy/CQRS-example/pkg/money"
+	eh "github.com/looplab/eventhorizon"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MigrateMoney converts a billing document saved with float totals to Money, returning false if it already was
+// The totals are recomputed from the minutes, which were always exact, so the drift of the floats is dropped
+func MigrateMoney(doc bson.M, rate money.Rate) bool {
+	if !isNumber(doc["totalpaid"]) {
+		return false
+	}
+	paid := money.Money{Currency: rate.Currency}
+	if bills, ok := doc["bills"].(bson.M); ok {
+		for key, b := range bills {
+			bill, ok := b.(bson.M)
+			if !ok {
+				continue
+			}
+			total := rate.Times(toInt64(bill["minutes"]), Rounding)
+			bill["total"] = bson.M{"amount": total.Amount, "currency": total.Currency}
+			bills[key] = bill
+			paid = paid.Add(total)
+		}
+	}
+	doc["totalpaid"] = bson.M{"amount": paid.Amount, "currency": paid.Currency}
+	return true
+}
+
+// MigrateMoneyCollection migrates every document of the billing collection, returning how many were changed
+func MigrateMoneyCollection(ctx context.Context, c *mongo.Collection, rate money.Rate) (int, error) {
+	cursor, err := c.Find(ctx, bson.M{})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	migrated := 0
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return migrated, err
+		}
+		if !MigrateMoney(doc, rate) {
+			continue
+		}
+		if _, err := c.ReplaceOne(ctx, bson.M{"_id": doc["_id"]}, doc); err != nil {
+			return migrated, fmt.Errorf("could not migrate %v: %w", doc["_id"], err)
+		}
+		migrated++
+	}
+	return migrated, cursor.Err()
+}
+
+// MigrateHistoryIDs merges the billing histories older versions saved under random IDs into the history with the user's
+// HistoryID, which the projector and queries look up, returning how many were merged. Run it after MigrateMoney.
+func MigrateHistoryIDs(ctx context.Context, repo eh.ReadWriteRepo) (int, error) {
+	entities, err := repo.FindAll(ctx)
+	if err != nil {
+		return 0, err
+	}
+	old := make(map[string][]*BillingHistory)
+	var users []string
+	for _, e := range entities {
+		h, ok := e.(*BillingHistory)
+		if !ok || h.ID == HistoryID(h.User) {
+			continue
+		}
+		if _, ok := old[h.User]; !ok {
+			users = append(users, h.User)
+		}
+		old[h.User] = append(old[h.User], h)
+	}
+	sort.Strings(users)
+
+	migrated := 0
+	for _, user := range users {
+		h, err := keyedHistory(ctx, repo, user)
+		if err != nil {
+			return migrated, err
+		}
+		// Merged and removed one at a time, so a failed migration can be run again
+		for _, o := range old[user] {
+			mergeHistory(h, o)
+			if err := repo.Save(ctx, h); err != nil {
+				return migrated, fmt.Errorf("could not save the billing history of %v: %w", user, err)
+			}
+			if err := repo.Remove(ctx, o.ID); err != nil {
+				return migrated, fmt.Errorf("could not remove %v: %w", o.ID, err)
+			}
+			migrated++
+		}
+	}
+	return migrated, nil
+}
+
+// keyedHistory returns the billing history with the user's HistoryID, a new one if there is none
+func keyedHistory(ctx context.Context, repo eh.ReadRepo, user string) (*BillingHistory, error) {
+	e, err := repo.Find(ctx, HistoryID(user))
+	if errors.Is(err, eh.ErrEntityNotFound) {
+		return &BillingHistory{ID: HistoryID(user), User: user, Bills: map[string]*Bill{}}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	h, ok := e.(*BillingHistory)
+	if !ok {
+		return nil, errors.New("incorrect entity type")
+	}
+	if h.Bills == nil {
+		h.Bills = map[string]*Bill{}
+	}
+	return h, nil
+}
+
+// mergeHistory adds the bills and totals of the other history to h
+func mergeHistory(h, other *BillingHistory) {
+	for key, bill := range other.Bills {
+		existing, ok := h.Bills[key]
+		if !ok {
+			h.Bills[key] = bill
+			continue
+		}
+		existing.Version += bill.Version
+		existing.Minutes += bill.Minutes
+		existing.Total = existing.Total.Add(bill.Total)
+		for id, line := range bill.Lines {
+			if existing.Lines == nil {
+				existing.Lines = map[string]*Line{}
+			}
+			existing.Lines[id] = line
+		}
+	}
+	for id, r := range other.Reservations {
+		if h.Reservations == nil {
+			h.Reservations = map[string]*BilledReservation{}
+		}
+		h.Reservations[id] = r
+	}
+	h.Version += other.Version
+	h.TotalMinutes += other.TotalMinutes
+	h.TotalPaid = h.TotalPaid.Add(other.TotalPaid)
+}
+
+func isNumber(v interface{}) bool {
+	switch v.(type) {
+	case float64, float32, int32, int64:
+		return true
+	}
+	return false
+}
+
+func toInt64(v interface{}) int64 {
+	switch n := v.(type) {
+	case int32:
+		return int64(n)
+	case int64:
+		return n
+	case float64:
+		return int64(n)
+	}
+	return 0
+}
@@ -0,0 +1,58 @@
+package billing
+
+import (
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/money"
+)
+
+// Quote is the part of a booking in one billing period, to be priced
+type Quote struct {
+	RoomID    int
+	StartTime time.Time
+	EndTime   time.Time
+	// PriorMinutes are the minutes the user was already billed for in the period, for volume tiers
+	PriorMinutes int
+}
+
+// Price is what a Quote costs
+type Price struct {
+	// Minutes are the billed minutes, which can be more than booked when billing in increments
+	Minutes int
+	// UnitPrice is the average price of a billed minute
+	UnitPrice money.Rate
+	Amount    money.Money
+	// RateVersion is the version of the rates the price was worked out with
+	RateVersion string
+}
+
+// PricingPolicy prices the bookings, it must be deterministic so replays produce the same bills
+type PricingPolicy interface {
+	Price(q Quote) Price
+	// Currency is the currency every price is in, new bills start in it
+	Currency() string
+}
+
+// FlatRate prices every minute of every room the same
+type FlatRate struct {
+	Rate     money.Rate
+	Rounding money.Rounding
+	Version  string
+}
+
+// DefaultPricingPolicy charges PricePerMinute
+var DefaultPricingPolicy PricingPolicy = &FlatRate{Rate: PricePerMinute, Rounding: Rounding, Version: "flat"}
+
+func (f *FlatRate) Currency() string {
+	return f.Rate.Currency
+}
+
+func (f *FlatRate) Price(q Quote) Price {
+	minutes := Minutes(q.StartTime, q.EndTime)
+	return Price{
+		Minutes:     minutes,
+		UnitPrice:   f.Rate,
+		Amount:      f.Rate.Times(int64(minutes), f.Rounding),
+		RateVersion: f.Version,
+	}
+}
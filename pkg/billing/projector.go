@@ -8,20 +8,92 @@ import (
 	"sync"
 	"time"
 
+	"github.com/MattDevy/CQRS-example/pkg/money"
+	"github.com/MattDevy/CQRS-example/pkg/payments"
+	"github.com/MattDevy/CQRS-example/pkg/promotions"
 	"github.com/MattDevy/CQRS-example/pkg/reservations"
 	"github.com/google/uuid"
 	eh "github.com/looplab/eventhorizon"
 )
 
-const (
-	PricePerMinute float32 = 0.04
+var (
+	PricePerMinute = money.MustParseRate("0.04", "USD")
+	// Rounding is how each charge is rounded to a minor unit
+	Rounding = money.HalfEven
 )
 
 type Bill struct {
 	ID      uuid.UUID
 	Version int
 	Minutes int
-	Total   float32
+	Total   money.Money
+	// Lines are what each reservation was charged, by reservation ID
+	Lines map[string]*Line
+	// PaymentStatus is PaymentPaid or PaymentFailed once the bill's invoice was charged
+	PaymentStatus string
+	Paid          money.Money
+	PaymentError  string
+}
+
+const (
+	// PaymentPaid is a bill whose invoice was charged
+	PaymentPaid = "paid"
+	// PaymentFailed is a bill whose invoice could not be charged
+	PaymentFailed = "failed"
+)
+
+const (
+	// KindBooking is the line of the time a reservation was booked for
+	KindBooking = "booking"
+	// KindCancellationFee is the fee of cancelling a confirmed reservation
+	KindCancellationFee = "cancellation fee"
+	// KindChangeFee is the fee of moving a confirmed reservation
+	KindChangeFee = "change fee"
+	// KindNoShowFee is the fee of a confirmed reservation nobody checked in to
+	KindNoShowFee = "no-show fee"
+	// KindDiscount is what a promotion took off a booking, a negative amount
+	KindDiscount = "discount"
+	// KindCredit is the account credit drawn down against a booking, a negative amount
+	KindCredit = "credit"
+)
+
+const (
+	// LineCharged is a line of a confirmed reservation
+	LineCharged = "charged"
+	// LineVoid is a line of a reservation that was cancelled, declined or moved, it is charged nothing
+	LineVoid = "void"
+)
+
+// Line is what a reservation was charged on a bill, for the booking or a fee
+type Line struct {
+	// ID is the line's key in Bill.Lines, the reservation ID for the line of a booking
+	ID            string
+	Kind          string
+	ReservationID uuid.UUID
+	RoomID        int
+	// StartTime and EndTime are the part of the booking in the bill's month
+	StartTime time.Time
+	EndTime   time.Time
+	Minutes   int
+	// UnitPrice is the average price of a billed minute
+	UnitPrice   money.Rate
+	Amount      money.Money
+	RateVersion string
+	// Policy is the fee policy a fee was worked out with
+	Policy string
+	// Mode is how the reservation was billed, ModeBooked or ModeUsage
+	Mode string
+	// Promotion is the code a discount was applied with
+	Promotion string
+	Status    string
+}
+
+// Key returns the line's key in Bill.Lines, lines saved before they had IDs are keyed by reservation
+func (l Line) Key() string {
+	if l.ID == "" {
+		return l.ReservationID.String()
+	}
+	return l.ID
 }
 
 type BillingHistory struct {
@@ -30,7 +102,48 @@ type BillingHistory struct {
 	User         string
 	Bills        map[string]*Bill
 	TotalMinutes int
-	TotalPaid    float32
+	TotalPaid    money.Money
+	// Reservations are the user's reservations, by reservation ID
+	Reservations map[string]*BilledReservation
+	// Credit is the account credit left to draw down
+	Credit money.Money
+	// CreditVersion is the version of the last credit grant applied
+	CreditVersion int
+	// PromotionVersions are the versions of the last event applied from each promotion, by promotion ID
+	PromotionVersions map[string]int
+	// PendingDiscounts are the discounts of reservations that were not projected yet, by reservation ID
+	// Promotions are published in their own order, so they can be applied before the reservation is created.
+	PendingDiscounts map[string]*Discount
+}
+
+// BilledReservation is what the projector knows of a reservation, kept with the bills so it survives restarts
+type BilledReservation struct {
+	// Version is the version of the last event applied to the reservation
+	Version   int
+	RoomID    int
+	StartTime time.Time
+	EndTime   time.Time
+	// CheckedIn is when the room was entered, zero until then
+	CheckedIn time.Time
+	// Discount is the promotion applied to the reservation, if any
+	Discount *Discount
+	// Charges are what the confirmed reservation was charged, refunds reverse them exactly
+	Charges []Charge
+}
+
+// Discount is a promotion taking Percent or a fixed Amount off a booking
+type Discount struct {
+	Code    string
+	Percent int
+	Amount  money.Money
+}
+
+// Namespace is the UUID namespace billing history and bill IDs are generated in
+var Namespace = uuid.MustParse("0f3d6a52-8c47-4e1b-a9d2-53b7e6c81f04")
+
+// HistoryID returns the ID of the user's BillingHistory
+func HistoryID(user string) uuid.UUID {
+	return uuid.NewSHA1(Namespace, []byte(user))
 }
 
 func (b *BillingHistory) EntityID() uuid.UUID {
@@ -41,150 +154,662 @@ func (b *BillingHistory) AggregateVersion() int {
 	return b.Version
 }
 
+// Charge is what a confirmed reservation was charged on one bill
+type Charge struct {
+	Key  string
+	Line Line
+}
+
 // BillingHistoryProjector is the read model for a user's Billing History
+// Reservations are billed to the months they take place in, in the projector's time zone
 type BillingHistoryProjector struct {
-	Pending            map[uuid.UUID]*reservations.ReservationCreatedData
-	UserBillingHistory map[string]uuid.UUID
-	ReservationsUser   map[uuid.UUID]string
-	repo               eh.ReadWriteRepo
-	repoMu             sync.Mutex
+	repo   eh.ReadWriteRepo
+	repoMu sync.Mutex
+	// users caches whose reservations are whose, the repo is scanned into it on the first miss
+	users   map[uuid.UUID]string
+	scanned bool
+	loc     *time.Location
+	policy  PricingPolicy
+	fees    FeePolicy
+	usage   UsagePolicy
 }
 
 // NewBillingHistoryProjector initializes a new BillingHistoryProjector, this method should be used
 // when creating a new BillingHistoryProjector
 func NewBillingHistoryProjector(repo eh.ReadWriteRepo) *BillingHistoryProjector {
 	return &BillingHistoryProjector{
-		Pending:            make(map[uuid.UUID]*reservations.ReservationCreatedData),
-		UserBillingHistory: make(map[string]uuid.UUID),
-		ReservationsUser:   make(map[uuid.UUID]string),
-		repo:               repo,
+		repo:   repo,
+		users:  make(map[uuid.UUID]string),
+		loc:    time.UTC,
+		policy: DefaultPricingPolicy,
+		fees:   DefaultFeePolicy,
+		usage:  DefaultUsagePolicy,
 	}
 }
 
+// SetLocation sets the time zone billing months start and end in, UTC by default
+func (b *BillingHistoryProjector) SetLocation(loc *time.Location) {
+	b.loc = loc
+}
+
+// SetPricingPolicy sets how bookings are priced, DefaultPricingPolicy by default
+func (b *BillingHistoryProjector) SetPricingPolicy(policy PricingPolicy) {
+	b.policy = policy
+}
+
+// SetFeePolicy sets the fees of cancelling and moving confirmed bookings, DefaultFeePolicy by default
+func (b *BillingHistoryProjector) SetFeePolicy(fees FeePolicy) {
+	b.fees = fees
+}
+
+// SetUsagePolicy sets whether bookings are billed for the minutes booked or used, DefaultUsagePolicy by default
+func (b *BillingHistoryProjector) SetUsagePolicy(usage UsagePolicy) {
+	b.usage = usage
+}
+
 // HandlerType returns the EventHandlerType of the Projector
 func (b *BillingHistoryProjector) HandlerType() eh.EventHandlerType {
 	return eh.EventHandlerType("BillingHistory")
 }
 
 // HandleEvent is the method that is called when any events that are registered are recieved from the event bus
+// Events at or below the version already applied to a reservation are ignored, so redeliveries and replays are safe
 func (b *BillingHistoryProjector) HandleEvent(ctx context.Context, event eh.Event) error {
 	// One big hack
 	b.repoMu.Lock()
 	defer b.repoMu.Unlock()
 
-	// Initialize the BillingHistory on fir
-	var h *BillingHistory
+	switch event.EventType() {
+	case payments.PaymentSucceededEvent, payments.PaymentFailedEvent:
+		return b.handlePayment(ctx, event)
+	case promotions.PromotionAppliedEvent:
+		return b.handlePromotion(ctx, event)
+	case promotions.CreditGrantedEvent:
+		return b.handleCredit(ctx, event)
+	}
+
+	id := event.AggregateID()
+	var user string
 	if event.EventType() == reservations.ReservationCreatedEvent {
 		data, ok := event.Data().(*reservations.ReservationCreatedData)
 		if !ok {
 			return fmt.Errorf("projector: invalid event data type: %v", event.Data())
 		}
-		b.ReservationsUser[event.AggregateID()] = data.User
-		b.UserBillingHistory[data.User] = uuid.New()
+		user = data.User
+	} else {
+		var err error
+		if user, err = b.user(ctx, id); errors.Is(err, errUnknownReservation) {
+			// Created before billing knew of it, eg by a version without the reservations in the histories,
+			// so redelivering the event would not help
+			fmt.Printf("Reservation %v is not billed, rebuild the billing projection to bill it\n", id)
+			return nil
+		} else if err != nil {
+			return err
+		}
 	}
-
-	user, ok := b.ReservationsUser[event.AggregateID()]
-	if !ok {
-		return errors.New("No reservation found")
+	h, err := b.history(ctx, user)
+	if err != nil {
+		return err
 	}
-	id, ok := b.UserBillingHistory[user]
+	r, ok := h.Reservations[id.String()]
 	if !ok {
-		return fmt.Errorf("No user %v found\n", user)
-	}
-	m, err := b.repo.Find(context.Background(), id)
-	if errors.Is(err, eh.ErrEntityNotFound) {
-		h = &BillingHistory{
-			ID:    id,
-			User:  user,
-			Bills: map[string]*Bill{},
-		}
-	} else if err != nil {
-		return err
-	} else {
-		var ok bool
-		h, ok = m.(*BillingHistory)
-		if !ok {
-			return errors.New("projector: incorrect entity type")
+		if event.EventType() != reservations.ReservationCreatedEvent {
+			return fmt.Errorf("projector: reservation %v not found", id)
 		}
+		r = &BilledReservation{}
+	}
+	if event.Version() <= r.Version {
+		b.users[id] = user
+		return nil
 	}
+	r.Version = event.Version()
 
 	switch event.EventType() {
 	case reservations.ReservationCreatedEvent:
-		data, ok := event.Data().(*reservations.ReservationCreatedData)
-		if !ok {
-			return fmt.Errorf("projector: invalid event data type: %v", event.Data())
+		data := event.Data().(*reservations.ReservationCreatedData)
+		r.RoomID, r.StartTime, r.EndTime = data.RoomID, data.StartTime, data.EndTime
+		if d, ok := h.PendingDiscounts[id.String()]; ok {
+			r.Discount = d
+			delete(h.PendingDiscounts, id.String())
 		}
-		b.Pending[event.AggregateID()] = data
-		h.Version++
+		h.Reservations[id.String()] = r
 	case reservations.ReservationConfirmedEvent:
-		pending, ok := b.Pending[event.AggregateID()]
-		if ok {
-			bill, ok := h.Bills[thisMonth()]
-			mins := int(math.Round(pending.EndTime.Sub(pending.StartTime).Minutes()))
-			if !ok {
-				bill = &Bill{
-					ID: uuid.New(),
-				}
-				h.Bills[thisMonth()] = bill
-			}
-			bill.Minutes += mins
-			bill.Total += float32(mins) * PricePerMinute
-			bill.Version++
-
-			h.TotalMinutes += mins
-			h.TotalPaid += float32(mins) * PricePerMinute
-			h.Version++
-		} else {
-			return errors.New("Event not found")
-		}
+		b.refund(h, id, r)
+		b.charge(h, id, r, r.StartTime, r.EndTime, ModeBooked)
 	case reservations.ReservationDeclinedEvent:
-		delete(b.Pending, event.AggregateID())
+		b.refund(h, id, r)
 	case reservations.ReservationCancelledEvent:
-		pending, ok := b.Pending[event.AggregateID()]
-		if ok {
-			bill, ok := h.Bills[thisMonth()]
-			mins := int(math.Round(pending.EndTime.Sub(pending.StartTime).Minutes()))
-			if ok {
-				bill.Minutes -= mins
-				bill.Total -= float32(mins) * PricePerMinute
-				bill.Version++
-
-				h.TotalMinutes -= mins
-				h.TotalPaid -= float32(mins) * PricePerMinute
-				h.Version++
-			}
-		}
+		b.fee(h, id, r, KindCancellationFee, event)
+		b.refund(h, id, r)
 	case reservations.ReservationTimeChangedEvent:
 		data, ok := event.Data().(*reservations.ReservationTimeChangeData)
 		if !ok {
 			return fmt.Errorf("projector: invalid event data type: %v", event.Data())
 		}
-		pending, ok := b.Pending[event.AggregateID()]
-		if ok {
-			mins := int(math.Round(pending.EndTime.Sub(pending.StartTime).Minutes()))
-			bill, ok := h.Bills[thisMonth()]
-			if ok {
-				bill.Minutes -= mins
-				bill.Total -= float32(mins) * PricePerMinute
-				bill.Version++
-
-				h.TotalMinutes -= mins
-				h.TotalPaid -= float32(mins) * PricePerMinute
-				h.Version++
-			}
-			pending.StartTime = data.StartTime
-			pending.EndTime = data.EndTime
+		b.fee(h, id, r, KindChangeFee, event)
+		b.refund(h, id, r)
+		r.StartTime = data.StartTime
+		r.EndTime = data.EndTime
+	case reservations.ReservationCheckedInEvent:
+		data, ok := event.Data().(*reservations.ReservationCheckedInData)
+		if !ok {
+			return fmt.Errorf("projector: invalid event data type: %v", event.Data())
+		}
+		r.CheckedIn = data.At
+	case reservations.ReservationCheckedOutEvent:
+		data, ok := event.Data().(*reservations.ReservationCheckedOutData)
+		if !ok {
+			return fmt.Errorf("projector: invalid event data type: %v", event.Data())
 		}
+		// Until the check-out the booked minutes are billed
+		if len(r.Charges) > 0 && !r.CheckedIn.IsZero() && b.usageOf(user, r).Mode == ModeUsage {
+			b.refund(h, id, r)
+			b.charge(h, id, r, r.CheckedIn, data.At, ModeUsage)
+		}
+	case reservations.ReservationNoShowEvent:
+		b.noShow(h, id, r, user, event)
 	default:
 		return fmt.Errorf("could not handle event: %s", event)
 	}
+	h.Version++
+	if err := b.repo.Save(ctx, h); err != nil {
+		return fmt.Errorf("projector: could not save: %w", err)
+	}
+	b.users[id] = user
+	return nil
+}
+
+// errUnknownReservation is when the reservation is in none of the histories
+var errUnknownReservation = errors.New("projector: reservation not found")
+
+// user returns whose the reservation is, scanning the histories into the cache on the first miss
+// The cache is kept up to date from then on, so later misses are reservations billing never saw.
+func (b *BillingHistoryProjector) user(ctx context.Context, id uuid.UUID) (string, error) {
+	if user, ok := b.users[id]; ok {
+		return user, nil
+	}
+	if b.scanned {
+		return "", fmt.Errorf("%w: %v", errUnknownReservation, id)
+	}
+	entities, err := b.repo.FindAll(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, e := range entities {
+		if h, ok := e.(*BillingHistory); ok {
+			for reservationID := range h.Reservations {
+				if rid, err := uuid.Parse(reservationID); err == nil {
+					b.users[rid] = h.User
+				}
+			}
+		}
+	}
+	b.scanned = true
+	if user, ok := b.users[id]; ok {
+		return user, nil
+	}
+	return "", fmt.Errorf("%w: %v", errUnknownReservation, id)
+}
+
+// history returns the user's BillingHistory, a new one if the user has none
+func (b *BillingHistoryProjector) history(ctx context.Context, user string) (*BillingHistory, error) {
+	m, err := b.repo.Find(ctx, HistoryID(user))
+	if errors.Is(err, eh.ErrEntityNotFound) {
+		return &BillingHistory{
+			ID:                HistoryID(user),
+			User:              user,
+			Bills:             map[string]*Bill{},
+			TotalPaid:         money.Money{Currency: b.policy.Currency()},
+			Reservations:      map[string]*BilledReservation{},
+			PromotionVersions: map[string]int{},
+			PendingDiscounts:  map[string]*Discount{},
+		}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	h, ok := m.(*BillingHistory)
+	if !ok {
+		return nil, errors.New("projector: incorrect entity type")
+	}
+	if h.Reservations == nil {
+		h.Reservations = map[string]*BilledReservation{}
+	}
+	if h.PromotionVersions == nil {
+		h.PromotionVersions = map[string]int{}
+	}
+	if h.PendingDiscounts == nil {
+		h.PendingDiscounts = map[string]*Discount{}
+	}
+	return h, nil
+}
+
+// handlePayment records the outcome of charging an invoice on its bill
+func (b *BillingHistoryProjector) handlePayment(ctx context.Context, event eh.Event) error {
+	var user, month string
+	switch data := event.Data().(type) {
+	case *payments.PaymentSucceededData:
+		user, month = data.User, data.Month
+	case *payments.PaymentFailedData:
+		user, month = data.User, data.Month
+	default:
+		return fmt.Errorf("projector: invalid event data type: %v", event.Data())
+	}
+	m, err := b.repo.Find(ctx, HistoryID(user))
+	if err != nil {
+		return fmt.Errorf("projector: no billing history for %v: %w", user, err)
+	}
+	h, ok := m.(*BillingHistory)
+	if !ok {
+		return errors.New("projector: incorrect entity type")
+	}
+	t, err := time.Parse("2006-01", month)
+	if err != nil {
+		return fmt.Errorf("projector: invalid month %q", month)
+	}
+	bill, ok := h.Bills[MonthKey(t)]
+	if !ok {
+		return fmt.Errorf("projector: no bill for %v in %v", user, month)
+	}
+
+	switch data := event.Data().(type) {
+	case *payments.PaymentSucceededData:
+		bill.PaymentStatus = PaymentPaid
+		bill.Paid = data.Amount
+		bill.PaymentError = ""
+	case *payments.PaymentFailedData:
+		bill.PaymentStatus = PaymentFailed
+		bill.PaymentError = data.Reason
+	}
+	bill.Version++
+	h.Version++
 	if err := b.repo.Save(ctx, h); err != nil {
 		return fmt.Errorf("projector: could not save: %w", err)
 	}
 	return nil
 }
 
-func thisMonth() string {
-	year, month, _ := time.Now().Date()
+// handlePromotion discounts the reservation, replacing any earlier discount, and draws credit down against what is left
+// The discount of a reservation that was not created yet is kept until it is.
+func (b *BillingHistoryProjector) handlePromotion(ctx context.Context, event eh.Event) error {
+	data, ok := event.Data().(*promotions.PromotionAppliedData)
+	if !ok {
+		return fmt.Errorf("projector: invalid event data type: %v", event.Data())
+	}
+	h, err := b.history(ctx, data.User)
+	if err != nil {
+		return err
+	}
+	promotion := event.AggregateID().String()
+	if event.Version() <= h.PromotionVersions[promotion] {
+		return nil
+	}
+	h.PromotionVersions[promotion] = event.Version()
+	discount := &Discount{Code: data.Code, Percent: data.Percent, Amount: data.Amount}
+	r, ok := h.Reservations[data.ReservationID.String()]
+	if !ok {
+		h.PendingDiscounts[data.ReservationID.String()] = discount
+		h.Version++
+		if err := b.repo.Save(ctx, h); err != nil {
+			return fmt.Errorf("projector: could not save: %w", err)
+		}
+		return nil
+	}
+	r.Discount = discount
+
+	var booking, rest []Charge
+	for _, c := range r.Charges {
+		if c.Line.Kind == KindBooking {
+			booking = append(booking, c)
+		} else {
+			rest = append(rest, c)
+		}
+	}
+	b.reverse(h, rest)
+	r.Charges = booking
+	b.discount(h, data.ReservationID, r)
+	b.drawCredit(h, data.ReservationID, r)
+	h.Version++
+	if err := b.repo.Save(ctx, h); err != nil {
+		return fmt.Errorf("projector: could not save: %w", err)
+	}
+	return nil
+}
+
+// handleCredit adds the credit granted to the user to draw down against their next bookings
+func (b *BillingHistoryProjector) handleCredit(ctx context.Context, event eh.Event) error {
+	data, ok := event.Data().(*promotions.CreditGrantedData)
+	if !ok {
+		return fmt.Errorf("projector: invalid event data type: %v", event.Data())
+	}
+	h, err := b.history(ctx, data.User)
+	if err != nil {
+		return err
+	}
+	if event.Version() <= h.CreditVersion {
+		return nil
+	}
+	h.CreditVersion = event.Version()
+	h.Credit = h.Credit.Add(data.Amount)
+	h.Version++
+	if err := b.repo.Save(ctx, h); err != nil {
+		return fmt.Errorf("projector: could not save: %w", err)
+	}
+	return nil
+}
+
+// charge prices the reservation and adds it to the bills of the months it takes place in
+// Each month's part of the booking is priced on its own, then discounted and paid for with credit
+func (b *BillingHistoryProjector) charge(h *BillingHistory, id uuid.UUID, r *BilledReservation, start, end time.Time, mode string) {
+	var charges []Charge
+	for _, p := range Periods(start, end, b.loc) {
+		prior := 0
+		if bill, ok := h.Bills[p.Key]; ok {
+			prior = bill.Minutes
+		}
+		price := b.policy.Price(Quote{
+			RoomID:       r.RoomID,
+			StartTime:    p.StartTime,
+			EndTime:      p.EndTime,
+			PriorMinutes: prior,
+		})
+		c := Charge{Key: p.Key, Line: Line{
+			ID:            id.String(),
+			Kind:          KindBooking,
+			ReservationID: id,
+			RoomID:        r.RoomID,
+			StartTime:     p.StartTime,
+			EndTime:       p.EndTime,
+			Minutes:       price.Minutes,
+			UnitPrice:     price.UnitPrice,
+			Amount:        price.Amount,
+			RateVersion:   price.RateVersion,
+			Mode:          mode,
+			Status:        LineCharged,
+		}}
+		line := c.Line
+		b.bill(h, c.Key).Lines[id.String()] = &line
+		b.apply(h, c.Key, c.Line.Minutes, c.Line.Amount)
+		charges = append(charges, c)
+	}
+	r.Charges = charges
+	b.discount(h, id, r)
+	b.drawCredit(h, id, r)
+}
+
+// discount takes the reservation's discount off its booking lines, a fixed amount from the earliest month first
+func (b *BillingHistoryProjector) discount(h *BillingHistory, id uuid.UUID, r *BilledReservation) {
+	d := r.Discount
+	if d == nil {
+		return
+	}
+	left := d.Amount
+	for _, c := range append([]Charge(nil), r.Charges...) {
+		if c.Line.Kind != KindBooking {
+			continue
+		}
+		charged := c.Line.Amount
+		amount := money.Fraction(charged.Amount*int64(d.Percent), 100, charged.Currency, Rounding)
+		if d.Percent == 0 {
+			if left.Currency != charged.Currency {
+				continue
+			}
+			amount = left
+			if amount.Amount > charged.Amount {
+				amount = charged
+			}
+			left = left.Sub(amount)
+		}
+		if amount.Amount <= 0 {
+			continue
+		}
+		b.add(h, r, c.Key, Line{
+			ID:            fmt.Sprintf("%v/%v", id, KindDiscount),
+			Kind:          KindDiscount,
+			ReservationID: id,
+			RoomID:        r.RoomID,
+			StartTime:     c.Line.StartTime,
+			EndTime:       c.Line.EndTime,
+			Amount:        amount.Neg(),
+			Mode:          c.Line.Mode,
+			Promotion:     d.Code,
+			Status:        LineCharged,
+		})
+	}
+}
+
+// drawCredit draws the user's credit down against what is left to pay for the reservation on each bill
+func (b *BillingHistoryProjector) drawCredit(h *BillingHistory, id uuid.UUID, r *BilledReservation) {
+	for _, c := range append([]Charge(nil), r.Charges...) {
+		if c.Line.Kind != KindBooking {
+			continue
+		}
+		due := money.Money{Currency: c.Line.Amount.Currency}
+		for _, other := range r.Charges {
+			if other.Key == c.Key {
+				due = due.Add(other.Line.Amount)
+			}
+		}
+		if h.Credit.Amount <= 0 || h.Credit.Currency != due.Currency || due.Amount <= 0 {
+			continue
+		}
+		draw := due
+		if h.Credit.Amount < draw.Amount {
+			draw = h.Credit
+		}
+		h.Credit = h.Credit.Sub(draw)
+		b.add(h, r, c.Key, Line{
+			ID:            fmt.Sprintf("%v/%v", id, KindCredit),
+			Kind:          KindCredit,
+			ReservationID: id,
+			RoomID:        r.RoomID,
+			StartTime:     c.Line.StartTime,
+			EndTime:       c.Line.EndTime,
+			Amount:        draw.Neg(),
+			Mode:          c.Line.Mode,
+			Status:        LineCharged,
+		})
+	}
+}
+
+// add charges the reservation the line on the month's bill
+func (b *BillingHistoryProjector) add(h *BillingHistory, r *BilledReservation, key string, line Line) {
+	stored := line
+	b.bill(h, key).Lines[line.ID] = &stored
+	b.apply(h, key, line.Minutes, line.Amount)
+	r.Charges = append(r.Charges, Charge{Key: key, Line: line})
+}
+
+// fee charges the fee of cancelling or moving the confirmed reservation
+func (b *BillingHistoryProjector) fee(h *BillingHistory, id uuid.UUID, r *BilledReservation, kind string, event eh.Event) {
+	if len(r.Charges) == 0 {
+		return
+	}
+	var charged money.Money
+	for _, c := range r.Charges {
+		// Credit pays for the booking, it does not make it cheaper
+		if c.Line.Kind != KindCredit {
+			charged = charged.Add(c.Line.Amount)
+		}
+	}
+	fee := b.fees.Fee(Change{
+		RoomID:    r.RoomID,
+		StartTime: r.StartTime,
+		EndTime:   r.EndTime,
+		At:        event.Timestamp(),
+		Charged:   charged,
+	})
+	b.feeLine(h, id, r, event, Line{
+		Kind:        kind,
+		Amount:      fee.Amount,
+		RateVersion: fee.RateVersion,
+		Policy:      fee.Policy,
+		Mode:        r.Charges[0].Line.Mode,
+	})
+}
+
+// noShow charges the no-show fee of the confirmed reservation, instead of the booked minutes if it is billed by usage
+func (b *BillingHistoryProjector) noShow(h *BillingHistory, id uuid.UUID, r *BilledReservation, user string, event eh.Event) {
+	if len(r.Charges) == 0 {
+		return
+	}
+	// Bookings billed as booked are already paid for in full, only usage ones are charged the fee instead
+	usage := b.usageOf(user, r)
+	if usage.Mode != ModeUsage {
+		return
+	}
+	b.refund(h, id, r)
+	b.feeLine(h, id, r, event, Line{
+		Kind:        KindNoShowFee,
+		Amount:      usage.NoShowFee,
+		RateVersion: usage.RateVersion,
+		Mode:        usage.Mode,
+	})
+}
+
+// feeLine charges the fee as a line of its own, on the bill of the month the booking started in, if it is more than nothing
+// Fees are kept when the booking is refunded
+func (b *BillingHistoryProjector) feeLine(h *BillingHistory, id uuid.UUID, r *BilledReservation, event eh.Event, line Line) {
+	if line.Amount.Amount <= 0 {
+		return
+	}
+	key := MonthKey(r.StartTime.In(b.loc))
+	line.ID = fmt.Sprintf("%v/%v/%d", id, line.Kind, event.Version())
+	line.ReservationID = id
+	line.RoomID = r.RoomID
+	line.StartTime, line.EndTime = r.StartTime, r.EndTime
+	line.Status = LineCharged
+	b.bill(h, key).Lines[line.ID] = &line
+	b.apply(h, key, 0, line.Amount)
+}
+
+// usageOf returns how the user's reservation is billed
+func (b *BillingHistoryProjector) usageOf(user string, r *BilledReservation) Usage {
+	return b.usage.Usage(Booking{User: user, RoomID: r.RoomID, StartTime: r.StartTime})
+}
+
+// refund reverses the reservation's charges exactly, if it has any, and voids its lines
+// Charging the reservation again replaces the void line of a month, adjusting it
+func (b *BillingHistoryProjector) refund(h *BillingHistory, id uuid.UUID, r *BilledReservation) {
+	b.reverse(h, r.Charges)
+	r.Charges = nil
+}
+
+// reverse voids the charges' lines, giving back the credit drawn down
+func (b *BillingHistoryProjector) reverse(h *BillingHistory, charges []Charge) {
+	for _, c := range charges {
+		if line, ok := b.bill(h, c.Key).Lines[c.Line.Key()]; ok {
+			line.Minutes = 0
+			line.Amount = money.Money{Currency: line.Amount.Currency}
+			line.Status = LineVoid
+		}
+		b.apply(h, c.Key, -c.Line.Minutes, c.Line.Amount.Neg())
+		if c.Line.Kind == KindCredit {
+			h.Credit = h.Credit.Add(c.Line.Amount.Neg())
+		}
+	}
+}
+
+// bill returns the bill of the month, adding it if there is none
+func (b *BillingHistoryProjector) bill(h *BillingHistory, key string) *Bill {
+	bill, ok := h.Bills[key]
+	if !ok {
+		bill = &Bill{
+			ID:    uuid.NewSHA1(h.ID, []byte(key)),
+			Total: money.Money{Currency: h.TotalPaid.Currency},
+			Lines: make(map[string]*Line),
+		}
+		h.Bills[key] = bill
+	}
+	if bill.Lines == nil {
+		// Bills saved before lines were added
+		bill.Lines = make(map[string]*Line)
+	}
+	return bill
+}
+
+// apply adds the minutes and amount to the month's bill and the totals
+func (b *BillingHistoryProjector) apply(h *BillingHistory, key string, minutes int, amount money.Money) {
+	bill := b.bill(h, key)
+	bill.Minutes += minutes
+	bill.Total = bill.Total.Add(amount)
+	bill.Version++
+
+	h.TotalMinutes += minutes
+	h.TotalPaid = h.TotalPaid.Add(amount)
+	h.Version++
+}
+
+// MonthKey returns the key of the bill in BillingHistory.Bills for the month t is in, in t's location
+func MonthKey(t time.Time) string {
+	year, month, _ := t.Date()
 	return time.Date(year, month, 1, 0, 0, 0, 0, time.UTC).String()
 }
+
+// ParseMonthKey returns the first day of the month of a key returned by MonthKey, in UTC
+func ParseMonthKey(key string) (time.Time, error) {
+	return time.Parse("2006-01-02 15:04:05 -0700 MST", key)
+}
+
+// Minutes returns the billed minutes of a booking
+func Minutes(start, end time.Time) int {
+	return int(math.Round(end.Sub(start).Minutes()))
+}
+
+// Period is the part of a booking billed to one month
+type Period struct {
+	Key       string
+	StartTime time.Time
+	EndTime   time.Time
+	Minutes   int
+}
+
+// Periods splits the booking at the starts of the months in loc
+// Minutes are rounded at each boundary from the start, so the periods always add up to Minutes(start, end)
+func Periods(start, end time.Time, loc *time.Location) []Period {
+	var periods []Period
+	billed := 0
+	from := start.In(loc)
+	for from.Before(end) {
+		year, month, _ := from.Date()
+		to := time.Date(year, month+1, 1, 0, 0, 0, 0, loc)
+		if to.After(end) {
+			to = end
+		}
+		minutes := Minutes(start, to) - billed
+		billed += minutes
+		periods = append(periods, Period{Key: MonthKey(from), StartTime: from.In(start.Location()), EndTime: to.In(start.Location()), Minutes: minutes})
+		from = to.In(loc)
+	}
+	return periods
+}
+
+// FindByUser returns the user's BillingHistory from the repo
+// Histories projected before their IDs were derived from the user are found by scanning, the latest wins
+func FindByUser(ctx context.Context, repo eh.ReadRepo, user string) (*BillingHistory, error) {
+	e, err := repo.Find(ctx, HistoryID(user))
+	if err == nil {
+		if h, ok := e.(*BillingHistory); ok {
+			return h, nil
+		}
+		return nil, errors.New("incorrect entity type")
+	} else if !errors.Is(err, eh.ErrEntityNotFound) {
+		return nil, err
+	}
+
+	entities, err := repo.FindAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var latest *BillingHistory
+	for _, e := range entities {
+		if h, ok := e.(*BillingHistory); ok && h.User == user {
+			if latest == nil || h.Version > latest.Version {
+				latest = h
+			}
+		}
+	}
+	if latest == nil {
+		return nil, eh.ErrEntityNotFound
+	}
+	return latest, nil
+}
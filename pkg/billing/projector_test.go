@@ -0,0 +1,769 @@
+package billing
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/money"
+	"github.com/MattDevy/CQRS-example/pkg/payments"
+	"github.com/MattDevy/CQRS-example/pkg/promotions"
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	memoryRepo "github.com/looplab/eventhorizon/repo/memory"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestPeriods(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+	july := MonthKey(time.Date(2021, time.July, 1, 0, 0, 0, 0, time.UTC))
+	june := MonthKey(time.Date(2021, time.June, 1, 0, 0, 0, 0, time.UTC))
+
+	tests := []struct {
+		name       string
+		start, end time.Time
+		loc        *time.Location
+		want       []Period
+	}{
+		{
+			name:  "within a month",
+			start: time.Date(2021, time.July, 1, 9, 0, 0, 0, time.UTC),
+			end:   time.Date(2021, time.July, 1, 10, 0, 0, 0, time.UTC),
+			loc:   time.UTC,
+			want:  []Period{{july, time.Date(2021, time.July, 1, 9, 0, 0, 0, time.UTC), time.Date(2021, time.July, 1, 10, 0, 0, 0, time.UTC), 60}},
+		},
+		{
+			name:  "across a month boundary",
+			start: time.Date(2021, time.June, 30, 23, 0, 0, 0, time.UTC),
+			end:   time.Date(2021, time.July, 1, 1, 30, 0, 0, time.UTC),
+			loc:   time.UTC,
+			want: []Period{
+				{june, time.Date(2021, time.June, 30, 23, 0, 0, 0, time.UTC), time.Date(2021, time.July, 1, 0, 0, 0, 0, time.UTC), 60},
+				{july, time.Date(2021, time.July, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, time.July, 1, 1, 30, 0, 0, time.UTC), 90},
+			},
+		},
+		{
+			name:  "in July in London, June in UTC",
+			start: time.Date(2021, time.June, 30, 23, 0, 0, 0, time.UTC),
+			end:   time.Date(2021, time.June, 30, 23, 30, 0, 0, time.UTC),
+			loc:   london,
+			want:  []Period{{july, time.Date(2021, time.June, 30, 23, 0, 0, 0, time.UTC), time.Date(2021, time.June, 30, 23, 30, 0, 0, time.UTC), 30}},
+		},
+		{
+			name:  "rounding adds up",
+			start: time.Date(2021, time.June, 30, 23, 59, 40, 0, time.UTC),
+			end:   time.Date(2021, time.July, 1, 0, 0, 40, 0, time.UTC),
+			loc:   time.UTC,
+			want: []Period{
+				{june, time.Date(2021, time.June, 30, 23, 59, 40, 0, time.UTC), time.Date(2021, time.July, 1, 0, 0, 0, 0, time.UTC), 0},
+				{july, time.Date(2021, time.July, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, time.July, 1, 0, 0, 40, 0, time.UTC), 1},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Periods(tt.start, tt.end, tt.loc); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Periods() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func newTestProjector() (*BillingHistoryProjector, *memoryRepo.Repo) {
+	repo := memoryRepo.NewRepo()
+	repo.SetEntityFactory(func() eh.Entity { return &BillingHistory{Bills: make(map[string]*Bill)} })
+	return NewBillingHistoryProjector(repo), repo
+}
+
+func TestBillingHistoryProjector(t *testing.T) {
+	id := uuid.New()
+	start := time.Date(2021, time.March, 31, 23, 0, 0, 0, time.UTC)
+	event := func(version int, eventType eh.EventType, data eh.EventData) eh.Event {
+		// Processed in January, long before the booking
+		processed := time.Date(2021, time.January, 31, 12, 0, 0, 0, time.UTC)
+		return eh.NewEvent(eventType, data, processed, eh.ForAggregate(reservations.ReservationAggregateType, id, version))
+	}
+	events := []eh.Event{
+		event(1, reservations.ReservationCreatedEvent, &reservations.ReservationCreatedData{RoomID: 1, Name: "Offsite", User: "Matt", StartTime: start, EndTime: start.Add(2 * time.Hour)}),
+		event(2, reservations.ReservationConfirmedEvent, &reservations.ReservationConfirmedData{User: "saga"}),
+	}
+	march := MonthKey(start)
+	april := MonthKey(start.Add(time.Hour))
+
+	// Replays give the same bills, whenever they run
+	var histories []*BillingHistory
+	for i := 0; i < 2; i++ {
+		p, repo := newTestProjector()
+		for _, e := range events {
+			if err := p.HandleEvent(context.Background(), e); err != nil {
+				t.Fatal(err)
+			}
+		}
+		h, err := FindByUser(context.Background(), repo, "Matt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		histories = append(histories, h)
+	}
+	for _, h := range histories {
+		if len(h.Bills) != 2 || h.Bills[march].Minutes != 60 || h.Bills[april].Minutes != 60 || h.TotalMinutes != 120 {
+			t.Errorf("bills = %v, want 60 minutes in March and April", h.Bills)
+		}
+	}
+	if histories[0].Bills[march].Minutes != histories[1].Bills[march].Minutes || histories[0].Bills[april].Total != histories[1].Bills[april].Total {
+		t.Error("replays gave different bills")
+	}
+}
+
+func TestBillingHistoryProjector_Cancel(t *testing.T) {
+	p, repo := newTestProjector()
+	id := uuid.New()
+	start := time.Date(2021, time.June, 30, 23, 0, 0, 0, time.UTC)
+	for i, e := range []struct {
+		eventType eh.EventType
+		data      eh.EventData
+	}{
+		{reservations.ReservationCreatedEvent, &reservations.ReservationCreatedData{RoomID: 1, Name: "Late", User: "Matt", StartTime: start, EndTime: start.Add(2 * time.Hour)}},
+		{reservations.ReservationConfirmedEvent, &reservations.ReservationConfirmedData{User: "saga"}},
+		{reservations.ReservationCancelledEvent, &reservations.ReservationCancelledData{User: "Matt"}},
+	} {
+		event := eh.NewEvent(e.eventType, e.data, time.Now(), eh.ForAggregate(reservations.ReservationAggregateType, id, i+1))
+		if err := p.HandleEvent(context.Background(), event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h, err := FindByUser(context.Background(), repo, "Matt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, bill := range h.Bills {
+		if bill.Minutes != 0 {
+			t.Errorf("bill %v = %d minutes after cancelling, want 0", key, bill.Minutes)
+		}
+	}
+	if len(h.Bills) != 2 || h.TotalMinutes != 0 {
+		t.Errorf("history = %+v, want two empty bills", h)
+	}
+}
+
+func TestBillingHistoryProjector_Lines(t *testing.T) {
+	p, repo := newTestProjector()
+	id := uuid.New()
+	start := time.Date(2021, time.July, 1, 9, 0, 0, 0, time.UTC)
+	moved := time.Date(2021, time.August, 2, 9, 0, 0, 0, time.UTC)
+	july, august := MonthKey(start), MonthKey(moved)
+
+	tests := []struct {
+		name      string
+		eventType eh.EventType
+		data      eh.EventData
+		// want are the status and minutes of the July and August lines, "" if there is none
+		want [2]string
+	}{
+		{"created", reservations.ReservationCreatedEvent, &reservations.ReservationCreatedData{RoomID: 1, Name: "Standup", User: "Matt", StartTime: start, EndTime: start.Add(time.Hour)}, [2]string{"", ""}},
+		{"confirmed", reservations.ReservationConfirmedEvent, &reservations.ReservationConfirmedData{User: "saga"}, [2]string{"charged 60", ""}},
+		{"moved", reservations.ReservationTimeChangedEvent, &reservations.ReservationTimeChangeData{User: "Matt", StartTime: moved, EndTime: moved.Add(30 * time.Minute)}, [2]string{"void 0", ""}},
+		{"confirmed again", reservations.ReservationConfirmedEvent, &reservations.ReservationConfirmedData{User: "saga"}, [2]string{"void 0", "charged 30"}},
+		{"cancelled", reservations.ReservationCancelledEvent, &reservations.ReservationCancelledData{User: "Matt"}, [2]string{"void 0", "void 0"}},
+	}
+	for i, tt := range tests {
+		event := eh.NewEvent(tt.eventType, tt.data, time.Now(), eh.ForAggregate(reservations.ReservationAggregateType, id, i+1))
+		if err := p.HandleEvent(context.Background(), event); err != nil {
+			t.Fatal(err)
+		}
+		h, err := FindByUser(context.Background(), repo, "Matt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got [2]string
+		for j, key := range []string{july, august} {
+			if bill, ok := h.Bills[key]; ok {
+				if line, ok := bill.Lines[id.String()]; ok {
+					got[j] = fmt.Sprintf("%v %d", line.Status, line.Minutes)
+				}
+			}
+		}
+		if got != tt.want {
+			t.Errorf("%v: lines = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBillingHistoryProjector_Payments(t *testing.T) {
+	p, repo := newTestProjector()
+	id := uuid.New()
+	start := time.Date(2021, time.July, 1, 9, 0, 0, 0, time.UTC)
+	for i, e := range []struct {
+		eventType eh.EventType
+		data      eh.EventData
+	}{
+		{reservations.ReservationCreatedEvent, &reservations.ReservationCreatedData{RoomID: 1, Name: "Standup", User: "Matt", StartTime: start, EndTime: start.Add(time.Hour)}},
+		{reservations.ReservationConfirmedEvent, &reservations.ReservationConfirmedData{User: "saga"}},
+	} {
+		event := eh.NewEvent(e.eventType, e.data, time.Now(), eh.ForAggregate(reservations.ReservationAggregateType, id, i+1))
+		if err := p.HandleEvent(context.Background(), event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	invoiceID := uuid.New()
+	tests := []struct {
+		name      string
+		eventType eh.EventType
+		data      eh.EventData
+		want      Bill
+	}{
+		{
+			name:      "failed",
+			eventType: payments.PaymentFailedEvent,
+			data:      &payments.PaymentFailedData{InvoiceID: invoiceID, User: "Matt", Month: "2021-07", Amount: money.New(240, "USD"), Reason: "payment declined"},
+			want:      Bill{PaymentStatus: PaymentFailed, PaymentError: "payment declined"},
+		},
+		{
+			name:      "succeeded",
+			eventType: payments.PaymentSucceededEvent,
+			data:      &payments.PaymentSucceededData{InvoiceID: invoiceID, User: "Matt", Month: "2021-07", Amount: money.New(240, "USD"), GatewayID: "pay_1"},
+			want:      Bill{PaymentStatus: PaymentPaid, Paid: money.New(240, "USD")},
+		},
+	}
+	for i, tt := range tests {
+		event := eh.NewEvent(tt.eventType, tt.data, time.Now(), eh.ForAggregate(payments.PaymentAggregateType, payments.PaymentID(invoiceID), i+1))
+		if err := p.HandleEvent(context.Background(), event); err != nil {
+			t.Fatal(err)
+		}
+		h, err := FindByUser(context.Background(), repo, "Matt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		bill := h.Bills[MonthKey(start)]
+		if bill.PaymentStatus != tt.want.PaymentStatus || bill.Paid != tt.want.Paid || bill.PaymentError != tt.want.PaymentError {
+			t.Errorf("%v: bill = %v %v %q, want %v %v %q", tt.name,
+				bill.PaymentStatus, bill.Paid, bill.PaymentError, tt.want.PaymentStatus, tt.want.Paid, tt.want.PaymentError)
+		}
+	}
+}
+
+func TestBillingHistoryProjector_Restart(t *testing.T) {
+	p, repo := newTestProjector()
+	ids := []uuid.UUID{uuid.New(), uuid.New()}
+	start := time.Date(2021, time.July, 1, 9, 0, 0, 0, time.UTC)
+	moved := start.Add(24 * time.Hour)
+	event := func(id uuid.UUID, version int, eventType eh.EventType, data eh.EventData) eh.Event {
+		return eh.NewEvent(eventType, data, time.Now(), eh.ForAggregate(reservations.ReservationAggregateType, id, version))
+	}
+	created := event(ids[0], 1, reservations.ReservationCreatedEvent, &reservations.ReservationCreatedData{RoomID: 1, Name: "Standup", User: "Matt", StartTime: start, EndTime: start.Add(time.Hour)})
+	confirmed := event(ids[0], 2, reservations.ReservationConfirmedEvent, &reservations.ReservationConfirmedData{User: "saga"})
+	for _, e := range []eh.Event{created, confirmed} {
+		if err := p.HandleEvent(context.Background(), e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A restarted projector picks up where the last one stopped, and ignores redeliveries
+	p = NewBillingHistoryProjector(repo)
+	for _, e := range []eh.Event{
+		confirmed,
+		event(ids[0], 3, reservations.ReservationTimeChangedEvent, &reservations.ReservationTimeChangeData{User: "Matt", StartTime: moved, EndTime: moved.Add(30 * time.Minute)}),
+		created,
+		event(ids[0], 4, reservations.ReservationConfirmedEvent, &reservations.ReservationConfirmedData{User: "saga"}),
+		confirmed,
+		event(ids[1], 1, reservations.ReservationCreatedEvent, &reservations.ReservationCreatedData{RoomID: 2, Name: "Retro", User: "Matt", StartTime: start, EndTime: start.Add(time.Hour)}),
+	} {
+		if err := p.HandleEvent(context.Background(), e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entities, err := repo.FindAll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entities) != 1 {
+		t.Fatalf("histories = %d, want 1", len(entities))
+	}
+	h := entities[0].(*BillingHistory)
+	if h.ID != HistoryID("Matt") {
+		t.Errorf("ID = %v, want %v", h.ID, HistoryID("Matt"))
+	}
+	if h.TotalMinutes != 30 || h.TotalPaid != money.New(120, "USD") || len(h.Reservations) != 2 {
+		t.Errorf("history = %d minutes, %v, %d reservations, want 30 minutes, USD 1.20, 2 reservations", h.TotalMinutes, h.TotalPaid, len(h.Reservations))
+	}
+	if r := h.Reservations[ids[0].String()]; r.Version != 4 || !r.StartTime.Equal(moved) || len(r.Charges) != 1 {
+		t.Errorf("reservation = %+v, want version 4 moved and charged once", r)
+	}
+}
+
+// lateFees charges half of a booking changed less than a day before it starts
+type lateFees struct{}
+
+func (lateFees) Fee(c Change) Fee {
+	if c.StartTime.Sub(c.At) >= 24*time.Hour {
+		return Fee{Amount: money.Money{Currency: c.Charged.Currency}}
+	}
+	return Fee{Amount: money.New(c.Charged.Amount/2, c.Charged.Currency), Policy: "late", RateVersion: "test"}
+}
+
+func TestBillingHistoryProjector_Fees(t *testing.T) {
+	p, repo := newTestProjector()
+	p.SetFeePolicy(lateFees{})
+	id := uuid.New()
+	start := time.Date(2021, time.July, 2, 9, 0, 0, 0, time.UTC)
+	moved := time.Date(2021, time.August, 10, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		at        time.Time
+		eventType eh.EventType
+		data      eh.EventData
+		// want are the kinds and amounts of the charged lines, in key order
+		want []string
+	}{
+		{"created", start.Add(-72 * time.Hour), reservations.ReservationCreatedEvent, &reservations.ReservationCreatedData{RoomID: 1, Name: "Standup", User: "Matt", StartTime: start, EndTime: start.Add(time.Hour)}, nil},
+		{"confirmed", start.Add(-72 * time.Hour), reservations.ReservationConfirmedEvent, &reservations.ReservationConfirmedData{User: "saga"}, []string{"booking USD 2.40"}},
+		{"moved late", start.Add(-time.Hour), reservations.ReservationTimeChangedEvent, &reservations.ReservationTimeChangeData{User: "Matt", StartTime: moved, EndTime: moved.Add(time.Hour)}, []string{"change fee USD 1.20"}},
+		{"cancelled before confirmed", start.Add(-time.Hour), reservations.ReservationCancelledEvent, &reservations.ReservationCancelledData{User: "Matt"}, []string{"change fee USD 1.20"}},
+	}
+	for i, tt := range tests {
+		event := eh.NewEvent(tt.eventType, tt.data, tt.at, eh.ForAggregate(reservations.ReservationAggregateType, id, i+1))
+		if err := p.HandleEvent(context.Background(), event); err != nil {
+			t.Fatal(err)
+		}
+		h, err := FindByUser(context.Background(), repo, "Matt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := chargedLines(h); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: lines = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// Cancelling a confirmed booking late is charged on the bill of its month
+	other := uuid.New()
+	for i, e := range []struct {
+		at        time.Time
+		eventType eh.EventType
+		data      eh.EventData
+	}{
+		{start.Add(-72 * time.Hour), reservations.ReservationCreatedEvent, &reservations.ReservationCreatedData{RoomID: 2, Name: "Retro", User: "Matt", StartTime: moved, EndTime: moved.Add(time.Hour)}},
+		{start.Add(-72 * time.Hour), reservations.ReservationConfirmedEvent, &reservations.ReservationConfirmedData{User: "saga"}},
+		{moved.Add(-time.Hour), reservations.ReservationCancelledEvent, &reservations.ReservationCancelledData{User: "Matt"}},
+	} {
+		event := eh.NewEvent(e.eventType, e.data, e.at, eh.ForAggregate(reservations.ReservationAggregateType, other, i+1))
+		if err := p.HandleEvent(context.Background(), event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h, err := FindByUser(context.Background(), repo, "Matt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fee, ok := h.Bills[MonthKey(moved)].Lines[fmt.Sprintf("%v/%v/3", other, KindCancellationFee)]
+	if !ok || fee.Kind != KindCancellationFee || fee.Policy != "late" || fee.RateVersion != "test" || fee.Amount != money.New(120, "USD") || fee.RoomID != 2 {
+		t.Errorf("cancellation fee = %+v, want USD 1.20 of the late policy", fee)
+	}
+	if h.TotalPaid != money.New(240, "USD") || h.TotalMinutes != 0 {
+		t.Errorf("history = %v for %d minutes, want USD 2.40 of fees", h.TotalPaid, h.TotalMinutes)
+	}
+}
+
+// chargedLines returns the kinds and amounts of the history's charged lines, in key order
+func chargedLines(h *BillingHistory) []string {
+	var keys []string
+	lines := make(map[string]*Line)
+	for _, bill := range h.Bills {
+		for key, line := range bill.Lines {
+			if line.Status == LineCharged {
+				keys = append(keys, key)
+				lines[key] = line
+			}
+		}
+	}
+	sort.Strings(keys)
+	var got []string
+	for _, key := range keys {
+		got = append(got, fmt.Sprintf("%v %v", lines[key].Kind, lines[key].Amount))
+	}
+	return got
+}
+
+// roomUsage bills room 1 by usage, charging USD 1.00 if nobody checks in, and other rooms as booked
+type roomUsage struct{}
+
+func (roomUsage) Usage(b Booking) Usage {
+	if b.RoomID != 1 {
+		return Usage{Mode: ModeBooked, NoShowFee: money.New(100, "USD"), RateVersion: "test"}
+	}
+	return Usage{Mode: ModeUsage, NoShowFee: money.New(100, "USD"), RateVersion: "test"}
+}
+
+func TestBillingHistoryProjector_Usage(t *testing.T) {
+	start := time.Date(2021, time.July, 2, 9, 0, 0, 0, time.UTC)
+	created := &reservations.ReservationCreatedData{RoomID: 1, Name: "Standup", User: "Matt", StartTime: start, EndTime: start.Add(time.Hour)}
+	booked := &reservations.ReservationCreatedData{RoomID: 2, Name: "Standup", User: "Matt", StartTime: start, EndTime: start.Add(time.Hour)}
+	confirmed := &reservations.ReservationConfirmedData{User: "saga"}
+	checkedIn := &reservations.ReservationCheckedInData{User: "Matt", At: start.Add(10 * time.Minute)}
+	checkedOut := &reservations.ReservationCheckedOutData{User: "Matt", At: start.Add(40 * time.Minute)}
+	noShow := &reservations.ReservationNoShowData{User: reservations.NoShowUser}
+
+	tests := []struct {
+		name   string
+		events []eh.EventData
+		// want are the kinds and amounts of the charged lines, in key order
+		want     []string
+		wantMode string
+	}{
+		{"checked out", []eh.EventData{created, confirmed, checkedIn, checkedOut}, []string{"booking USD 1.20"}, ModeUsage},
+		{"not checked out", []eh.EventData{created, confirmed, checkedIn}, []string{"booking USD 2.40"}, ModeBooked},
+		{"no show", []eh.EventData{created, confirmed, noShow}, []string{"no-show fee USD 1.00"}, ModeUsage},
+		{"booked checked out", []eh.EventData{booked, confirmed, checkedIn, checkedOut}, []string{"booking USD 2.40"}, ModeBooked},
+		{"booked no show", []eh.EventData{booked, confirmed, noShow}, []string{"booking USD 2.40"}, ModeBooked},
+	}
+	eventTypes := map[reflect.Type]eh.EventType{
+		reflect.TypeOf(created):    reservations.ReservationCreatedEvent,
+		reflect.TypeOf(confirmed):  reservations.ReservationConfirmedEvent,
+		reflect.TypeOf(checkedIn):  reservations.ReservationCheckedInEvent,
+		reflect.TypeOf(checkedOut): reservations.ReservationCheckedOutEvent,
+		reflect.TypeOf(noShow):     reservations.ReservationNoShowEvent,
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, repo := newTestProjector()
+			p.SetUsagePolicy(roomUsage{})
+			id := uuid.New()
+			for i, data := range tt.events {
+				event := eh.NewEvent(eventTypes[reflect.TypeOf(data)], data, start, eh.ForAggregate(reservations.ReservationAggregateType, id, i+1))
+				if err := p.HandleEvent(context.Background(), event); err != nil {
+					t.Fatal(err)
+				}
+			}
+			h, err := FindByUser(context.Background(), repo, "Matt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := chargedLines(h); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lines = %q, want %q", got, tt.want)
+			}
+			for _, line := range h.Bills[MonthKey(start)].Lines {
+				if line.Status == LineCharged && line.Mode != tt.wantMode {
+					t.Errorf("%v line mode = %v, want %v", line.Kind, line.Mode, tt.wantMode)
+				}
+			}
+		})
+	}
+}
+
+func TestBillingHistoryProjector_Promotions(t *testing.T) {
+	p, repo := newTestProjector()
+	half, fixed := uuid.New(), uuid.New()
+	start := time.Date(2021, time.July, 2, 9, 0, 0, 0, time.UTC)
+	reservation := func(id uuid.UUID, version int, eventType eh.EventType, data eh.EventData) eh.Event {
+		return eh.NewEvent(eventType, data, start, eh.ForAggregate(reservations.ReservationAggregateType, id, version))
+	}
+	applied := func(code string, version int, id uuid.UUID, percent int, amount money.Money) eh.Event {
+		data := &promotions.PromotionAppliedData{Code: code, User: "Matt", ReservationID: id, Percent: percent, Amount: amount}
+		return eh.NewEvent(promotions.PromotionAppliedEvent, data, start, eh.ForAggregate(promotions.PromotionAggregateType, promotions.PromotionID(code), version))
+	}
+	granted := eh.NewEvent(promotions.CreditGrantedEvent, &promotions.CreditGrantedData{User: "Matt", Amount: money.New(100, "USD"), Reason: "goodwill"},
+		start, eh.ForAggregate(promotions.AccountAggregateType, promotions.AccountID("Matt"), 1))
+
+	tests := []struct {
+		name  string
+		event eh.Event
+		// want are the kinds and amounts of the charged lines, in key order
+		want       []string
+		wantCredit money.Money
+	}{
+		{"credit granted", granted, nil, money.New(100, "USD")},
+		{"credit redelivered", granted, nil, money.New(100, "USD")},
+		{"created", reservation(half, 1, reservations.ReservationCreatedEvent, &reservations.ReservationCreatedData{RoomID: 1, Name: "Standup", User: "Matt", StartTime: start, EndTime: start.Add(time.Hour)}), nil, money.New(100, "USD")},
+		{"credit drawn down", reservation(half, 2, reservations.ReservationConfirmedEvent, &reservations.ReservationConfirmedData{User: "saga"}), []string{"booking USD 2.40", "credit USD -1.00"}, money.New(0, "USD")},
+		{"discounted after confirmed", applied("HALF", 1, half, 50, money.Money{}), []string{"booking USD 2.40", "credit USD -1.00", "discount USD -1.20"}, money.New(0, "USD")},
+		{"discount redelivered", applied("HALF", 1, half, 50, money.Money{}), []string{"booking USD 2.40", "credit USD -1.00", "discount USD -1.20"}, money.New(0, "USD")},
+		{"reservation not projected yet", applied("HALF", 2, uuid.New(), 50, money.Money{}), []string{"booking USD 2.40", "credit USD -1.00", "discount USD -1.20"}, money.New(0, "USD")},
+		{"cancelled", reservation(half, 3, reservations.ReservationCancelledEvent, &reservations.ReservationCancelledData{User: "Matt"}), nil, money.New(100, "USD")},
+		{"created with a code", reservation(fixed, 1, reservations.ReservationCreatedEvent, &reservations.ReservationCreatedData{RoomID: 1, Name: "Retro", User: "Matt", StartTime: start, EndTime: start.Add(time.Hour), PromotionCode: "FREE"}), nil, money.New(100, "USD")},
+		{"discounted before confirmed", applied("FREE", 1, fixed, 0, money.New(300, "USD")), nil, money.New(100, "USD")},
+		{"free", reservation(fixed, 2, reservations.ReservationConfirmedEvent, &reservations.ReservationConfirmedData{User: "saga"}), []string{"booking USD 2.40", "discount USD -2.40"}, money.New(100, "USD")},
+	}
+	for _, tt := range tests {
+		if err := p.HandleEvent(context.Background(), tt.event); err != nil {
+			t.Fatal(err)
+		}
+		h, err := FindByUser(context.Background(), repo, "Matt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := chargedLines(h); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: lines = %q, want %q", tt.name, got, tt.want)
+		}
+		if h.Credit != tt.wantCredit {
+			t.Errorf("%v: credit = %v, want %v", tt.name, h.Credit, tt.wantCredit)
+		}
+	}
+
+	h, err := FindByUser(context.Background(), repo, "Matt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	discount, ok := h.Bills[MonthKey(start)].Lines[fmt.Sprintf("%v/%v", fixed, KindDiscount)]
+	if !ok || discount.Promotion != "FREE" || discount.ReservationID != fixed {
+		t.Errorf("discount = %+v, want the line of FREE", discount)
+	}
+	if h.TotalPaid != money.New(0, "USD") || h.Bills[MonthKey(start)].Total != money.New(0, "USD") {
+		t.Errorf("history = %v, bill = %v, want nothing to pay", h.TotalPaid, h.Bills[MonthKey(start)].Total)
+	}
+}
+
+func TestBillingHistoryProjector_PromotionBeforeReservation(t *testing.T) {
+	p, repo := newTestProjector()
+	id := uuid.New()
+	start := time.Date(2021, time.July, 2, 9, 0, 0, 0, time.UTC)
+	events := []eh.Event{
+		eh.NewEvent(promotions.PromotionAppliedEvent, &promotions.PromotionAppliedData{Code: "HALF", User: "Matt", ReservationID: id, Percent: 50},
+			start, eh.ForAggregate(promotions.PromotionAggregateType, promotions.PromotionID("HALF"), 1)),
+		eh.NewEvent(reservations.ReservationCreatedEvent, &reservations.ReservationCreatedData{RoomID: 1, Name: "Standup", User: "Matt", StartTime: start, EndTime: start.Add(time.Hour), PromotionCode: "HALF"},
+			start, eh.ForAggregate(reservations.ReservationAggregateType, id, 1)),
+		eh.NewEvent(reservations.ReservationConfirmedEvent, &reservations.ReservationConfirmedData{User: "saga"},
+			start, eh.ForAggregate(reservations.ReservationAggregateType, id, 2)),
+	}
+	for _, event := range events {
+		if err := p.HandleEvent(context.Background(), event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h, err := FindByUser(context.Background(), repo, "Matt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := chargedLines(h), []string{"booking USD 2.40", "discount USD -1.20"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("lines = %q, want %q", got, want)
+	}
+	if len(h.PendingDiscounts) != 0 {
+		t.Errorf("pending discounts = %v, want none", h.PendingDiscounts)
+	}
+}
+
+func TestMigrateMoney(t *testing.T) {
+	type oldBill struct {
+		ID      uuid.UUID
+		Version int
+		Minutes int
+		Total   float32
+	}
+	type oldHistory struct {
+		ID           uuid.UUID
+		Version      int
+		User         string
+		Bills        map[string]*oldBill
+		TotalMinutes int
+		TotalPaid    float32
+	}
+	july := MonthKey(time.Date(2021, time.July, 1, 0, 0, 0, 0, time.UTC))
+	raw, err := bson.Marshal(&oldHistory{
+		ID:   uuid.New(),
+		User: "Matt",
+		// Drifted from 2.40 and 1.20
+		Bills:        map[string]*oldBill{july: {Minutes: 60, Total: 2.3999999}, "other": {Minutes: 30, Total: 1.2000001}},
+		TotalMinutes: 90,
+		TotalPaid:    3.5999997,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	if !MigrateMoney(doc, PricePerMinute) {
+		t.Fatal("MigrateMoney() = false, want the document migrated")
+	}
+	if MigrateMoney(doc, PricePerMinute) {
+		t.Error("MigrateMoney() of a migrated document = true, want false")
+	}
+	if raw, err = bson.Marshal(doc); err != nil {
+		t.Fatal(err)
+	}
+	h := &BillingHistory{}
+	if err := bson.Unmarshal(raw, h); err != nil {
+		t.Fatal(err)
+	}
+	if h.TotalPaid != money.New(360, "USD") || h.Bills[july].Total != money.New(240, "USD") || h.Bills[july].Minutes != 60 {
+		t.Errorf("migrated history = %+v, july = %+v", h, h.Bills[july])
+	}
+}
+
+func TestMigrateHistoryIDs(t *testing.T) {
+	ctx := context.Background()
+	july := MonthKey(time.Date(2021, time.July, 1, 0, 0, 0, 0, time.UTC))
+	june := MonthKey(time.Date(2021, time.June, 1, 0, 0, 0, 0, time.UTC))
+	usd := func(amount int64) money.Money { return money.New(amount, "USD") }
+	p, repo := newTestProjector()
+	// Older versions saved a history under a new random ID for every reservation
+	for _, h := range []*BillingHistory{
+		{ID: uuid.New(), Version: 2, User: "Matt", Bills: map[string]*Bill{july: {Version: 1, Minutes: 60, Total: usd(240)}}, TotalMinutes: 60, TotalPaid: usd(240)},
+		{ID: uuid.New(), Version: 2, User: "Matt", Bills: map[string]*Bill{july: {Version: 1, Minutes: 30, Total: usd(120)}}, TotalMinutes: 30, TotalPaid: usd(120)},
+		{ID: uuid.New(), Version: 2, User: "Bob", Bills: map[string]*Bill{june: {Version: 1, Minutes: 15, Total: usd(60)}}, TotalMinutes: 15, TotalPaid: usd(60)},
+	} {
+		if err := repo.Save(ctx, h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// and Matt booked again since the upgrade
+	start := time.Date(2021, time.July, 2, 9, 0, 0, 0, time.UTC)
+	id := uuid.New()
+	for _, event := range []eh.Event{
+		eh.NewEvent(reservations.ReservationCreatedEvent, &reservations.ReservationCreatedData{RoomID: 1, Name: "Standup", User: "Matt", StartTime: start, EndTime: start.Add(time.Hour)},
+			start, eh.ForAggregate(reservations.ReservationAggregateType, id, 1)),
+		eh.NewEvent(reservations.ReservationConfirmedEvent, &reservations.ReservationConfirmedData{User: "saga"},
+			start, eh.ForAggregate(reservations.ReservationAggregateType, id, 2)),
+	} {
+		if err := p.HandleEvent(ctx, event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	merged, err := MigrateHistoryIDs(ctx, repo)
+	if err != nil || merged != 3 {
+		t.Fatalf("MigrateHistoryIDs() = %v, %v, want 3 merged", merged, err)
+	}
+	if merged, err := MigrateHistoryIDs(ctx, repo); err != nil || merged != 0 {
+		t.Errorf("MigrateHistoryIDs() again = %v, %v, want nothing merged", merged, err)
+	}
+	entities, err := repo.FindAll(ctx)
+	if err != nil || len(entities) != 2 {
+		t.Fatalf("FindAll() = %v histories, %v, want 2", len(entities), err)
+	}
+
+	matt, err := repo.Find(ctx, HistoryID("Matt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := matt.(*BillingHistory); h.TotalMinutes != 150 || h.TotalPaid != usd(600) || h.Bills[july].Minutes != 150 || h.Bills[july].Total != usd(600) || len(h.Reservations) != 1 {
+		t.Errorf("Matt's history = %+v, july = %+v", h, h.Bills[july])
+	}
+	bob, err := repo.Find(ctx, HistoryID("Bob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := bob.(*BillingHistory); h.TotalPaid != usd(60) || h.Bills[june].Minutes != 15 {
+		t.Errorf("Bob's history = %+v", h)
+	}
+
+	// Reservations made before the upgrade are in no history, their events are skipped instead of failing forever
+	cancelled := eh.NewEvent(reservations.ReservationCancelledEvent, &reservations.ReservationCancelledData{User: "Matt"},
+		start, eh.ForAggregate(reservations.ReservationAggregateType, uuid.New(), 3))
+	if err := NewBillingHistoryProjector(repo).HandleEvent(ctx, cancelled); err != nil {
+		t.Errorf("HandleEvent() of an unknown reservation error = %v", err)
+	}
+}
+
+// TestBillingHistoryProjector_NetsToZero replays random sequences of events, and then cancels everything
+func TestBillingHistoryProjector_NetsToZero(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		london = time.UTC
+	}
+	eventTypes := []eh.EventType{
+		reservations.ReservationConfirmedEvent,
+		reservations.ReservationDeclinedEvent,
+		reservations.ReservationTimeChangedEvent,
+		reservations.ReservationCancelledEvent,
+	}
+	users := []string{"Matt", "Alice"}
+
+	f := func(seed int64) bool {
+		rnd := rand.New(rand.NewSource(seed))
+		p, repo := newTestProjector()
+		p.SetLocation(london)
+		randomTimes := func() (time.Time, time.Time) {
+			// Anywhere in 2021, often across the end of a month
+			start := time.Date(2021, time.Month(1+rnd.Intn(12)), 28+rnd.Intn(4), rnd.Intn(24), rnd.Intn(60), rnd.Intn(60), 0, time.UTC)
+			return start, start.Add(time.Duration(1+rnd.Intn(3*24*60*60)) * time.Second)
+		}
+
+		ids := make([]uuid.UUID, 1+rnd.Intn(5))
+		versions := make(map[uuid.UUID]int)
+		handle := func(id uuid.UUID, eventType eh.EventType, data eh.EventData) {
+			versions[id]++
+			event := eh.NewEvent(eventType, data, time.Now(), eh.ForAggregate(reservations.ReservationAggregateType, id, versions[id]))
+			if err := p.HandleEvent(context.Background(), event); err != nil {
+				t.Fatalf("seed %d: HandleEvent(%v) error = %v", seed, eventType, err)
+			}
+		}
+		for i := range ids {
+			ids[i] = uuid.New()
+			start, end := randomTimes()
+			handle(ids[i], reservations.ReservationCreatedEvent, &reservations.ReservationCreatedData{
+				RoomID: 1, Name: "Meeting", User: users[rnd.Intn(len(users))], StartTime: start, EndTime: end,
+			})
+		}
+		for i := 0; i < rnd.Intn(40); i++ {
+			id := ids[rnd.Intn(len(ids))]
+			switch eventType := eventTypes[rnd.Intn(len(eventTypes))]; eventType {
+			case reservations.ReservationConfirmedEvent:
+				handle(id, eventType, &reservations.ReservationConfirmedData{User: "saga"})
+			case reservations.ReservationDeclinedEvent:
+				handle(id, eventType, &reservations.ReservationDeclinedData{User: "saga"})
+			case reservations.ReservationTimeChangedEvent:
+				start, end := randomTimes()
+				handle(id, eventType, &reservations.ReservationTimeChangeData{User: "Matt", StartTime: start, EndTime: end})
+			case reservations.ReservationCancelledEvent:
+				handle(id, eventType, &reservations.ReservationCancelledData{User: "Matt"})
+			}
+		}
+
+		// The totals are always the sum of the bills, and exactly the price of the charged minutes
+		for _, user := range users {
+			h, err := FindByUser(context.Background(), repo, user)
+			if err != nil {
+				continue
+			}
+			var minutes int
+			total := money.Money{Currency: PricePerMinute.Currency}
+			for _, bill := range h.Bills {
+				minutes += bill.Minutes
+				total = total.Add(bill.Total)
+			}
+			if minutes != h.TotalMinutes || total != h.TotalPaid || h.TotalPaid != PricePerMinute.Times(int64(h.TotalMinutes), Rounding) {
+				t.Errorf("seed %d: %v has %d minutes, %v paid, bills add up to %d minutes, %v", seed, user, h.TotalMinutes, h.TotalPaid, minutes, total)
+				return false
+			}
+		}
+
+		for _, id := range ids {
+			handle(id, reservations.ReservationCancelledEvent, &reservations.ReservationCancelledData{User: "Matt"})
+		}
+		for _, user := range users {
+			h, err := FindByUser(context.Background(), repo, user)
+			if err != nil {
+				continue
+			}
+			if h.TotalMinutes != 0 || !h.TotalPaid.IsZero() {
+				t.Errorf("seed %d: %v has %d minutes, %v paid after cancelling everything", seed, user, h.TotalMinutes, h.TotalPaid)
+				return false
+			}
+			for key, bill := range h.Bills {
+				if bill.Minutes != 0 || !bill.Total.IsZero() {
+					t.Errorf("seed %d: %v bill %v = %d minutes, %v after cancelling everything", seed, user, key, bill.Minutes, bill.Total)
+					return false
+				}
+			}
+		}
+		return true
+	}
+	if err := quick.Check(f, &quick.Config{MaxCount: 300}); err != nil {
+		t.Error(err)
+	}
+}
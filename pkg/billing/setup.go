@@ -2,7 +2,10 @@ package billing
 
 import (
 	"context"
+	"time"
 
+	"github.com/MattDevy/CQRS-example/pkg/payments"
+	"github.com/MattDevy/CQRS-example/pkg/promotions"
 	"github.com/MattDevy/CQRS-example/pkg/reservations"
 	eh "github.com/looplab/eventhorizon"
 	"github.com/looplab/eventhorizon/commandhandler/bus"
@@ -11,12 +14,18 @@ import (
 )
 
 // Setup will initilize and register all the required billing CQRS commands, events, aggregates, projectors and sagas
+// Reservations are billed to the months they take place in, in loc, priced by policy, charged fees by fees
+// and billed for the minutes booked or used by usage
 func Setup(
 	ctx context.Context,
 	eventStore eh.EventStore,
 	eventBus eh.EventBus,
 	commandBus *bus.CommandHandler,
 	billingRepo eh.ReadWriteRepo,
+	loc *time.Location,
+	policy PricingPolicy,
+	fees FeePolicy,
+	usage UsagePolicy,
 ) {
 	if memoryRepo := memory.IntoRepo(ctx, billingRepo); memoryRepo != nil {
 		memoryRepo.SetEntityFactory(func() eh.Entity {
@@ -34,11 +43,22 @@ func Setup(
 	}
 
 	billingProjector := NewBillingHistoryProjector(billingRepo)
+	billingProjector.SetLocation(loc)
+	billingProjector.SetPricingPolicy(policy)
+	billingProjector.SetFeePolicy(fees)
+	billingProjector.SetUsagePolicy(usage)
 	eventBus.AddHandler(ctx, eh.MatchEvents{
 		reservations.ReservationCreatedEvent,
 		reservations.ReservationConfirmedEvent,
 		reservations.ReservationDeclinedEvent,
 		reservations.ReservationTimeChangedEvent,
 		reservations.ReservationCancelledEvent,
+		reservations.ReservationCheckedInEvent,
+		reservations.ReservationCheckedOutEvent,
+		reservations.ReservationNoShowEvent,
+		payments.PaymentSucceededEvent,
+		payments.PaymentFailedEvent,
+		promotions.PromotionAppliedEvent,
+		promotions.CreditGrantedEvent,
 	}, billingProjector)
 }
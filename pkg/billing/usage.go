@@ -0,0 +1,46 @@
+package billing
+
+import (
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/money"
+)
+
+const (
+	// ModeBooked bills the minutes a reservation was booked for
+	ModeBooked = "booked"
+	// ModeUsage bills the minutes between check-in and check-out, the booked minutes if there was no check-out
+	ModeUsage = "usage"
+)
+
+// Booking is a user's booking of a room, to select how it is billed
+type Booking struct {
+	User      string
+	RoomID    int
+	StartTime time.Time
+}
+
+// Usage is how a booking is billed
+type Usage struct {
+	// Mode is ModeBooked or ModeUsage
+	Mode string
+	// NoShowFee is charged in place of the booking if nobody checks in to a usage booking, nothing if it is zero
+	NoShowFee money.Money
+	// RateVersion is the version of the rates the no-show fee is from
+	RateVersion string
+}
+
+// UsagePolicy selects how bookings are billed, it must be deterministic so replays produce the same bills
+type UsagePolicy interface {
+	Usage(b Booking) Usage
+}
+
+// BookedMinutes bills every booking for the minutes booked, without a no-show fee
+type BookedMinutes struct{}
+
+func (BookedMinutes) Usage(b Booking) Usage {
+	return Usage{Mode: ModeBooked}
+}
+
+// DefaultUsagePolicy bills the minutes booked
+var DefaultUsagePolicy UsagePolicy = BookedMinutes{}
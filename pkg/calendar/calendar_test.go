@@ -0,0 +1,130 @@
+package calendar
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	memoryRepo "github.com/looplab/eventhorizon/repo/memory"
+)
+
+func TestWrite(t *testing.T) {
+	id := uuid.MustParse("0c3f8a2e-55b1-4c8e-8d6b-2d3f4e5a6b7c")
+	start := time.Date(2021, time.July, 1, 9, 0, 0, 0, time.UTC)
+	rs := []*reservations.Reservation{
+		{
+			ID:        id,
+			Version:   3,
+			Name:      "Planning; Q3, budget",
+			Creator:   "Matt",
+			RoomID:    2,
+			StartTime: start,
+			EndTime:   start.Add(time.Hour),
+			Status:    reservations.StatusDeclined,
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, "Matt", rs, start); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:" + ProductID,
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:Matt",
+		"BEGIN:VEVENT",
+		"UID:" + id.String(),
+		"SEQUENCE:3",
+		"DTSTAMP:20210701T090000Z",
+		"DTSTART:20210701T090000Z",
+		"DTEND:20210701T100000Z",
+		`SUMMARY:Planning\; Q3\, budget`,
+		"LOCATION:Room 2",
+		"DESCRIPTION:Booked by Matt",
+		"STATUS:CANCELLED",
+		"END:VEVENT",
+		"END:VCALENDAR",
+		"",
+	}, "\r\n")
+	if buf.String() != want {
+		t.Errorf("Write() =\n%v\nwant\n%v", buf.String(), want)
+	}
+}
+
+func TestContentWriter_Folding(t *testing.T) {
+	var buf bytes.Buffer
+	cw := &contentWriter{w: &buf}
+	cw.line("SUMMARY:" + strings.Repeat("é", 60))
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line is %d octets, want at most 75", len(line))
+		}
+	}
+	if unfolded := strings.ReplaceAll(buf.String(), "\r\n ", ""); unfolded != "SUMMARY:"+strings.Repeat("é", 60)+"\r\n" {
+		t.Errorf("unfolded = %q", unfolded)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	repo := memoryRepo.NewRepo()
+	repo.SetEntityFactory(func() eh.Entity { return &reservations.Reservation{} })
+	start := time.Date(2021, time.July, 1, 9, 0, 0, 0, time.UTC)
+	for i, creator := range []string{"Matt", "Alice", "Matt"} {
+		if err := repo.Save(context.Background(), &reservations.Reservation{
+			ID:        uuid.New(),
+			Version:   1,
+			Name:      "Meeting",
+			Creator:   creator,
+			RoomID:    i + 1,
+			StartTime: start,
+			EndTime:   start.Add(time.Hour),
+			Status:    reservations.StatusConfirmed,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tokens := NewTokens([]byte("secret"))
+	srv := httptest.NewServer(Handler(repo, tokens))
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		path   string
+		want   int
+		events int
+	}{
+		{"user", "users/Matt.ics?token=" + tokens.UserToken("Matt"), http.StatusOK, 2},
+		{"room", "rooms/2.ics?token=" + tokens.RoomToken(2), http.StatusOK, 1},
+		{"missing token", "users/Matt.ics", http.StatusNotFound, 0},
+		{"other user's token", "users/Matt.ics?token=" + tokens.UserToken("Alice"), http.StatusNotFound, 0},
+		{"other secret", "users/Matt.ics?token=" + NewTokens([]byte("guess")).UserToken("Matt"), http.StatusNotFound, 0},
+		{"unknown feed", "teams/1.ics", http.StatusNotFound, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(srv.URL + Path + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+			if resp.StatusCode != tt.want {
+				t.Fatalf("GET %v = %v, want %v", tt.path, resp.StatusCode, tt.want)
+			}
+			if events := strings.Count(string(body), "BEGIN:VEVENT"); events != tt.events {
+				t.Errorf("GET %v = %d events, want %d", tt.path, events, tt.events)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package calendar
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	eh "github.com/looplab/eventhorizon"
+)
+
+const (
+	// Path is the path prefix of the feeds, users/{user}.ics and rooms/{id}.ics
+	Path = "/calendar/"
+	// TokenParam is the query parameter the feed's token is passed in
+	TokenParam = "token"
+)
+
+// Handler serves the user and room feeds, each needs its token from Tokens
+func Handler(reservationRepo eh.ReadRepo, tokens *Tokens) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "unsupported method: "+r.Method, http.StatusMethodNotAllowed)
+			return
+		}
+
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, Path), "/")
+		if len(parts) != 2 || !strings.HasSuffix(parts[1], ".ics") {
+			http.NotFound(w, r)
+			return
+		}
+		feed := strings.TrimSuffix(parts[1], ".ics")
+
+		var name, token string
+		var match func(*reservations.Reservation) bool
+		switch parts[0] {
+		case "users":
+			name, token = feed, tokens.UserToken(feed)
+			match = func(res *reservations.Reservation) bool { return res.Creator == feed }
+		case "rooms":
+			roomID, err := strconv.Atoi(feed)
+			if err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			name, token = fmt.Sprintf("Room %d", roomID), tokens.RoomToken(roomID)
+			match = func(res *reservations.Reservation) bool { return res.RoomID == roomID }
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		// Don't reveal whether the feed exists without its token
+		if !valid(r.URL.Query().Get(TokenParam), token) {
+			http.NotFound(w, r)
+			return
+		}
+
+		entities, err := reservationRepo.FindAll(r.Context())
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var rs []*reservations.Reservation
+		for _, e := range entities {
+			if res, ok := e.(*reservations.Reservation); ok && match(res) {
+				rs = append(rs, res)
+			}
+		}
+		sort.Slice(rs, func(i, j int) bool { return rs[i].StartTime.Before(rs[j].StartTime) })
+
+		var buf bytes.Buffer
+		if err := Write(&buf, name, rs, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.Header().Set("Cache-Control", "private, max-age=300")
+		if r.Method == http.MethodGet {
+			w.Write(buf.Bytes())
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package calendar
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+)
+
+// ProductID is the PRODID of the calendars
+const ProductID = "-//MattDevy//CQRS-example//EN"
+
+const icsTime = "20060102T150405Z"
+
+// statuses maps reservation statuses onto VEVENT statuses
+var statuses = map[reservations.ReservationStatus]string{
+	reservations.StatusPending:   "TENTATIVE",
+	reservations.StatusConfirmed: "CONFIRMED",
+	reservations.StatusDeclined:  "CANCELLED",
+	reservations.StatusCancelled: "CANCELLED",
+}
+
+// Write writes the reservations as an iCalendar (RFC 5545) with one VEVENT each
+// The UID is the reservation ID and the SEQUENCE its version, so clients update events in place
+func Write(w io.Writer, name string, rs []*reservations.Reservation, now time.Time) error {
+	cw := &contentWriter{w: w}
+	cw.line("BEGIN:VCALENDAR")
+	cw.line("VERSION:2.0")
+	cw.line("PRODID:" + ProductID)
+	cw.line("CALSCALE:GREGORIAN")
+	cw.line("METHOD:PUBLISH")
+	cw.line("X-WR-CALNAME:" + escape(name))
+	for _, r := range rs {
+		cw.line("BEGIN:VEVENT")
+		cw.line("UID:" + r.ID.String())
+		cw.line(fmt.Sprintf("SEQUENCE:%d", r.Version))
+		cw.line("DTSTAMP:" + now.UTC().Format(icsTime))
+		cw.line("DTSTART:" + r.StartTime.UTC().Format(icsTime))
+		cw.line("DTEND:" + r.EndTime.UTC().Format(icsTime))
+		cw.line("SUMMARY:" + escape(r.Name))
+		cw.line(fmt.Sprintf("LOCATION:Room %d", r.RoomID))
+		cw.line("DESCRIPTION:" + escape("Booked by "+r.Creator))
+		cw.line("STATUS:" + statuses[r.Status])
+		cw.line("END:VEVENT")
+	}
+	cw.line("END:VCALENDAR")
+	return cw.err
+}
+
+// contentWriter writes content lines, folded at 75 octets and ended with CRLF
+type contentWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (cw *contentWriter) line(s string) {
+	if cw.err != nil {
+		return
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range s {
+		// Never split a multi-byte character when folding
+		size := len(string(r))
+		if n+size > 75 {
+			b.WriteString("\r\n ")
+			n = 1
+		}
+		b.WriteRune(r)
+		n += size
+	}
+	b.WriteString("\r\n")
+	_, cw.err = io.WriteString(cw.w, b.String())
+}
+
+// escape escapes a TEXT value
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
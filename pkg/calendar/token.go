@@ -0,0 +1,50 @@
+package calendar
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+)
+
+// Tokens derives the unguessable tokens that protect the feeds, a token is only valid for its own feed
+// Tokens are an HMAC of the feed, so need no storage, but changing the secret revokes every token
+type Tokens struct {
+	secret []byte
+}
+
+// NewTokens returns Tokens derived from the secret
+func NewTokens(secret []byte) *Tokens {
+	return &Tokens{secret: secret}
+}
+
+// UserToken returns the token of the user's feed
+func (t *Tokens) UserToken(user string) string {
+	return t.token("user:" + user)
+}
+
+// RoomToken returns the token of the room's feed
+func (t *Tokens) RoomToken(roomID int) string {
+	return t.token("room:" + strconv.Itoa(roomID))
+}
+
+// ValidUserToken returns true if the token is the one of the user's feed
+func (t *Tokens) ValidUserToken(user, token string) bool {
+	return valid(token, t.UserToken(user))
+}
+
+// ValidRoomToken returns true if the token is the one of the room's feed
+func (t *Tokens) ValidRoomToken(roomID int, token string) bool {
+	return valid(token, t.RoomToken(roomID))
+}
+
+func (t *Tokens) token(feed string) string {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte(feed))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// valid compares in constant time, so the token can not be guessed a byte at a time
+func valid(token, want string) bool {
+	return hmac.Equal([]byte(token), []byte(want))
+}
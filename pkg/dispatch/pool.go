@@ -0,0 +1,163 @@
+package dispatch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrPoolClosed is returned when submitting a job to a closed Pool
+var ErrPoolClosed = errors.New("dispatch: pool closed")
+
+// ErrQueueFull is returned when a partition stays full for longer than the submit timeout
+var ErrQueueFull = errors.New("dispatch: partition queue full")
+
+// Job is the work submitted for an aggregate
+// ctx is cancelled when the Pool is closed, jobs still queued then are run with the cancelled ctx so they can give up.
+type Job func(ctx context.Context)
+
+// PartitionStats are the metrics of a single partition of the Pool
+type PartitionStats struct {
+	Partition int
+	// Queued is the number of jobs currently waiting
+	Queued int
+	// Processed is the total number of jobs run
+	Processed int64
+	// Rejected is the total number of jobs not queued because the partition stayed full
+	Rejected int64
+	// Blocked is the total time submitters waited for room in the full queue
+	Blocked time.Duration
+	// Busy is the total time spent running jobs
+	Busy time.Duration
+}
+
+type partition struct {
+	queue     chan Job
+	processed int64
+	rejected  int64
+	blocked   int64
+	busy      int64
+}
+
+// Pool runs jobs in parallel while keeping the jobs of each aggregate in order
+// Aggregates are assigned to a partition (each with a single worker) using consistent hashing,
+// so changing the number of workers moves as few aggregates as possible
+// Each partition applies its own backpressure, a full partition only holds up the jobs submitted to it
+type Pool struct {
+	ring          *ring
+	partitions    []*partition
+	submitTimeout time.Duration
+
+	// ctx is passed to every job and cancelled by Close
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	closed   bool
+	closedMu sync.RWMutex
+	wg       sync.WaitGroup
+}
+
+// NewPool starts a Pool with a worker per partition, each queueing up to queueDepth jobs
+// Submitting to a full partition waits up to submitTimeout for room before the job is rejected.
+func NewPool(workers, queueDepth int, submitTimeout time.Duration) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Pool{
+		ring:          newRing(workers),
+		partitions:    make([]*partition, workers),
+		submitTimeout: submitTimeout,
+		ctx:           ctx,
+		cancel:        cancel,
+	}
+	for i := range p.partitions {
+		part := &partition{queue: make(chan Job, queueDepth)}
+		p.partitions[i] = part
+		p.wg.Add(1)
+		go p.work(part)
+	}
+	return p
+}
+
+func (p *Pool) work(part *partition) {
+	defer p.wg.Done()
+	for job := range part.queue {
+		start := time.Now()
+		job(p.ctx)
+		atomic.AddInt64(&part.busy, int64(time.Since(start)))
+		atomic.AddInt64(&part.processed, 1)
+	}
+}
+
+// Partition returns the partition the aggregate's jobs are run on
+func (p *Pool) Partition(id uuid.UUID) int {
+	return p.ring.lookup(id)
+}
+
+// Submit queues the job on the aggregate's partition, waiting up to the submit timeout while the partition is full
+// It returns ErrQueueFull if the partition is still full by then, the job is not queued and the caller must retry it
+// (after any later jobs for the aggregate) to keep the aggregate's jobs in order.
+func (p *Pool) Submit(ctx context.Context, id uuid.UUID, job Job) error {
+	p.closedMu.RLock()
+	defer p.closedMu.RUnlock()
+	if p.closed {
+		return ErrPoolClosed
+	}
+
+	part := p.partitions[p.Partition(id)]
+	select {
+	case part.queue <- job:
+		return nil
+	default:
+	}
+
+	start := time.Now()
+	defer func() { atomic.AddInt64(&part.blocked, int64(time.Since(start))) }()
+	timer := time.NewTimer(p.submitTimeout)
+	defer timer.Stop()
+	select {
+	case part.queue <- job:
+		return nil
+	case <-timer.C:
+		atomic.AddInt64(&part.rejected, 1)
+		return fmt.Errorf("%w: partition %v", ErrQueueFull, p.Partition(id))
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stats returns the metrics of every partition
+func (p *Pool) Stats() []PartitionStats {
+	stats := make([]PartitionStats, len(p.partitions))
+	for i, part := range p.partitions {
+		stats[i] = PartitionStats{
+			Partition: i,
+			Queued:    len(part.queue),
+			Processed: atomic.LoadInt64(&part.processed),
+			Rejected:  atomic.LoadInt64(&part.rejected),
+			Blocked:   time.Duration(atomic.LoadInt64(&part.blocked)),
+			Busy:      time.Duration(atomic.LoadInt64(&part.busy)),
+		}
+	}
+	return stats
+}
+
+// Close stops accepting jobs, cancels the ctx of running jobs and waits for all queued jobs to run
+func (p *Pool) Close() {
+	p.closedMu.Lock()
+	if !p.closed {
+		p.closed = true
+		p.cancel()
+		for _, part := range p.partitions {
+			close(part.queue)
+		}
+	}
+	p.closedMu.Unlock()
+	p.wg.Wait()
+}
@@ -0,0 +1,184 @@
+package dispatch
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestPool_OrderedPerAggregate(t *testing.T) {
+	pool := NewPool(4, 100, time.Minute)
+
+	ids := make([]uuid.UUID, 20)
+	for i := range ids {
+		ids[i] = uuid.New()
+	}
+
+	var mu sync.Mutex
+	got := make(map[uuid.UUID][]int)
+	for n := 0; n < 50; n++ {
+		for _, id := range ids {
+			id, n := id, n
+			if err := pool.Submit(context.Background(), id, func(ctx context.Context) {
+				mu.Lock()
+				got[id] = append(got[id], n)
+				mu.Unlock()
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	pool.Close()
+
+	for _, id := range ids {
+		if len(got[id]) != 50 {
+			t.Fatalf("aggregate %v ran %v jobs, want 50", id, len(got[id]))
+		}
+		for i, n := range got[id] {
+			if n != i {
+				t.Fatalf("aggregate %v ran job %v at position %v", id, n, i)
+			}
+		}
+	}
+
+	var processed int64
+	for _, s := range pool.Stats() {
+		processed += s.Processed
+	}
+	if processed != 1000 {
+		t.Errorf("Stats() processed = %v, want 1000", processed)
+	}
+}
+
+func TestPool_BackpressurePerPartition(t *testing.T) {
+	pool := NewPool(2, 1, time.Minute)
+	defer pool.Close()
+
+	// Find an aggregate on each partition
+	full, free := uuid.New(), uuid.New()
+	for pool.Partition(free) == pool.Partition(full) {
+		free = uuid.New()
+	}
+
+	block := make(chan struct{})
+	defer close(block)
+
+	// One job running, one queued
+	started := make(chan struct{})
+	if err := pool.Submit(context.Background(), full, func(ctx context.Context) {
+		close(started)
+		<-block
+	}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if err := pool.Submit(context.Background(), full, func(ctx context.Context) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The full partition blocks until the submitter gives up
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := pool.Submit(ctx, full, func(ctx context.Context) {}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Submit() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if s := pool.Stats()[pool.Partition(full)]; s.Queued != 1 || s.Blocked < 20*time.Millisecond {
+		t.Errorf("Stats() = %+v, want 1 queued and blocked for at least 20ms", s)
+	}
+
+	// While the other partition keeps running jobs
+	ran := make(chan struct{})
+	if err := pool.Submit(context.Background(), free, func(ctx context.Context) { close(ran) }); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Fatal("a full partition blocked another partition")
+	}
+}
+
+func TestPool_RejectsWhenFull(t *testing.T) {
+	pool := NewPool(1, 1, 20*time.Millisecond)
+	defer pool.Close()
+	id := uuid.New()
+
+	block := make(chan struct{})
+	defer close(block)
+	started := make(chan struct{})
+	if err := pool.Submit(context.Background(), id, func(ctx context.Context) {
+		close(started)
+		<-block
+	}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if err := pool.Submit(context.Background(), id, func(ctx context.Context) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pool.Submit(context.Background(), id, func(ctx context.Context) {}); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Submit() error = %v, want %v", err, ErrQueueFull)
+	}
+	if s := pool.Stats()[0]; s.Queued != 1 || s.Rejected != 1 || s.Blocked < 20*time.Millisecond {
+		t.Errorf("Stats() = %+v, want 1 queued, 1 rejected and blocked for at least 20ms", s)
+	}
+}
+
+func TestPool_CloseCancelsJobs(t *testing.T) {
+	pool := NewPool(1, 1, time.Minute)
+	id := uuid.New()
+
+	started := make(chan struct{})
+	var running, queued error
+	if err := pool.Submit(context.Background(), id, func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+		running = ctx.Err()
+	}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if err := pool.Submit(context.Background(), id, func(ctx context.Context) { queued = ctx.Err() }); err != nil {
+		t.Fatal(err)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		pool.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close() did not cancel the running job")
+	}
+	if running != context.Canceled || queued != context.Canceled {
+		t.Errorf("job ctx errors = %v, %v, want %v", running, queued, context.Canceled)
+	}
+	if err := pool.Submit(context.Background(), id, func(ctx context.Context) {}); err != ErrPoolClosed {
+		t.Errorf("Submit() error = %v, want %v", err, ErrPoolClosed)
+	}
+}
+
+func Test_ringStable(t *testing.T) {
+	small, large := newRing(8), newRing(9)
+	moved := 0
+	for i := 0; i < 1000; i++ {
+		id := uuid.New()
+		if small.lookup(id) != small.lookup(id) {
+			t.Fatal("lookup() is not deterministic")
+		}
+		if small.lookup(id) != large.lookup(id) {
+			moved++
+		}
+	}
+	// Adding a ninth partition should only move about 1/9th of the aggregates
+	if moved > 250 {
+		t.Errorf("adding a partition moved %v of 1000 aggregates", moved)
+	}
+}
@@ -0,0 +1,50 @@
+package dispatch
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// virtualNodes is how many points each partition has on the ring, more points spread aggregates more evenly
+const virtualNodes = 64
+
+// ring is a consistent hash ring mapping aggregate IDs onto partitions
+type ring struct {
+	hashes     []uint32
+	partitions map[uint32]int
+}
+
+func newRing(partitions int) *ring {
+	r := &ring{partitions: make(map[uint32]int)}
+	for p := 0; p < partitions; p++ {
+		for v := 0; v < virtualNodes; v++ {
+			h := hash([]byte(strconv.Itoa(p) + "-" + strconv.Itoa(v)))
+			if _, ok := r.partitions[h]; ok {
+				continue
+			}
+			r.partitions[h] = p
+			r.hashes = append(r.hashes, h)
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+	return r
+}
+
+// lookup returns the partition owning the first point on the ring after the ID
+func (r *ring) lookup(id uuid.UUID) int {
+	h := hash(id[:])
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.partitions[r.hashes[i]]
+}
+
+func hash(b []byte) uint32 {
+	h := fnv.New32a()
+	h.Write(b)
+	return h.Sum32()
+}
@@ -0,0 +1,84 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	"github.com/MattDevy/CQRS-example/pkg/signing"
+	eh "github.com/looplab/eventhorizon"
+)
+
+var _ = reservations.CommandSender(&Client{})
+
+// Client is a HTTP client that will send Commands to the command gateway
+// It can be used in place of a reservations.Client where PubSub is not available
+type Client struct {
+	baseURL string
+	http    *http.Client
+	signer  *signing.Signer
+	subject string
+}
+
+// NewClient returns an initialized Client for the gateway at baseURL (eg http://localhost:8080)
+func NewClient(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		http:    httpClient,
+	}
+}
+
+// SetSigner makes the Client sign every command it sends, subject is the identity the commands are sent on behalf of
+func (c *Client) SetSigner(subject string, signer *signing.Signer) {
+	c.subject = subject
+	c.signer = signer
+}
+
+// SendCommand will send any eh.Command to the command gateway
+// Blocks until handled, as the gateway handles commands synchronously
+func (c *Client) SendCommand(ctx context.Context, command eh.Command) error {
+	_, err := c.SendCommandAndWait(ctx, command)
+	return err
+}
+
+// SendCommandAndWait will send any eh.Command to the command gateway and return its result
+// The error is a *reservations.CommandError if the command was rejected
+func (c *Client) SendCommandAndWait(ctx context.Context, command eh.Command) (*reservations.CommandResult, error) {
+	data, err := json.Marshal(command)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		c.baseURL+CommandsPath+command.CommandType().String(), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.signer != nil {
+		authorization, err := reservations.BearerToken(c.signer, c.subject, string(command.CommandType()), data)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result := &reservations.CommandResult{}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, fmt.Errorf("could not decode command result (status %v): %w", resp.StatusCode, err)
+	}
+	return result, result.Err()
+}
@@ -0,0 +1,121 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	"github.com/MattDevy/CQRS-example/pkg/signing"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// CommandsPath is the path prefix commands are posted to, followed by the CommandType
+const CommandsPath = "/commands/"
+
+// ErrInvalidCommand is when a command could not be decoded or is missing fields
+var ErrInvalidCommand = errors.New("invalid command")
+
+func init() {
+	reservations.RegisterErrorCode("InvalidCommand", ErrInvalidCommand)
+	reservations.RegisterErrorCode("UnknownCommand", eh.ErrCommandNotRegistered)
+	reservations.RegisterErrorKind("InvalidCommand", reservations.ErrorInvalid)
+	reservations.RegisterErrorKind("UnknownCommand", reservations.ErrorNotFound)
+}
+
+// statusCodes maps error kinds onto HTTP status codes
+var statusCodes = map[reservations.ErrorKind]int{
+	reservations.ErrorRejected:        http.StatusUnprocessableEntity,
+	reservations.ErrorInvalid:         http.StatusBadRequest,
+	reservations.ErrorNotFound:        http.StatusNotFound,
+	reservations.ErrorConflict:        http.StatusConflict,
+	reservations.ErrorUnauthenticated: http.StatusUnauthorized,
+	reservations.ErrorInternal:        http.StatusInternalServerError,
+}
+
+// StatusCode returns the HTTP status code for a command error
+func StatusCode(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+	return statusCodes[reservations.ErrorKindOf(reservations.ErrorCode(err))]
+}
+
+// CommandHandler is a HTTP handler for commands, it expects a POST to CommandsPath + CommandType with the command
+// as the JSON body. The response body is always a reservations.CommandResult.
+// If keys is not nil every command must be signed like PubSub commands, with the JWT sent as a Bearer token.
+// The command handler's event store must be a reservations.VersionStore for the results to carry the new versions.
+func CommandHandler(commandHandler eh.CommandHandler, keys *signing.KeySet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "unsupported method: "+r.Method, http.StatusMethodNotAllowed)
+			return
+		}
+
+		commandType := eh.CommandType(strings.TrimPrefix(r.URL.Path, CommandsPath))
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeResult(w, &reservations.CommandResult{CommandType: commandType}, fmt.Errorf("could not read command: %v: %w", err, ErrInvalidCommand))
+			return
+		}
+		if keys != nil {
+			if err := reservations.VerifyBearer(keys, r.Header.Get("Authorization"), string(commandType), data); err != nil {
+				writeResult(w, &reservations.CommandResult{CommandType: commandType}, err)
+				return
+			}
+		}
+		cmd, err := decodeCommand(data, commandType)
+		if err != nil {
+			writeResult(w, &reservations.CommandResult{CommandType: commandType}, err)
+			return
+		}
+
+		// Use a new context when handling, else it will be cancelled with the HTTP request
+		// which will cause projectors and sagas to fail if they run past the request
+		ctx := context.Background()
+		version, handleErr := reservations.HandleVersioned(ctx, commandHandler, cmd)
+		if handleErr != nil {
+			fmt.Printf("Error: %v\n", handleErr)
+		}
+
+		writeResult(w, &reservations.CommandResult{
+			CommandType: commandType,
+			AggregateID: cmd.AggregateID(),
+			Version:     version,
+		}, handleErr)
+	})
+}
+
+// decodeCommand creates a command of the type and validates it
+func decodeCommand(data []byte, commandType eh.CommandType) (eh.Command, error) {
+	cmd, err := eh.CreateCommand(commandType)
+	if err != nil {
+		return nil, fmt.Errorf("could not create command %q: %w", commandType, err)
+	}
+	if err := json.NewDecoder(bytes.NewReader(data)).Decode(cmd); err != nil {
+		return nil, fmt.Errorf("could not decode command: %v: %w", err, ErrInvalidCommand)
+	}
+	if err := eh.CheckCommand(cmd); err != nil {
+		return nil, fmt.Errorf("%v: %w", err, ErrInvalidCommand)
+	}
+	return cmd, nil
+}
+
+func writeResult(w http.ResponseWriter, result *reservations.CommandResult, err error) {
+	result.Success = err == nil
+	result.ErrorCode = reservations.ErrorCode(err)
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(StatusCode(err))
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		fmt.Printf("Could not write command result: %v\n", err)
+	}
+}
@@ -0,0 +1,194 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	"github.com/MattDevy/CQRS-example/pkg/signing"
+	"github.com/google/uuid"
+	"github.com/looplab/eventhorizon/aggregatestore/events"
+	"github.com/looplab/eventhorizon/commandhandler/aggregate"
+	"github.com/looplab/eventhorizon/eventstore/memory"
+)
+
+var testSigner = signing.NewHS256Signer("dev", []byte("secret"))
+
+// sign returns the token of a command sent to the gateway
+func sign(t *testing.T, commandType, body string) string {
+	token, err := testSigner.Sign(signing.Claims{
+		Subject:     "writer",
+		IssuedAt:    time.Now().Unix(),
+		CommandType: commandType,
+		Digest:      signing.Digest([]byte(body)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func newTestGateway(t *testing.T) *httptest.Server {
+	eventStore, err := memory.NewEventStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	aggregateStore, err := events.NewAggregateStore(reservations.NewVersionStore(eventStore))
+	if err != nil {
+		t.Fatal(err)
+	}
+	commandHandler, err := aggregate.NewCommandHandler(reservations.ReservationAggregateType, aggregateStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	keys := signing.NewKeySet(time.Minute)
+	keys.AddHS256("dev", []byte("secret"))
+	mux.Handle(CommandsPath, CommandHandler(commandHandler, keys))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestClient_SendCommandAndWait(t *testing.T) {
+	srv := newTestGateway(t)
+	client := NewClient(srv.URL, srv.Client())
+	client.SetSigner("writer", testSigner)
+	ctx := context.Background()
+
+	id := uuid.New()
+	create := &reservations.CreateReservation{
+		ID:        id,
+		Name:      "Standup",
+		User:      "Matt",
+		RoomID:    1,
+		StartTime: time.Now(),
+		EndTime:   time.Now().Add(time.Hour),
+	}
+	result, err := client.SendCommandAndWait(ctx, create)
+	if err != nil {
+		t.Fatalf("SendCommandAndWait() error = %v", err)
+	}
+	if !result.Success || result.AggregateID != id || result.Version != 1 {
+		t.Errorf("SendCommandAndWait() = %+v, want success at version 1", result)
+	}
+
+	if _, err := client.SendCommandAndWait(ctx, create); !errors.Is(err, reservations.ErrReservationExists) {
+		t.Errorf("SendCommandAndWait() error = %v, want %v", err, reservations.ErrReservationExists)
+	}
+	if err := client.SendCommand(ctx, &reservations.CancelReservation{ID: id}); !errors.Is(err, ErrInvalidCommand) {
+		t.Errorf("SendCommand() error = %v, want %v", err, ErrInvalidCommand)
+	}
+}
+
+func TestCommandHandler_StatusCodes(t *testing.T) {
+	srv := newTestGateway(t)
+	id := uuid.New().String()
+
+	tests := []struct {
+		name string
+		path string
+		body string
+		// token is sent as the Bearer token, the body is signed for the path if it is empty
+		token string
+		want  int
+	}{
+		{
+			name: "created",
+			path: "CreateReservation",
+			body: `{"ID":"` + id + `","Name":"Standup","User":"Matt","RoomID":1,"StartTime":"2021-07-01T10:00:00Z","EndTime":"2021-07-01T11:00:00Z"}`,
+			want: http.StatusOK,
+		},
+		{
+			name: "already exists",
+			path: "CreateReservation",
+			body: `{"ID":"` + id + `","Name":"Standup","User":"Matt","RoomID":1,"StartTime":"2021-07-01T10:00:00Z","EndTime":"2021-07-01T11:00:00Z"}`,
+			want: http.StatusConflict,
+		},
+		{
+			name: "invalid time range",
+			path: "ChangeReservationTime",
+			body: `{"ID":"` + id + `","User":"Matt","StartTime":"2021-07-01T11:00:00Z","EndTime":"2021-07-01T10:00:00Z"}`,
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "declined",
+			path: "DeclineReservation",
+			body: `{"ID":"` + id + `","User":"Matt","Message":"busy"}`,
+			want: http.StatusOK,
+		},
+		{
+			name: "declined twice",
+			path: "DeclineReservation",
+			body: `{"ID":"` + id + `","User":"Matt","Message":"busy"}`,
+			want: http.StatusUnprocessableEntity,
+		},
+		{
+			name:  "unsigned",
+			path:  "ConfirmReservation",
+			body:  `{"ID":"` + id + `","User":"Matt"}`,
+			token: "none",
+			want:  http.StatusUnauthorized,
+		},
+		{
+			name:  "signed for another command",
+			path:  "ConfirmReservation",
+			body:  `{"ID":"` + id + `","User":"Matt"}`,
+			token: sign(t, "ConfirmReservation", `{"ID":"`+id+`","User":"Mallory"}`),
+			want:  http.StatusUnauthorized,
+		},
+		{
+			name: "not found",
+			path: "ConfirmReservation",
+			body: `{"ID":"` + uuid.New().String() + `","User":"Matt"}`,
+			want: http.StatusNotFound,
+		},
+		{
+			name: "missing field",
+			path: "ConfirmReservation",
+			body: `{"ID":"` + id + `"}`,
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "bad json",
+			path: "ConfirmReservation",
+			body: `{`,
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "unknown command",
+			path: "DeleteEverything",
+			body: `{}`,
+			want: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, srv.URL+CommandsPath+tt.path, bytes.NewBufferString(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch tt.token {
+			case "none":
+			case "":
+				req.Header.Set("Authorization", "Bearer "+sign(t, tt.path, tt.body))
+			default:
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("POST %v = %v, want %v", tt.path, resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
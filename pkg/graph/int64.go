@@ -0,0 +1,36 @@
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Int64 is the Int64 scalar, it is sent as a string like the proto's int64 fields
+// as a JSON number can't hold every int64 exactly
+type Int64 int64
+
+func (Int64) ImplementsGraphQLType(name string) bool {
+	return name == "Int64"
+}
+
+// UnmarshalGraphQL accepts the string form, or an Int literal
+func (i *Int64) UnmarshalGraphQL(input interface{}) error {
+	switch input := input.(type) {
+	case string:
+		n, err := strconv.ParseInt(input, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid Int64 %q: %w", input, err)
+		}
+		*i = Int64(n)
+	case int32:
+		*i = Int64(input)
+	default:
+		return fmt.Errorf("wrong type for Int64: %T", input)
+	}
+	return nil
+}
+
+func (i Int64) MarshalJSON() ([]byte, error) {
+	return json.Marshal(strconv.FormatInt(int64(i), 10))
+}
@@ -0,0 +1,163 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/repo/mongodb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// DefaultBatchWait is how long the loader waits to collect IDs into a batch
+const DefaultBatchWait = 2 * time.Millisecond
+
+type loaderKey struct{}
+
+// reservationLoader batches and caches reservation lookups for the duration of a request,
+// fields resolved in parallel that each need a reservation are fetched from the repo together
+type reservationLoader struct {
+	repo eh.ReadRepo
+	wait time.Duration
+
+	results map[uuid.UUID]*loadResult
+	pending []uuid.UUID
+	mu      sync.Mutex
+}
+
+type loadResult struct {
+	reservation *reservations.Reservation
+	err         error
+	done        chan struct{}
+}
+
+func newReservationLoader(repo eh.ReadRepo, wait time.Duration) *reservationLoader {
+	return &reservationLoader{
+		repo:    repo,
+		wait:    wait,
+		results: make(map[uuid.UUID]*loadResult),
+	}
+}
+
+func withLoader(ctx context.Context, l *reservationLoader) context.Context {
+	return context.WithValue(ctx, loaderKey{}, l)
+}
+
+func loaderFrom(ctx context.Context) *reservationLoader {
+	l, _ := ctx.Value(loaderKey{}).(*reservationLoader)
+	return l
+}
+
+// Load returns the reservation, or nil if it does not exist
+func (l *reservationLoader) Load(ctx context.Context, id uuid.UUID) (*reservations.Reservation, error) {
+	rs, err := l.LoadMany(ctx, []uuid.UUID{id})
+	if err != nil {
+		return nil, err
+	}
+	return rs[0], nil
+}
+
+// LoadMany returns the reservations in the order of ids, with nil for those that do not exist
+func (l *reservationLoader) LoadMany(ctx context.Context, ids []uuid.UUID) ([]*reservations.Reservation, error) {
+	results := make([]*loadResult, len(ids))
+	l.mu.Lock()
+	for i, id := range ids {
+		res, ok := l.results[id]
+		if !ok {
+			res = &loadResult{done: make(chan struct{})}
+			l.results[id] = res
+			if len(l.pending) == 0 {
+				time.AfterFunc(l.wait, l.dispatch)
+			}
+			l.pending = append(l.pending, id)
+		}
+		results[i] = res
+	}
+	l.mu.Unlock()
+
+	rs := make([]*reservations.Reservation, len(ids))
+	for i, res := range results {
+		select {
+		case <-res.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if res.err != nil {
+			return nil, res.err
+		}
+		rs[i] = res.reservation
+	}
+	return rs, nil
+}
+
+// dispatch fetches the pending batch
+func (l *reservationLoader) dispatch() {
+	l.mu.Lock()
+	batch := l.pending
+	l.pending = nil
+	results := make(map[uuid.UUID]*loadResult, len(batch))
+	for _, id := range batch {
+		results[id] = l.results[id]
+	}
+	l.mu.Unlock()
+
+	found, err := l.fetch(context.Background(), batch)
+	for id, res := range results {
+		res.reservation, res.err = found[id], err
+		close(res.done)
+	}
+}
+
+// fetch gets a batch in one round trip, with a query on Mongo or by scanning the other repos
+func (l *reservationLoader) fetch(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]*reservations.Reservation, error) {
+	found := make(map[uuid.UUID]*reservations.Reservation, len(ids))
+	if len(ids) == 1 {
+		e, err := l.repo.Find(ctx, ids[0])
+		if errors.Is(err, eh.ErrEntityNotFound) {
+			return found, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if r, ok := e.(*reservations.Reservation); ok {
+			found[r.ID] = r
+		}
+		return found, nil
+	}
+
+	var entities []interface{}
+	var err error
+	if mongoRepo := mongodb.IntoRepo(ctx, l.repo); mongoRepo != nil {
+		keys := make([]string, len(ids))
+		for i, id := range ids {
+			keys[i] = id.String()
+		}
+		entities, err = mongoRepo.FindCustom(ctx, func(ctx context.Context, c *mongo.Collection) (*mongo.Cursor, error) {
+			return c.Find(ctx, bson.M{"_id": bson.M{"$in": keys}})
+		})
+	} else {
+		var all []eh.Entity
+		all, err = l.repo.FindAll(ctx)
+		for _, e := range all {
+			entities = append(entities, e)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := make(map[uuid.UUID]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	for _, e := range entities {
+		if r, ok := e.(*reservations.Reservation); ok && wanted[r.ID] {
+			found[r.ID] = r
+		}
+	}
+	return found, nil
+}
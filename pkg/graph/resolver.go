@@ -0,0 +1,406 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/MattDevy/CQRS-example/pkg/availability"
+	"github.com/MattDevy/CQRS-example/pkg/billing"
+	"github.com/MattDevy/CQRS-example/pkg/gateway"
+	"github.com/MattDevy/CQRS-example/pkg/live"
+	"github.com/MattDevy/CQRS-example/pkg/money"
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	"github.com/google/uuid"
+	"github.com/graph-gophers/graphql-go"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// Resolver is the root resolver of the Schema
+type Resolver struct {
+	commandHandler   eh.CommandHandler
+	reservationRepo  eh.ReadRepo
+	billingRepo      eh.ReadRepo
+	availabilityRepo eh.ReadRepo
+	hub              *live.Hub
+}
+
+// commandError is a rejected command, the domain error code is returned in the error's extensions
+type commandError struct {
+	err error
+}
+
+func (e commandError) Error() string {
+	return e.err.Error()
+}
+
+func (e commandError) Unwrap() error {
+	return e.err
+}
+
+func (e commandError) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": reservations.ErrorCode(e.err)}
+}
+
+func parseID(id graphql.ID) (uuid.UUID, error) {
+	parsed, err := uuid.Parse(string(id))
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid id %q: %v", id, err)
+	}
+	return parsed, nil
+}
+
+// Queries
+
+func (r *Resolver) Reservation(ctx context.Context, args struct{ ID graphql.ID }) (*ReservationResolver, error) {
+	id, err := parseID(args.ID)
+	if err != nil {
+		return nil, err
+	}
+	reservation, err := loaderFrom(ctx).Load(ctx, id)
+	if err != nil || reservation == nil {
+		return nil, err
+	}
+	return &ReservationResolver{r, reservation}, nil
+}
+
+// reservationsArgs are the filters of the reservations queries
+type reservationsArgs struct {
+	IDs     *[]graphql.ID
+	RoomID  *int32
+	Creator *string
+	Status  *string
+	From    *graphql.Time
+}
+
+func (r *Resolver) Reservations(ctx context.Context, args reservationsArgs) ([]*ReservationResolver, error) {
+	var rs []*reservations.Reservation
+	if args.IDs != nil {
+		ids := make([]uuid.UUID, len(*args.IDs))
+		for i, id := range *args.IDs {
+			var err error
+			if ids[i], err = parseID(id); err != nil {
+				return nil, err
+			}
+		}
+		loaded, err := loaderFrom(ctx).LoadMany(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, reservation := range loaded {
+			if reservation != nil {
+				rs = append(rs, reservation)
+			}
+		}
+	} else {
+		entities, err := r.reservationRepo.FindAll(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entities {
+			if reservation, ok := e.(*reservations.Reservation); ok {
+				rs = append(rs, reservation)
+			}
+		}
+		sort.Slice(rs, func(i, j int) bool { return rs[i].StartTime.Before(rs[j].StartTime) })
+	}
+
+	resolvers := []*ReservationResolver{}
+	for _, reservation := range rs {
+		if (args.RoomID == nil || reservation.RoomID == int(*args.RoomID)) &&
+			(args.Creator == nil || reservation.Creator == *args.Creator) &&
+			(args.Status == nil || reservation.Status == reservations.ReservationStatus(strings.ToLower(*args.Status))) &&
+			(args.From == nil || reservation.EndTime.After(args.From.Time)) {
+			resolvers = append(resolvers, &ReservationResolver{r, reservation})
+		}
+	}
+	return resolvers, nil
+}
+
+func (r *Resolver) Room(args struct{ ID int32 }) *RoomResolver {
+	for _, id := range availability.DefaultRooms {
+		if id == int(args.ID) {
+			return &RoomResolver{r, id}
+		}
+	}
+	return nil
+}
+
+func (r *Resolver) Rooms() []*RoomResolver {
+	rooms := make([]*RoomResolver, len(availability.DefaultRooms))
+	for i, id := range availability.DefaultRooms {
+		rooms[i] = &RoomResolver{r, id}
+	}
+	return rooms
+}
+
+func (r *Resolver) BillingHistory(ctx context.Context, args struct{ User string }) (*BillingHistoryResolver, error) {
+	h, err := billing.FindByUser(ctx, r.billingRepo, args.User)
+	if errors.Is(err, eh.ErrEntityNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &BillingHistoryResolver{h}, nil
+}
+
+// Mutations
+
+type CreateReservationInput struct {
+	ID        graphql.ID
+	Name      string
+	User      string
+	RoomID    int32
+	StartTime graphql.Time
+	EndTime   graphql.Time
+}
+
+func (r *Resolver) CreateReservation(ctx context.Context, args struct{ Input CreateReservationInput }) (*CommandResultResolver, error) {
+	id, err := parseID(args.Input.ID)
+	if err != nil {
+		return nil, err
+	}
+	return r.handle(ctx, &reservations.CreateReservation{
+		ID:        id,
+		Name:      args.Input.Name,
+		User:      args.Input.User,
+		RoomID:    int(args.Input.RoomID),
+		StartTime: args.Input.StartTime.Time,
+		EndTime:   args.Input.EndTime.Time,
+	})
+}
+
+type ConfirmReservationInput struct {
+	ID   graphql.ID
+	User string
+}
+
+func (r *Resolver) ConfirmReservation(ctx context.Context, args struct{ Input ConfirmReservationInput }) (*CommandResultResolver, error) {
+	id, err := parseID(args.Input.ID)
+	if err != nil {
+		return nil, err
+	}
+	return r.handle(ctx, &reservations.ConfirmReservation{
+		ID:   id,
+		User: args.Input.User,
+	})
+}
+
+type DeclineReservationInput struct {
+	ID      graphql.ID
+	User    string
+	Message string
+}
+
+func (r *Resolver) DeclineReservation(ctx context.Context, args struct{ Input DeclineReservationInput }) (*CommandResultResolver, error) {
+	id, err := parseID(args.Input.ID)
+	if err != nil {
+		return nil, err
+	}
+	return r.handle(ctx, &reservations.DeclineReservation{
+		ID:      id,
+		User:    args.Input.User,
+		Message: args.Input.Message,
+	})
+}
+
+type ChangeReservationTimeInput struct {
+	ID        graphql.ID
+	User      string
+	StartTime graphql.Time
+	EndTime   graphql.Time
+}
+
+func (r *Resolver) ChangeReservationTime(ctx context.Context, args struct{ Input ChangeReservationTimeInput }) (*CommandResultResolver, error) {
+	id, err := parseID(args.Input.ID)
+	if err != nil {
+		return nil, err
+	}
+	return r.handle(ctx, &reservations.ChangeReservationTime{
+		ID:        id,
+		User:      args.Input.User,
+		StartTime: args.Input.StartTime.Time,
+		EndTime:   args.Input.EndTime.Time,
+	})
+}
+
+type CancelReservationInput struct {
+	ID   graphql.ID
+	User string
+}
+
+func (r *Resolver) CancelReservation(ctx context.Context, args struct{ Input CancelReservationInput }) (*CommandResultResolver, error) {
+	id, err := parseID(args.Input.ID)
+	if err != nil {
+		return nil, err
+	}
+	return r.handle(ctx, &reservations.CancelReservation{
+		ID:   id,
+		User: args.Input.User,
+	})
+}
+
+// handle checks the request was signed, then validates and handles the command, returning the new aggregate version
+func (r *Resolver) handle(ctx context.Context, cmd eh.Command) (*CommandResultResolver, error) {
+	if err := verifySignature(ctx); err != nil {
+		return nil, commandError{err}
+	}
+	if err := eh.CheckCommand(cmd); err != nil {
+		return nil, commandError{fmt.Errorf("%v: %w", err, gateway.ErrInvalidCommand)}
+	}
+
+	version, err := reservations.HandleVersioned(ctx, r.commandHandler, cmd)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return nil, commandError{err}
+	}
+	return &CommandResultResolver{id: cmd.AggregateID(), version: version}, nil
+}
+
+// Subscriptions
+
+func (r *Resolver) ReservationUpdates(ctx context.Context, args struct {
+	ID     *graphql.ID
+	RoomID *int32
+	User   *string
+	Token  *string
+}) (<-chan *ReservationResolver, error) {
+	var filter live.Filter
+	if args.ID != nil {
+		id, err := parseID(*args.ID)
+		if err != nil {
+			return nil, err
+		}
+		filter.ID = id
+	}
+	if args.RoomID != nil {
+		filter.RoomID = int(*args.RoomID)
+	}
+	if args.User != nil {
+		filter.User = *args.User
+	}
+	if args.Token != nil {
+		filter.Token = *args.Token
+	}
+
+	updates, err := r.hub.Subscribe(ctx, filter, 0)
+	if err != nil {
+		return nil, err
+	}
+	resolvers := make(chan *ReservationResolver)
+	go func() {
+		defer close(resolvers)
+		for u := range updates {
+			select {
+			case resolvers <- &ReservationResolver{r, u.Reservation}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return resolvers, nil
+}
+
+// Types
+
+type ReservationResolver struct {
+	root *Resolver
+	r    *reservations.Reservation
+}
+
+func (r *ReservationResolver) ID() graphql.ID          { return graphql.ID(r.r.ID.String()) }
+func (r *ReservationResolver) Version() int32          { return int32(r.r.Version) }
+func (r *ReservationResolver) Name() string            { return r.r.Name }
+func (r *ReservationResolver) Creator() string         { return r.r.Creator }
+func (r *ReservationResolver) RoomID() int32           { return int32(r.r.RoomID) }
+func (r *ReservationResolver) StartTime() graphql.Time { return graphql.Time{Time: r.r.StartTime} }
+func (r *ReservationResolver) EndTime() graphql.Time   { return graphql.Time{Time: r.r.EndTime} }
+func (r *ReservationResolver) Status() string          { return strings.ToUpper(string(r.r.Status)) }
+func (r *ReservationResolver) Room() *RoomResolver     { return &RoomResolver{r.root, r.r.RoomID} }
+
+type IntervalResolver struct {
+	i availability.Interval
+}
+
+func (r *IntervalResolver) Start() graphql.Time { return graphql.Time{Time: r.i.Start} }
+func (r *IntervalResolver) End() graphql.Time   { return graphql.Time{Time: r.i.End} }
+
+type RoomResolver struct {
+	root *Resolver
+	id   int
+}
+
+func (r *RoomResolver) ID() int32 { return int32(r.id) }
+
+func (r *RoomResolver) Busy(ctx context.Context, args struct{ From, To graphql.Time }) ([]*IntervalResolver, error) {
+	if !args.To.After(args.From.Time) || args.To.Sub(args.From.Time) > availability.MaxRange {
+		return nil, fmt.Errorf("to must be after from, and at most %v later", availability.MaxRange)
+	}
+	busy, err := availability.Busy(ctx, r.root.availabilityRepo, r.id, args.From.Time, args.To.Time)
+	if err != nil {
+		return nil, err
+	}
+	resolvers := make([]*IntervalResolver, len(busy))
+	for i, interval := range busy {
+		resolvers[i] = &IntervalResolver{interval}
+	}
+	return resolvers, nil
+}
+
+func (r *RoomResolver) Reservations(ctx context.Context, args struct{ From *graphql.Time }) ([]*ReservationResolver, error) {
+	roomID := int32(r.id)
+	return r.root.Reservations(ctx, reservationsArgs{RoomID: &roomID, From: args.From})
+}
+
+type MoneyResolver struct {
+	m money.Money
+}
+
+func (r *MoneyResolver) MinorUnits() Int64 { return Int64(r.m.Amount) }
+func (r *MoneyResolver) Currency() string  { return r.m.Currency }
+func (r *MoneyResolver) Formatted() string { return r.m.String() }
+
+type BillResolver struct {
+	month string
+	b     *billing.Bill
+}
+
+func (r *BillResolver) Month() string  { return r.month }
+func (r *BillResolver) Version() int32 { return int32(r.b.Version) }
+func (r *BillResolver) Minutes() int32 { return int32(r.b.Minutes) }
+func (r *BillResolver) Total() float64 { return r.b.Total.Float64() }
+
+func (r *BillResolver) TotalAmount() *MoneyResolver { return &MoneyResolver{r.b.Total} }
+
+type BillingHistoryResolver struct {
+	h *billing.BillingHistory
+}
+
+func (r *BillingHistoryResolver) ID() graphql.ID      { return graphql.ID(r.h.ID.String()) }
+func (r *BillingHistoryResolver) Version() int32      { return int32(r.h.Version) }
+func (r *BillingHistoryResolver) User() string        { return r.h.User }
+func (r *BillingHistoryResolver) TotalMinutes() int32 { return int32(r.h.TotalMinutes) }
+func (r *BillingHistoryResolver) TotalPaid() float64  { return r.h.TotalPaid.Float64() }
+
+func (r *BillingHistoryResolver) TotalPaidAmount() *MoneyResolver {
+	return &MoneyResolver{r.h.TotalPaid}
+}
+
+func (r *BillingHistoryResolver) Bills() []*BillResolver {
+	bills := make([]*BillResolver, 0, len(r.h.Bills))
+	for month, b := range r.h.Bills {
+		bills = append(bills, &BillResolver{month: month, b: b})
+	}
+	sort.Slice(bills, func(i, j int) bool { return bills[i].month < bills[j].month })
+	return bills
+}
+
+type CommandResultResolver struct {
+	id      uuid.UUID
+	version int
+}
+
+func (r *CommandResultResolver) AggregateID() graphql.ID { return graphql.ID(r.id.String()) }
+func (r *CommandResultResolver) Version() int32          { return int32(r.version) }
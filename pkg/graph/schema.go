@@ -0,0 +1,139 @@
+package graph
+
+// Schema is the GraphQL schema, mutations map one to one onto the reservation commands
+const Schema = `
+schema {
+	query: Query
+	mutation: Mutation
+	subscription: Subscription
+}
+
+scalar Time
+
+# Int64 is a 64-bit integer, sent as a string
+scalar Int64
+
+enum ReservationStatus {
+	PENDING
+	DECLINED
+	CONFIRMED
+	CANCELLED
+}
+
+type Reservation {
+	id: ID!
+	version: Int!
+	name: String!
+	creator: String!
+	roomId: Int!
+	startTime: Time!
+	endTime: Time!
+	status: ReservationStatus!
+	room: Room!
+}
+
+# Interval is a busy period of a room
+type Interval {
+	start: Time!
+	end: Time!
+}
+
+type Room {
+	id: Int!
+	# busy returns the room's merged busy intervals between from and to, at most 31 days apart
+	busy(from: Time!, to: Time!): [Interval!]!
+	# reservations returns the room's reservations, that end after from if set
+	reservations(from: Time): [Reservation!]!
+}
+
+# Money is an exact amount, in the minor units (eg cents) of its currency
+type Money {
+	minorUnits: Int64!
+	currency: String!
+	# formatted is the amount with its currency, eg "USD 4.80"
+	formatted: String!
+}
+
+type Bill {
+	# month is the start of the month the bill is for
+	month: String!
+	version: Int!
+	minutes: Int!
+	# total is totalAmount in major units, rounded to a float
+	total: Float!
+	totalAmount: Money!
+}
+
+type BillingHistory {
+	id: ID!
+	version: Int!
+	user: String!
+	bills: [Bill!]!
+	totalMinutes: Int!
+	# totalPaid is totalPaidAmount in major units, rounded to a float
+	totalPaid: Float!
+	totalPaidAmount: Money!
+}
+
+type Query {
+	reservation(id: ID!): Reservation
+	# reservations returns the reservations with the ids, or all reservations, matching the filters
+	# Set from to the current time for the upcoming reservations, those that end after it
+	reservations(ids: [ID!], roomId: Int, creator: String, status: ReservationStatus, from: Time): [Reservation!]!
+	room(id: Int!): Room
+	rooms: [Room!]!
+	billingHistory(user: String!): BillingHistory
+}
+
+type CommandResult {
+	aggregateId: ID!
+	# version is the version of the aggregate after the command was applied
+	version: Int!
+}
+
+input CreateReservationInput {
+	id: ID!
+	name: String!
+	user: String!
+	roomId: Int!
+	startTime: Time!
+	endTime: Time!
+}
+
+input ConfirmReservationInput {
+	id: ID!
+	user: String!
+}
+
+input DeclineReservationInput {
+	id: ID!
+	user: String!
+	message: String!
+}
+
+input ChangeReservationTimeInput {
+	id: ID!
+	user: String!
+	startTime: Time!
+	endTime: Time!
+}
+
+input CancelReservationInput {
+	id: ID!
+	user: String!
+}
+
+type Mutation {
+	createReservation(input: CreateReservationInput!): CommandResult!
+	confirmReservation(input: ConfirmReservationInput!): CommandResult!
+	declineReservation(input: DeclineReservationInput!): CommandResult!
+	changeReservationTime(input: ChangeReservationTimeInput!): CommandResult!
+	cancelReservation(input: CancelReservationInput!): CommandResult!
+}
+
+type Subscription {
+	# reservationUpdates streams reservations as they are projected, matching every filter set
+	# A user's or room's reservations need the token of its calendar feed, otherwise id must be set
+	reservationUpdates(id: ID, roomId: Int, user: String, token: String): Reservation!
+}
+`
@@ -0,0 +1,161 @@
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/MattDevy/CQRS-example/pkg/live"
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	"github.com/MattDevy/CQRS-example/pkg/signing"
+	"github.com/graph-gophers/graphql-go"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// Path is the path the GraphQL endpoint is served on
+const Path = "/graphql"
+
+// SignedCommandType is the command type of the JWT signing a request's mutations
+// The JWT is sent as a Bearer token, with the digest of the exact request body.
+const SignedCommandType = "GraphQL"
+
+// Server serves the Schema over HTTP
+// Queries and mutations are POSTed (or sent with GET), subscriptions are streamed as server-sent events
+// when the request accepts text/event-stream
+type Server struct {
+	schema          *graphql.Schema
+	reservationRepo eh.ReadRepo
+	keys            *signing.KeySet
+}
+
+// NewServer returns an initialized Server
+// If keys is not nil mutations are only handled in POSTed requests signed like gateway commands, see SignedCommandType.
+// Subscriptions are fed by the hub, and the command handler's event store must be a reservations.VersionStore
+// for mutations to return the new versions.
+func NewServer(
+	commandHandler eh.CommandHandler,
+	reservationRepo eh.ReadRepo,
+	billingRepo eh.ReadRepo,
+	availabilityRepo eh.ReadRepo,
+	hub *live.Hub,
+	keys *signing.KeySet,
+) (*Server, error) {
+	schema, err := graphql.ParseSchema(Schema, &Resolver{
+		commandHandler:   commandHandler,
+		reservationRepo:  reservationRepo,
+		billingRepo:      billingRepo,
+		availabilityRepo: availabilityRepo,
+		hub:              hub,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not parse schema: %w", err)
+	}
+	return &Server{schema: schema, reservationRepo: reservationRepo, keys: keys}, nil
+}
+
+// signedKey is the context key of the result of verifying the request's signature
+type signedKey struct{}
+
+// signature is the result of verifying the request's signature, err is nil if its mutations can be handled
+type signature struct {
+	err error
+}
+
+// verifySignature returns an error unless the request's mutations were signed by a trusted key
+func verifySignature(ctx context.Context) error {
+	s, ok := ctx.Value(signedKey{}).(*signature)
+	if !ok {
+		return fmt.Errorf("%v: %w", reservations.ErrUnsignedCommand, reservations.ErrUnauthenticated)
+	}
+	return s.err
+}
+
+type request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req request
+	signed := &signature{}
+	switch r.Method {
+	case http.MethodGet:
+		req.Query = r.URL.Query().Get("query")
+		req.OperationName = r.URL.Query().Get("operationName")
+		if v := r.URL.Query().Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				http.Error(w, "invalid variables: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		// Mutations must never be sent with GET
+		if s.keys != nil {
+			signed.err = fmt.Errorf("mutations must be POSTed: %w", reservations.ErrUnauthenticated)
+		}
+	case http.MethodPost:
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "could not read request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := json.Unmarshal(data, &req); err != nil {
+			http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if s.keys != nil {
+			signed.err = reservations.VerifyBearer(s.keys, r.Header.Get("Authorization"), SignedCommandType, data)
+		}
+	default:
+		http.Error(w, "unsupported method: "+r.Method, http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Each request gets its own loader, so reservations are only cached for the request
+	ctx := withLoader(r.Context(), newReservationLoader(s.reservationRepo, DefaultBatchWait))
+	ctx = context.WithValue(ctx, signedKey{}, signed)
+
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		s.subscribe(w, r.WithContext(ctx), &req)
+		return
+	}
+
+	resp := s.schema.Exec(ctx, req.Query, req.OperationName, req.Variables)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		fmt.Printf("Could not write response: %v\n", err)
+	}
+}
+
+// subscribe streams every response as a "next" event, followed by a "complete" event once the subscription ends
+func (s *Server) subscribe(w http.ResponseWriter, r *http.Request, req *request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	responses, err := s.schema.Subscribe(r.Context(), req.Query, req.OperationName, req.Variables)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for resp := range responses {
+		data, err := json.Marshal(resp)
+		if err != nil {
+			fmt.Printf("Could not marshal response: %v\n", err)
+			continue
+		}
+		fmt.Fprintf(w, "event: next\ndata: %s\n\n", data)
+		flusher.Flush()
+	}
+	fmt.Fprint(w, "event: complete\ndata:\n\n")
+	flusher.Flush()
+}
@@ -0,0 +1,367 @@
+package graph
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/availability"
+	"github.com/MattDevy/CQRS-example/pkg/billing"
+	"github.com/MattDevy/CQRS-example/pkg/calendar"
+	"github.com/MattDevy/CQRS-example/pkg/live"
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	"github.com/MattDevy/CQRS-example/pkg/signing"
+	"github.com/MattDevy/CQRS-example/pkg/watch"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/commandhandler/bus"
+	"github.com/looplab/eventhorizon/eventbus/local"
+	"github.com/looplab/eventhorizon/eventstore/memory"
+	memoryRepo "github.com/looplab/eventhorizon/repo/memory"
+)
+
+// countingRepo counts the reads, to check they are batched
+type countingRepo struct {
+	eh.ReadWriteRepo
+	reads int32
+}
+
+func (r *countingRepo) InnerRepo(ctx context.Context) eh.ReadRepo {
+	return r.ReadWriteRepo
+}
+
+func (r *countingRepo) Find(ctx context.Context, id uuid.UUID) (eh.Entity, error) {
+	atomic.AddInt32(&r.reads, 1)
+	return r.ReadWriteRepo.Find(ctx, id)
+}
+
+func (r *countingRepo) FindAll(ctx context.Context) ([]eh.Entity, error) {
+	atomic.AddInt32(&r.reads, 1)
+	return r.ReadWriteRepo.FindAll(ctx)
+}
+
+func newTestServer(t *testing.T) (*httptest.Server, *countingRepo) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	eventBus := local.NewEventBus()
+	store, err := memory.NewEventStore(memory.WithEventHandler(eventBus))
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventStore := reservations.NewVersionStore(store)
+	commandBus := bus.NewCommandHandler()
+	reservationRepo := &countingRepo{ReadWriteRepo: memoryRepo.NewRepo()}
+	watchedRepo := watch.NewRepo(reservationRepo)
+	billingRepo := memoryRepo.NewRepo()
+	billing.Setup(ctx, eventStore, eventBus, commandBus, billingRepo, time.UTC, billing.DefaultPricingPolicy, billing.DefaultFeePolicy, billing.DefaultUsagePolicy)
+	reservations.Setup(ctx, eventStore, eventBus, commandBus, watchedRepo)
+	availabilityRepo := memoryRepo.NewRepo()
+	availability.Setup(ctx, eventBus, availabilityRepo)
+
+	hub := live.NewHub(ctx, watchedRepo, testTokens, live.DefaultBufferSize)
+	keys := signing.NewKeySet(time.Minute)
+	keys.AddHS256("test", testSecret)
+	s, err := NewServer(commandBus, watchedRepo, billingRepo, availabilityRepo, hub, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return srv, reservationRepo
+}
+
+type response struct {
+	Data   map[string]json.RawMessage
+	Errors []struct {
+		Message    string
+		Extensions map[string]interface{}
+	}
+}
+
+// testSecret is the secret mutations are signed with
+var testSecret = []byte("test-secret")
+
+// testTokens are the calendar feed tokens subscriptions are authorized with
+var testTokens = calendar.NewTokens([]byte("calendar-secret"))
+
+// post posts the query signed with testSecret
+func post(t *testing.T, url, query string, variables map[string]interface{}) *response {
+	return postSigned(t, url, query, variables, signing.NewHS256Signer("test", testSecret))
+}
+
+// postSigned posts the query, signed with the signer unless it is nil
+func postSigned(t *testing.T, url, query string, variables map[string]interface{}, signer *signing.Signer) *response {
+	body, _ := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
+	req, _ := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if signer != nil {
+		authorization, err := reservations.BearerToken(signer, "test", SignedCommandType, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	r := &response{}
+	if err := json.NewDecoder(resp.Body).Decode(r); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+const createReservation = `mutation($id: ID!, $start: Time!, $end: Time!) {
+	createReservation(input: {id: $id, name: "Standup", user: "Matt", roomId: 3, startTime: $start, endTime: $end}) {
+		aggregateId
+		version
+	}
+}`
+
+func TestServer_Subscription(t *testing.T) {
+	srv, _ := newTestServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	subscribe := func(vars map[string]interface{}) *bufio.Scanner {
+		body, _ := json.Marshal(map[string]interface{}{
+			"query":     `subscription($id: ID, $user: String, $token: String) { reservationUpdates(id: $id, user: $user, token: $token) { id status } }`,
+			"variables": vars,
+		})
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL, bytes.NewReader(body))
+		req.Header.Set("Accept", "text/event-stream")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return bufio.NewScanner(resp.Body)
+	}
+	type update struct {
+		Data struct {
+			ReservationUpdates struct {
+				ID     string
+				Status string
+			}
+		}
+		Errors []struct{ Message string }
+	}
+	next := func(scanner *bufio.Scanner) update {
+		for scanner.Scan() {
+			if !strings.HasPrefix(scanner.Text(), "data: ") {
+				continue
+			}
+			var u update
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(scanner.Text(), "data: ")), &u); err != nil {
+				t.Fatal(err)
+			}
+			return u
+		}
+		t.Fatalf("stream ended: %v", scanner.Err())
+		return update{}
+	}
+
+	// A user's reservations need the user's token
+	if u := next(subscribe(map[string]interface{}{"user": "Matt"})); len(u.Errors) != 1 || !strings.Contains(u.Errors[0].Message, live.ErrForbidden.Error()) {
+		t.Errorf("reservationUpdates without a token = %+v, want %v", u, live.ErrForbidden)
+	}
+
+	id := uuid.New().String()
+	single := subscribe(map[string]interface{}{"id": id})
+	user := subscribe(map[string]interface{}{"user": "Matt", "token": testTokens.UserToken("Matt")})
+
+	start := time.Now().Add(time.Hour)
+	r := post(t, srv.URL, createReservation, map[string]interface{}{
+		"id":    id,
+		"start": start.Format(time.RFC3339),
+		"end":   start.Add(time.Hour).Format(time.RFC3339),
+	})
+	if len(r.Errors) != 0 {
+		t.Fatalf("createReservation errors = %v", r.Errors)
+	}
+
+	// The conflict saga confirms the reservation straight after it is created
+	for _, stream := range []*bufio.Scanner{single, user} {
+		var got []string
+		for len(got) < 2 {
+			u := next(stream)
+			if u.Data.ReservationUpdates.ID != id {
+				t.Fatalf("reservationUpdates = %+v, want %v", u, id)
+			}
+			got = append(got, u.Data.ReservationUpdates.Status)
+		}
+		if want := "PENDING,CONFIRMED"; strings.Join(got, ",") != want {
+			t.Errorf("reservationUpdates = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestServer_Errors(t *testing.T) {
+	srv, _ := newTestServer(t)
+	id := uuid.New().String()
+	vars := map[string]interface{}{"id": id, "start": "2021-07-01T10:00:00Z", "end": "2021-07-01T11:00:00Z"}
+	if r := post(t, srv.URL, createReservation, vars); len(r.Errors) != 0 {
+		t.Fatalf("createReservation errors = %v", r.Errors)
+	}
+
+	signer := signing.NewHS256Signer("test", testSecret)
+	cancel := `mutation { cancelReservation(input: {id: "` + id + `", user: "Matt"}) { version } }`
+	tests := []struct {
+		name   string
+		query  string
+		vars   map[string]interface{}
+		signer *signing.Signer
+		want   string
+	}{
+		{"already exists", createReservation, vars, signer, "AlreadyExists"},
+		{"not found", `mutation { confirmReservation(input: {id: "` + uuid.New().String() + `", user: "Matt"}) { version } }`, nil, signer, "NotFound"},
+		{"invalid", `mutation { cancelReservation(input: {id: "` + id + `", user: ""}) { version } }`, nil, signer, "InvalidCommand"},
+		{"unsigned", cancel, nil, nil, "Unauthenticated"},
+		{"untrusted key", cancel, nil, signing.NewHS256Signer("test", []byte("another-secret")), "Unauthenticated"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := postSigned(t, srv.URL, tt.query, tt.vars, tt.signer)
+			if len(r.Errors) != 1 || r.Errors[0].Extensions["code"] != tt.want {
+				t.Errorf("errors = %+v, want code %v", r.Errors, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_Batching(t *testing.T) {
+	srv, repo := newTestServer(t)
+	var ids []string
+	for i := 0; i < 3; i++ {
+		id := uuid.New().String()
+		start := time.Date(2021, time.July, 1, 9+i, 0, 0, 0, time.UTC)
+		r := post(t, srv.URL, createReservation, map[string]interface{}{
+			"id":    id,
+			"start": start.Format(time.RFC3339),
+			"end":   start.Add(time.Hour).Format(time.RFC3339),
+		})
+		if len(r.Errors) != 0 {
+			t.Fatalf("createReservation errors = %v", r.Errors)
+		}
+		ids = append(ids, id)
+	}
+
+	// Wait for the projector, then count only the reads made by the query
+	time.Sleep(100 * time.Millisecond)
+	atomic.StoreInt32(&repo.reads, 0)
+	r := post(t, srv.URL, `query($a: ID!, $b: ID!, $c: ID!) {
+		a: reservation(id: $a) { name }
+		b: reservation(id: $b) { name }
+		c: reservation(id: $c) { name }
+		again: reservation(id: $a) { name }
+	}`, map[string]interface{}{"a": ids[0], "b": ids[1], "c": ids[2]})
+	if len(r.Errors) != 0 {
+		t.Fatalf("query errors = %v", r.Errors)
+	}
+	for _, alias := range []string{"a", "b", "c", "again"} {
+		if !strings.Contains(string(r.Data[alias]), "Standup") {
+			t.Errorf("%v = %s, want the reservation", alias, r.Data[alias])
+		}
+	}
+	if reads := atomic.LoadInt32(&repo.reads); reads != 1 {
+		t.Errorf("repo reads = %d, want 1", reads)
+	}
+}
+
+func TestServer_Rooms(t *testing.T) {
+	srv, _ := newTestServer(t)
+	for i := 0; i < 3; i++ {
+		start := time.Date(2021, time.July, 1+i, 10, 0, 0, 0, time.UTC)
+		r := post(t, srv.URL, createReservation, map[string]interface{}{
+			"id":    uuid.New().String(),
+			"start": start.Format(time.RFC3339),
+			"end":   start.Add(time.Hour).Format(time.RFC3339),
+		})
+		if len(r.Errors) != 0 {
+			t.Fatalf("createReservation errors = %v", r.Errors)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{
+			"upcoming",
+			`{ reservations(roomId: 3, from: "2021-07-02T10:30:00Z") { startTime } }`,
+			`{"reservations":[{"startTime":"2021-07-02T10:00:00Z"},{"startTime":"2021-07-03T10:00:00Z"}]}`,
+		},
+		{
+			"room",
+			`{ room(id: 3) { id busy(from: "2021-07-01T00:00:00Z", to: "2021-07-02T10:30:00Z") { start end } reservations(from: "2021-07-03T00:00:00Z") { startTime } } }`,
+			`{"room":{"id":3,"busy":[{"start":"2021-07-01T10:00:00Z","end":"2021-07-01T11:00:00Z"},{"start":"2021-07-02T10:00:00Z","end":"2021-07-02T10:30:00Z"}],"reservations":[{"startTime":"2021-07-03T10:00:00Z"}]}}`,
+		},
+		{
+			"reservation's room",
+			`{ reservations(from: "2021-07-03T00:00:00Z") { room { busy(from: "2021-07-03T00:00:00Z", to: "2021-07-04T00:00:00Z") { start } } } }`,
+			`{"reservations":[{"room":{"busy":[{"start":"2021-07-03T10:00:00Z"}]}}]}`,
+		},
+		{"unknown room", `{ room(id: 7) { id } }`, `{"room":null}`},
+		{"rooms", `{ rooms { id } }`, `{"rooms":[{"id":1},{"id":2},{"id":3},{"id":4},{"id":5},{"id":6}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := post(t, srv.URL, tt.query, nil)
+			if len(r.Errors) != 0 {
+				t.Fatalf("errors = %v", r.Errors)
+			}
+			got, _ := json.Marshal(r.Data)
+			if string(got) != tt.want {
+				t.Errorf("data = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	r := post(t, srv.URL, `{ room(id: 3) { busy(from: "2021-07-01T00:00:00Z", to: "2021-09-01T00:00:00Z") { start } } }`, nil)
+	if len(r.Errors) != 1 {
+		t.Errorf("errors = %v, want the range rejected", r.Errors)
+	}
+}
+
+func TestInt64(t *testing.T) {
+	tests := []struct {
+		name  string
+		input interface{}
+		want  Int64
+		json  string
+	}{
+		{"beyond int32", "5000000000", 5000000000, `"5000000000"`},
+		{"beyond float64", "9007199254740993", 9007199254740993, `"9007199254740993"`},
+		{"int literal", int32(-250), -250, `"-250"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Int64
+			if err := got.UnmarshalGraphQL(tt.input); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("UnmarshalGraphQL(%v) = %v, want %v", tt.input, got, tt.want)
+			}
+			if b, _ := json.Marshal(got); string(b) != tt.json {
+				t.Errorf("json = %s, want %s", b, tt.json)
+			}
+		})
+	}
+	var got Int64
+	if err := got.UnmarshalGraphQL("1.5"); err == nil {
+		t.Error("UnmarshalGraphQL(1.5) succeeded, want error")
+	}
+}
@@ -0,0 +1,150 @@
+package importer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/query"
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+)
+
+// Lister lists the pending and confirmed reservations of a room that overlap a time range
+type Lister interface {
+	Overlapping(ctx context.Context, roomID int, from, to time.Time) ([]*reservations.Reservation, error)
+}
+
+// Check is the dry run of an import, it marks the valid rows that would not be booked
+// Rows for rooms not in rooms are invalid, as are repeated keys. A row overlapping an earlier row in the file,
+// or an existing reservation other than its own, is a conflict. Rows that were already imported are marked as existing.
+func Check(ctx context.Context, rows []*Row, lister Lister, rooms []int) error {
+	known := make(map[int]bool)
+	for _, room := range rooms {
+		known[room] = true
+	}
+
+	keys := make(map[string]*Row)
+	var booked []*Row
+	for _, row := range rows {
+		if row.Result != ResultValid {
+			continue
+		}
+		if first, ok := keys[row.Key]; ok {
+			row.invalid("duplicate of line %d", first.Line)
+			continue
+		}
+		keys[row.Key] = row
+		if !known[row.RoomID] {
+			row.invalid("unknown room %d", row.RoomID)
+			continue
+		}
+
+		for _, other := range booked {
+			if other.RoomID == row.RoomID && overlaps(row, other.StartTime, other.EndTime) {
+				row.Result = ResultConflict
+				row.Detail = fmt.Sprintf("overlaps line %d", other.Line)
+				break
+			}
+		}
+		if row.Result != ResultValid {
+			continue
+		}
+
+		existing, err := lister.Overlapping(ctx, row.RoomID, row.StartTime, row.EndTime)
+		if err != nil {
+			return fmt.Errorf("could not check line %d: %w", row.Line, err)
+		}
+		// The row's own reservation is only found once it was imported, any other overlap is a conflict
+		var own *reservations.Reservation
+		for _, r := range existing {
+			if r.ID == row.ID {
+				own = r
+				row.Result = ResultExists
+				row.Detail = fmt.Sprintf("already imported, %s", r.Status)
+				break
+			}
+		}
+		for _, r := range existing {
+			if r.ID != row.ID {
+				row.Result = ResultConflict
+				row.Detail = fmt.Sprintf("overlaps reservation %s", r.ID)
+				if own != nil {
+					row.Detail = "already imported, " + row.Detail
+				}
+				break
+			}
+		}
+		if row.Result == ResultValid || own != nil {
+			booked = append(booked, row)
+		}
+	}
+	return nil
+}
+
+func overlaps(row *Row, start, end time.Time) bool {
+	return row.StartTime.Before(end) && start.Before(row.EndTime)
+}
+
+// QueryLister lists reservations from the query API
+type QueryLister struct {
+	baseURL string
+	client  *http.Client
+}
+
+var _ = Lister(&QueryLister{})
+
+// NewQueryLister returns a QueryLister for the query API served at baseURL
+func NewQueryLister(baseURL string, client *http.Client) *QueryLister {
+	return &QueryLister{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  client,
+	}
+}
+
+// Overlapping follows the pages of the room's reservations in the range
+func (l *QueryLister) Overlapping(ctx context.Context, roomID int, from, to time.Time) ([]*reservations.Reservation, error) {
+	values := url.Values{
+		"room":   {strconv.Itoa(roomID)},
+		"from":   {from.Format(time.RFC3339)},
+		"to":     {to.Format(time.RFC3339)},
+		"status": {string(reservations.StatusPending) + "," + string(reservations.StatusConfirmed)},
+		"limit":  {strconv.Itoa(query.MaxPageSize)},
+	}
+	var rs []*reservations.Reservation
+	for {
+		page, err := l.page(ctx, values)
+		if err != nil {
+			return nil, err
+		}
+		rs = append(rs, page.Reservations...)
+		if page.NextCursor == "" {
+			return rs, nil
+		}
+		values.Set("cursor", page.NextCursor)
+	}
+}
+
+func (l *QueryLister) page(ctx context.Context, values url.Values) (*query.ReservationPage, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, l.baseURL+query.ReservationsPath+"?"+values.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("query API returned %s", resp.Status)
+	}
+	page := &query.ReservationPage{}
+	if err := json.NewDecoder(resp.Body).Decode(page); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
@@ -0,0 +1,95 @@
+package importer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/MattDevy/CQRS-example/pkg/live"
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	"github.com/google/uuid"
+)
+
+// Decider waits for the conflict saga to confirm or decline a reservation
+type Decider interface {
+	Decided(ctx context.Context, id uuid.UUID) (*reservations.Reservation, error)
+}
+
+// AwaitDecisions marks each created row confirmed or declined, once the conflict saga has decided it
+// Rows that are not decided before ctx is done stay created.
+func AwaitDecisions(ctx context.Context, decider Decider, rows []*Row) {
+	for _, row := range rows {
+		if row.Result != ResultCreated {
+			continue
+		}
+		r, err := decider.Decided(ctx, row.ID)
+		if err != nil {
+			row.Detail = fmt.Sprintf("%s, not decided: %v", row.Detail, err)
+			continue
+		}
+		switch r.Status {
+		case reservations.StatusConfirmed:
+			row.Result = ResultConfirmed
+		case reservations.StatusDeclined:
+			row.Result = ResultDeclined
+		}
+		row.Detail = fmt.Sprintf("version %d, %s", r.Version, r.Status)
+	}
+}
+
+// LiveDecider waits for decisions on the live updates served at baseURL
+type LiveDecider struct {
+	baseURL string
+	client  *http.Client
+}
+
+var _ = Decider(&LiveDecider{})
+
+// NewLiveDecider returns a LiveDecider for the live updates served at baseURL
+// The client must not have a timeout shorter than the wait, the stream is only closed once ctx is done.
+func NewLiveDecider(baseURL string, client *http.Client) *LiveDecider {
+	return &LiveDecider{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  client,
+	}
+}
+
+// Decided streams the reservation's updates until it is no longer pending
+func (d *LiveDecider) Decided(ctx context.Context, id uuid.UUID) (*reservations.Reservation, error) {
+	values := url.Values{"id": {id.String()}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.baseURL+live.EventsPath+"?"+values.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("live updates returned %s", resp.Status)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data := strings.TrimPrefix(scanner.Text(), "data: ")
+		if data == scanner.Text() {
+			continue
+		}
+		r := &reservations.Reservation{}
+		if err := json.Unmarshal([]byte(data), r); err != nil {
+			return nil, err
+		}
+		if r.Status != reservations.StatusPending {
+			return r, nil
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("live updates ended: %v", scanner.Err())
+}
@@ -0,0 +1,321 @@
+package importer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/live"
+	"github.com/MattDevy/CQRS-example/pkg/query"
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+var start = time.Date(2021, time.July, 1, 9, 0, 0, 0, time.UTC)
+
+func TestParseCSV(t *testing.T) {
+	file := strings.Join([]string{
+		"name,user,room,start,end",
+		"Standup,Matt,1,2021-07-01T09:00:00Z,2021-07-01T09:15:00Z",
+		"Review,Matt,two,2021-07-01T09:00:00Z,2021-07-01T10:00:00Z",
+		"Backwards,Matt,1,2021-07-01T10:00:00Z,2021-07-01T09:00:00Z",
+		",Matt,1,2021-07-01T09:00:00Z,2021-07-01T10:00:00Z",
+	}, "\n")
+	rows, err := ParseCSV(strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		line   int
+		result string
+		detail string
+	}{
+		{2, ResultValid, ""},
+		{3, ResultInvalid, `invalid room "two"`},
+		{4, ResultInvalid, reservations.ErrInvalidTimeRange.Error()},
+		{5, ResultInvalid, "missing name"},
+	}
+	if len(rows) != len(tests) {
+		t.Fatalf("ParseCSV() = %d rows, want %d", len(rows), len(tests))
+	}
+	for i, tt := range tests {
+		if rows[i].Line != tt.line || rows[i].Result != tt.result || rows[i].Detail != tt.detail {
+			t.Errorf("row %d = line %d, %v %q, want line %d, %v %q", i, rows[i].Line, rows[i].Result, rows[i].Detail, tt.line, tt.result, tt.detail)
+		}
+	}
+	if rows[0].RoomID != 1 || !rows[0].EndTime.Equal(start.Add(15*time.Minute)) {
+		t.Errorf("row 0 = %+v", rows[0])
+	}
+
+	again, _ := ParseCSV(strings.NewReader(file))
+	if again[0].ID != rows[0].ID {
+		t.Errorf("ID changed between parses, %v != %v", again[0].ID, rows[0].ID)
+	}
+
+	if _, err := ParseCSV(strings.NewReader("name,user,room,start\n")); err == nil {
+		t.Error("ParseCSV() without an end column should fail")
+	}
+}
+
+func TestParseICS(t *testing.T) {
+	file := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"BEGIN:VEVENT",
+		"UID:a@example.com",
+		`SUMMARY:Planning\, Q3`,
+		"LOCATION:Room 2",
+		"DTSTART:20210701T090000Z",
+		"DTEND:20210701T100000Z",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:b@example.com",
+		"SUMMARY:Long",
+		" er name",
+		`ORGANIZER;CN="Alice":mailto:alice@example.com`,
+		"LOCATION:3",
+		"DTSTART;TZID=Europe/London:20210701T100000",
+		"DTEND;TZID=Europe/London:20210701T110000",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:c@example.com",
+		"SUMMARY:Cancelled",
+		"STATUS:CANCELLED",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:d@example.com",
+		"SUMMARY:All day",
+		"LOCATION:1",
+		"DTSTART;VALUE=DATE:20210701",
+		"DTEND;VALUE=DATE:20210702",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n")
+	rows, err := ParseICS(strings.NewReader(file), "Matt", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("ParseICS() = %d rows, want 3", len(rows))
+	}
+
+	want := &Row{Line: 2, Key: "ics:a@example.com", Name: "Planning, Q3", User: "Matt", RoomID: 2, StartTime: start, EndTime: start.Add(time.Hour), Result: ResultValid}
+	want.ID = uuid.NewSHA1(Namespace, []byte(want.Key))
+	if got := rows[0]; *got != *want {
+		t.Errorf("row 0 = %+v, want %+v", got, want)
+	}
+	if got := rows[1]; got.Name != "Longer name" || got.User != "Alice" || got.RoomID != 3 || !got.StartTime.Equal(start) {
+		t.Errorf("row 1 = %+v", got)
+	}
+	if got := rows[2]; got.Result != ResultInvalid {
+		t.Errorf("all day row = %v, want %v", got.Result, ResultInvalid)
+	}
+}
+
+type fakeLister []*reservations.Reservation
+
+func (l fakeLister) Overlapping(ctx context.Context, roomID int, from, to time.Time) ([]*reservations.Reservation, error) {
+	var rs []*reservations.Reservation
+	for _, r := range l {
+		if r.RoomID == roomID && r.StartTime.Before(to) && from.Before(r.EndTime) {
+			rs = append(rs, r)
+		}
+	}
+	return rs, nil
+}
+
+func row(line, room int, offset, length time.Duration) *Row {
+	r := newRow(line, string(rune('a'+line)))
+	r.Name, r.User, r.RoomID = "Meeting", "Matt", room
+	r.StartTime = start.Add(offset)
+	r.EndTime = r.StartTime.Add(length)
+	return r
+}
+
+func TestCheck(t *testing.T) {
+	imported := row(6, 2, 4*time.Hour, time.Hour)
+	// Imported rows overlapping another reservation conflict, whichever is listed first
+	ownFirst, otherFirst := row(8, 3, 0, time.Hour), row(9, 3, 2*time.Hour, time.Hour)
+	lister := fakeLister{
+		{ID: uuid.New(), RoomID: 1, StartTime: start.Add(2 * time.Hour), EndTime: start.Add(3 * time.Hour), Status: reservations.StatusConfirmed},
+		{ID: imported.ID, RoomID: 2, StartTime: imported.StartTime, EndTime: imported.EndTime, Status: reservations.StatusPending},
+		{ID: ownFirst.ID, RoomID: 3, StartTime: ownFirst.StartTime, EndTime: ownFirst.EndTime, Status: reservations.StatusConfirmed},
+		{ID: uuid.New(), RoomID: 3, StartTime: ownFirst.StartTime, EndTime: otherFirst.EndTime, Status: reservations.StatusPending},
+		{ID: otherFirst.ID, RoomID: 3, StartTime: otherFirst.StartTime, EndTime: otherFirst.EndTime, Status: reservations.StatusPending},
+	}
+	duplicate := row(1, 1, 0, time.Hour)
+	duplicate.Line = 7
+	rows := []*Row{
+		row(1, 1, 0, time.Hour),
+		row(2, 1, 30*time.Minute, time.Hour),
+		row(3, 2, 30*time.Minute, time.Hour),
+		row(4, 1, 2*time.Hour+30*time.Minute, time.Hour),
+		row(5, 9, 0, time.Hour),
+		imported,
+		duplicate,
+		ownFirst,
+		otherFirst,
+	}
+	if err := Check(context.Background(), rows, lister, []int{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{ResultValid, ResultConflict, ResultValid, ResultConflict, ResultInvalid, ResultExists, ResultInvalid, ResultConflict, ResultConflict}
+	for i, row := range rows {
+		if row.Result != want[i] {
+			t.Errorf("line %d = %v (%v), want %v", row.Line, row.Result, row.Detail, want[i])
+		}
+	}
+	if rows[1].Detail != "overlaps line 1" {
+		t.Errorf("line 2 detail = %q", rows[1].Detail)
+	}
+	if want := "already imported, overlaps reservation " + lister[3].ID.String(); ownFirst.Detail != want || otherFirst.Detail != want {
+		t.Errorf("line 8, 9 details = %q, %q, want %q", ownFirst.Detail, otherFirst.Detail, want)
+	}
+}
+
+type fakeSender struct {
+	created map[uuid.UUID]bool
+}
+
+func (s *fakeSender) SendCommand(ctx context.Context, command eh.Command) error {
+	_, err := s.SendCommandAndWait(ctx, command)
+	return err
+}
+
+func (s *fakeSender) SendCommandAndWait(ctx context.Context, command eh.Command) (*reservations.CommandResult, error) {
+	cmd := command.(*reservations.CreateReservation)
+	result := &reservations.CommandResult{AggregateID: cmd.ID, Success: true, Version: 1}
+	switch {
+	case s.created[cmd.ID]:
+		result.Success, result.ErrorCode, result.Error = false, "AlreadyExists", reservations.ErrReservationExists.Error()
+	case cmd.RoomID == 3:
+		result.Success, result.ErrorCode, result.Error = false, "InvalidCommand", "room is closed"
+	default:
+		s.created[cmd.ID] = true
+	}
+	return result, result.Err()
+}
+
+func TestSubmit(t *testing.T) {
+	sender := &fakeSender{created: make(map[uuid.UUID]bool)}
+	rows := []*Row{row(1, 1, 0, time.Hour), row(2, 3, 0, time.Hour), row(3, 1, 0, time.Hour)}
+	rows[2].Result = ResultConflict
+	Submit(context.Background(), sender, rows)
+
+	want := []string{ResultCreated, ResultRejected, ResultConflict}
+	for i, row := range rows {
+		if row.Result != want[i] {
+			t.Errorf("line %d = %v, want %v", row.Line, row.Result, want[i])
+		}
+	}
+
+	// Submitting again is idempotent
+	again := []*Row{row(1, 1, 0, time.Hour)}
+	Submit(context.Background(), sender, again)
+	if again[0].Result != ResultExists {
+		t.Errorf("resubmitted row = %v, want %v", again[0].Result, ResultExists)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteReport(&buf, rows); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(rows)+1 || !strings.HasSuffix(lines[2], "rejected,InvalidCommand: room is closed") {
+		t.Errorf("WriteReport() =\n%v", buf.String())
+	}
+}
+
+type fakeDecider map[uuid.UUID]reservations.ReservationStatus
+
+func (d fakeDecider) Decided(ctx context.Context, id uuid.UUID) (*reservations.Reservation, error) {
+	status, ok := d[id]
+	if !ok {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return &reservations.Reservation{ID: id, Version: 2, Status: status}, nil
+}
+
+func TestAwaitDecisions(t *testing.T) {
+	rows := []*Row{row(1, 1, 0, time.Hour), row(2, 1, 0, time.Hour), row(3, 1, 0, time.Hour), row(4, 1, 0, time.Hour)}
+	for _, row := range rows {
+		row.Result, row.Detail = ResultCreated, "version 1"
+	}
+	rows[3].Result = ResultRejected
+	decider := fakeDecider{rows[0].ID: reservations.StatusConfirmed, rows[1].ID: reservations.StatusDeclined, rows[3].ID: reservations.StatusConfirmed}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	AwaitDecisions(ctx, decider, rows)
+
+	want := []struct{ result, detail string }{
+		{ResultConfirmed, "version 2, confirmed"},
+		{ResultDeclined, "version 2, declined"},
+		{ResultCreated, "version 1, not decided: context deadline exceeded"},
+		{ResultRejected, "version 1"},
+	}
+	for i, row := range rows {
+		if row.Result != want[i].result || row.Detail != want[i].detail {
+			t.Errorf("line %d = %v %q, want %v %q", row.Line, row.Result, row.Detail, want[i].result, want[i].detail)
+		}
+	}
+}
+
+func TestLiveDecider(t *testing.T) {
+	id := uuid.New()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != live.EventsPath || r.URL.Query().Get("id") != id.String() {
+			http.Error(w, "unexpected query", http.StatusBadRequest)
+			return
+		}
+		for version, status := range []reservations.ReservationStatus{reservations.StatusPending, reservations.StatusDeclined} {
+			data, _ := json.Marshal(&reservations.Reservation{ID: id, Version: version + 1, Status: status})
+			fmt.Fprintf(w, "id: %d\nevent: reservation\ndata: %s\n\n", version+1, data)
+		}
+	}))
+	defer srv.Close()
+
+	r, err := NewLiveDecider(srv.URL, srv.Client()).Decided(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Status != reservations.StatusDeclined || r.Version != 2 {
+		t.Errorf("Decided() = %+v, want declined at version 2", r)
+	}
+	if _, err := NewLiveDecider(srv.URL, srv.Client()).Decided(context.Background(), uuid.New()); err == nil {
+		t.Error("Decided() of an unknown reservation error = nil")
+	}
+}
+
+func TestQueryLister(t *testing.T) {
+	rs := []*reservations.Reservation{{ID: uuid.New(), RoomID: 1}, {ID: uuid.New(), RoomID: 1}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != query.ReservationsPath || r.URL.Query().Get("room") != "1" || r.URL.Query().Get("status") != "pending,confirmed" {
+			http.Error(w, "unexpected query", http.StatusBadRequest)
+			return
+		}
+		page := &query.ReservationPage{Reservations: rs[:1], NextCursor: "next"}
+		if r.URL.Query().Get("cursor") == "next" {
+			page = &query.ReservationPage{Reservations: rs[1:]}
+		}
+		json.NewEncoder(w).Encode(page)
+	}))
+	defer srv.Close()
+
+	got, err := NewQueryLister(srv.URL, srv.Client()).Overlapping(context.Background(), 1, start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].ID != rs[0].ID || got[1].ID != rs[1].ID {
+		t.Errorf("Overlapping() = %v, want both pages", got)
+	}
+}
@@ -0,0 +1,204 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CSVColumns are the columns a CSV file needs, in any order, with times in RFC 3339
+// An optional id column is used as the row's key, otherwise the key is the row's values
+var CSVColumns = []string{"name", "user", "room", "start", "end"}
+
+// ParseCSV reads the rows of a CSV file with a header row
+func ParseCSV(r io.Reader) ([]*Row, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("could not read header: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range CSVColumns {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("missing column %q", name)
+		}
+	}
+
+	var rows []*Row
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		get := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		key := "csv:" + get("id")
+		if get("id") == "" {
+			key = "csv:" + strings.Join([]string{get("name"), get("user"), get("room"), get("start"), get("end")}, "|")
+		}
+		row := newRow(line, key)
+		row.Name = get("name")
+		row.User = get("user")
+		if room := get("room"); room != "" {
+			if row.RoomID, err = strconv.Atoi(room); err != nil {
+				row.invalid("invalid room %q", room)
+			}
+		}
+		if start := get("start"); start != "" {
+			if row.StartTime, err = time.Parse(time.RFC3339, start); err != nil {
+				row.invalid("invalid start %q, expected RFC 3339", start)
+			}
+		}
+		if end := get("end"); end != "" {
+			if row.EndTime, err = time.Parse(time.RFC3339, end); err != nil {
+				row.invalid("invalid end %q, expected RFC 3339", end)
+			}
+		}
+		row.validate()
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// ParseICS reads the VEVENTs of an iCalendar file as rows, cancelled events are skipped
+// The user is the ORGANIZER's CN if set, else user. LOCATION is the room, either "Room 3" or "3".
+// Floating times are in loc.
+func ParseICS(r io.Reader, user string, loc *time.Location) ([]*Row, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []*Row
+	var row *Row
+	var cancelled bool
+	for _, l := range lines {
+		name, params, value := splitContentLine(l.text)
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			row = newRow(l.number, "")
+			row.User = user
+			cancelled = false
+		case row == nil:
+			continue
+		case name == "END" && value == "VEVENT":
+			if row.Key == "" {
+				row.invalid("missing UID")
+			}
+			row.validate()
+			if !cancelled {
+				rows = append(rows, row)
+			}
+			row = nil
+		case name == "UID":
+			key := newRow(row.Line, "ics:"+value)
+			row.Key, row.ID = key.Key, key.ID
+		case name == "SUMMARY":
+			row.Name = unescape(value)
+		case name == "ORGANIZER" && params["CN"] != "":
+			row.User = params["CN"]
+		case name == "LOCATION":
+			room := strings.TrimSpace(strings.TrimPrefix(strings.ToLower(unescape(value)), "room"))
+			if row.RoomID, err = strconv.Atoi(room); err != nil {
+				row.invalid("invalid location %q, expected a room number", value)
+			}
+		case name == "DTSTART":
+			if row.StartTime, err = parseICSTime(value, params, loc); err != nil {
+				row.invalid("invalid DTSTART: %v", err)
+			}
+		case name == "DTEND":
+			if row.EndTime, err = parseICSTime(value, params, loc); err != nil {
+				row.invalid("invalid DTEND: %v", err)
+			}
+		case name == "STATUS":
+			cancelled = value == "CANCELLED"
+		}
+	}
+	return rows, nil
+}
+
+type contentLine struct {
+	number int
+	text   string
+}
+
+// unfold joins folded lines back together
+func unfold(r io.Reader) ([]contentLine, error) {
+	var lines []contentLine
+	scanner := bufio.NewScanner(r)
+	for number := 1; scanner.Scan(); number++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(text, " ") || strings.HasPrefix(text, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1].text += text[1:]
+			continue
+		}
+		if text != "" {
+			lines = append(lines, contentLine{number: number, text: text})
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// splitContentLine splits NAME;PARAM=VALUE:VALUE, colons in quoted parameters are not separators
+func splitContentLine(line string) (string, map[string]string, string) {
+	quoted := false
+	end := len(line)
+	for i, c := range line {
+		if c == '"' {
+			quoted = !quoted
+		} else if c == ':' && !quoted {
+			end = i
+			break
+		}
+	}
+	value := ""
+	if end < len(line) {
+		value = line[end+1:]
+	}
+
+	parts := strings.Split(line[:end], ";")
+	params := make(map[string]string)
+	for _, p := range parts[1:] {
+		if kv := strings.SplitN(p, "=", 2); len(kv) == 2 {
+			params[strings.ToUpper(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+	return strings.ToUpper(parts[0]), params, value
+}
+
+func parseICSTime(value string, params map[string]string, loc *time.Location) (time.Time, error) {
+	if params["VALUE"] == "DATE" {
+		return time.Time{}, errors.New("all day events can not be booked")
+	}
+	if strings.HasSuffix(value, "Z") {
+		return time.Parse("20060102T150405Z", value)
+	}
+	if tzid := params["TZID"]; tzid != "" {
+		var err error
+		if loc, err = time.LoadLocation(tzid); err != nil {
+			return time.Time{}, fmt.Errorf("unknown TZID %q", tzid)
+		}
+	}
+	return time.ParseInLocation("20060102T150405", value, loc)
+}
+
+func unescape(s string) string {
+	return strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n").Replace(s)
+}
@@ -0,0 +1,92 @@
+package importer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	"github.com/google/uuid"
+)
+
+// Namespace is the UUID namespace imported reservation IDs are generated in
+var Namespace = uuid.MustParse("3f0a9c5e-8b1d-4e27-a6f4-5c2d9e1b7a30")
+
+// Results of a row
+const (
+	ResultValid    = "valid"
+	ResultInvalid  = "invalid"
+	ResultConflict = "conflict"
+	ResultCreated  = "created"
+	// ResultConfirmed and ResultDeclined are created rows the conflict saga has decided
+	ResultConfirmed = "confirmed"
+	ResultDeclined  = "declined"
+	ResultExists    = "exists"
+	ResultRejected  = "rejected"
+	ResultFailed    = "failed"
+)
+
+// Row is a reservation to import, and what happened to it
+type Row struct {
+	// Line is where the row starts in the file
+	Line int
+	// Key identifies the row across imports, its ID is derived from it so re-importing is idempotent
+	Key       string
+	ID        uuid.UUID
+	Name      string
+	User      string
+	RoomID    int
+	StartTime time.Time
+	EndTime   time.Time
+
+	Result string
+	Detail string
+}
+
+// newRow returns a row with its ID derived from the key
+func newRow(line int, key string) *Row {
+	return &Row{
+		Line:   line,
+		Key:    key,
+		ID:     uuid.NewSHA1(Namespace, []byte(key)),
+		Result: ResultValid,
+	}
+}
+
+// invalid marks the row invalid, unless it already is
+func (r *Row) invalid(format string, a ...interface{}) {
+	if r.Result == ResultInvalid {
+		return
+	}
+	r.Result = ResultInvalid
+	r.Detail = fmt.Sprintf(format, a...)
+}
+
+// validate checks the row has every field a CreateReservation needs
+func (r *Row) validate() {
+	switch {
+	case r.Name == "":
+		r.invalid("missing name")
+	case r.User == "":
+		r.invalid("missing user")
+	case r.RoomID == 0:
+		r.invalid("missing room")
+	case r.StartTime.IsZero() || r.EndTime.IsZero():
+		r.invalid("missing start or end time")
+	case !r.EndTime.After(r.StartTime):
+		r.invalid("%v", reservations.ErrInvalidTimeRange)
+	case r.EndTime.Sub(r.StartTime) > reservations.MaxDuration:
+		r.invalid("%v", reservations.ErrReservationTooLong)
+	}
+}
+
+// Command returns the command that creates the row's reservation
+func (r *Row) Command() *reservations.CreateReservation {
+	return &reservations.CreateReservation{
+		ID:        r.ID,
+		Name:      r.Name,
+		User:      r.User,
+		RoomID:    r.RoomID,
+		StartTime: r.StartTime,
+		EndTime:   r.EndTime,
+	}
+}
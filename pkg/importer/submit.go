@@ -0,0 +1,84 @@
+package importer
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+)
+
+// Submit sends a CreateReservation for each valid row and waits for its result
+// Rows are only submitted once checked, conflicting and invalid rows are skipped.
+// As row IDs are derived from their keys, resubmitting a file is safe.
+func Submit(ctx context.Context, sender reservations.CommandSender, rows []*Row) {
+	for _, row := range rows {
+		if row.Result != ResultValid {
+			continue
+		}
+		result, err := sender.SendCommandAndWait(ctx, row.Command())
+		var commandErr *reservations.CommandError
+		switch {
+		case errors.Is(err, reservations.ErrReservationExists):
+			row.Result = ResultExists
+			row.Detail = "already imported"
+		case errors.As(err, &commandErr):
+			row.Result = ResultRejected
+			row.Detail = fmt.Sprintf("%s: %s", commandErr.Code, commandErr.Message)
+		case err != nil:
+			row.Result = ResultFailed
+			row.Detail = err.Error()
+		default:
+			row.Result = ResultCreated
+			row.Detail = fmt.Sprintf("version %d", result.Version)
+		}
+	}
+}
+
+// ReportColumns are the columns of the result report
+var ReportColumns = []string{"line", "id", "name", "user", "room", "start", "end", "result", "detail"}
+
+// WriteReport writes the result of every row as CSV
+func WriteReport(w io.Writer, rows []*Row) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(ReportColumns); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err := cw.Write([]string{
+			strconv.Itoa(row.Line),
+			row.ID.String(),
+			row.Name,
+			row.User,
+			strconv.Itoa(row.RoomID),
+			formatTime(row.StartTime),
+			formatTime(row.EndTime),
+			row.Result,
+			row.Detail,
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Count returns how many rows have each result
+func Count(rows []*Row) map[string]int {
+	counts := make(map[string]int)
+	for _, row := range rows {
+		counts[row.Result]++
+	}
+	return counts
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,219 @@
+package invoicing
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/billing"
+	"github.com/MattDevy/CQRS-example/pkg/money"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/aggregatestore/events"
+)
+
+func init() {
+	eh.RegisterAggregate(func(id uuid.UUID) eh.Aggregate {
+		return NewInvoiceAggregate(id)
+	})
+}
+
+const InvoiceAggregateType eh.AggregateType = "Invoice"
+
+var _ = eh.Aggregate(&InvoiceAggregate{})
+
+// Namespace is the UUID namespace invoice and note IDs are generated in
+var Namespace = uuid.MustParse("9e7e3814-633a-42d5-98c1-8aca00f752c3")
+
+// InvoiceID returns the ID of a user's invoice for a month (yyyy-mm), so a month is only invoiced once
+func InvoiceID(user, month string) uuid.UUID {
+	return uuid.NewSHA1(Namespace, []byte(user+"/"+month))
+}
+
+// InvoiceAggregate is the write-model of an issued invoice, it is never changed once issued
+// Corrections are made with credit and debit notes, and the lines they were made to are kept up to date
+type InvoiceAggregate struct {
+	*events.AggregateBase
+
+	issued bool
+	void   bool
+	// lines are what was invoiced, by line key, after any notes
+	lines    map[string]billing.Line
+	total    money.Money
+	paid     money.Money
+	payments map[string]bool
+	notes    int
+}
+
+// NewInvoiceAggregate returns an initialized InvoiceAggregate, this should always be used to create the aggregate
+func NewInvoiceAggregate(id uuid.UUID) *InvoiceAggregate {
+	return &InvoiceAggregate{
+		AggregateBase: events.NewAggregateBase(InvoiceAggregateType, id),
+		lines:         make(map[string]billing.Line),
+		payments:      make(map[string]bool),
+	}
+}
+
+// Issued returns true if the invoice was issued
+func (a *InvoiceAggregate) Issued() bool { return a.issued }
+
+// Void returns true if the invoice was voided
+func (a *InvoiceAggregate) Void() bool { return a.void }
+
+// Notes returns the number of notes issued for the invoice
+func (a *InvoiceAggregate) Notes() int { return a.notes }
+
+// Line returns what the line with the key was invoiced, after any notes
+func (a *InvoiceAggregate) Line(key string) (billing.Line, bool) {
+	line, ok := a.lines[key]
+	return line, ok
+}
+
+// Balance returns what is still owed for the invoice
+func (a *InvoiceAggregate) Balance() money.Money {
+	return a.total.Sub(a.paid)
+}
+
+// HandleCommand is called whenever the commandBus recieves a command for which this aggregate is registered
+func (a *InvoiceAggregate) HandleCommand(ctx context.Context, cmd eh.Command) error {
+	if _, ok := cmd.(*IssueInvoice); !ok && !a.issued {
+		return ErrInvoiceNotFound
+	}
+
+	switch cmd := cmd.(type) {
+	case *IssueInvoice:
+		if a.issued {
+			return ErrInvoiceIssued
+		}
+		if cmd.Number == "" || cmd.User == "" || cmd.Month == "" {
+			return ErrInvalidInvoice
+		}
+		var total money.Money
+		for _, line := range cmd.Lines {
+			total = total.Add(line.Amount)
+		}
+		a.AppendEvent(InvoiceIssuedEvent, &InvoiceIssuedData{
+			Number: cmd.Number,
+			User:   cmd.User,
+			Month:  cmd.Month,
+			Lines:  cmd.Lines,
+			Total:  total,
+		}, time.Now())
+	case *RecordPayment:
+		if a.void {
+			return ErrInvoiceVoid
+		}
+		if cmd.Amount.Amount <= 0 || cmd.Amount.Currency != a.total.Currency {
+			return ErrInvalidAmount
+		}
+		if a.payments[cmd.Reference] {
+			return ErrPaymentRecorded
+		}
+		a.AppendEvent(PaymentRecordedEvent, &PaymentRecordedData{
+			Amount:    cmd.Amount,
+			Reference: cmd.Reference,
+		}, time.Now())
+	case *VoidInvoice:
+		if a.void {
+			return ErrInvoiceVoid
+		}
+		if !a.paid.IsZero() {
+			return ErrInvoicePaid
+		}
+		a.AppendEvent(InvoiceVoidedEvent, &InvoiceVoidedData{
+			Reason: cmd.Reason,
+		}, time.Now())
+	case *IssueCreditNote:
+		if a.void {
+			return ErrInvoiceVoid
+		}
+		if cmd.Number == "" {
+			return ErrInvalidInvoice
+		}
+		var noteLines []NoteLine
+		amount := money.Money{Currency: a.total.Currency}
+		for _, line := range a.Changes(cmd.Lines) {
+			was := a.lines[line.Key()]
+			noteLine := NoteLine{
+				Line:    line,
+				Minutes: line.Minutes - was.Minutes,
+				Amount:  line.Amount.Sub(was.Amount),
+			}
+			noteLines = append(noteLines, noteLine)
+			amount = amount.Add(noteLine.Amount)
+		}
+		if len(noteLines) == 0 {
+			return ErrNothingToCorrect
+		}
+		kind := CreditNote
+		if amount.Amount > 0 {
+			kind = DebitNote
+		}
+		a.AppendEvent(CreditNoteIssuedEvent, &CreditNoteIssuedData{
+			Number: cmd.Number,
+			Kind:   kind,
+			Lines:  noteLines,
+			Amount: amount,
+			Reason: cmd.Reason,
+		}, time.Now())
+	default:
+		return fmt.Errorf("could not handle command: %s", cmd.CommandType())
+	}
+	return nil
+}
+
+// ApplyEvent is called whenever an event is recieved on the eventBus
+func (a *InvoiceAggregate) ApplyEvent(ctx context.Context, event eh.Event) error {
+	switch event.EventType() {
+	case InvoiceIssuedEvent:
+		if data, ok := event.Data().(*InvoiceIssuedData); ok {
+			a.issued = true
+			a.total = data.Total
+			a.paid = money.Money{Currency: data.Total.Currency}
+			for _, line := range data.Lines {
+				a.lines[line.Key()] = line
+			}
+		}
+	case PaymentRecordedEvent:
+		if data, ok := event.Data().(*PaymentRecordedData); ok {
+			a.paid = a.paid.Add(data.Amount)
+			a.payments[data.Reference] = true
+		}
+	case InvoiceVoidedEvent:
+		a.void = true
+	case CreditNoteIssuedEvent:
+		if data, ok := event.Data().(*CreditNoteIssuedData); ok {
+			a.notes++
+			a.total = a.total.Add(data.Amount)
+			for _, noteLine := range data.Lines {
+				a.lines[noteLine.Line.Key()] = noteLine.Line
+			}
+		}
+	}
+	return nil
+}
+
+// Changes returns the lines that are not as they were invoiced
+// A void line of a reservation that was never invoiced is not a change
+func (a *InvoiceAggregate) Changes(lines []billing.Line) []billing.Line {
+	var changes []billing.Line
+	for _, line := range lines {
+		was, ok := a.lines[line.Key()]
+		if !ok && line.Status == billing.LineVoid {
+			continue
+		}
+		if changed(was, line) {
+			changes = append(changes, line)
+		}
+	}
+	return changes
+}
+
+// changed returns true if the line is not as it was invoiced
+func changed(was, line billing.Line) bool {
+	return was.Minutes != line.Minutes ||
+		was.Amount.Amount != line.Amount.Amount ||
+		was.Status != line.Status ||
+		!was.StartTime.Equal(line.StartTime) ||
+		!was.EndTime.Equal(line.EndTime)
+}
@@ -0,0 +1,259 @@
+package invoicing
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/billing"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/aggregatestore/events"
+)
+
+// Results of closing a bill
+const (
+	Issued = "issued"
+	Noted  = "noted"
+	// Unchanged is an invoice whose bill has not changed since
+	Unchanged = "unchanged"
+	// Voided is an invoice that was voided, its bill is no longer invoiced
+	Voided = "voided"
+	// Failed is a bill that could not be closed, it is retried by the next close
+	Failed = "failed"
+)
+
+// Result is what closing a user's bill for a month did
+type Result struct {
+	User   string
+	Month  string
+	Number string
+	Result string
+	// Error is why the bill Failed
+	Error string
+}
+
+// CloseError is returned when some of the bills could not be closed, every other bill was
+type CloseError struct {
+	Errors []error
+}
+
+func (e *CloseError) Error() string {
+	errs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d bills could not be closed: %v", len(e.Errors), strings.Join(errs, "; "))
+}
+
+// Closer freezes the bills of the months that have ended into invoices, and corrects the
+// invoices of bills that changed since with credit or debit notes
+// Invoices and notes are numbered from a single gap-free sequence, a number is only reserved once
+// there is a document to issue and is bound to it, so a close that fails part way reuses it when retried
+type Closer struct {
+	commandHandler eh.CommandHandler
+	aggregateStore *events.AggregateStore
+	billingRepo    eh.ReadRepo
+	loc            *time.Location
+}
+
+// NewCloser returns a Closer of the bills in billingRepo, months end in loc
+func NewCloser(commandHandler eh.CommandHandler, eventStore eh.EventStore, billingRepo eh.ReadRepo, loc *time.Location) (*Closer, error) {
+	aggregateStore, err := events.NewAggregateStore(eventStore)
+	if err != nil {
+		return nil, err
+	}
+	return &Closer{
+		commandHandler: commandHandler,
+		aggregateStore: aggregateStore,
+		billingRepo:    billingRepo,
+		loc:            loc,
+	}, nil
+}
+
+// Close invoices every bill of a month that ended before now, and issues notes for the invoiced bills that changed
+// Bills are closed by user and then month, so invoices of a close are numbered in that order
+// A bill that fails to close is recorded as Failed and the rest are still closed, the failures are returned as a *CloseError
+func (c *Closer) Close(ctx context.Context, now time.Time) ([]Result, error) {
+	entities, err := c.billingRepo.FindAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	histories := make(map[string]*billing.BillingHistory)
+	for _, e := range entities {
+		if h, ok := e.(*billing.BillingHistory); ok {
+			if latest, ok := histories[h.User]; !ok || h.Version > latest.Version {
+				histories[h.User] = h
+			}
+		}
+	}
+	users := make([]string, 0, len(histories))
+	for user := range histories {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+
+	var results []Result
+	closeErr := &CloseError{}
+	fail := func(user, month string, err error) {
+		results = append(results, Result{User: user, Month: month, Result: Failed, Error: err.Error()})
+		closeErr.Errors = append(closeErr.Errors, err)
+	}
+	for _, user := range users {
+		h := histories[user]
+		var months []time.Time
+		for key := range h.Bills {
+			month, err := billing.ParseMonthKey(key)
+			if err != nil {
+				fail(user, key, fmt.Errorf("invalid bill %q of %v: %w", key, user, err))
+				continue
+			}
+			end := time.Date(month.Year(), month.Month()+1, 1, 0, 0, 0, 0, c.loc)
+			if !end.After(now) {
+				months = append(months, month)
+			}
+		}
+		sort.Slice(months, func(i, j int) bool { return months[i].Before(months[j]) })
+
+		for _, month := range months {
+			invoice, _ := h.Invoice(month)
+			result, err := c.closeBill(ctx, invoice)
+			if err != nil {
+				fail(user, invoice.Month, fmt.Errorf("could not close %v of %v: %w", invoice.Month, user, err))
+				continue
+			}
+			if result != nil {
+				results = append(results, *result)
+			}
+		}
+	}
+	if len(closeErr.Errors) > 0 {
+		return results, closeErr
+	}
+	return results, nil
+}
+
+// closeBill invoices the bill or corrects its invoice, returning nil if there is nothing to invoice
+func (c *Closer) closeBill(ctx context.Context, bill *billing.Invoice) (*Result, error) {
+	id := InvoiceID(bill.User, bill.Month)
+	a, err := c.aggregateStore.Load(ctx, InvoiceAggregateType, id)
+	if err != nil {
+		return nil, err
+	}
+	invoice, ok := a.(*InvoiceAggregate)
+	if !ok {
+		return nil, fmt.Errorf("incorrect aggregate type: %T", a)
+	}
+	result := &Result{User: bill.User, Month: bill.Month}
+
+	switch {
+	case !invoice.Issued():
+		var lines []billing.Line
+		for _, line := range bill.Lines {
+			if line.Status != billing.LineVoid {
+				lines = append(lines, *line)
+			}
+		}
+		reserved, err := c.reserved(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		// A number reserved by a close that failed is always used, even if nothing is left to invoice
+		if len(lines) == 0 && reserved == "" {
+			return nil, nil
+		}
+		if result.Number, err = c.reserve(ctx, id); err != nil {
+			return nil, err
+		}
+		err = c.commandHandler.HandleCommand(ctx, &IssueInvoice{
+			ID:     id,
+			Number: result.Number,
+			User:   bill.User,
+			Month:  bill.Month,
+			Lines:  lines,
+		})
+		if err != nil {
+			return nil, err
+		}
+		result.Result = Issued
+	case invoice.Void():
+		result.Result = Voided
+	default:
+		lines := make([]billing.Line, len(bill.Lines))
+		for i, line := range bill.Lines {
+			lines[i] = *line
+		}
+		changes := invoice.Changes(lines)
+		if len(changes) == 0 {
+			result.Result = Unchanged
+			return result, nil
+		}
+		noteID := uuid.NewSHA1(Namespace, []byte(fmt.Sprintf("%v/notes/%d", id, invoice.Notes()+1)))
+		if result.Number, err = c.reserve(ctx, noteID); err != nil {
+			return nil, err
+		}
+		err = c.commandHandler.HandleCommand(ctx, &IssueCreditNote{
+			ID:     id,
+			Number: result.Number,
+			Lines:  changes,
+			Reason: "bill changed after the invoice was issued",
+		})
+		if err != nil {
+			return nil, err
+		}
+		result.Result = Noted
+	}
+	return result, nil
+}
+
+// reserve reserves the next number of the sequence for the document, or returns the one it already has
+func (c *Closer) reserve(ctx context.Context, documentID uuid.UUID) (string, error) {
+	if err := c.commandHandler.HandleCommand(ctx, &ReserveNumber{ID: SequenceID, DocumentID: documentID}); err != nil {
+		return "", err
+	}
+	number, err := c.reserved(ctx, documentID)
+	if err == nil && number == "" {
+		err = fmt.Errorf("no number reserved for %v", documentID)
+	}
+	return number, err
+}
+
+// reserved returns the number reserved for the document, "" if there is none
+func (c *Closer) reserved(ctx context.Context, documentID uuid.UUID) (string, error) {
+	a, err := c.aggregateStore.Load(ctx, SequenceAggregateType, SequenceID)
+	if err != nil {
+		return "", err
+	}
+	sequence, ok := a.(*SequenceAggregate)
+	if !ok {
+		return "", fmt.Errorf("incorrect aggregate type: %T", a)
+	}
+	if n, ok := sequence.Number(documentID); ok {
+		return FormatNumber(n), nil
+	}
+	return "", nil
+}
+
+// Run closes the bills every interval until ctx is done, so each month is invoiced soon after it ends
+func (c *Closer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		results, err := c.Close(ctx, time.Now())
+		if err != nil {
+			fmt.Printf("Error: could not close bills: %v\n", err)
+		}
+		for _, r := range results {
+			if r.Result != Unchanged && r.Result != Failed {
+				fmt.Printf("Invoice %v of %v for %v: %v\n", r.Number, r.User, r.Month, r.Result)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
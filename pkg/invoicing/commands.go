@@ -0,0 +1,86 @@
+package invoicing
+
+import (
+	"github.com/MattDevy/CQRS-example/pkg/billing"
+	"github.com/MattDevy/CQRS-example/pkg/money"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+func init() {
+	eh.RegisterCommand(func() eh.Command { return &IssueInvoice{} })
+	eh.RegisterCommand(func() eh.Command { return &RecordPayment{} })
+	eh.RegisterCommand(func() eh.Command { return &VoidInvoice{} })
+	eh.RegisterCommand(func() eh.Command { return &IssueCreditNote{} })
+	eh.RegisterCommand(func() eh.Command { return &ReserveNumber{} })
+}
+
+const (
+	IssueInvoiceCommand    eh.CommandType = "IssueInvoice"
+	RecordPaymentCommand   eh.CommandType = "RecordPayment"
+	VoidInvoiceCommand     eh.CommandType = "VoidInvoice"
+	IssueCreditNoteCommand eh.CommandType = "IssueCreditNote"
+	ReserveNumberCommand   eh.CommandType = "ReserveNumber"
+)
+
+// IssueInvoice is the command to freeze a user's bill for a month into an invoice
+// Number must be reserved from the Sequence for the invoice's ID first
+type IssueInvoice struct {
+	ID     uuid.UUID
+	Number string
+	User   string
+	// Month is yyyy-mm
+	Month string
+	Lines []billing.Line
+}
+
+func (c IssueInvoice) AggregateID() uuid.UUID          { return c.ID }
+func (c IssueInvoice) AggregateType() eh.AggregateType { return InvoiceAggregateType }
+func (c IssueInvoice) CommandType() eh.CommandType     { return IssueInvoiceCommand }
+
+// RecordPayment is the command to record a payment against an issued invoice
+type RecordPayment struct {
+	ID     uuid.UUID
+	Amount money.Money
+	// Reference identifies the payment, eg the payment provider's ID, a payment is only recorded once
+	Reference string
+}
+
+func (c RecordPayment) AggregateID() uuid.UUID          { return c.ID }
+func (c RecordPayment) AggregateType() eh.AggregateType { return InvoiceAggregateType }
+func (c RecordPayment) CommandType() eh.CommandType     { return RecordPaymentCommand }
+
+// VoidInvoice is the command to void an issued invoice that has not been paid
+type VoidInvoice struct {
+	ID     uuid.UUID
+	Reason string
+}
+
+func (c VoidInvoice) AggregateID() uuid.UUID          { return c.ID }
+func (c VoidInvoice) AggregateType() eh.AggregateType { return InvoiceAggregateType }
+func (c VoidInvoice) CommandType() eh.CommandType     { return VoidInvoiceCommand }
+
+// IssueCreditNote is the command to correct an issued invoice with a note
+// Lines are the lines of the reservations that changed as they are now, the note credits or debits the
+// difference to what was invoiced for them, a debit note if the difference is positive
+type IssueCreditNote struct {
+	ID     uuid.UUID
+	Number string
+	Lines  []billing.Line
+	Reason string
+}
+
+func (c IssueCreditNote) AggregateID() uuid.UUID          { return c.ID }
+func (c IssueCreditNote) AggregateType() eh.AggregateType { return InvoiceAggregateType }
+func (c IssueCreditNote) CommandType() eh.CommandType     { return IssueCreditNoteCommand }
+
+// ReserveNumber is the command to take the next number of a Sequence for a document
+// Reserving again for the same document is a no-op, so a retried close does not leave gaps
+type ReserveNumber struct {
+	ID         uuid.UUID
+	DocumentID uuid.UUID
+}
+
+func (c ReserveNumber) AggregateID() uuid.UUID          { return c.ID }
+func (c ReserveNumber) AggregateType() eh.AggregateType { return SequenceAggregateType }
+func (c ReserveNumber) CommandType() eh.CommandType     { return ReserveNumberCommand }
@@ -0,0 +1,30 @@
+package invoicing
+
+import (
+	"errors"
+
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+)
+
+func init() {
+	reservations.RegisterErrorCode("InvoiceNotFound", ErrInvoiceNotFound)
+	reservations.RegisterErrorCode("InvoiceIssued", ErrInvoiceIssued)
+	reservations.RegisterErrorCode("InvoiceVoid", ErrInvoiceVoid)
+	reservations.RegisterErrorCode("InvoicePaid", ErrInvoicePaid)
+	reservations.RegisterErrorCode("PaymentRecorded", ErrPaymentRecorded)
+	reservations.RegisterErrorCode("InvalidAmount", ErrInvalidAmount)
+	reservations.RegisterErrorCode("NothingToCorrect", ErrNothingToCorrect)
+	reservations.RegisterErrorCode("InvalidInvoice", ErrInvalidInvoice)
+}
+
+// Domain errors returned when a command can not be applied to an invoice
+var (
+	ErrInvoiceNotFound  = errors.New("invoice not found")
+	ErrInvoiceIssued    = errors.New("invoice already issued")
+	ErrInvoiceVoid      = errors.New("invoice is void")
+	ErrInvoicePaid      = errors.New("invoice has payments")
+	ErrPaymentRecorded  = errors.New("payment already recorded")
+	ErrInvalidAmount    = errors.New("amount must be positive and in the invoice's currency")
+	ErrNothingToCorrect = errors.New("the lines are as invoiced")
+	ErrInvalidInvoice   = errors.New("invoice needs a number, user and month")
+)
@@ -0,0 +1,80 @@
+package invoicing
+
+import (
+	"github.com/MattDevy/CQRS-example/pkg/billing"
+	"github.com/MattDevy/CQRS-example/pkg/money"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+func init() {
+	eh.RegisterEventData(InvoiceIssuedEvent, func() eh.EventData {
+		return &InvoiceIssuedData{}
+	})
+	eh.RegisterEventData(PaymentRecordedEvent, func() eh.EventData {
+		return &PaymentRecordedData{}
+	})
+	eh.RegisterEventData(InvoiceVoidedEvent, func() eh.EventData {
+		return &InvoiceVoidedData{}
+	})
+	eh.RegisterEventData(CreditNoteIssuedEvent, func() eh.EventData {
+		return &CreditNoteIssuedData{}
+	})
+	eh.RegisterEventData(NumberReservedEvent, func() eh.EventData {
+		return &NumberReservedData{}
+	})
+}
+
+const (
+	InvoiceIssuedEvent    eh.EventType = "InvoiceIssued"
+	PaymentRecordedEvent  eh.EventType = "PaymentRecorded"
+	InvoiceVoidedEvent    eh.EventType = "InvoiceVoided"
+	CreditNoteIssuedEvent eh.EventType = "CreditNoteIssued"
+	NumberReservedEvent   eh.EventType = "NumberReserved"
+)
+
+const (
+	// CreditNote lowers what is owed for an invoice
+	CreditNote = "credit"
+	// DebitNote raises what is owed for an invoice
+	DebitNote = "debit"
+)
+
+type InvoiceIssuedData struct {
+	Number string
+	User   string
+	Month  string
+	Lines  []billing.Line
+	Total  money.Money
+}
+
+type PaymentRecordedData struct {
+	Amount    money.Money
+	Reference string
+}
+
+type InvoiceVoidedData struct {
+	Reason string
+}
+
+// NoteLine is a reservation's line as it is now, and what changed since it was invoiced
+type NoteLine struct {
+	Line billing.Line
+	// Minutes and Amount are the differences to what was invoiced
+	Minutes int
+	Amount  money.Money
+}
+
+type CreditNoteIssuedData struct {
+	Number string
+	// Kind is CreditNote or DebitNote
+	Kind   string
+	Lines  []NoteLine
+	Amount money.Money
+	Reason string
+}
+
+type NumberReservedData struct {
+	DocumentID uuid.UUID
+	Number     int
+}
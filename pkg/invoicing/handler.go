@@ -0,0 +1,49 @@
+package invoicing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// Path + "{user}/{yyyy-mm}" gets a user's invoice for a month, with its notes and payments
+const Path = "/invoices/"
+
+// Handler serves the invoices read model
+func Handler(repo eh.ReadRepo) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "unsupported method: "+r.Method, http.StatusMethodNotAllowed)
+			return
+		}
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, Path), "/")
+		if len(parts) != 2 || parts[0] == "" {
+			http.NotFound(w, r)
+			return
+		}
+		if _, err := time.Parse("2006-01", parts[1]); err != nil {
+			http.Error(w, "invalid month, expected yyyy-mm: "+parts[1], http.StatusBadRequest)
+			return
+		}
+
+		invoice, err := repo.Find(r.Context(), InvoiceID(parts[0], parts[1]))
+		if errors.Is(err, eh.ErrEntityNotFound) {
+			http.Error(w, fmt.Sprintf("no invoice for %q in %v", parts[0], parts[1]), http.StatusNotFound)
+			return
+		} else if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(invoice); err != nil {
+			fmt.Printf("Could not write response: %v\n", err)
+		}
+	})
+}
@@ -0,0 +1,319 @@
+package invoicing
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/billing"
+	"github.com/MattDevy/CQRS-example/pkg/money"
+	"github.com/MattDevy/CQRS-example/pkg/payments"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/commandhandler/bus"
+	"github.com/looplab/eventhorizon/eventbus/local"
+	"github.com/looplab/eventhorizon/eventstore/memory"
+	memoryRepo "github.com/looplab/eventhorizon/repo/memory"
+)
+
+func testLine(start time.Time, minutes int, amount int64) billing.Line {
+	return billing.Line{
+		ReservationID: uuid.New(),
+		RoomID:        1,
+		StartTime:     start,
+		EndTime:       start.Add(time.Duration(minutes) * time.Minute),
+		Minutes:       minutes,
+		Amount:        money.New(amount, "USD"),
+		Status:        billing.LineCharged,
+	}
+}
+
+func TestInvoiceAggregate_HandleCommand(t *testing.T) {
+	id := uuid.New()
+	line := testLine(time.Date(2021, time.July, 1, 9, 0, 0, 0, time.UTC), 60, 240)
+	issue := &IssueInvoice{ID: id, Number: "000001", User: "Matt", Month: "2021-07", Lines: []billing.Line{line}}
+	voided := line
+	voided.Minutes, voided.Amount, voided.Status = 0, money.New(0, "USD"), billing.LineVoid
+
+	tests := []struct {
+		name    string
+		cmds    []eh.Command
+		wantErr error
+	}{
+		{"issue", []eh.Command{issue}, nil},
+		{"issue twice", []eh.Command{issue, issue}, ErrInvoiceIssued},
+		{"issue without a number", []eh.Command{&IssueInvoice{ID: id, User: "Matt", Month: "2021-07"}}, ErrInvalidInvoice},
+		{"pay before issue", []eh.Command{&RecordPayment{ID: id, Amount: money.New(240, "USD"), Reference: "a"}}, ErrInvoiceNotFound},
+		{"pay", []eh.Command{issue, &RecordPayment{ID: id, Amount: money.New(240, "USD"), Reference: "a"}}, nil},
+		{"pay twice", []eh.Command{issue, &RecordPayment{ID: id, Amount: money.New(100, "USD"), Reference: "a"}, &RecordPayment{ID: id, Amount: money.New(100, "USD"), Reference: "a"}}, ErrPaymentRecorded},
+		{"pay in another currency", []eh.Command{issue, &RecordPayment{ID: id, Amount: money.New(240, "EUR"), Reference: "a"}}, ErrInvalidAmount},
+		{"pay nothing", []eh.Command{issue, &RecordPayment{ID: id, Amount: money.New(0, "USD"), Reference: "a"}}, ErrInvalidAmount},
+		{"void", []eh.Command{issue, &VoidInvoice{ID: id}}, nil},
+		{"void paid", []eh.Command{issue, &RecordPayment{ID: id, Amount: money.New(240, "USD"), Reference: "a"}, &VoidInvoice{ID: id}}, ErrInvoicePaid},
+		{"pay void", []eh.Command{issue, &VoidInvoice{ID: id}, &RecordPayment{ID: id, Amount: money.New(240, "USD"), Reference: "a"}}, ErrInvoiceVoid},
+		{"credit", []eh.Command{issue, &IssueCreditNote{ID: id, Number: "000002", Lines: []billing.Line{voided}}}, nil},
+		{"credit unchanged", []eh.Command{issue, &IssueCreditNote{ID: id, Number: "000002", Lines: []billing.Line{line}}}, ErrNothingToCorrect},
+		{"credit twice", []eh.Command{issue, &IssueCreditNote{ID: id, Number: "000002", Lines: []billing.Line{voided}}, &IssueCreditNote{ID: id, Number: "000003", Lines: []billing.Line{voided}}}, ErrNothingToCorrect},
+		{"credit void", []eh.Command{issue, &VoidInvoice{ID: id}, &IssueCreditNote{ID: id, Number: "000002", Lines: []billing.Line{voided}}}, ErrInvoiceVoid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewInvoiceAggregate(id)
+			var err error
+			for _, cmd := range tt.cmds {
+				if err = a.HandleCommand(context.Background(), cmd); err != nil {
+					break
+				}
+				for _, e := range a.UncommittedEvents() {
+					a.ApplyEvent(context.Background(), e)
+				}
+				a.ClearUncommittedEvents()
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("HandleCommand() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestInvoiceAggregate_Notes(t *testing.T) {
+	id := uuid.New()
+	a := NewInvoiceAggregate(id)
+	start := time.Date(2021, time.July, 1, 9, 0, 0, 0, time.UTC)
+	kept, moved := testLine(start, 60, 240), testLine(start.Add(2*time.Hour), 30, 120)
+	added := testLine(start.Add(4*time.Hour), 90, 360)
+
+	longer := moved
+	longer.EndTime, longer.Minutes, longer.Amount = longer.EndTime.Add(30*time.Minute), 60, money.New(240, "USD")
+	cancelled := kept
+	cancelled.Minutes, cancelled.Amount, cancelled.Status = 0, money.New(0, "USD"), billing.LineVoid
+
+	tests := []struct {
+		name     string
+		cmd      eh.Command
+		wantKind string
+		want     money.Money
+	}{
+		{"debit a longer and added booking", &IssueCreditNote{ID: id, Number: "2", Lines: []billing.Line{kept, longer, added}}, DebitNote, money.New(480, "USD")},
+		{"credit a cancelled booking", &IssueCreditNote{ID: id, Number: "3", Lines: []billing.Line{cancelled, longer, added}}, CreditNote, money.New(-240, "USD")},
+	}
+	if err := a.HandleCommand(context.Background(), &IssueInvoice{ID: id, Number: "1", User: "Matt", Month: "2021-07", Lines: []billing.Line{kept, moved}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range a.UncommittedEvents() {
+		a.ApplyEvent(context.Background(), e)
+	}
+	a.ClearUncommittedEvents()
+	for _, tt := range tests {
+		if err := a.HandleCommand(context.Background(), tt.cmd); err != nil {
+			t.Fatalf("%v: %v", tt.name, err)
+		}
+		events := a.UncommittedEvents()
+		data, ok := events[0].Data().(*CreditNoteIssuedData)
+		if len(events) != 1 || !ok {
+			t.Fatalf("%v: events = %v, want a note", tt.name, events)
+		}
+		if data.Kind != tt.wantKind || data.Amount != tt.want {
+			t.Errorf("%v: note = %v of %v, want %v of %v", tt.name, data.Kind, data.Amount, tt.wantKind, tt.want)
+		}
+		a.ApplyEvent(context.Background(), events[0])
+		a.ClearUncommittedEvents()
+	}
+	if a.Balance() != money.New(600, "USD") {
+		t.Errorf("Balance() = %v, want USD 6.00", a.Balance())
+	}
+}
+
+func TestCloser_Close(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	eventBus := local.NewEventBus()
+	eventStore, err := memory.NewEventStore(memory.WithEventHandler(eventBus))
+	if err != nil {
+		t.Fatal(err)
+	}
+	commandBus := bus.NewCommandHandler()
+	invoiceRepo := memoryRepo.NewRepo()
+	Setup(ctx, eventStore, eventBus, commandBus, invoiceRepo, payments.NewFake(), payments.StaticMethods{Default: "card"})
+	billingRepo := memoryRepo.NewRepo()
+	billingRepo.SetEntityFactory(func() eh.Entity { return &billing.BillingHistory{} })
+
+	june := time.Date(2021, time.June, 30, 9, 0, 0, 0, time.UTC)
+	july := time.Date(2021, time.July, 1, 9, 0, 0, 0, time.UTC)
+	matt := &billing.BillingHistory{ID: uuid.New(), Version: 1, User: "Matt", Bills: map[string]*billing.Bill{}}
+	alice := &billing.BillingHistory{ID: uuid.New(), Version: 1, User: "Alice", Bills: map[string]*billing.Bill{}}
+	setBill := func(h *billing.BillingHistory, lines ...billing.Line) {
+		bill := &billing.Bill{ID: uuid.New(), Lines: map[string]*billing.Line{}}
+		for i := range lines {
+			bill.Lines[lines[i].ReservationID.String()] = &lines[i]
+			bill.Minutes += lines[i].Minutes
+			bill.Total = bill.Total.Add(lines[i].Amount)
+		}
+		h.Bills[billing.MonthKey(lines[0].StartTime)] = bill
+		h.Version++
+		if err := billingRepo.Save(ctx, h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mattJune, mattJuly := testLine(june, 60, 240), testLine(july, 30, 120)
+	setBill(matt, mattJune)
+	setBill(matt, mattJuly)
+	setBill(alice, testLine(june, 15, 60))
+
+	closer, err := NewCloser(commandBus, eventStore, billingRepo, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeAt := func(now time.Time, want []Result) {
+		t.Helper()
+		got, err := closer.Close(ctx, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Close() = %+v, want %+v", got, want)
+		}
+	}
+
+	// July has not ended
+	closeAt(july, []Result{
+		{User: "Alice", Month: "2021-06", Number: "000001", Result: Issued},
+		{User: "Matt", Month: "2021-06", Number: "000002", Result: Issued},
+	})
+	// Closing again changes nothing, and July is numbered after June
+	august := time.Date(2021, time.August, 1, 0, 0, 0, 0, time.UTC)
+	closeAt(august, []Result{
+		{User: "Alice", Month: "2021-06", Result: Unchanged},
+		{User: "Matt", Month: "2021-06", Result: Unchanged},
+		{User: "Matt", Month: "2021-07", Number: "000003", Result: Issued},
+	})
+
+	// A cancellation after the invoice was issued is credited
+	cancelled := mattJune
+	cancelled.Minutes, cancelled.Amount, cancelled.Status = 0, money.New(0, "USD"), billing.LineVoid
+	setBill(matt, cancelled)
+	closeAt(august, []Result{
+		{User: "Alice", Month: "2021-06", Result: Unchanged},
+		{User: "Matt", Month: "2021-06", Number: "000004", Result: Noted},
+		{User: "Matt", Month: "2021-07", Result: Unchanged},
+	})
+
+	// A bill that can't be closed is recorded, and every other bill is still closed
+	bob := &billing.BillingHistory{ID: uuid.New(), Version: 1, User: "Bob", Bills: map[string]*billing.Bill{"June": {ID: uuid.New()}}}
+	if err := billingRepo.Save(ctx, bob); err != nil {
+		t.Fatal(err)
+	}
+	setBill(alice, testLine(july, 15, 60))
+	got, err := closer.Close(ctx, august)
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) || len(closeErr.Errors) != 1 {
+		t.Fatalf("Close() error = %v, want a CloseError for Bob's bill", err)
+	}
+	if len(got) != 5 || got[2].User != "Bob" || got[2].Result != Failed || got[2].Error == "" {
+		t.Fatalf("Close() = %+v, want Bob's bill failed", got)
+	}
+	got[2].Error = ""
+	want := []Result{
+		{User: "Alice", Month: "2021-06", Result: Unchanged},
+		{User: "Alice", Month: "2021-07", Number: "000005", Result: Issued},
+		{User: "Bob", Month: "June", Result: Failed},
+		{User: "Matt", Month: "2021-06", Result: Unchanged},
+		{User: "Matt", Month: "2021-07", Result: Unchanged},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Close() = %+v, want %+v", got, want)
+	}
+
+	// The read model is projected asynchronously
+	for {
+		e, err := invoiceRepo.Find(ctx, InvoiceID("Matt", "2021-06"))
+		if invoice, ok := e.(*Invoice); err == nil && ok && len(invoice.Notes) == 1 {
+			if invoice.Number != "000002" || invoice.Notes[0].Kind != CreditNote || !invoice.Due.IsZero() || invoice.Total != money.New(240, "USD") {
+				t.Errorf("invoice = %+v, want 000002 for USD 2.40 fully credited", invoice)
+			}
+			break
+		} else if ctx.Err() != nil {
+			t.Fatalf("Find() = %+v, %v, want an invoice with a note", e, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPaymentSaga(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	eventBus := local.NewEventBus()
+	eventStore, err := memory.NewEventStore(memory.WithEventHandler(eventBus))
+	if err != nil {
+		t.Fatal(err)
+	}
+	commandBus := bus.NewCommandHandler()
+	invoiceRepo := memoryRepo.NewRepo()
+	gateway := payments.NewFake()
+	gateway.Decline("expired-card")
+	payments.Setup(ctx, eventStore, commandBus)
+	Setup(ctx, eventStore, eventBus, commandBus, invoiceRepo, gateway, payments.StaticMethods{
+		Default: "card",
+		Users:   map[string]string{"Alice": "expired-card"},
+	})
+
+	june := time.Date(2021, time.June, 30, 9, 0, 0, 0, time.UTC)
+	for i, user := range []string{"Matt", "Alice"} {
+		err := commandBus.HandleCommand(ctx, &IssueInvoice{
+			ID:     InvoiceID(user, "2021-06"),
+			Number: FormatNumber(i + 1),
+			User:   user,
+			Month:  "2021-06",
+			Lines:  []billing.Line{testLine(june, 60, 240)},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Matt's card is charged and the payment recorded on the invoice
+	for {
+		e, err := invoiceRepo.Find(ctx, InvoiceID("Matt", "2021-06"))
+		if invoice, ok := e.(*Invoice); err == nil && ok && invoice.Status == StatusPaid {
+			if invoice.Paid != money.New(240, "USD") || len(invoice.Payments) != 1 {
+				t.Errorf("invoice = %+v, want paid USD 2.40 once", invoice)
+			}
+			break
+		} else if ctx.Err() != nil {
+			t.Fatalf("Find() = %+v, %v, want a paid invoice", e, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Alice's card is declined, the invoice stays due
+	for {
+		events, err := eventStore.Load(ctx, payments.PaymentID(InvoiceID("Alice", "2021-06")))
+		if err == nil && len(events) == 1 {
+			data, ok := events[0].Data().(*payments.PaymentFailedData)
+			if !ok || !strings.Contains(data.Reason, payments.ErrDeclined.Error()) {
+				t.Errorf("event = %v %+v, want a declined PaymentFailed", events[0].EventType(), events[0].Data())
+			}
+			break
+		} else if ctx.Err() != nil {
+			t.Fatalf("Load() = %v, %v, want a failed payment", events, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for {
+		e, err := invoiceRepo.Find(ctx, InvoiceID("Alice", "2021-06"))
+		if invoice, ok := e.(*Invoice); err == nil && ok {
+			if invoice.Status != StatusIssued || !invoice.Paid.IsZero() {
+				t.Errorf("invoice = %+v, want an unpaid invoice", invoice)
+			}
+			break
+		} else if ctx.Err() != nil {
+			t.Fatalf("Find() = %+v, %v, want an invoice", e, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,135 @@
+package invoicing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/billing"
+	"github.com/MattDevy/CQRS-example/pkg/money"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/eventhandler/projector"
+)
+
+type InvoiceStatus string
+
+const (
+	StatusIssued InvoiceStatus = "issued"
+	StatusPaid   InvoiceStatus = "paid"
+	StatusVoid   InvoiceStatus = "void"
+)
+
+// Note is a credit or debit note issued for an invoice
+type Note struct {
+	Number   string
+	Kind     string
+	IssuedAt time.Time
+	Lines    []NoteLine
+	Amount   money.Money
+	Reason   string
+}
+
+// Payment is a payment recorded against an invoice
+type Payment struct {
+	Amount     money.Money
+	Reference  string
+	RecordedAt time.Time
+}
+
+// Invoice is the read-model of an invoice with its notes and payments
+type Invoice struct {
+	ID       uuid.UUID
+	Version  int
+	Number   string
+	User     string
+	Month    string
+	Status   InvoiceStatus
+	IssuedAt time.Time
+	Lines    []billing.Line
+	// Total is what was invoiced, Due is after the notes
+	Total    money.Money
+	Due      money.Money
+	Paid     money.Money
+	Notes    []*Note
+	Payments []*Payment
+}
+
+func (i *Invoice) EntityID() uuid.UUID {
+	return i.ID
+}
+
+func (i *Invoice) AggregateVersion() int {
+	return i.Version
+}
+
+// InvoiceProjector is the projector for the read-model
+type InvoiceProjector struct{}
+
+func NewInvoiceProjector() *InvoiceProjector {
+	return &InvoiceProjector{}
+}
+
+func (p *InvoiceProjector) ProjectorType() projector.Type {
+	return projector.Type(InvoiceAggregateType.String())
+}
+
+// Project is called each time an event related to a specific AggregateID come from the eventBus
+func (p *InvoiceProjector) Project(ctx context.Context, event eh.Event, entity eh.Entity) (eh.Entity, error) {
+	i, ok := entity.(*Invoice)
+	if !ok {
+		return nil, errors.New("model is of incorrect type")
+	}
+
+	switch event.EventType() {
+	case InvoiceIssuedEvent:
+		data, ok := event.Data().(*InvoiceIssuedData)
+		if !ok {
+			return nil, fmt.Errorf("projector: invalid event data type: %v", event.Data())
+		}
+		i.ID = event.AggregateID()
+		i.Number = data.Number
+		i.User = data.User
+		i.Month = data.Month
+		i.IssuedAt = event.Timestamp()
+		i.Lines = data.Lines
+		i.Total = data.Total
+		i.Due = data.Total
+		i.Paid = money.Money{Currency: data.Total.Currency}
+	case PaymentRecordedEvent:
+		data, ok := event.Data().(*PaymentRecordedData)
+		if !ok {
+			return nil, fmt.Errorf("projector: invalid event data type: %v", event.Data())
+		}
+		i.Paid = i.Paid.Add(data.Amount)
+		i.Payments = append(i.Payments, &Payment{Amount: data.Amount, Reference: data.Reference, RecordedAt: event.Timestamp()})
+	case InvoiceVoidedEvent:
+		i.Status = StatusVoid
+	case CreditNoteIssuedEvent:
+		data, ok := event.Data().(*CreditNoteIssuedData)
+		if !ok {
+			return nil, fmt.Errorf("projector: invalid event data type: %v", event.Data())
+		}
+		i.Due = i.Due.Add(data.Amount)
+		i.Notes = append(i.Notes, &Note{
+			Number:   data.Number,
+			Kind:     data.Kind,
+			IssuedAt: event.Timestamp(),
+			Lines:    data.Lines,
+			Amount:   data.Amount,
+			Reason:   data.Reason,
+		})
+	default:
+		return nil, fmt.Errorf("could not project event: %s", event.EventType())
+	}
+
+	if i.Status != StatusVoid {
+		i.Status = StatusIssued
+		if i.Paid.Amount >= i.Due.Amount {
+			i.Status = StatusPaid
+		}
+	}
+	i.Version++
+	return i, nil
+}
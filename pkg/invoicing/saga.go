@@ -0,0 +1,99 @@
+package invoicing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/MattDevy/CQRS-example/pkg/payments"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/eventhandler/saga"
+)
+
+const PaymentSagaType saga.Type = "PaymentSaga"
+
+// PaymentSaga charges issued invoices to the user's stored payment method, and records the payments on them
+// Authorisations are referenced by invoice, so a redelivered InvoiceIssued does not charge twice
+type PaymentSaga struct {
+	gateway payments.PaymentGateway
+	methods payments.Methods
+}
+
+func NewPaymentSaga(gateway payments.PaymentGateway, methods payments.Methods) *PaymentSaga {
+	return &PaymentSaga{
+		gateway: gateway,
+		methods: methods,
+	}
+}
+
+func (s *PaymentSaga) SagaType() saga.Type {
+	return PaymentSagaType
+}
+
+// RunSaga charges an issued invoice, and records a payment that succeeded on its invoice
+func (s *PaymentSaga) RunSaga(ctx context.Context, event eh.Event, h eh.CommandHandler) error {
+	switch event.EventType() {
+	case InvoiceIssuedEvent:
+		data, ok := event.Data().(*InvoiceIssuedData)
+		if !ok {
+			return fmt.Errorf("saga: invalid event data type: %v", event.Data())
+		}
+		if data.Total.Amount <= 0 {
+			return nil
+		}
+		err := h.HandleCommand(ctx, s.charge(ctx, event, data))
+		if errors.Is(err, payments.ErrPaymentSucceeded) {
+			return nil
+		}
+		return err
+	case payments.PaymentSucceededEvent:
+		data, ok := event.Data().(*payments.PaymentSucceededData)
+		if !ok {
+			return fmt.Errorf("saga: invalid event data type: %v", event.Data())
+		}
+		err := h.HandleCommand(ctx, &RecordPayment{
+			ID:        data.InvoiceID,
+			Amount:    data.Amount,
+			Reference: data.GatewayID,
+		})
+		if errors.Is(err, ErrPaymentRecorded) {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// charge authorises and captures the invoice's total, returning the command recording the outcome
+func (s *PaymentSaga) charge(ctx context.Context, event eh.Event, data *InvoiceIssuedData) eh.Command {
+	fail := func(err error) eh.Command {
+		return &payments.FailPayment{
+			ID:        payments.PaymentID(event.AggregateID()),
+			InvoiceID: event.AggregateID(),
+			User:      data.User,
+			Month:     data.Month,
+			Amount:    data.Total,
+			Reason:    err.Error(),
+		}
+	}
+
+	method, err := s.methods.Method(ctx, data.User)
+	if err != nil {
+		return fail(err)
+	}
+	gatewayID, err := s.gateway.Authorise(ctx, method, data.Total, event.AggregateID().String())
+	if err != nil {
+		return fail(err)
+	}
+	if err := s.gateway.Capture(ctx, gatewayID); err != nil {
+		return fail(err)
+	}
+	return &payments.CompletePayment{
+		ID:        payments.PaymentID(event.AggregateID()),
+		InvoiceID: event.AggregateID(),
+		User:      data.User,
+		Month:     data.Month,
+		Amount:    data.Total,
+		GatewayID: gatewayID,
+	}
+}
@@ -0,0 +1,78 @@
+package invoicing
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/aggregatestore/events"
+)
+
+func init() {
+	eh.RegisterAggregate(func(id uuid.UUID) eh.Aggregate {
+		return NewSequenceAggregate(id)
+	})
+}
+
+const SequenceAggregateType eh.AggregateType = "InvoiceSequence"
+
+var _ = eh.Aggregate(&SequenceAggregate{})
+
+// SequenceID is the ID of the sequence invoices and notes are numbered from
+var SequenceID = uuid.NewSHA1(Namespace, []byte("sequence"))
+
+// FormatNumber formats a number of the sequence, eg "000042"
+func FormatNumber(n int) string {
+	return fmt.Sprintf("%06d", n)
+}
+
+// SequenceAggregate hands out gap-free numbers, each number is bound to the document it was reserved for
+// Concurrent reservations are ordered by the event store's optimistic locking
+type SequenceAggregate struct {
+	*events.AggregateBase
+
+	last    int
+	numbers map[uuid.UUID]int
+}
+
+// NewSequenceAggregate returns an initialized SequenceAggregate, this should always be used to create the aggregate
+func NewSequenceAggregate(id uuid.UUID) *SequenceAggregate {
+	return &SequenceAggregate{
+		AggregateBase: events.NewAggregateBase(SequenceAggregateType, id),
+		numbers:       make(map[uuid.UUID]int),
+	}
+}
+
+// Number returns the number reserved for the document, false if there is none
+func (s *SequenceAggregate) Number(documentID uuid.UUID) (int, bool) {
+	n, ok := s.numbers[documentID]
+	return n, ok
+}
+
+// HandleCommand is called whenever the commandBus recieves a command for which this aggregate is registered
+func (s *SequenceAggregate) HandleCommand(ctx context.Context, cmd eh.Command) error {
+	switch cmd := cmd.(type) {
+	case *ReserveNumber:
+		if _, ok := s.numbers[cmd.DocumentID]; ok {
+			return nil
+		}
+		s.AppendEvent(NumberReservedEvent, &NumberReservedData{
+			DocumentID: cmd.DocumentID,
+			Number:     s.last + 1,
+		}, time.Now())
+	default:
+		return fmt.Errorf("could not handle command: %s", cmd.CommandType())
+	}
+	return nil
+}
+
+// ApplyEvent is called whenever an event is recieved on the eventBus
+func (s *SequenceAggregate) ApplyEvent(ctx context.Context, event eh.Event) error {
+	if data, ok := event.Data().(*NumberReservedData); ok {
+		s.last = data.Number
+		s.numbers[data.DocumentID] = data.Number
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package invoicing
+
+import (
+	"context"
+	"log"
+
+	"github.com/MattDevy/CQRS-example/pkg/payments"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/aggregatestore/events"
+	"github.com/looplab/eventhorizon/commandhandler/aggregate"
+	"github.com/looplab/eventhorizon/commandhandler/bus"
+	"github.com/looplab/eventhorizon/eventhandler/projector"
+	"github.com/looplab/eventhorizon/eventhandler/saga"
+	"github.com/looplab/eventhorizon/repo/memory"
+	"github.com/looplab/eventhorizon/repo/mongodb"
+)
+
+// Setup will initialize and register the invoice and sequence aggregates, their commands, the invoice projector
+// and the saga charging issued invoices through gateway
+func Setup(
+	ctx context.Context,
+	eventStore eh.EventStore,
+	eventBus eh.EventBus,
+	commandBus *bus.CommandHandler,
+	invoiceRepo eh.ReadWriteRepo,
+	gateway payments.PaymentGateway,
+	methods payments.Methods,
+) {
+	if memoryRepo := memory.IntoRepo(ctx, invoiceRepo); memoryRepo != nil {
+		memoryRepo.SetEntityFactory(func() eh.Entity { return &Invoice{} })
+	}
+	if mongoRepo := mongodb.IntoRepo(ctx, invoiceRepo); mongoRepo != nil {
+		mongoRepo.SetEntityFactory(func() eh.Entity { return &Invoice{} })
+	}
+
+	invoiceProjector := projector.NewEventHandler(NewInvoiceProjector(), invoiceRepo)
+	invoiceProjector.SetEntityFactory(func() eh.Entity { return &Invoice{} })
+	eventBus.AddHandler(ctx, eh.MatchEvents{
+		InvoiceIssuedEvent,
+		PaymentRecordedEvent,
+		InvoiceVoidedEvent,
+		CreditNoteIssuedEvent,
+	}, invoiceProjector)
+
+	paymentSaga := saga.NewEventHandler(NewPaymentSaga(gateway, methods), commandBus)
+	eventBus.AddHandler(ctx, eh.MatchEvents{
+		InvoiceIssuedEvent,
+		payments.PaymentSucceededEvent,
+	}, paymentSaga)
+
+	aggregateStore, err := events.NewAggregateStore(eventStore)
+	if err != nil {
+		log.Fatalf("could not create aggregate store: %v", err)
+	}
+
+	handlers := map[eh.AggregateType][]eh.CommandType{
+		InvoiceAggregateType: {
+			IssueInvoiceCommand,
+			RecordPaymentCommand,
+			VoidInvoiceCommand,
+			IssueCreditNoteCommand,
+		},
+		SequenceAggregateType: {
+			ReserveNumberCommand,
+		},
+	}
+	for aggregateType, commands := range handlers {
+		commandHandler, err := aggregate.NewCommandHandler(aggregateType, aggregateStore)
+		if err != nil {
+			log.Fatalf("could not create command handler: %s", err)
+		}
+		for _, cmdType := range commands {
+			if err := commandBus.SetHandler(commandHandler, cmdType); err != nil {
+				log.Fatalf("could not set command handler: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package live
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/calendar"
+	"github.com/google/uuid"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// EventsPath streams updates as server-sent events
+	EventsPath = "/live/reservations"
+	// WebSocketPath streams updates as JSON WebSocket messages
+	WebSocketPath = "/live/reservations/ws"
+	// KeepAliveInterval is how often an idle event stream is sent a comment, so proxies keep it open
+	KeepAliveInterval = 15 * time.Second
+)
+
+// Register registers the SSE and WebSocket endpoints on the mux
+// Both take the id, room, user and version filters as query parameters, and the token of the user's or room's
+// calendar feed. SSE resumes from the Last-Event-ID header, WebSockets from the lastEventId parameter.
+func (h *Hub) Register(mux *http.ServeMux) {
+	mux.HandleFunc(EventsPath, h.serveEvents)
+	mux.Handle(WebSocketPath, websocket.Handler(h.serveWebSocket))
+}
+
+// parseSubscription reads the filter and the sequence to resume from
+func parseSubscription(values url.Values, lastEventID string) (Filter, uint64, error) {
+	var f Filter
+	var err error
+	if id := values.Get("id"); id != "" {
+		if f.ID, err = uuid.Parse(id); err != nil {
+			return f, 0, fmt.Errorf("invalid id %q", id)
+		}
+	}
+	if room := values.Get("room"); room != "" {
+		if f.RoomID, err = strconv.Atoi(room); err != nil {
+			return f, 0, fmt.Errorf("invalid room %q", room)
+		}
+	}
+	f.User = values.Get("user")
+	f.Token = values.Get(calendar.TokenParam)
+	if version := values.Get("version"); version != "" {
+		if f.ID == uuid.Nil {
+			return f, 0, fmt.Errorf("version can only be used with id")
+		}
+		if f.Version, err = strconv.Atoi(version); err != nil {
+			return f, 0, fmt.Errorf("invalid version %q", version)
+		}
+	}
+
+	var lastSeq uint64
+	if lastEventID != "" {
+		if lastSeq, err = strconv.ParseUint(lastEventID, 10, 64); err != nil {
+			return f, 0, fmt.Errorf("invalid last event id %q", lastEventID)
+		}
+	}
+	return f, lastSeq, nil
+}
+
+func (h *Hub) serveEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "unsupported method: "+r.Method, http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	filter, lastSeq, err := parseSubscription(r.URL.Query(), r.Header.Get("Last-Event-ID"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	updates, err := h.Subscribe(r.Context(), filter, lastSeq)
+	if errors.Is(err, ErrForbidden) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(KeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case u, ok := <-updates:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(u.Reservation)
+			if err != nil {
+				fmt.Printf("Could not marshal reservation: %v\n", err)
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\nevent: reservation\ndata: %s\n\n", u.Seq, data)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		flusher.Flush()
+	}
+}
+
+func (h *Hub) serveWebSocket(ws *websocket.Conn) {
+	defer ws.Close()
+	r := ws.Request()
+	filter, lastSeq, err := parseSubscription(r.URL.Query(), r.URL.Query().Get("lastEventId"))
+	if err != nil {
+		websocket.JSON.Send(ws, map[string]string{"Error": err.Error()})
+		return
+	}
+
+	// The client never sends anything, reading only notices when it goes away
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		defer cancel()
+		var discard []byte
+		for websocket.Message.Receive(ws, &discard) == nil {
+		}
+	}()
+
+	updates, err := h.Subscribe(ctx, filter, lastSeq)
+	if err != nil {
+		if !errors.Is(err, ErrForbidden) {
+			fmt.Printf("Error: %v\n", err)
+		}
+		websocket.JSON.Send(ws, map[string]string{"Error": err.Error()})
+		return
+	}
+	for u := range updates {
+		if err := websocket.JSON.Send(ws, u); err != nil {
+			return
+		}
+	}
+}
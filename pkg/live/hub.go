@@ -0,0 +1,245 @@
+package live
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/MattDevy/CQRS-example/pkg/calendar"
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	"github.com/MattDevy/CQRS-example/pkg/watch"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// DefaultBufferSize is how many updates are kept for subscribers to resume from
+const DefaultBufferSize = 1024
+
+// ErrForbidden is returned when subscribing to a user's or room's reservations without the token of its calendar feed
+var ErrForbidden = errors.New("live: subscription needs an id, or the token of the user or room")
+
+// Update is a projected reservation, Seq orders the updates of a Hub
+type Update struct {
+	Seq         uint64
+	Reservation *reservations.Reservation
+}
+
+// Filter selects the reservations a subscriber is sent, zero fields match everything
+type Filter struct {
+	ID     uuid.UUID
+	RoomID int
+	User   string
+	// Version skips updates of the reservation at or below it, to resume a single reservation
+	Version int
+	// Token is the calendar feed token of the User, or of the RoomID if no User is set
+	Token string
+}
+
+// Match returns true if the reservation passes the filter
+func (f Filter) Match(r *reservations.Reservation) bool {
+	return (f.ID == uuid.Nil || r.ID == f.ID) &&
+		(f.RoomID == 0 || r.RoomID == f.RoomID) &&
+		(f.User == "" || r.Creator == f.User) &&
+		(f.ID == uuid.Nil || r.Version > f.Version)
+}
+
+// Hub streams reservations to subscribers once the projector has saved them
+// It watches the repo the projector saves to, so only sees the saves made by this process.
+type Hub struct {
+	repo   *watch.Repo
+	tokens *calendar.Tokens
+
+	seq       uint64
+	buffer    []Update
+	published map[uuid.UUID]int
+	subs      map[*subscriber]struct{}
+	mu        sync.Mutex
+}
+
+type subscriber struct {
+	filter  Filter
+	updates chan Update
+}
+
+// NewHub returns a Hub publishing every reservation saved to repo, until ctx is done
+// If tokens is not nil, subscribing to a user's or room's reservations needs the token of its calendar feed,
+// like the feed itself, and other subscriptions must be for a single reservation.
+func NewHub(ctx context.Context, repo *watch.Repo, tokens *calendar.Tokens, bufferSize int) *Hub {
+	h := &Hub{
+		repo:      repo,
+		tokens:    tokens,
+		buffer:    make([]Update, 0, bufferSize),
+		published: make(map[uuid.UUID]int),
+		subs:      make(map[*subscriber]struct{}),
+	}
+	saved := repo.Watch(ctx, func(e eh.Entity) bool {
+		_, ok := e.(*reservations.Reservation)
+		return ok
+	})
+	go func() {
+		for e := range saved {
+			h.publish(e.(*reservations.Reservation))
+		}
+	}()
+	return h
+}
+
+// authorize checks the subscriber may see the reservations matching the filter
+// A user's or room's reservations need its token, a reservation's unguessable ID is enough on its own.
+func (h *Hub) authorize(f Filter) error {
+	if h.tokens == nil {
+		return nil
+	}
+	switch {
+	case f.User != "":
+		if h.tokens.ValidUserToken(f.User, f.Token) {
+			return nil
+		}
+	case f.RoomID != 0:
+		if h.tokens.ValidRoomToken(f.RoomID, f.Token) {
+			return nil
+		}
+	case f.ID != uuid.Nil:
+		return nil
+	}
+	return ErrForbidden
+}
+
+// publish buffers the update and sends it to the subscribers, skipping versions already published
+func (h *Hub) publish(r *reservations.Reservation) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if r.Version <= h.published[r.ID] {
+		return
+	}
+	h.published[r.ID] = r.Version
+
+	h.seq++
+	u := Update{Seq: h.seq, Reservation: r}
+	if len(h.buffer) == cap(h.buffer) {
+		copy(h.buffer, h.buffer[1:])
+		h.buffer = h.buffer[:len(h.buffer)-1]
+	}
+	h.buffer = append(h.buffer, u)
+
+	for s := range h.subs {
+		if !s.filter.Match(r) {
+			continue
+		}
+		// Never block the event bus on a slow subscriber, it can resume from its last update
+		select {
+		case s.updates <- u:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel of the updates matching the filter, which is closed once ctx is done
+// If lastSeq is set the buffered updates after it are sent first, or if they are no longer buffered
+// the current state of every matching reservation is sent instead
+// It returns ErrForbidden if the filter is not authorized, see NewHub.
+func (h *Hub) Subscribe(ctx context.Context, filter Filter, lastSeq uint64) (<-chan Update, error) {
+	if err := h.authorize(filter); err != nil {
+		return nil, err
+	}
+	s := &subscriber{
+		filter:  filter,
+		updates: make(chan Update, 64),
+	}
+
+	h.mu.Lock()
+	seq := h.seq
+	var backlog []Update
+	snapshot := lastSeq != 0 || filter.ID != uuid.Nil
+	if lastSeq != 0 && len(h.buffer) > 0 && lastSeq >= h.buffer[0].Seq-1 && lastSeq <= seq {
+		snapshot = false
+		for _, u := range h.buffer {
+			if u.Seq > lastSeq && filter.Match(u.Reservation) {
+				backlog = append(backlog, u)
+			}
+		}
+	}
+	h.subs[s] = struct{}{}
+	h.mu.Unlock()
+
+	if snapshot {
+		rs, err := h.find(ctx, filter)
+		if err != nil {
+			h.unsubscribe(s)
+			return nil, err
+		}
+		for _, r := range rs {
+			backlog = append(backlog, Update{Seq: seq, Reservation: r})
+		}
+	}
+
+	out := make(chan Update)
+	go func() {
+		defer close(out)
+		defer h.unsubscribe(s)
+
+		// The snapshot and the live updates can overlap, only send each version once
+		sent := make(map[uuid.UUID]int)
+		send := func(u Update) bool {
+			if u.Reservation.Version <= sent[u.Reservation.ID] {
+				return true
+			}
+			select {
+			case out <- u:
+				sent[u.Reservation.ID] = u.Reservation.Version
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		for _, u := range backlog {
+			if !send(u) {
+				return
+			}
+		}
+		for {
+			select {
+			case u := <-s.updates:
+				if !send(u) {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (h *Hub) unsubscribe(s *subscriber) {
+	h.mu.Lock()
+	delete(h.subs, s)
+	h.mu.Unlock()
+}
+
+// find returns the current state of the reservations matching the filter
+func (h *Hub) find(ctx context.Context, filter Filter) ([]*reservations.Reservation, error) {
+	var entities []eh.Entity
+	if filter.ID != uuid.Nil {
+		e, err := h.repo.Find(ctx, filter.ID)
+		if errors.Is(err, eh.ErrEntityNotFound) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		entities = append(entities, e)
+	} else {
+		var err error
+		if entities, err = h.repo.FindAll(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	var rs []*reservations.Reservation
+	for _, e := range entities {
+		if r, ok := e.(*reservations.Reservation); ok && filter.Match(r) {
+			rs = append(rs, r)
+		}
+	}
+	return rs, nil
+}
@@ -0,0 +1,222 @@
+package live
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/calendar"
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	"github.com/MattDevy/CQRS-example/pkg/watch"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/commandhandler/bus"
+	"github.com/looplab/eventhorizon/eventbus/local"
+	"github.com/looplab/eventhorizon/eventstore/memory"
+	memoryRepo "github.com/looplab/eventhorizon/repo/memory"
+	"golang.org/x/net/websocket"
+)
+
+// tokens are the calendar feed tokens subscriptions are authorized with
+var tokens = calendar.NewTokens([]byte("test-secret"))
+
+func newTestHub(t *testing.T) (*httptest.Server, *Hub, eh.CommandHandler) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	eventBus := local.NewEventBus()
+	eventStore, err := memory.NewEventStore(memory.WithEventHandler(eventBus))
+	if err != nil {
+		t.Fatal(err)
+	}
+	commandBus := bus.NewCommandHandler()
+	reservationRepo := watch.NewRepo(memoryRepo.NewRepo())
+	reservations.Setup(ctx, eventStore, eventBus, commandBus, reservationRepo)
+
+	hub := NewHub(ctx, reservationRepo, tokens, DefaultBufferSize)
+	mux := http.NewServeMux()
+	hub.Register(mux)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, hub, commandBus
+}
+
+func create(t *testing.T, commandHandler eh.CommandHandler, roomID int, user string) uuid.UUID {
+	id := uuid.New()
+	start := time.Now().Add(time.Hour)
+	if err := commandHandler.HandleCommand(context.Background(), &reservations.CreateReservation{
+		ID:        id,
+		Name:      "Standup",
+		User:      user,
+		RoomID:    roomID,
+		StartTime: start,
+		EndTime:   start.Add(time.Hour),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// readEvent reads the next reservation event of a stream
+func readEvent(t *testing.T, scanner *bufio.Scanner) (string, *reservations.Reservation) {
+	var id string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "id: ") {
+			id = strings.TrimPrefix(line, "id: ")
+		} else if strings.HasPrefix(line, "data: ") {
+			r := &reservations.Reservation{}
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), r); err != nil {
+				t.Fatal(err)
+			}
+			return id, r
+		}
+	}
+	t.Fatalf("stream ended: %v", scanner.Err())
+	return "", nil
+}
+
+func openEvents(t *testing.T, ctx context.Context, url, lastEventID string) *bufio.Scanner {
+	resp := get(t, ctx, url, lastEventID)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %v = %v", url, resp.StatusCode)
+	}
+	return bufio.NewScanner(resp.Body)
+}
+
+func get(t *testing.T, ctx context.Context, url, lastEventID string) *http.Response {
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestHub_Events(t *testing.T) {
+	srv, _, commandHandler := newTestHub(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	room := srv.URL + EventsPath + "?room=2&token=" + tokens.RoomToken(2)
+	stream := openEvents(t, ctx, room, "")
+	create(t, commandHandler, 1, "Matt")
+	id := create(t, commandHandler, 2, "Matt")
+
+	// Only room 2 is streamed, it is confirmed by the saga straight after being created
+	var lastEventID string
+	for {
+		var r *reservations.Reservation
+		lastEventID, r = readEvent(t, stream)
+		if r.ID != id {
+			t.Fatalf("event = %v, want %v", r.ID, id)
+		}
+		if r.Status == reservations.StatusConfirmed {
+			break
+		}
+	}
+
+	// Resuming replays only the updates after the last event
+	other := create(t, commandHandler, 2, "Alice")
+	resumed := openEvents(t, ctx, room, lastEventID)
+	if _, r := readEvent(t, resumed); r.ID != other {
+		t.Errorf("resumed event = %v, want %v", r.ID, other)
+	}
+
+	// A single reservation resumes from its version
+	single := openEvents(t, ctx, srv.URL+EventsPath+"?id="+id.String()+"&version=1", "")
+	if _, r := readEvent(t, single); r.ID != id || r.Status != reservations.StatusConfirmed {
+		t.Errorf("single event = %v %v, want %v confirmed", r.ID, r.Status, id)
+	}
+}
+
+func TestHub_WebSocket(t *testing.T) {
+	srv, hub, commandHandler := newTestHub(t)
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+WebSocketPath+"?user=Alice&token="+tokens.UserToken("Alice"), "", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	ws.SetDeadline(time.Now().Add(10 * time.Second))
+
+	// The handshake completes before the handler subscribes
+	for subscribed := false; !subscribed; time.Sleep(time.Millisecond) {
+		hub.mu.Lock()
+		subscribed = len(hub.subs) == 1
+		hub.mu.Unlock()
+	}
+
+	create(t, commandHandler, 1, "Matt")
+	id := create(t, commandHandler, 1, "Alice")
+
+	var u Update
+	if err := websocket.JSON.Receive(ws, &u); err != nil {
+		t.Fatal(err)
+	}
+	if u.Reservation.ID != id || u.Seq == 0 {
+		t.Errorf("update = %+v, want %v", u, id)
+	}
+}
+
+func TestHub_Forbidden(t *testing.T) {
+	srv, _, _ := newTestHub(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tests := []struct {
+		name  string
+		query string
+		want  int
+	}{
+		{"everything", "", http.StatusForbidden},
+		{"user without a token", "user=Alice", http.StatusForbidden},
+		{"user with another user's token", "user=Alice&token=" + tokens.UserToken("Matt"), http.StatusForbidden},
+		{"user with the room's token", "user=Alice&room=2&token=" + tokens.RoomToken(2), http.StatusForbidden},
+		{"room without a token", "room=2", http.StatusForbidden},
+		{"reservation with a user", "id=" + uuid.New().String() + "&user=Alice", http.StatusForbidden},
+		{"user", "user=Alice&token=" + tokens.UserToken("Alice"), http.StatusOK},
+		{"user in a room", "user=Alice&room=2&token=" + tokens.UserToken("Alice"), http.StatusOK},
+		{"reservation", "id=" + uuid.New().String(), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			if resp := get(t, ctx, srv.URL+EventsPath+"?"+tt.query, ""); resp.StatusCode != tt.want {
+				t.Errorf("GET %v = %v, want %v", tt.query, resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSubscription(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		wantErr bool
+	}{
+		{"empty", "", false},
+		{"all filters", "id=" + uuid.New().String() + "&room=1&user=Matt&version=3", false},
+		{"invalid id", "id=nope", true},
+		{"invalid room", "room=one", true},
+		{"version without id", "version=3", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, EventsPath+"?"+tt.query, nil)
+			if _, _, err := parseSubscription(req.URL.Query(), ""); (err != nil) != tt.wantErr {
+				t.Errorf("parseSubscription() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
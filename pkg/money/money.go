@@ -0,0 +1,134 @@
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// exponents are the number of minor unit digits of the ISO 4217 currencies, 2 if not listed
+var exponents = map[string]int{
+	"JPY": 0,
+	"KRW": 0,
+	"BHD": 3,
+	"KWD": 3,
+}
+
+// Exponent returns the number of digits after the decimal point of the currency
+func Exponent(currency string) int {
+	if e, ok := exponents[currency]; ok {
+		return e
+	}
+	return 2
+}
+
+// ErrCurrencyMismatch is returned when amounts in different currencies are combined
+var ErrCurrencyMismatch = errors.New("currencies do not match")
+
+// Money is an exact amount in the minor units (eg cents) of a currency
+// The zero value has no currency and can be added to an amount of any currency
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+// New returns the amount of minor units of the currency
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// Parse parses a decimal amount of the currency, eg "4.80", it fails rather than rounding
+func Parse(s, currency string) (Money, error) {
+	amount, err := parseDecimal(s, Exponent(currency))
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+// Add returns m + o, panicking with ErrCurrencyMismatch if their currencies differ
+func (m Money) Add(o Money) Money {
+	return Money{Amount: m.Amount + o.Amount, Currency: m.currency(o)}
+}
+
+// Sub returns m - o, panicking with ErrCurrencyMismatch if their currencies differ
+func (m Money) Sub(o Money) Money {
+	return Money{Amount: m.Amount - o.Amount, Currency: m.currency(o)}
+}
+
+// Neg returns -m
+func (m Money) Neg() Money {
+	return Money{Amount: -m.Amount, Currency: m.Currency}
+}
+
+// IsZero returns true if the amount is zero, in any currency
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// currency returns the currency of the result of combining m and o
+func (m Money) currency(o Money) string {
+	switch {
+	case m.Currency == o.Currency || o.Currency == "":
+		return m.Currency
+	case m.Currency == "":
+		return o.Currency
+	default:
+		panic(fmt.Errorf("%w: %v and %v", ErrCurrencyMismatch, m.Currency, o.Currency))
+	}
+}
+
+// Float64 returns the amount in major units, for display and legacy APIs only
+func (m Money) Float64() float64 {
+	f, _ := new(big.Rat).SetFrac64(m.Amount, pow10(Exponent(m.Currency))).Float64()
+	return f
+}
+
+// String formats the amount with its currency, eg "USD 4.80"
+func (m Money) String() string {
+	return strings.TrimSpace(m.Currency + " " + formatDecimal(m.Amount, Exponent(m.Currency)))
+}
+
+func pow10(n int) int64 {
+	p := int64(1)
+	for i := 0; i < n; i++ {
+		p *= 10
+	}
+	return p
+}
+
+// parseDecimal parses s as an integer scaled by 10^exp, failing if it has more decimals than exp
+func parseDecimal(s string, exp int) (int64, error) {
+	negative := strings.HasPrefix(s, "-")
+	whole, frac := strings.TrimPrefix(s, "-"), ""
+	if i := strings.IndexByte(whole, '.'); i >= 0 {
+		whole, frac = whole[:i], whole[i+1:]
+	}
+	if whole == "" && frac == "" || len(frac) > exp || strings.ContainsAny(whole+frac, "+-") {
+		return 0, fmt.Errorf("invalid amount %q, expected at most %d decimals", s, exp)
+	}
+	frac += strings.Repeat("0", exp-len(frac))
+	amount, err := strconv.ParseInt("0"+whole+frac, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+// formatDecimal formats amount scaled by 10^exp with exp decimals
+func formatDecimal(amount int64, exp int) string {
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	if exp == 0 {
+		return sign + strconv.FormatInt(amount, 10)
+	}
+	p := pow10(exp)
+	return fmt.Sprintf("%s%d.%0*d", sign, amount/p, exp, amount%p)
+}
@@ -0,0 +1,102 @@
+package money
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in       string
+		currency string
+		want     Money
+		wantErr  bool
+	}{
+		{"4.80", "USD", New(480, "USD"), false},
+		{"4.8", "USD", New(480, "USD"), false},
+		{"-0.05", "USD", New(-5, "USD"), false},
+		{".5", "USD", New(50, "USD"), false},
+		{"1200", "JPY", New(1200, "JPY"), false},
+		{"1.005", "USD", Money{}, true},
+		{"1.5", "JPY", Money{}, true},
+		{"", "USD", Money{}, true},
+		{"1.-5", "USD", Money{}, true},
+		{"abc", "USD", Money{}, true},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in, tt.currency)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("Parse(%q, %v) = %v, %v, want %v, error %v", tt.in, tt.currency, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestMoney_String(t *testing.T) {
+	tests := []struct {
+		m    Money
+		want string
+	}{
+		{New(480, "USD"), "USD 4.80"},
+		{New(-5, "USD"), "USD -0.05"},
+		{New(1200, "JPY"), "JPY 1200"},
+		{New(1, "KWD"), "KWD 0.001"},
+	}
+	for _, tt := range tests {
+		if got := tt.m.String(); got != tt.want {
+			t.Errorf("String() = %v, want %v", got, tt.want)
+		}
+		if back, err := Parse(tt.m.String()[4:], tt.m.Currency); err != nil || back != tt.m {
+			t.Errorf("Parse(String()) = %v, %v, want %v", back, err, tt.m)
+		}
+	}
+}
+
+func TestMoney_Add(t *testing.T) {
+	if got := (Money{}).Add(New(5, "USD")); got != New(5, "USD") {
+		t.Errorf("zero + USD 0.05 = %v", got)
+	}
+	if got := New(5, "USD").Sub(New(7, "USD")); got != New(-2, "USD") {
+		t.Errorf("USD 0.05 - USD 0.07 = %v", got)
+	}
+	defer func() {
+		err, _ := recover().(error)
+		if !errors.Is(err, ErrCurrencyMismatch) {
+			t.Errorf("USD + GBP panicked with %v, want %v", err, ErrCurrencyMismatch)
+		}
+	}()
+	New(5, "USD").Add(New(5, "GBP"))
+}
+
+func TestRate_Times(t *testing.T) {
+	rate := MustParseRate("0.045", "USD")
+	tests := []struct {
+		units    int64
+		rounding Rounding
+		want     int64
+	}{
+		{100, HalfEven, 450},
+		// 4.5 cents
+		{1, HalfEven, 4},
+		{1, HalfUp, 5},
+		{1, Down, 4},
+		{1, Up, 5},
+		// 13.5 cents
+		{3, HalfEven, 14},
+		{-1, HalfEven, -4},
+		{-1, HalfUp, -5},
+		{-1, Down, -4},
+		{-1, Up, -5},
+		{0, Up, 0},
+	}
+	for _, tt := range tests {
+		if got := rate.Times(tt.units, tt.rounding); got != New(tt.want, "USD") {
+			t.Errorf("Times(%d, %v) = %v, want %v", tt.units, tt.rounding, got, tt.want)
+		}
+	}
+	if got := MustParseRate("0.0405", "USD").Times(1, HalfUp); got != New(4, "USD") {
+		t.Errorf("USD 0.0405 Times(1) = %v, want USD 0.04", got)
+	}
+	if got := rate.String(); got != "USD 0.045000" {
+		t.Errorf("String() = %v", got)
+	}
+}
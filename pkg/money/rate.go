@@ -0,0 +1,109 @@
+package money
+
+import "fmt"
+
+// RateExponent is the number of decimals of a minor unit a Rate is precise to
+const RateExponent = 4
+
+// Rounding is how a fraction of a minor unit is rounded
+type Rounding int
+
+const (
+	// HalfEven rounds to the nearest minor unit, and halves to the even one (banker's rounding)
+	HalfEven Rounding = iota
+	// HalfUp rounds to the nearest minor unit, and halves away from zero
+	HalfUp
+	// Down rounds towards zero
+	Down
+	// Up rounds away from zero
+	Up
+)
+
+// Rate is a price per unit, eg per minute, precise to fractions of a minor unit
+type Rate struct {
+	// PerUnit is in 10^-RateExponent minor units
+	PerUnit  int64
+	Currency string
+}
+
+// ParseRate parses a decimal price per unit in major units of the currency, eg "0.045"
+func ParseRate(s, currency string) (Rate, error) {
+	perUnit, err := parseDecimal(s, Exponent(currency)+RateExponent)
+	if err != nil {
+		return Rate{}, err
+	}
+	return Rate{PerUnit: perUnit, Currency: currency}, nil
+}
+
+// MustParseRate is ParseRate, panicking on an invalid rate
+func MustParseRate(s, currency string) Rate {
+	r, err := ParseRate(s, currency)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Times returns the price of the units, rounded once to a minor unit
+func (r Rate) Times(units int64, rounding Rounding) Money {
+	return Fraction(r.PerUnit*units, pow10(RateExponent), r.Currency, rounding)
+}
+
+// Fraction returns n/d minor units of the currency, rounded once, d must be positive
+func Fraction(n, d int64, currency string, rounding Rounding) Money {
+	return Money{Amount: divide(n, d, rounding), Currency: currency}
+}
+
+// ParseRounding parses the name of a rounding rule, "half-even", "half-up", "down" or "up"
+func ParseRounding(s string) (Rounding, error) {
+	for rounding, name := range roundingNames {
+		if name == s {
+			return rounding, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid rounding %q", s)
+}
+
+var roundingNames = map[Rounding]string{
+	HalfEven: "half-even",
+	HalfUp:   "half-up",
+	Down:     "down",
+	Up:       "up",
+}
+
+func (r Rounding) String() string {
+	return roundingNames[r]
+}
+
+// String formats the rate in major units with its currency, eg "USD 0.045000"
+func (r Rate) String() string {
+	return r.Currency + " " + formatDecimal(r.PerUnit, Exponent(r.Currency)+RateExponent)
+}
+
+// divide returns n / d rounded, d must be positive
+func divide(n, d int64, rounding Rounding) int64 {
+	q, rem := n/d, n%d
+	if rem == 0 {
+		return q
+	}
+	// Go truncates towards zero, so the remainder has the sign of n
+	away := int64(1)
+	if n < 0 {
+		away, rem = -1, -rem
+	}
+	switch rounding {
+	case Down:
+		return q
+	case Up:
+		return q + away
+	case HalfUp:
+		if 2*rem >= d {
+			return q + away
+		}
+	case HalfEven:
+		if 2*rem > d || (2*rem == d && q%2 != 0) {
+			return q + away
+		}
+	}
+	return q
+}
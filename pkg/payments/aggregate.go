@@ -0,0 +1,84 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/aggregatestore/events"
+)
+
+func init() {
+	eh.RegisterAggregate(func(id uuid.UUID) eh.Aggregate {
+		return NewPaymentAggregate(id)
+	})
+}
+
+const PaymentAggregateType eh.AggregateType = "Payment"
+
+var _ = eh.Aggregate(&PaymentAggregate{})
+
+// ErrPaymentSucceeded is returned when a payment that already succeeded is completed or failed again
+var ErrPaymentSucceeded = errors.New("payment already succeeded")
+
+// Namespace is the UUID namespace payment IDs are generated in
+var Namespace = uuid.MustParse("b4ca8247-2bd5-4759-9787-9256ffc9a149")
+
+// PaymentID returns the ID of the payment of an invoice, so an invoice is only charged once
+func PaymentID(invoiceID uuid.UUID) uuid.UUID {
+	return uuid.NewSHA1(Namespace, invoiceID[:])
+}
+
+// PaymentAggregate is the write-model of charging an invoice, it can fail any number of times but only succeed once
+type PaymentAggregate struct {
+	*events.AggregateBase
+
+	succeeded bool
+}
+
+// NewPaymentAggregate returns an initialized PaymentAggregate, this should always be used to create the aggregate
+func NewPaymentAggregate(id uuid.UUID) *PaymentAggregate {
+	return &PaymentAggregate{
+		AggregateBase: events.NewAggregateBase(PaymentAggregateType, id),
+	}
+}
+
+// HandleCommand is called whenever the commandBus recieves a command for which this aggregate is registered
+func (p *PaymentAggregate) HandleCommand(ctx context.Context, cmd eh.Command) error {
+	if p.succeeded {
+		return ErrPaymentSucceeded
+	}
+
+	switch cmd := cmd.(type) {
+	case *CompletePayment:
+		p.AppendEvent(PaymentSucceededEvent, &PaymentSucceededData{
+			InvoiceID: cmd.InvoiceID,
+			User:      cmd.User,
+			Month:     cmd.Month,
+			Amount:    cmd.Amount,
+			GatewayID: cmd.GatewayID,
+		}, time.Now())
+	case *FailPayment:
+		p.AppendEvent(PaymentFailedEvent, &PaymentFailedData{
+			InvoiceID: cmd.InvoiceID,
+			User:      cmd.User,
+			Month:     cmd.Month,
+			Amount:    cmd.Amount,
+			Reason:    cmd.Reason,
+		}, time.Now())
+	default:
+		return fmt.Errorf("could not handle command: %s", cmd.CommandType())
+	}
+	return nil
+}
+
+// ApplyEvent is called whenever an event is recieved on the eventBus
+func (p *PaymentAggregate) ApplyEvent(ctx context.Context, event eh.Event) error {
+	if event.EventType() == PaymentSucceededEvent {
+		p.succeeded = true
+	}
+	return nil
+}
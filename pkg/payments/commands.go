@@ -0,0 +1,47 @@
+package payments
+
+import (
+	"github.com/MattDevy/CQRS-example/pkg/money"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+func init() {
+	eh.RegisterCommand(func() eh.Command { return &CompletePayment{} })
+	eh.RegisterCommand(func() eh.Command { return &FailPayment{} })
+}
+
+const (
+	CompletePaymentCommand eh.CommandType = "CompletePayment"
+	FailPaymentCommand     eh.CommandType = "FailPayment"
+)
+
+// CompletePayment is the command to record that an invoice was charged
+type CompletePayment struct {
+	ID        uuid.UUID
+	InvoiceID uuid.UUID
+	User      string
+	// Month is the month of the bill the invoice is for, yyyy-mm
+	Month  string
+	Amount money.Money
+	// GatewayID is the provider's ID of the payment
+	GatewayID string
+}
+
+func (c CompletePayment) AggregateID() uuid.UUID          { return c.ID }
+func (c CompletePayment) AggregateType() eh.AggregateType { return PaymentAggregateType }
+func (c CompletePayment) CommandType() eh.CommandType     { return CompletePaymentCommand }
+
+// FailPayment is the command to record that charging an invoice failed
+type FailPayment struct {
+	ID        uuid.UUID
+	InvoiceID uuid.UUID
+	User      string
+	Month     string
+	Amount    money.Money
+	Reason    string
+}
+
+func (c FailPayment) AggregateID() uuid.UUID          { return c.ID }
+func (c FailPayment) AggregateType() eh.AggregateType { return PaymentAggregateType }
+func (c FailPayment) CommandType() eh.CommandType     { return FailPaymentCommand }
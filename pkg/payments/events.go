@@ -0,0 +1,37 @@
+package payments
+
+import (
+	"github.com/MattDevy/CQRS-example/pkg/money"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+func init() {
+	eh.RegisterEventData(PaymentSucceededEvent, func() eh.EventData {
+		return &PaymentSucceededData{}
+	})
+	eh.RegisterEventData(PaymentFailedEvent, func() eh.EventData {
+		return &PaymentFailedData{}
+	})
+}
+
+const (
+	PaymentSucceededEvent eh.EventType = "PaymentSucceeded"
+	PaymentFailedEvent    eh.EventType = "PaymentFailed"
+)
+
+type PaymentSucceededData struct {
+	InvoiceID uuid.UUID
+	User      string
+	Month     string
+	Amount    money.Money
+	GatewayID string
+}
+
+type PaymentFailedData struct {
+	InvoiceID uuid.UUID
+	User      string
+	Month     string
+	Amount    money.Money
+	Reason    string
+}
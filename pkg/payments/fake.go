@@ -0,0 +1,112 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/MattDevy/CQRS-example/pkg/money"
+)
+
+// Statuses of a payment at the provider
+const (
+	Authorised = "authorised"
+	Captured   = "captured"
+)
+
+// FakePayment is a payment made through the Fake
+type FakePayment struct {
+	ID        string
+	Method    string
+	Reference string
+	Amount    money.Money
+	Refunded  money.Money
+	Status    string
+}
+
+// Fake is an in-process PaymentGateway, every method is authorised unless it was declined
+type Fake struct {
+	mu         sync.Mutex
+	payments   map[string]*FakePayment
+	references map[string]string
+	declined   map[string]bool
+}
+
+var _ PaymentGateway = (*Fake)(nil)
+
+// NewFake returns a Fake with no payments
+func NewFake() *Fake {
+	return &Fake{
+		payments:   make(map[string]*FakePayment),
+		references: make(map[string]string),
+		declined:   make(map[string]bool),
+	}
+}
+
+// Decline makes authorising the method fail with ErrDeclined
+func (f *Fake) Decline(method string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.declined[method] = true
+}
+
+// Payment returns a copy of the payment, false if there is none
+func (f *Fake) Payment(id string) (FakePayment, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.payments[id]
+	if !ok {
+		return FakePayment{}, false
+	}
+	return *p, true
+}
+
+func (f *Fake) Authorise(ctx context.Context, method string, amount money.Money, reference string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if id, ok := f.references[reference]; ok {
+		return id, nil
+	}
+	if f.declined[method] {
+		return "", fmt.Errorf("%w: %v", ErrDeclined, method)
+	}
+	id := fmt.Sprintf("fake_%d", len(f.payments)+1)
+	f.payments[id] = &FakePayment{
+		ID:        id,
+		Method:    method,
+		Reference: reference,
+		Amount:    amount,
+		Refunded:  money.Money{Currency: amount.Currency},
+		Status:    Authorised,
+	}
+	f.references[reference] = id
+	return id, nil
+}
+
+func (f *Fake) Capture(ctx context.Context, paymentID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.payments[paymentID]
+	if !ok {
+		return ErrUnknownPayment
+	}
+	p.Status = Captured
+	return nil
+}
+
+func (f *Fake) Refund(ctx context.Context, paymentID string, amount money.Money) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.payments[paymentID]
+	if !ok {
+		return ErrUnknownPayment
+	}
+	if p.Status != Captured {
+		return ErrNotCaptured
+	}
+	if amount.Currency != p.Amount.Currency || p.Refunded.Add(amount).Amount > p.Amount.Amount {
+		return ErrRefundTooLarge
+	}
+	p.Refunded = p.Refunded.Add(amount)
+	return nil
+}
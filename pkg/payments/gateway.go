@@ -0,0 +1,56 @@
+package payments
+
+import (
+	"context"
+	"errors"
+
+	"github.com/MattDevy/CQRS-example/pkg/money"
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+)
+
+func init() {
+	reservations.RegisterErrorCode("PaymentDeclined", ErrDeclined)
+	reservations.RegisterErrorCode("PaymentSucceeded", ErrPaymentSucceeded)
+}
+
+// Errors returned by a PaymentGateway
+var (
+	ErrDeclined        = errors.New("payment declined")
+	ErrUnknownPayment  = errors.New("unknown payment")
+	ErrNotAuthorised   = errors.New("payment is not authorised")
+	ErrNotCaptured     = errors.New("payment is not captured")
+	ErrRefundTooLarge  = errors.New("refund is more than was captured")
+	ErrNoPaymentMethod = errors.New("no stored payment method")
+)
+
+// PaymentGateway charges stored payment methods through a payment provider
+type PaymentGateway interface {
+	// Authorise holds the amount on the payment method and returns the provider's payment ID
+	// Authorising again with the same reference returns the same payment, so retries do not charge twice
+	Authorise(ctx context.Context, method string, amount money.Money, reference string) (string, error)
+	// Capture takes the authorised amount, capturing a captured payment again is a no-op
+	Capture(ctx context.Context, paymentID string) error
+	// Refund returns some or all of a captured payment
+	Refund(ctx context.Context, paymentID string, amount money.Money) error
+}
+
+// Methods looks up the payment method stored for a user, eg a card token of the provider
+type Methods interface {
+	Method(ctx context.Context, user string) (string, error)
+}
+
+// StaticMethods are payment methods by user, with a Default for everyone else if it is set
+type StaticMethods struct {
+	Default string
+	Users   map[string]string
+}
+
+func (m StaticMethods) Method(ctx context.Context, user string) (string, error) {
+	if method, ok := m.Users[user]; ok {
+		return method, nil
+	}
+	if m.Default == "" {
+		return "", ErrNoPaymentMethod
+	}
+	return m.Default, nil
+}
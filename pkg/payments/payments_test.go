@@ -0,0 +1,238 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/money"
+)
+
+func TestFake(t *testing.T) {
+	ctx := context.Background()
+	f := NewFake()
+	f.Decline("expired-card")
+
+	id, err := f.Authorise(ctx, "card", money.New(480, "USD"), "invoice-1")
+	if err != nil {
+		t.Fatalf("Authorise() error = %v", err)
+	}
+	if again, err := f.Authorise(ctx, "card", money.New(480, "USD"), "invoice-1"); err != nil || again != id {
+		t.Errorf("Authorise() again = %v, %v, want %v", again, err, id)
+	}
+	if _, err := f.Authorise(ctx, "expired-card", money.New(480, "USD"), "invoice-2"); !errors.Is(err, ErrDeclined) {
+		t.Errorf("Authorise() declined error = %v, want %v", err, ErrDeclined)
+	}
+
+	if err := f.Refund(ctx, id, money.New(100, "USD")); !errors.Is(err, ErrNotCaptured) {
+		t.Errorf("Refund() before capture error = %v, want %v", err, ErrNotCaptured)
+	}
+	if err := f.Capture(ctx, id); err != nil {
+		t.Fatalf("Capture() error = %v", err)
+	}
+	if err := f.Capture(ctx, "missing"); !errors.Is(err, ErrUnknownPayment) {
+		t.Errorf("Capture() unknown error = %v, want %v", err, ErrUnknownPayment)
+	}
+	if err := f.Refund(ctx, id, money.New(300, "USD")); err != nil {
+		t.Errorf("Refund() error = %v", err)
+	}
+	if err := f.Refund(ctx, id, money.New(200, "USD")); !errors.Is(err, ErrRefundTooLarge) {
+		t.Errorf("Refund() over capture error = %v, want %v", err, ErrRefundTooLarge)
+	}
+
+	p, ok := f.Payment(id)
+	if !ok || p.Status != Captured || p.Refunded != money.New(300, "USD") || p.Reference != "invoice-1" {
+		t.Errorf("Payment() = %+v, %v", p, ok)
+	}
+}
+
+func TestStaticMethods_Method(t *testing.T) {
+	tests := []struct {
+		methods StaticMethods
+		user    string
+		want    string
+		wantErr error
+	}{
+		{StaticMethods{Default: "card"}, "Matt", "card", nil},
+		{StaticMethods{Default: "card", Users: map[string]string{"Matt": "iban"}}, "Matt", "iban", nil},
+		{StaticMethods{Users: map[string]string{"Matt": "iban"}}, "Paul", "", ErrNoPaymentMethod},
+	}
+	for _, tt := range tests {
+		got, err := tt.methods.Method(context.Background(), tt.user)
+		if got != tt.want || !errors.Is(err, tt.wantErr) {
+			t.Errorf("Method(%v) = %v, %v, want %v, %v", tt.user, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// provider is an HTTP stand-in for an asynchronous payment provider, posting signed webhooks to webhookURL
+type provider struct {
+	secret     []byte
+	webhookURL string
+
+	mu         sync.Mutex
+	payments   map[string]*webhookPayment
+	references map[string]string
+}
+
+func (p *provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if r.URL.Path == "/payments" {
+		var req webhookPayment
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if id, ok := p.references[req.Reference]; ok {
+			json.NewEncoder(w).Encode(p.payments[id])
+			return
+		}
+		req.ID = fmt.Sprintf("pay_%d", len(p.payments)+1)
+		req.Status = "pending"
+		p.payments[req.ID] = &req
+		p.references[req.Reference] = req.ID
+		json.NewEncoder(w).Encode(req)
+
+		webhook := Webhook{ID: req.ID, Status: Authorised}
+		if req.Method == "expired-card" {
+			webhook = Webhook{ID: req.ID, Status: "declined", Reason: "card expired"}
+		}
+		req.Status = webhook.Status
+		go p.post(webhook)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/payments/"), "/")
+	payment, ok := p.payments[parts[0]]
+	if !ok || len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	if parts[1] == "capture" {
+		if payment.Status != Authorised && payment.Status != Captured {
+			http.Error(w, "not authorised", http.StatusConflict)
+			return
+		}
+		payment.Status = Captured
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (p *provider) post(webhook Webhook) {
+	body, _ := json.Marshal(webhook)
+	req, _ := http.NewRequest(http.MethodPost, p.webhookURL, bytes.NewReader(body))
+	req.Header.Set(SignatureHeader, Sign(p.secret, body))
+	if resp, err := http.DefaultClient.Do(req); err == nil {
+		resp.Body.Close()
+	}
+}
+
+func TestWebhookGateway(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	secret := []byte("secret")
+	stub := &provider{secret: secret, payments: map[string]*webhookPayment{}, references: map[string]string{}}
+	providerServer := httptest.NewServer(stub)
+	defer providerServer.Close()
+
+	g := NewWebhookGateway(providerServer.URL, providerServer.Client(), secret)
+	webhookServer := httptest.NewServer(g.Handler())
+	defer webhookServer.Close()
+	stub.webhookURL = webhookServer.URL
+
+	id, err := g.Authorise(ctx, "card", money.New(480, "USD"), "invoice-1")
+	if err != nil {
+		t.Fatalf("Authorise() error = %v", err)
+	}
+	if again, err := g.Authorise(ctx, "card", money.New(480, "USD"), "invoice-1"); err != nil || again != id {
+		t.Errorf("Authorise() again = %v, %v, want %v", again, err, id)
+	}
+	if err := g.Capture(ctx, id); err != nil {
+		t.Errorf("Capture() error = %v", err)
+	}
+	if err := g.Capture(ctx, "missing"); !errors.Is(err, ErrUnknownPayment) {
+		t.Errorf("Capture() unknown error = %v, want %v", err, ErrUnknownPayment)
+	}
+	if err := g.Refund(ctx, id, money.New(480, "USD")); err != nil {
+		t.Errorf("Refund() error = %v", err)
+	}
+
+	declined, err := g.Authorise(ctx, "expired-card", money.New(480, "USD"), "invoice-2")
+	if !errors.Is(err, ErrDeclined) || !strings.Contains(err.Error(), "card expired") {
+		t.Errorf("Authorise() declined error = %v, want %v", err, ErrDeclined)
+	}
+	if err := g.Capture(ctx, declined); err == nil {
+		t.Errorf("Capture() of declined payment error = nil")
+	}
+}
+
+func TestWebhookGateway_Handler(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte(`{"id":"pay_1","status":"authorised"}`)
+	tests := []struct {
+		name      string
+		method    string
+		body      []byte
+		signature string
+		want      int
+	}{
+		{"signed", http.MethodPost, body, Sign(secret, body), http.StatusNoContent},
+		{"unsigned", http.MethodPost, body, "", http.StatusUnauthorized},
+		{"wrong secret", http.MethodPost, body, Sign([]byte("other"), body), http.StatusUnauthorized},
+		{"invalid", http.MethodPost, []byte(`{}`), Sign(secret, []byte(`{}`)), http.StatusBadRequest},
+		{"get", http.MethodGet, nil, "", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWebhookGateway("http://provider.invalid", http.DefaultClient, secret)
+			req := httptest.NewRequest(tt.method, WebhookPath, bytes.NewReader(tt.body))
+			req.Header.Set(SignatureHeader, tt.signature)
+			rec := httptest.NewRecorder()
+			g.Handler().ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %v, want %v", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestPaymentAggregate_HandleCommand(t *testing.T) {
+	ctx := context.Background()
+	a := NewPaymentAggregate(PaymentID(Namespace))
+
+	fail := &FailPayment{ID: a.EntityID(), User: "Matt", Month: "2021-06", Amount: money.New(480, "USD"), Reason: "declined"}
+	for i := 0; i < 2; i++ {
+		if err := a.HandleCommand(ctx, fail); err != nil {
+			t.Fatalf("HandleCommand(FailPayment) error = %v", err)
+		}
+	}
+	complete := &CompletePayment{ID: a.EntityID(), User: "Matt", Month: "2021-06", Amount: money.New(480, "USD"), GatewayID: "pay_1"}
+	if err := a.HandleCommand(ctx, complete); err != nil {
+		t.Fatalf("HandleCommand(CompletePayment) error = %v", err)
+	}
+	for _, event := range a.UncommittedEvents() {
+		if err := a.ApplyEvent(ctx, event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := len(a.UncommittedEvents()); got != 3 {
+		t.Errorf("events = %v, want 3", got)
+	}
+	if err := a.HandleCommand(ctx, complete); !errors.Is(err, ErrPaymentSucceeded) {
+		t.Errorf("HandleCommand(CompletePayment) again error = %v, want %v", err, ErrPaymentSucceeded)
+	}
+	if err := a.HandleCommand(ctx, fail); !errors.Is(err, ErrPaymentSucceeded) {
+		t.Errorf("HandleCommand(FailPayment) after success error = %v, want %v", err, ErrPaymentSucceeded)
+	}
+}
@@ -0,0 +1,35 @@
+package payments
+
+import (
+	"context"
+	"log"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/aggregatestore/events"
+	"github.com/looplab/eventhorizon/commandhandler/aggregate"
+	"github.com/looplab/eventhorizon/commandhandler/bus"
+)
+
+// Setup will initialize and register the payment aggregate and its commands
+func Setup(
+	ctx context.Context,
+	eventStore eh.EventStore,
+	commandBus *bus.CommandHandler,
+) {
+	aggregateStore, err := events.NewAggregateStore(eventStore)
+	if err != nil {
+		log.Fatalf("could not create aggregate store: %v", err)
+	}
+	commandHandler, err := aggregate.NewCommandHandler(PaymentAggregateType, aggregateStore)
+	if err != nil {
+		log.Fatalf("could not create command handler: %s", err)
+	}
+	for _, cmdType := range []eh.CommandType{
+		CompletePaymentCommand,
+		FailPaymentCommand,
+	} {
+		if err := commandBus.SetHandler(commandHandler, cmdType); err != nil {
+			log.Fatalf("could not set command handler: %v", err)
+		}
+	}
+}
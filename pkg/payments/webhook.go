@@ -0,0 +1,214 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/MattDevy/CQRS-example/pkg/money"
+)
+
+const (
+	// WebhookPath is where the provider posts the outcomes of authorisations
+	WebhookPath = "/payments/webhook"
+	// SignatureHeader is the hex HMAC-SHA256 of a webhook's body with the shared secret
+	SignatureHeader = "X-Signature"
+	// maxWebhookSize is the largest webhook body read
+	maxWebhookSize = 1 << 16
+)
+
+// Webhook is the outcome of an authorisation, posted by the provider to WebhookPath
+type Webhook struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// webhookPayment is a payment in the provider's API
+type webhookPayment struct {
+	ID        string `json:"id,omitempty"`
+	Status    string `json:"status,omitempty"`
+	Method    string `json:"method,omitempty"`
+	Amount    int64  `json:"amount,omitempty"`
+	Currency  string `json:"currency,omitempty"`
+	Reference string `json:"reference,omitempty"`
+}
+
+// WebhookGateway is a PaymentGateway for providers that authorise asynchronously
+// An authorisation is created pending, and Authorise waits until the provider posts its outcome to Handler
+type WebhookGateway struct {
+	baseURL string
+	client  *http.Client
+	secret  []byte
+
+	mu       sync.Mutex
+	outcomes map[string]*outcome
+}
+
+// outcome is the result of an authorisation, done is closed once it is known
+type outcome struct {
+	done    chan struct{}
+	webhook Webhook
+}
+
+var _ PaymentGateway = (*WebhookGateway)(nil)
+
+// NewWebhookGateway returns a WebhookGateway for the provider at baseURL, webhooks are verified with secret
+func NewWebhookGateway(baseURL string, client *http.Client, secret []byte) *WebhookGateway {
+	return &WebhookGateway{
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		client:   client,
+		secret:   secret,
+		outcomes: make(map[string]*outcome),
+	}
+}
+
+// Authorise creates a payment at the provider and waits for its outcome until ctx is done
+func (g *WebhookGateway) Authorise(ctx context.Context, method string, amount money.Money, reference string) (string, error) {
+	var p webhookPayment
+	err := g.post(ctx, "/payments", &webhookPayment{
+		Method:    method,
+		Amount:    amount.Amount,
+		Currency:  amount.Currency,
+		Reference: reference,
+	}, &p)
+	if err != nil {
+		return "", err
+	}
+
+	// A retried authorisation may already be decided
+	if p.Status != "pending" {
+		return p.ID, authorisationError(Webhook{ID: p.ID, Status: p.Status})
+	}
+	o := g.outcome(p.ID)
+	select {
+	case <-o.done:
+		g.mu.Lock()
+		delete(g.outcomes, p.ID)
+		g.mu.Unlock()
+		return p.ID, authorisationError(o.webhook)
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (g *WebhookGateway) Capture(ctx context.Context, paymentID string) error {
+	return g.post(ctx, "/payments/"+url.PathEscape(paymentID)+"/capture", nil, nil)
+}
+
+func (g *WebhookGateway) Refund(ctx context.Context, paymentID string, amount money.Money) error {
+	return g.post(ctx, "/payments/"+url.PathEscape(paymentID)+"/refunds", &webhookPayment{
+		Amount:   amount.Amount,
+		Currency: amount.Currency,
+	}, nil)
+}
+
+// Handler receives the provider's webhooks
+func (g *WebhookGateway) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "unsupported method: "+r.Method, http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxWebhookSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !hmac.Equal([]byte(Sign(g.secret, body)), []byte(r.Header.Get(SignatureHeader))) {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+		var webhook Webhook
+		if err := json.Unmarshal(body, &webhook); err != nil || webhook.ID == "" {
+			http.Error(w, "invalid webhook", http.StatusBadRequest)
+			return
+		}
+
+		o := g.outcome(webhook.ID)
+		g.mu.Lock()
+		select {
+		case <-o.done:
+			// Providers retry webhooks, the first outcome stands
+		default:
+			o.webhook = webhook
+			close(o.done)
+		}
+		g.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// Sign returns the signature of a webhook's body
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// outcome returns the outcome of the payment, which may have been posted before it was waited for
+func (g *WebhookGateway) outcome(id string) *outcome {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	o, ok := g.outcomes[id]
+	if !ok {
+		o = &outcome{done: make(chan struct{})}
+		g.outcomes[id] = o
+	}
+	return o
+}
+
+func authorisationError(webhook Webhook) error {
+	switch webhook.Status {
+	case Authorised, Captured:
+		return nil
+	case "declined":
+		return fmt.Errorf("%w: %v", ErrDeclined, webhook.Reason)
+	default:
+		return fmt.Errorf("%w: %v is %v", ErrNotAuthorised, webhook.ID, webhook.Status)
+	}
+}
+
+// post posts req as JSON to the provider and decodes the response into resp, if it is not nil
+func (g *WebhookGateway) post(ctx context.Context, path string, req, resp interface{}) error {
+	var body bytes.Buffer
+	if req != nil {
+		if err := json.NewEncoder(&body).Encode(req); err != nil {
+			return err
+		}
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, g.baseURL+path, &body)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpResp, err := g.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+
+	switch {
+	case httpResp.StatusCode == http.StatusNotFound:
+		return ErrUnknownPayment
+	case httpResp.StatusCode == http.StatusPaymentRequired:
+		return ErrDeclined
+	case httpResp.StatusCode >= 300:
+		msg, _ := ioutil.ReadAll(io.LimitReader(httpResp.Body, 512))
+		return fmt.Errorf("payment provider: %v: %s", httpResp.Status, strings.TrimSpace(string(msg)))
+	}
+	if resp == nil {
+		return nil
+	}
+	return json.NewDecoder(httpResp.Body).Decode(resp)
+}
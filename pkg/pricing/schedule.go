@@ -0,0 +1,475 @@
+package pricing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/billing"
+	"github.com/MattDevy/CQRS-example/pkg/money"
+)
+
+// Rates are the prices per minute of a room, in major units of the currency, eg "0.04"
+// Peak and Weekend default to OffPeak
+type Rates struct {
+	OffPeak string `json:"offPeak"`
+	Peak    string `json:"peak,omitempty"`
+	Weekend string `json:"weekend,omitempty"`
+
+	offPeak, peak, weekend money.Rate
+}
+
+// Hours are a window of the day, "09:00" to "17:00"
+type Hours struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+
+	from, to int
+}
+
+// Tier discounts the minutes a user books in a month beyond AboveMinutes
+type Tier struct {
+	AboveMinutes int `json:"aboveMinutes"`
+	PercentOff   int `json:"percentOff"`
+}
+
+// Window charges Percent of what a booking was charged if it is cancelled or moved less than Before its start, eg "24h"
+type Window struct {
+	Before  string `json:"before"`
+	Percent int    `json:"percent"`
+
+	before time.Duration
+}
+
+// Cancellation are the policies charging fees for cancelling and moving confirmed bookings late
+// A policy is shared by the tier of rooms assigned to it
+type Cancellation struct {
+	Policies map[string][]*Window `json:"policies"`
+	// Default is the policy of rooms not assigned one, cancelling is free if it is not set
+	Default string         `json:"default,omitempty"`
+	Rooms   map[int]string `json:"rooms,omitempty"`
+}
+
+// UsageBilling selects the bookings billed for the minutes used rather than booked, by room or by user
+type UsageBilling struct {
+	// Default is the mode of bookings no room or user selects one for, billing.ModeBooked if it is not set
+	Default string         `json:"default,omitempty"`
+	Rooms   map[int]string `json:"rooms,omitempty"`
+	// Users select the mode of their bookings of any room, over the room's
+	Users map[string]string `json:"users,omitempty"`
+	// NoShowFee is charged in place of a usage booking nobody checked in to, booked ones are charged in full
+	NoShowFee string `json:"noShowFee,omitempty"`
+
+	noShowFee money.Money
+}
+
+// Version is a set of rates, used for bookings starting from EffectiveFrom until the next version
+type Version struct {
+	Version       string    `json:"version"`
+	EffectiveFrom time.Time `json:"effectiveFrom"`
+	Currency      string    `json:"currency"`
+	// Rounding is how the price of a booking is rounded to a minor unit, half-even by default
+	Rounding string `json:"rounding,omitempty"`
+	// TimeZone is the zone the peak hours and weekends are in, UTC by default
+	TimeZone string `json:"timeZone,omitempty"`
+	Default  Rates  `json:"default"`
+	// Rooms override the default rates of a room
+	Rooms map[int]*Rates `json:"rooms,omitempty"`
+	// PeakHours are the hours of weekdays charged at the peak rate
+	PeakHours *Hours `json:"peakHours,omitempty"`
+	// MinimumCharge is the least a booking is charged in each month it takes place in
+	MinimumCharge string `json:"minimumCharge,omitempty"`
+	// IncrementMinutes rounds the minutes billed up to a multiple, eg 15 minute blocks
+	IncrementMinutes int    `json:"incrementMinutes,omitempty"`
+	Tiers            []Tier `json:"tiers,omitempty"`
+	// Cancellation charges for cancelling and moving bookings late, it is free if not set
+	Cancellation *Cancellation `json:"cancellation,omitempty"`
+	// Usage bills bookings for the minutes used, they are billed for the minutes booked if not set
+	Usage *UsageBilling `json:"usage,omitempty"`
+
+	rounding money.Rounding
+	loc      *time.Location
+	minimum  money.Money
+}
+
+// Schedule is a billing.PricingPolicy, billing.FeePolicy and billing.UsagePolicy from versioned rates
+type Schedule struct {
+	versions []*Version
+}
+
+// NewSchedule validates the versions and returns a Schedule of them
+func NewSchedule(versions []*Version) (*Schedule, error) {
+	if len(versions) == 0 {
+		return nil, errors.New("pricing: no versions")
+	}
+	seen := make(map[string]bool)
+	for _, v := range versions {
+		if err := v.parse(); err != nil {
+			return nil, fmt.Errorf("pricing: version %q: %w", v.Version, err)
+		}
+		if seen[v.Version] {
+			return nil, fmt.Errorf("pricing: duplicate version %q", v.Version)
+		}
+		seen[v.Version] = true
+		if v.Currency != versions[0].Currency {
+			return nil, fmt.Errorf("pricing: version %q is in %v, not %v like the others", v.Version, v.Currency, versions[0].Currency)
+		}
+	}
+	sorted := append([]*Version(nil), versions...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].EffectiveFrom.Before(sorted[j].EffectiveFrom)
+	})
+	return &Schedule{versions: sorted}, nil
+}
+
+// Load reads a Schedule from JSON, {"versions": [...]}
+func Load(r io.Reader) (*Schedule, error) {
+	var config struct {
+		Versions []*Version `json:"versions"`
+	}
+	d := json.NewDecoder(r)
+	d.DisallowUnknownFields()
+	if err := d.Decode(&config); err != nil {
+		return nil, fmt.Errorf("pricing: %w", err)
+	}
+	return NewSchedule(config.Versions)
+}
+
+// LoadFile reads a Schedule from a JSON file
+func LoadFile(path string) (*Schedule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Load(f)
+}
+
+// At returns the version in effect at t, the earliest version if none was yet
+func (s *Schedule) At(t time.Time) *Version {
+	v := s.versions[0]
+	for _, next := range s.versions[1:] {
+		if next.EffectiveFrom.After(t) {
+			break
+		}
+		v = next
+	}
+	return v
+}
+
+// Currency is the currency of every version
+func (s *Schedule) Currency() string {
+	return s.versions[0].Currency
+}
+
+// Price prices the quote with the version in effect when it starts
+// Each minute is charged at the rate of when it starts, less the discount of the user's tier
+func (s *Schedule) Price(q billing.Quote) billing.Price {
+	v := s.At(q.StartTime)
+	rates := v.rates(q.RoomID)
+
+	minutes := billing.Minutes(q.StartTime, q.EndTime)
+	if v.IncrementMinutes > 0 && minutes%v.IncrementMinutes != 0 {
+		minutes += v.IncrementMinutes - minutes%v.IncrementMinutes
+	}
+
+	// Sum in hundredths of a rate unit so the tier discounts are exact, and round once
+	// The minutes are charged in runs at the same rate and discount, rather than one at a time
+	var total int64
+	start := q.StartTime.In(v.loc)
+	for i := 0; i < minutes; {
+		t := start.Add(time.Duration(i) * time.Minute)
+		n := v.tierRun(q.PriorMinutes+i, v.rateRun(t, minutes-i))
+		total += v.rateAt(rates, t).PerUnit * int64(100-v.percentOff(q.PriorMinutes+i)) * int64(n)
+		i += n
+	}
+	amount := money.Fraction(total, 100*pow10(money.RateExponent), v.Currency, v.rounding)
+	if minutes > 0 && amount.Amount < v.minimum.Amount {
+		amount = v.minimum
+	}
+	unitPrice := money.Rate{Currency: v.Currency}
+	if minutes > 0 {
+		// The average is in rate units, Fraction only does the rounding
+		unitPrice.PerUnit = money.Fraction(total, 100*int64(minutes), v.Currency, money.HalfEven).Amount
+	}
+	return billing.Price{Minutes: minutes, UnitPrice: unitPrice, Amount: amount, RateVersion: v.Version}
+}
+
+// Fee charges the highest percentage of the windows of the room's policy the change is in,
+// with the version in effect when the booking starts
+func (s *Schedule) Fee(c billing.Change) billing.Fee {
+	v := s.At(c.StartTime)
+	fee := billing.Fee{Amount: money.Money{Currency: c.Charged.Currency}, RateVersion: v.Version}
+	if v.Cancellation == nil {
+		return fee
+	}
+	policy, ok := v.Cancellation.Rooms[c.RoomID]
+	if !ok {
+		policy = v.Cancellation.Default
+	}
+	percent := 0
+	for _, w := range v.Cancellation.Policies[policy] {
+		if c.StartTime.Sub(c.At) < w.before && w.Percent > percent {
+			percent = w.Percent
+		}
+	}
+	if percent > 0 {
+		fee.Policy = policy
+		fee.Amount = money.Fraction(c.Charged.Amount*int64(percent), 100, c.Charged.Currency, v.rounding)
+	}
+	return fee
+}
+
+// Usage selects how the booking is billed with the version in effect when it starts
+func (s *Schedule) Usage(b billing.Booking) billing.Usage {
+	v := s.At(b.StartTime)
+	usage := billing.Usage{Mode: billing.ModeBooked, NoShowFee: money.Money{Currency: v.Currency}, RateVersion: v.Version}
+	if v.Usage == nil {
+		return usage
+	}
+	if mode, ok := v.Usage.Users[b.User]; ok {
+		usage.Mode = mode
+	} else if mode, ok := v.Usage.Rooms[b.RoomID]; ok {
+		usage.Mode = mode
+	} else if v.Usage.Default != "" {
+		usage.Mode = v.Usage.Default
+	}
+	usage.NoShowFee = v.Usage.noShowFee
+	return usage
+}
+
+// rates returns the rates of the room
+func (v *Version) rates(roomID int) *Rates {
+	if r, ok := v.Rooms[roomID]; ok {
+		return r
+	}
+	return &v.Default
+}
+
+// rateAt returns the rate of the minute starting at t, in the version's time zone
+func (v *Version) rateAt(r *Rates, t time.Time) money.Rate {
+	if wd := t.Weekday(); wd == time.Saturday || wd == time.Sunday {
+		return r.weekend
+	}
+	if v.PeakHours != nil {
+		m := t.Hour()*60 + t.Minute()
+		if m >= v.PeakHours.from && m < v.PeakHours.to {
+			return r.peak
+		}
+	}
+	return r.offPeak
+}
+
+// rateRun returns how many of the next max minutes, from the one starting at t, are charged at the same rate
+func (v *Version) rateRun(t time.Time, max int) int {
+	m := t.Hour()*60 + t.Minute()
+	end := 24 * 60
+	if wd := t.Weekday(); wd != time.Saturday && wd != time.Sunday && v.PeakHours != nil {
+		for _, b := range []int{v.PeakHours.from, v.PeakHours.to} {
+			if b > m && b < end {
+				end = b
+			}
+		}
+	}
+	n := end - m
+	if n > max {
+		n = max
+	}
+	// The clock only moves on a minute a minute while the zone's offset is the same, so end the run at a daylight saving change
+	_, offset := t.Zone()
+	if _, last := t.Add(time.Duration(n-1) * time.Minute).Zone(); last != offset {
+		n = sort.Search(n, func(i int) bool {
+			_, o := t.Add(time.Duration(i) * time.Minute).Zone()
+			return o != offset
+		})
+	}
+	return n
+}
+
+// tierRun returns how many of the next max minutes, from the nth the user books in the month, get the same discount
+func (v *Version) tierRun(n, max int) int {
+	for _, t := range v.Tiers {
+		if t.AboveMinutes > n && t.AboveMinutes-n < max {
+			max = t.AboveMinutes - n
+		}
+	}
+	return max
+}
+
+// percentOff returns the discount of the nth minute the user books in the month
+func (v *Version) percentOff(n int) int {
+	off := 0
+	for _, t := range v.Tiers {
+		if n >= t.AboveMinutes && t.PercentOff > off {
+			off = t.PercentOff
+		}
+	}
+	return off
+}
+
+// parse checks the version and parses its rates
+func (v *Version) parse() error {
+	if v.Version == "" {
+		return errors.New("no version")
+	}
+	if v.Currency == "" {
+		return errors.New("no currency")
+	}
+	v.rounding = money.HalfEven
+	if v.Rounding != "" {
+		r, err := money.ParseRounding(v.Rounding)
+		if err != nil {
+			return err
+		}
+		v.rounding = r
+	}
+	loc, err := time.LoadLocation(v.TimeZone)
+	if err != nil {
+		return err
+	}
+	v.loc = loc
+	v.minimum = money.Money{Currency: v.Currency}
+	if v.MinimumCharge != "" {
+		if v.minimum, err = money.Parse(v.MinimumCharge, v.Currency); err != nil {
+			return err
+		}
+	}
+	if v.IncrementMinutes < 0 {
+		return fmt.Errorf("invalid increment %d minutes", v.IncrementMinutes)
+	}
+	if v.PeakHours != nil {
+		if err := v.PeakHours.parse(); err != nil {
+			return err
+		}
+	}
+	for _, t := range v.Tiers {
+		if t.AboveMinutes < 0 || t.PercentOff < 0 || t.PercentOff > 100 {
+			return fmt.Errorf("invalid tier %+v", t)
+		}
+	}
+	if v.Cancellation != nil {
+		if err := v.Cancellation.parse(); err != nil {
+			return fmt.Errorf("cancellation: %w", err)
+		}
+	}
+	if v.Usage != nil {
+		if err := v.Usage.parse(v.Currency); err != nil {
+			return fmt.Errorf("usage: %w", err)
+		}
+	}
+	if err := v.Default.parse(v.Currency); err != nil {
+		return fmt.Errorf("default rates: %w", err)
+	}
+	for room, r := range v.Rooms {
+		if err := r.parse(v.Currency); err != nil {
+			return fmt.Errorf("room %d rates: %w", room, err)
+		}
+	}
+	return nil
+}
+
+func (r *Rates) parse(currency string) error {
+	var err error
+	if r.offPeak, err = money.ParseRate(r.OffPeak, currency); err != nil {
+		return err
+	}
+	r.peak, r.weekend = r.offPeak, r.offPeak
+	if r.Peak != "" {
+		if r.peak, err = money.ParseRate(r.Peak, currency); err != nil {
+			return err
+		}
+	}
+	if r.Weekend != "" {
+		if r.weekend, err = money.ParseRate(r.Weekend, currency); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Cancellation) parse() error {
+	if _, ok := c.Policies[c.Default]; c.Default != "" && !ok {
+		return fmt.Errorf("unknown default policy %q", c.Default)
+	}
+	for room, policy := range c.Rooms {
+		if _, ok := c.Policies[policy]; !ok {
+			return fmt.Errorf("room %d: unknown policy %q", room, policy)
+		}
+	}
+	for name, windows := range c.Policies {
+		for _, w := range windows {
+			var err error
+			if w.before, err = time.ParseDuration(w.Before); err != nil {
+				return fmt.Errorf("policy %q: %w", name, err)
+			}
+			if w.Percent < 0 || w.Percent > 100 {
+				return fmt.Errorf("policy %q: invalid percent %d", name, w.Percent)
+			}
+		}
+	}
+	return nil
+}
+
+func (u *UsageBilling) parse(currency string) error {
+	var modes []string
+	if u.Default != "" {
+		modes = append(modes, u.Default)
+	}
+	for _, mode := range u.Rooms {
+		modes = append(modes, mode)
+	}
+	for _, mode := range u.Users {
+		modes = append(modes, mode)
+	}
+	for _, mode := range modes {
+		if mode != billing.ModeBooked && mode != billing.ModeUsage {
+			return fmt.Errorf("invalid mode %q", mode)
+		}
+	}
+	u.noShowFee = money.Money{Currency: currency}
+	if u.NoShowFee != "" {
+		var err error
+		if u.noShowFee, err = money.Parse(u.NoShowFee, currency); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *Hours) parse() error {
+	var err error
+	if h.from, err = minuteOfDay(h.From); err != nil {
+		return err
+	}
+	if h.to, err = minuteOfDay(h.To); err != nil {
+		return err
+	}
+	if h.from >= h.to {
+		return fmt.Errorf("invalid peak hours %v to %v", h.From, h.To)
+	}
+	return nil
+}
+
+// minuteOfDay parses "15:04" as minutes after midnight, "24:00" is the end of the day
+func minuteOfDay(s string) (int, error) {
+	if s == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func pow10(n int) int64 {
+	p := int64(1)
+	for i := 0; i < n; i++ {
+		p *= 10
+	}
+	return p
+}
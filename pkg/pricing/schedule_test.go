@@ -0,0 +1,291 @@
+package pricing
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/billing"
+	"github.com/MattDevy/CQRS-example/pkg/money"
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	memoryRepo "github.com/looplab/eventhorizon/repo/memory"
+)
+
+const testConfig = `{
+	"versions": [
+		{
+			"version": "2021-01",
+			"effectiveFrom": "2021-01-01T00:00:00Z",
+			"currency": "USD",
+			"default": {"offPeak": "0.04"}
+		},
+		{
+			"version": "2021-07",
+			"effectiveFrom": "2021-07-01T00:00:00Z",
+			"currency": "USD",
+			"default": {"offPeak": "0.03", "peak": "0.05", "weekend": "0.02"},
+			"rooms": {"7": {"offPeak": "0.10"}},
+			"peakHours": {"from": "09:00", "to": "17:00"},
+			"minimumCharge": "1.00",
+			"incrementMinutes": 15,
+			"tiers": [{"aboveMinutes": 600, "percentOff": 10}, {"aboveMinutes": 1200, "percentOff": 25}],
+			"cancellation": {
+				"policies": {
+					"standard": [{"before": "24h", "percent": 50}, {"before": "1h", "percent": 100}],
+					"boardroom": [{"before": "72h", "percent": 100}]
+				},
+				"default": "standard",
+				"rooms": {"7": "boardroom"}
+			},
+			"usage": {
+				"default": "usage",
+				"rooms": {"7": "booked"},
+				"users": {"Matt": "booked", "Paul": "usage"},
+				"noShowFee": "5.00"
+			}
+		}
+	]
+}`
+
+func TestSchedule_Price(t *testing.T) {
+	s, err := Load(strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// July 1st 2021 is a Thursday
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2021, month, day, hour, minute, 0, 0, time.UTC)
+	}
+	rate := func(perMinute string) money.Rate {
+		return money.MustParseRate(perMinute, "USD")
+	}
+
+	tests := []struct {
+		name string
+		q    billing.Quote
+		want billing.Price
+	}{
+		{
+			name: "before the latest version",
+			q:    billing.Quote{RoomID: 1, StartTime: at(time.June, 3, 9, 0), EndTime: at(time.June, 3, 10, 0)},
+			want: billing.Price{Minutes: 60, UnitPrice: rate("0.04"), Amount: money.New(240, "USD"), RateVersion: "2021-01"},
+		},
+		{
+			name: "off peak",
+			q:    billing.Quote{RoomID: 1, StartTime: at(time.July, 1, 6, 0), EndTime: at(time.July, 1, 8, 0)},
+			want: billing.Price{Minutes: 120, UnitPrice: rate("0.03"), Amount: money.New(360, "USD"), RateVersion: "2021-07"},
+		},
+		{
+			name: "into peak",
+			q:    billing.Quote{RoomID: 1, StartTime: at(time.July, 1, 8, 0), EndTime: at(time.July, 1, 10, 0)},
+			want: billing.Price{Minutes: 120, UnitPrice: rate("0.04"), Amount: money.New(480, "USD"), RateVersion: "2021-07"},
+		},
+		{
+			name: "weekend",
+			q:    billing.Quote{RoomID: 1, StartTime: at(time.July, 3, 10, 0), EndTime: at(time.July, 3, 12, 0)},
+			want: billing.Price{Minutes: 120, UnitPrice: rate("0.02"), Amount: money.New(240, "USD"), RateVersion: "2021-07"},
+		},
+		{
+			name: "room rate",
+			q:    billing.Quote{RoomID: 7, StartTime: at(time.July, 1, 10, 0), EndTime: at(time.July, 1, 11, 0)},
+			want: billing.Price{Minutes: 60, UnitPrice: rate("0.10"), Amount: money.New(600, "USD"), RateVersion: "2021-07"},
+		},
+		{
+			name: "rounded up to a block",
+			q:    billing.Quote{RoomID: 1, StartTime: at(time.July, 1, 6, 0), EndTime: at(time.July, 1, 7, 50)},
+			want: billing.Price{Minutes: 120, UnitPrice: rate("0.03"), Amount: money.New(360, "USD"), RateVersion: "2021-07"},
+		},
+		{
+			name: "minimum charge",
+			q:    billing.Quote{RoomID: 1, StartTime: at(time.July, 1, 6, 0), EndTime: at(time.July, 1, 6, 15)},
+			want: billing.Price{Minutes: 15, UnitPrice: rate("0.03"), Amount: money.New(100, "USD"), RateVersion: "2021-07"},
+		},
+		{
+			name: "into a volume tier",
+			q:    billing.Quote{RoomID: 1, StartTime: at(time.July, 1, 6, 0), EndTime: at(time.July, 1, 8, 0), PriorMinutes: 540},
+			want: billing.Price{Minutes: 120, UnitPrice: rate("0.0285"), Amount: money.New(342, "USD"), RateVersion: "2021-07"},
+		},
+		{
+			name: "highest volume tier",
+			q:    billing.Quote{RoomID: 1, StartTime: at(time.July, 1, 6, 0), EndTime: at(time.July, 1, 8, 0), PriorMinutes: 1200},
+			want: billing.Price{Minutes: 120, UnitPrice: rate("0.0225"), Amount: money.New(270, "USD"), RateVersion: "2021-07"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.Price(tt.q); got != tt.want {
+				t.Errorf("Price() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchedule_PriceRuns(t *testing.T) {
+	s, err := Load(strings.NewReader(`{"versions": [{
+		"version": "2021-01",
+		"effectiveFrom": "2021-01-01T00:00:00Z",
+		"currency": "GBP",
+		"timeZone": "Europe/London",
+		"default": {"offPeak": "0.03", "peak": "0.05", "weekend": "0.02"},
+		"peakHours": {"from": "01:30", "to": "17:00"},
+		"tiers": [{"aboveMinutes": 600, "percentOff": 10}, {"aboveMinutes": 1200, "percentOff": 25}]
+	}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// perMinute charges each minute on its own, as the runs must add up to
+	perMinute := func(q billing.Quote) int64 {
+		v := s.At(q.StartTime)
+		var total int64
+		for i := 0; i < billing.Minutes(q.StartTime, q.EndTime); i++ {
+			rate := v.rateAt(&v.Default, q.StartTime.In(v.loc).Add(time.Duration(i)*time.Minute))
+			total += rate.PerUnit * int64(100-v.percentOff(q.PriorMinutes+i))
+		}
+		return money.Fraction(total, 100*pow10(money.RateExponent), "GBP", v.rounding).Amount
+	}
+
+	tests := []struct {
+		name string
+		q    billing.Quote
+	}{
+		{"a week", billing.Quote{StartTime: time.Date(2021, time.June, 1, 8, 20, 30, 0, time.UTC), EndTime: time.Date(2021, time.June, 8, 8, 20, 0, 0, time.UTC)}},
+		{"into the clocks going forward", billing.Quote{StartTime: time.Date(2021, time.March, 26, 12, 0, 0, 0, time.UTC), EndTime: time.Date(2021, time.March, 30, 3, 0, 0, 0, time.UTC), PriorMinutes: 100}},
+		{"into the clocks going back", billing.Quote{StartTime: time.Date(2021, time.October, 29, 0, 10, 0, 0, time.UTC), EndTime: time.Date(2021, time.November, 2, 2, 45, 0, 0, time.UTC)}},
+		{"across tiers", billing.Quote{StartTime: time.Date(2021, time.July, 1, 16, 0, 0, 0, time.UTC), EndTime: time.Date(2021, time.July, 2, 16, 0, 0, 0, time.UTC), PriorMinutes: 500}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, want := s.Price(tt.q).Amount.Amount, perMinute(tt.q); got != want {
+				t.Errorf("Price() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSchedule_Fee(t *testing.T) {
+	s, err := Load(strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2021, time.July, 5, 10, 0, 0, 0, time.UTC)
+	change := func(roomID int, before time.Duration) billing.Change {
+		return billing.Change{RoomID: roomID, StartTime: start, EndTime: start.Add(time.Hour), At: start.Add(-before), Charged: money.New(301, "USD")}
+	}
+
+	tests := []struct {
+		name string
+		c    billing.Change
+		want billing.Fee
+	}{
+		{"free until 24h before", change(1, 25*time.Hour), billing.Fee{Amount: money.New(0, "USD"), RateVersion: "2021-07"}},
+		{"half until 1h before", change(1, 2*time.Hour), billing.Fee{Amount: money.New(150, "USD"), Policy: "standard", RateVersion: "2021-07"}},
+		{"all within the hour", change(1, 30*time.Minute), billing.Fee{Amount: money.New(301, "USD"), Policy: "standard", RateVersion: "2021-07"}},
+		{"all once started", change(1, -30*time.Minute), billing.Fee{Amount: money.New(301, "USD"), Policy: "standard", RateVersion: "2021-07"}},
+		{"room's tier", change(7, 48*time.Hour), billing.Fee{Amount: money.New(301, "USD"), Policy: "boardroom", RateVersion: "2021-07"}},
+		{
+			name: "version without a policy",
+			c:    billing.Change{RoomID: 1, StartTime: start.AddDate(0, -1, 0), At: start.AddDate(0, -1, 0), Charged: money.New(301, "USD")},
+			want: billing.Fee{Amount: money.New(0, "USD"), RateVersion: "2021-01"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.Fee(tt.c); got != tt.want {
+				t.Errorf("Fee() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchedule_Usage(t *testing.T) {
+	s, err := Load(strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2021, time.July, 5, 10, 0, 0, 0, time.UTC)
+	fee := money.New(500, "USD")
+
+	tests := []struct {
+		name string
+		b    billing.Booking
+		want billing.Usage
+	}{
+		{"default", billing.Booking{User: "Jo", RoomID: 1, StartTime: start}, billing.Usage{Mode: billing.ModeUsage, NoShowFee: fee, RateVersion: "2021-07"}},
+		{"room's mode", billing.Booking{User: "Jo", RoomID: 7, StartTime: start}, billing.Usage{Mode: billing.ModeBooked, NoShowFee: fee, RateVersion: "2021-07"}},
+		{"user's mode", billing.Booking{User: "Matt", RoomID: 1, StartTime: start}, billing.Usage{Mode: billing.ModeBooked, NoShowFee: fee, RateVersion: "2021-07"}},
+		{"user's mode over the room's", billing.Booking{User: "Paul", RoomID: 7, StartTime: start}, billing.Usage{Mode: billing.ModeUsage, NoShowFee: fee, RateVersion: "2021-07"}},
+		{"version without usage", billing.Booking{User: "Paul", RoomID: 1, StartTime: start.AddDate(0, -1, 0)}, billing.Usage{Mode: billing.ModeBooked, NoShowFee: money.New(0, "USD"), RateVersion: "2021-01"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.Usage(tt.b); got != tt.want {
+				t.Errorf("Usage() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{name: "valid", config: testConfig},
+		{name: "no versions", config: `{"versions": []}`, wantErr: true},
+		{name: "unknown field", config: `{"versions": [{"version": "1", "currency": "USD", "default": {"offPeak": "0.04"}, "peak": "0.05"}]}`, wantErr: true},
+		{name: "no currency", config: `{"versions": [{"version": "1", "default": {"offPeak": "0.04"}}]}`, wantErr: true},
+		{name: "invalid rate", config: `{"versions": [{"version": "1", "currency": "USD", "default": {"offPeak": "four"}}]}`, wantErr: true},
+		{name: "invalid peak hours", config: `{"versions": [{"version": "1", "currency": "USD", "default": {"offPeak": "0.04"}, "peakHours": {"from": "17:00", "to": "09:00"}}]}`, wantErr: true},
+		{name: "unknown cancellation policy", config: `{"versions": [{"version": "1", "currency": "USD", "default": {"offPeak": "0.04"}, "cancellation": {"policies": {}, "default": "standard"}}]}`, wantErr: true},
+		{name: "invalid cancellation window", config: `{"versions": [{"version": "1", "currency": "USD", "default": {"offPeak": "0.04"}, "cancellation": {"policies": {"standard": [{"before": "a day", "percent": 50}]}}}]}`, wantErr: true},
+		{name: "invalid usage mode", config: `{"versions": [{"version": "1", "currency": "USD", "default": {"offPeak": "0.04"}, "usage": {"rooms": {"1": "used"}}}]}`, wantErr: true},
+		{name: "invalid no-show fee", config: `{"versions": [{"version": "1", "currency": "USD", "default": {"offPeak": "0.04"}, "usage": {"noShowFee": "five"}}]}`, wantErr: true},
+		{name: "mixed currencies", config: `{"versions": [{"version": "1", "currency": "USD", "default": {"offPeak": "0.04"}}, {"version": "2", "currency": "EUR", "effectiveFrom": "2021-07-01T00:00:00Z", "default": {"offPeak": "0.04"}}]}`, wantErr: true},
+		{name: "duplicate version", config: `{"versions": [{"version": "1", "currency": "USD", "default": {"offPeak": "0.04"}}, {"version": "1", "currency": "USD", "default": {"offPeak": "0.04"}}]}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Load(strings.NewReader(tt.config)); (err != nil) != tt.wantErr {
+				t.Errorf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSchedule_BillsInItsCurrency(t *testing.T) {
+	s, err := Load(strings.NewReader(`{"versions": [{"version": "eur", "currency": "EUR", "default": {"offPeak": "0.05"}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := memoryRepo.NewRepo()
+	repo.SetEntityFactory(func() eh.Entity { return &billing.BillingHistory{Bills: make(map[string]*billing.Bill)} })
+	p := billing.NewBillingHistoryProjector(repo)
+	p.SetPricingPolicy(s)
+
+	id := uuid.New()
+	start := time.Date(2021, time.July, 5, 22, 0, 0, 0, time.UTC)
+	for i, data := range []eh.EventData{
+		&reservations.ReservationCreatedData{RoomID: 1, Name: "Standup", User: "Matt", StartTime: start, EndTime: start.Add(time.Hour)},
+		&reservations.ReservationConfirmedData{User: "saga"},
+	} {
+		eventType := reservations.ReservationCreatedEvent
+		if i == 1 {
+			eventType = reservations.ReservationConfirmedEvent
+		}
+		event := eh.NewEvent(eventType, data, start, eh.ForAggregate(reservations.ReservationAggregateType, id, i+1))
+		if err := p.HandleEvent(context.Background(), event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h, err := billing.FindByUser(context.Background(), repo, "Matt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.TotalPaid != money.New(300, "EUR") {
+		t.Errorf("TotalPaid = %v, want EUR 3.00", h.TotalPaid)
+	}
+}
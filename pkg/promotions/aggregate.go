@@ -0,0 +1,176 @@
+package promotions
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/money"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/aggregatestore/events"
+)
+
+func init() {
+	eh.RegisterAggregate(func(id uuid.UUID) eh.Aggregate {
+		return NewPromotionAggregate(id)
+	})
+	eh.RegisterAggregate(func(id uuid.UUID) eh.Aggregate {
+		return NewAccountAggregate(id)
+	})
+}
+
+const (
+	PromotionAggregateType eh.AggregateType = "Promotion"
+	AccountAggregateType   eh.AggregateType = "Account"
+)
+
+var (
+	_ = eh.Aggregate(&PromotionAggregate{})
+	_ = eh.Aggregate(&AccountAggregate{})
+)
+
+// Namespace is the UUID namespace promotion and account IDs are generated in
+var Namespace = uuid.MustParse("6d0f8b1e-37a4-4c52-9e85-a1c7f2d94b36")
+
+// PromotionID returns the ID of the promotion of a code, codes are not case sensitive
+func PromotionID(code string) uuid.UUID {
+	return uuid.NewSHA1(Namespace, []byte("promotion/"+strings.ToUpper(strings.TrimSpace(code))))
+}
+
+// AccountID returns the ID of the user's account
+func AccountID(user string) uuid.UUID {
+	return uuid.NewSHA1(Namespace, []byte("account/"+user))
+}
+
+// PromotionAggregate is the write-model of a discount code, it enforces the code's expiry and usage limits
+type PromotionAggregate struct {
+	*events.AggregateBase
+
+	created        bool
+	code           string
+	percent        int
+	amount         money.Money
+	expiresAt      time.Time
+	maxUses        int
+	maxUsesPerUser int
+	uses           int
+	users          map[string]int
+	reservations   map[uuid.UUID]bool
+}
+
+// NewPromotionAggregate returns an initialized PromotionAggregate, this should always be used to create the aggregate
+func NewPromotionAggregate(id uuid.UUID) *PromotionAggregate {
+	return &PromotionAggregate{
+		AggregateBase: events.NewAggregateBase(PromotionAggregateType, id),
+		users:         make(map[string]int),
+		reservations:  make(map[uuid.UUID]bool),
+	}
+}
+
+// HandleCommand is called whenever the commandBus recieves a command for which this aggregate is registered
+func (p *PromotionAggregate) HandleCommand(ctx context.Context, cmd eh.Command) error {
+	switch cmd := cmd.(type) {
+	case *CreatePromotion:
+		if p.created {
+			return ErrPromotionExists
+		}
+		if (cmd.Percent != 0) == (cmd.Amount.Amount != 0) || cmd.Percent < 0 || cmd.Percent > 100 ||
+			cmd.Amount.Amount < 0 || (cmd.Amount.Amount > 0 && cmd.Amount.Currency == "") ||
+			cmd.MaxUses < 0 || cmd.MaxUsesPerUser < 0 {
+			return ErrInvalidDiscount
+		}
+		p.AppendEvent(PromotionCreatedEvent, &PromotionCreatedData{
+			Code:           strings.ToUpper(strings.TrimSpace(cmd.Code)),
+			User:           cmd.User,
+			Percent:        cmd.Percent,
+			Amount:         cmd.Amount,
+			ExpiresAt:      cmd.ExpiresAt,
+			MaxUses:        cmd.MaxUses,
+			MaxUsesPerUser: cmd.MaxUsesPerUser,
+		}, time.Now())
+	case *ApplyPromotion:
+		switch {
+		case !p.created:
+			return ErrPromotionNotFound
+		case p.reservations[cmd.ReservationID]:
+			return ErrPromotionApplied
+		case !p.expiresAt.IsZero() && !time.Now().Before(p.expiresAt):
+			return ErrPromotionExpired
+		case p.maxUses > 0 && p.uses >= p.maxUses:
+			return ErrPromotionUsedUp
+		case p.maxUsesPerUser > 0 && p.users[cmd.User] >= p.maxUsesPerUser:
+			return ErrPromotionUserLimit
+		}
+		p.AppendEvent(PromotionAppliedEvent, &PromotionAppliedData{
+			Code:          p.code,
+			User:          cmd.User,
+			ReservationID: cmd.ReservationID,
+			Percent:       p.percent,
+			Amount:        p.amount,
+		}, time.Now())
+	default:
+		return fmt.Errorf("could not handle command: %s", cmd.CommandType())
+	}
+	return nil
+}
+
+// ApplyEvent is called whenever an event is recieved on the eventBus
+func (p *PromotionAggregate) ApplyEvent(ctx context.Context, event eh.Event) error {
+	switch data := event.Data().(type) {
+	case *PromotionCreatedData:
+		p.created = true
+		p.code = data.Code
+		p.percent, p.amount = data.Percent, data.Amount
+		p.expiresAt = data.ExpiresAt
+		p.maxUses, p.maxUsesPerUser = data.MaxUses, data.MaxUsesPerUser
+	case *PromotionAppliedData:
+		p.uses++
+		p.users[data.User]++
+		p.reservations[data.ReservationID] = true
+	default:
+		return fmt.Errorf("could not apply event: %s", event.EventType())
+	}
+	return nil
+}
+
+// AccountAggregate is the write-model of a user's account credit
+type AccountAggregate struct {
+	*events.AggregateBase
+
+	currency string
+}
+
+// NewAccountAggregate returns an initialized AccountAggregate, this should always be used to create the aggregate
+func NewAccountAggregate(id uuid.UUID) *AccountAggregate {
+	return &AccountAggregate{
+		AggregateBase: events.NewAggregateBase(AccountAggregateType, id),
+	}
+}
+
+// HandleCommand is called whenever the commandBus recieves a command for which this aggregate is registered
+func (a *AccountAggregate) HandleCommand(ctx context.Context, cmd eh.Command) error {
+	switch cmd := cmd.(type) {
+	case *GrantCredit:
+		if cmd.Amount.Amount <= 0 || cmd.Amount.Currency == "" || (a.currency != "" && cmd.Amount.Currency != a.currency) {
+			return ErrInvalidCredit
+		}
+		a.AppendEvent(CreditGrantedEvent, &CreditGrantedData{
+			User:   cmd.User,
+			Amount: cmd.Amount,
+			Reason: cmd.Reason,
+		}, time.Now())
+	default:
+		return fmt.Errorf("could not handle command: %s", cmd.CommandType())
+	}
+	return nil
+}
+
+// ApplyEvent is called whenever an event is recieved on the eventBus
+func (a *AccountAggregate) ApplyEvent(ctx context.Context, event eh.Event) error {
+	if data, ok := event.Data().(*CreditGrantedData); ok {
+		a.currency = data.Amount.Currency
+	}
+	return nil
+}
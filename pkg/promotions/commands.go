@@ -0,0 +1,65 @@
+package promotions
+
+import (
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/money"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+func init() {
+	eh.RegisterCommand(func() eh.Command { return &CreatePromotion{} })
+	eh.RegisterCommand(func() eh.Command { return &ApplyPromotion{} })
+	eh.RegisterCommand(func() eh.Command { return &GrantCredit{} })
+}
+
+const (
+	CreatePromotionCommand eh.CommandType = "CreatePromotion"
+	ApplyPromotionCommand  eh.CommandType = "ApplyPromotion"
+	GrantCreditCommand     eh.CommandType = "GrantCredit"
+)
+
+// CreatePromotion is the command to create a discount code, taking Percent off bookings or a fixed Amount
+// The promotion's ID is derived from the code
+type CreatePromotion struct {
+	Code string
+	User string
+	// Percent is the percentage taken off each booking, 1 to 100
+	Percent int `eh:"optional"`
+	// Amount is taken off each booking, up to what the booking costs
+	Amount money.Money `eh:"optional"`
+	// ExpiresAt is when the code can no longer be applied, never if it is zero
+	ExpiresAt time.Time `eh:"optional"`
+	// MaxUses is how many bookings the code can be applied to, unlimited if it is zero
+	MaxUses int
+	// MaxUsesPerUser is how many bookings of a user the code can be applied to, unlimited if it is zero
+	MaxUsesPerUser int
+}
+
+func (c CreatePromotion) AggregateID() uuid.UUID          { return PromotionID(c.Code) }
+func (c CreatePromotion) AggregateType() eh.AggregateType { return PromotionAggregateType }
+func (c CreatePromotion) CommandType() eh.CommandType     { return CreatePromotionCommand }
+
+// ApplyPromotion is the command to apply a discount code to a user's reservation
+type ApplyPromotion struct {
+	Code          string
+	User          string
+	ReservationID uuid.UUID
+}
+
+func (c ApplyPromotion) AggregateID() uuid.UUID          { return PromotionID(c.Code) }
+func (c ApplyPromotion) AggregateType() eh.AggregateType { return PromotionAggregateType }
+func (c ApplyPromotion) CommandType() eh.CommandType     { return ApplyPromotionCommand }
+
+// GrantCredit is the command to give a user account credit, drawn down by their next bookings
+// The account's ID is derived from the user
+type GrantCredit struct {
+	User   string
+	Amount money.Money
+	Reason string
+}
+
+func (c GrantCredit) AggregateID() uuid.UUID          { return AccountID(c.User) }
+func (c GrantCredit) AggregateType() eh.AggregateType { return AccountAggregateType }
+func (c GrantCredit) CommandType() eh.CommandType     { return GrantCreditCommand }
@@ -0,0 +1,32 @@
+package promotions
+
+import (
+	"errors"
+
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+)
+
+func init() {
+	reservations.RegisterErrorCode("PromotionExists", ErrPromotionExists)
+	reservations.RegisterErrorCode("PromotionNotFound", ErrPromotionNotFound)
+	reservations.RegisterErrorCode("PromotionExpired", ErrPromotionExpired)
+	reservations.RegisterErrorCode("PromotionUsedUp", ErrPromotionUsedUp)
+	reservations.RegisterErrorCode("PromotionUserLimit", ErrPromotionUserLimit)
+	reservations.RegisterErrorCode("PromotionApplied", ErrPromotionApplied)
+	reservations.RegisterErrorCode("NotReservationOwner", ErrNotReservationOwner)
+	reservations.RegisterErrorCode("InvalidDiscount", ErrInvalidDiscount)
+	reservations.RegisterErrorCode("InvalidCredit", ErrInvalidCredit)
+}
+
+// Domain errors returned when a command can not be applied to a promotion or account
+var (
+	ErrPromotionExists     = errors.New("promotion already exists")
+	ErrPromotionNotFound   = errors.New("promotion not found")
+	ErrPromotionExpired    = errors.New("promotion has expired")
+	ErrPromotionUsedUp     = errors.New("promotion has been used up")
+	ErrPromotionUserLimit  = errors.New("promotion has been used up by the user")
+	ErrPromotionApplied    = errors.New("promotion already applied to the reservation")
+	ErrNotReservationOwner = errors.New("reservation belongs to another user")
+	ErrInvalidDiscount     = errors.New("promotion needs a percent from 1 to 100 or a positive amount, and limits of zero or more")
+	ErrInvalidCredit       = errors.New("credit must be positive and in the account's currency")
+)
@@ -0,0 +1,52 @@
+package promotions
+
+import (
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/money"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+func init() {
+	eh.RegisterEventData(PromotionCreatedEvent, func() eh.EventData {
+		return &PromotionCreatedData{}
+	})
+	eh.RegisterEventData(PromotionAppliedEvent, func() eh.EventData {
+		return &PromotionAppliedData{}
+	})
+	eh.RegisterEventData(CreditGrantedEvent, func() eh.EventData {
+		return &CreditGrantedData{}
+	})
+}
+
+const (
+	PromotionCreatedEvent eh.EventType = "PromotionCreated"
+	PromotionAppliedEvent eh.EventType = "PromotionApplied"
+	CreditGrantedEvent    eh.EventType = "CreditGranted"
+)
+
+type PromotionCreatedData struct {
+	Code           string
+	User           string
+	Percent        int
+	Amount         money.Money
+	ExpiresAt      time.Time
+	MaxUses        int
+	MaxUsesPerUser int
+}
+
+// PromotionAppliedData carries the discount so billing does not need to know the promotion
+type PromotionAppliedData struct {
+	Code          string
+	User          string
+	ReservationID uuid.UUID
+	Percent       int
+	Amount        money.Money
+}
+
+type CreditGrantedData struct {
+	User   string
+	Amount money.Money
+	Reason string
+}
@@ -0,0 +1,40 @@
+package promotions
+
+import (
+	"context"
+
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// OwnerCheck is a command handler only passing ApplyPromotion on when the reservation belongs to the user applying the code
+// The promotion aggregate can't see reservations, so it is checked against the reservation's events before it is handled
+type OwnerCheck struct {
+	eh.CommandHandler
+	eventStore eh.EventStore
+}
+
+// NewOwnerCheck wraps the promotion command handler, reservations are looked up in the eventStore
+func NewOwnerCheck(h eh.CommandHandler, eventStore eh.EventStore) *OwnerCheck {
+	return &OwnerCheck{
+		CommandHandler: h,
+		eventStore:     eventStore,
+	}
+}
+
+// HandleCommand returns ErrReservationNotFound or ErrNotReservationOwner instead of applying a code to someone else's reservation
+func (h *OwnerCheck) HandleCommand(ctx context.Context, cmd eh.Command) error {
+	if cmd, ok := cmd.(*ApplyPromotion); ok {
+		events, err := h.eventStore.Load(ctx, cmd.ReservationID)
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return reservations.ErrReservationNotFound
+		}
+		if data, ok := events[0].Data().(*reservations.ReservationCreatedData); !ok || data.User != cmd.User {
+			return ErrNotReservationOwner
+		}
+	}
+	return h.CommandHandler.HandleCommand(ctx, cmd)
+}
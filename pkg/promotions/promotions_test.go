@@ -0,0 +1,189 @@
+package promotions
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/money"
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/eventstore/memory"
+)
+
+func TestPromotionID(t *testing.T) {
+	if PromotionID("free5") != PromotionID(" FREE5 ") {
+		t.Error("PromotionID() depends on case and spaces")
+	}
+	if PromotionID("FREE5") == AccountID("FREE5") {
+		t.Error("PromotionID() and AccountID() collide")
+	}
+}
+
+func TestPromotionAggregate_HandleCommand(t *testing.T) {
+	ctx := context.Background()
+	r1, r2, r3, r4 := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+
+	tests := []struct {
+		name    string
+		cmd     eh.Command
+		wantErr error
+	}{
+		{"apply before created", &ApplyPromotion{Code: "free5", User: "Matt", ReservationID: r1}, ErrPromotionNotFound},
+		{"percent and amount", &CreatePromotion{Code: "free5", User: "marketing", Percent: 10, Amount: money.New(1200, "USD")}, ErrInvalidDiscount},
+		{"neither", &CreatePromotion{Code: "free5", User: "marketing"}, ErrInvalidDiscount},
+		{"over 100 percent", &CreatePromotion{Code: "free5", User: "marketing", Percent: 101}, ErrInvalidDiscount},
+		{"negative limit", &CreatePromotion{Code: "free5", User: "marketing", Percent: 10, MaxUses: -1}, ErrInvalidDiscount},
+		{"create", &CreatePromotion{Code: "free5", User: "marketing", Amount: money.New(1200, "USD"), MaxUses: 2, MaxUsesPerUser: 1}, nil},
+		{"create again", &CreatePromotion{Code: "FREE5", User: "marketing", Percent: 10}, ErrPromotionExists},
+		{"apply", &ApplyPromotion{Code: "free5", User: "Matt", ReservationID: r1}, nil},
+		{"apply to the same reservation", &ApplyPromotion{Code: "FREE5", User: "Matt", ReservationID: r1}, ErrPromotionApplied},
+		{"over the user's limit", &ApplyPromotion{Code: "free5", User: "Matt", ReservationID: r2}, ErrPromotionUserLimit},
+		{"apply for another user", &ApplyPromotion{Code: "free5", User: "Paul", ReservationID: r3}, nil},
+		{"used up", &ApplyPromotion{Code: "free5", User: "Jo", ReservationID: r4}, ErrPromotionUsedUp},
+	}
+
+	p := NewPromotionAggregate(PromotionID("FREE5"))
+	for _, tt := range tests {
+		if err := p.HandleCommand(ctx, tt.cmd); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%v: HandleCommand() error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+		for _, event := range p.UncommittedEvents() {
+			if err := p.ApplyEvent(ctx, event); err != nil {
+				t.Fatal(err)
+			}
+		}
+		p.ClearUncommittedEvents()
+	}
+}
+
+func TestPromotionAggregate_Expired(t *testing.T) {
+	ctx := context.Background()
+	p := NewPromotionAggregate(PromotionID("SUMMER"))
+	create := &CreatePromotion{Code: "SUMMER", User: "marketing", Percent: 20, ExpiresAt: time.Now().Add(-time.Minute)}
+	if err := p.HandleCommand(ctx, create); err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range p.UncommittedEvents() {
+		p.ApplyEvent(ctx, event)
+	}
+	p.ClearUncommittedEvents()
+
+	if err := p.HandleCommand(ctx, &ApplyPromotion{Code: "SUMMER", User: "Matt", ReservationID: uuid.New()}); !errors.Is(err, ErrPromotionExpired) {
+		t.Errorf("HandleCommand() error = %v, want %v", err, ErrPromotionExpired)
+	}
+}
+
+func TestAccountAggregate_HandleCommand(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		amount  money.Money
+		wantErr error
+	}{
+		{"nothing", money.New(0, "USD"), ErrInvalidCredit},
+		{"negative", money.New(-500, "USD"), ErrInvalidCredit},
+		{"no currency", money.Money{Amount: 500}, ErrInvalidCredit},
+		{"grant", money.New(500, "USD"), nil},
+		{"grant more", money.New(250, "USD"), nil},
+		{"another currency", money.New(500, "EUR"), ErrInvalidCredit},
+	}
+
+	a := NewAccountAggregate(AccountID("Matt"))
+	for _, tt := range tests {
+		err := a.HandleCommand(ctx, &GrantCredit{User: "Matt", Amount: tt.amount, Reason: "goodwill"})
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%v: HandleCommand() error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+		for _, event := range a.UncommittedEvents() {
+			a.ApplyEvent(ctx, event)
+		}
+		a.ClearUncommittedEvents()
+	}
+}
+
+func TestPromotionSaga(t *testing.T) {
+	ctx := context.Background()
+	id := uuid.New()
+	start := time.Date(2021, time.July, 2, 9, 0, 0, 0, time.UTC)
+	created := func(code string) eh.Event {
+		return eh.NewEvent(reservations.ReservationCreatedEvent, &reservations.ReservationCreatedData{
+			RoomID: 1, Name: "Standup", User: "Matt", StartTime: start, EndTime: start.Add(time.Hour), PromotionCode: code,
+		}, start, eh.ForAggregate(reservations.ReservationAggregateType, id, 1))
+	}
+
+	tests := []struct {
+		name       string
+		event      eh.Event
+		handlerErr error
+		want       *ApplyPromotion
+		wantErr    bool
+	}{
+		{"no code", created(""), nil, nil, false},
+		{"code", created("FREE5"), nil, &ApplyPromotion{Code: "FREE5", User: "Matt", ReservationID: id}, false},
+		{"redelivered", created("FREE5"), ErrPromotionApplied, &ApplyPromotion{Code: "FREE5", User: "Matt", ReservationID: id}, false},
+		{"expired", created("FREE5"), ErrPromotionExpired, &ApplyPromotion{Code: "FREE5", User: "Matt", ReservationID: id}, false},
+		{"not the owner", created("FREE5"), ErrNotReservationOwner, &ApplyPromotion{Code: "FREE5", User: "Matt", ReservationID: id}, false},
+		{"transient", created("FREE5"), errors.New("database unavailable"), &ApplyPromotion{Code: "FREE5", User: "Matt", ReservationID: id}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *ApplyPromotion
+			h := eh.CommandHandlerFunc(func(ctx context.Context, cmd eh.Command) error {
+				got = cmd.(*ApplyPromotion)
+				return tt.handlerErr
+			})
+			err := NewPromotionSaga().RunSaga(ctx, tt.event, h)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("RunSaga() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("command = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOwnerCheck(t *testing.T) {
+	ctx := context.Background()
+	eventStore, err := memory.NewEventStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := uuid.New()
+	start := time.Date(2021, time.July, 2, 9, 0, 0, 0, time.UTC)
+	if err := eventStore.Save(ctx, []eh.Event{
+		eh.NewEvent(reservations.ReservationCreatedEvent, &reservations.ReservationCreatedData{
+			RoomID: 1, Name: "Standup", User: "Matt", StartTime: start, EndTime: start.Add(time.Hour),
+		}, start, eh.ForAggregate(reservations.ReservationAggregateType, id, 1)),
+	}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		cmd     *ApplyPromotion
+		wantErr error
+	}{
+		{"owner", &ApplyPromotion{Code: "FREE5", User: "Matt", ReservationID: id}, nil},
+		{"another user", &ApplyPromotion{Code: "FREE5", User: "Mallory", ReservationID: id}, ErrNotReservationOwner},
+		{"unknown reservation", &ApplyPromotion{Code: "FREE5", User: "Matt", ReservationID: uuid.New()}, reservations.ErrReservationNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handled := false
+			h := NewOwnerCheck(eh.CommandHandlerFunc(func(ctx context.Context, cmd eh.Command) error {
+				handled = true
+				return nil
+			}), eventStore)
+			if err := h.HandleCommand(ctx, tt.cmd); !errors.Is(err, tt.wantErr) {
+				t.Errorf("HandleCommand() error = %v, want %v", err, tt.wantErr)
+			}
+			if handled != (tt.wantErr == nil) {
+				t.Errorf("HandleCommand() passed on = %v, want %v", handled, tt.wantErr == nil)
+			}
+		})
+	}
+}
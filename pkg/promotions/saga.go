@@ -0,0 +1,54 @@
+package promotions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/eventhandler/saga"
+)
+
+const PromotionSagaType saga.Type = "PromotionSaga"
+
+// PromotionSaga applies the discount codes reservations are created with
+// A code that can't be applied (expired, used up, unknown...) is logged and the booking is billed in full
+type PromotionSaga struct{}
+
+func NewPromotionSaga() *PromotionSaga {
+	return &PromotionSaga{}
+}
+
+func (s *PromotionSaga) SagaType() saga.Type {
+	return PromotionSagaType
+}
+
+// RunSaga applies the promotion code of a created reservation, once
+func (s *PromotionSaga) RunSaga(ctx context.Context, event eh.Event, h eh.CommandHandler) error {
+	if event.EventType() != reservations.ReservationCreatedEvent {
+		return nil
+	}
+	data, ok := event.Data().(*reservations.ReservationCreatedData)
+	if !ok {
+		return fmt.Errorf("saga: invalid event data type: %v", event.Data())
+	}
+	if data.PromotionCode == "" {
+		return nil
+	}
+	err := h.HandleCommand(ctx, &ApplyPromotion{
+		Code:          data.PromotionCode,
+		User:          data.User,
+		ReservationID: event.AggregateID(),
+	})
+	if errors.Is(err, ErrPromotionApplied) {
+		return nil
+	} else if reservations.ErrorCode(err) != reservations.InternalErrorCode {
+		// Rejected codes are final, returning them would only have the event redelivered
+		if err != nil {
+			fmt.Printf("Promotion %v not applied to %v: %v\n", data.PromotionCode, event.AggregateID(), err)
+		}
+		return nil
+	}
+	return fmt.Errorf("saga: could not apply %v to %v: %w", data.PromotionCode, event.AggregateID(), err)
+}
@@ -0,0 +1,57 @@
+package promotions
+
+import (
+	"context"
+	"log"
+
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/aggregatestore/events"
+	"github.com/looplab/eventhorizon/commandhandler/aggregate"
+	"github.com/looplab/eventhorizon/commandhandler/bus"
+	"github.com/looplab/eventhorizon/eventhandler/saga"
+)
+
+// Setup will initialize and register the promotion and account aggregates, their commands
+// and the saga applying the codes reservations are created with
+func Setup(
+	ctx context.Context,
+	eventStore eh.EventStore,
+	eventBus eh.EventBus,
+	commandBus *bus.CommandHandler,
+) {
+	promotionSaga := saga.NewEventHandler(NewPromotionSaga(), commandBus)
+	eventBus.AddHandler(ctx, eh.MatchEvents{
+		reservations.ReservationCreatedEvent,
+	}, promotionSaga)
+
+	aggregateStore, err := events.NewAggregateStore(eventStore)
+	if err != nil {
+		log.Fatalf("could not create aggregate store: %v", err)
+	}
+
+	handlers := map[eh.AggregateType][]eh.CommandType{
+		PromotionAggregateType: {
+			CreatePromotionCommand,
+			ApplyPromotionCommand,
+		},
+		AccountAggregateType: {
+			GrantCreditCommand,
+		},
+	}
+	for aggregateType, commands := range handlers {
+		commandHandler, err := aggregate.NewCommandHandler(aggregateType, aggregateStore)
+		if err != nil {
+			log.Fatalf("could not create command handler: %s", err)
+		}
+		var h eh.CommandHandler = commandHandler
+		if aggregateType == PromotionAggregateType {
+			h = NewOwnerCheck(commandHandler, eventStore)
+		}
+		for _, cmdType := range commands {
+			if err := commandBus.SetHandler(h, cmdType); err != nil {
+				log.Fatalf("could not set command handler: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package query
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// versionETag is the ETag of a single read model, which changes with every projected event
+func versionETag(version int) string {
+	return fmt.Sprintf(`"v%d"`, version)
+}
+
+// pageETag is the ETag of a page, it changes when any reservation on it does or the page boundary moves
+func pageETag(page *ReservationPage) string {
+	h := fnv.New64a()
+	for _, r := range page.Reservations {
+		fmt.Fprintf(h, "%v:%d,", r.ID, r.Version)
+	}
+	fmt.Fprint(h, page.NextCursor)
+	return fmt.Sprintf(`"%x"`, h.Sum64())
+}
+
+// etagMatches returns true if the If-None-Match header matches the ETag, weak tags are compared weakly
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package query
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+)
+
+// ErrInvalidCursor is when a cursor could not be decoded or was issued for a different sort
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// sortKeys returns a key for each sortable field, keys must compare in the same order as the field
+var sortKeys = map[string]func(*reservations.Reservation) string{
+	"start":   func(r *reservations.Reservation) string { return timeKey(r.StartTime) },
+	"end":     func(r *reservations.Reservation) string { return timeKey(r.EndTime) },
+	"room":    func(r *reservations.Reservation) string { return fmt.Sprintf("%020d", r.RoomID) },
+	"name":    func(r *reservations.Reservation) string { return r.Name },
+	"creator": func(r *reservations.Reservation) string { return r.Creator },
+	"status":  func(r *reservations.Reservation) string { return string(r.Status) },
+	"version": func(r *reservations.Reservation) string { return fmt.Sprintf("%020d", r.Version) },
+}
+
+// timeKey formats t in UTC with a fixed width so keys sort chronologically
+func timeKey(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000000000")
+}
+
+// ReservationPage is a page of reservations, NextCursor is empty on the last page
+type ReservationPage struct {
+	Reservations []*reservations.Reservation
+	NextCursor   string `json:",omitempty"`
+}
+
+// listQuery is the parsed query string of a list request
+type listQuery struct {
+	room     int
+	from, to time.Time
+	statuses map[reservations.ReservationStatus]bool
+	creator  string
+
+	sort  string
+	desc  bool
+	limit int
+	after *cursor
+}
+
+// cursor is the position of the last reservation of a page, in the sort it was issued for
+type cursor struct {
+	Sort string
+	Key  string
+	ID   string
+}
+
+func parseListQuery(values url.Values) (*listQuery, error) {
+	q := &listQuery{sort: "start", limit: DefaultPageSize}
+	var err error
+	if room := values.Get("room"); room != "" {
+		if q.room, err = strconv.Atoi(room); err != nil {
+			return nil, fmt.Errorf("invalid room %q", room)
+		}
+	}
+	if from := values.Get("from"); from != "" {
+		if q.from, err = time.Parse(time.RFC3339, from); err != nil {
+			return nil, fmt.Errorf("invalid from %q, expected RFC 3339", from)
+		}
+	}
+	if to := values.Get("to"); to != "" {
+		if q.to, err = time.Parse(time.RFC3339, to); err != nil {
+			return nil, fmt.Errorf("invalid to %q, expected RFC 3339", to)
+		}
+	}
+	if status := values.Get("status"); status != "" {
+		q.statuses = make(map[reservations.ReservationStatus]bool)
+		for _, s := range strings.Split(status, ",") {
+			q.statuses[reservations.ReservationStatus(s)] = true
+		}
+	}
+	q.creator = values.Get("creator")
+
+	if s := values.Get("sort"); s != "" {
+		q.desc = strings.HasPrefix(s, "-")
+		q.sort = strings.TrimPrefix(s, "-")
+		if _, ok := sortKeys[q.sort]; !ok {
+			return nil, fmt.Errorf("invalid sort %q", s)
+		}
+	}
+	if limit := values.Get("limit"); limit != "" {
+		if q.limit, err = strconv.Atoi(limit); err != nil || q.limit < 1 || q.limit > MaxPageSize {
+			return nil, fmt.Errorf("invalid limit %q, expected 1 to %d", limit, MaxPageSize)
+		}
+	}
+	if c := values.Get("cursor"); c != "" {
+		if q.after, err = decodeCursor(c, q.sortName()); err != nil {
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+// sortName is the sort as given in the query string
+func (q *listQuery) sortName() string {
+	if q.desc {
+		return "-" + q.sort
+	}
+	return q.sort
+}
+
+// match returns true if r passes the filters, from and to match reservations overlapping the range
+func (q *listQuery) match(r *reservations.Reservation) bool {
+	return (q.room == 0 || r.RoomID == q.room) &&
+		(q.from.IsZero() || r.EndTime.After(q.from)) &&
+		(q.to.IsZero() || r.StartTime.Before(q.to)) &&
+		(q.statuses == nil || q.statuses[r.Status]) &&
+		(q.creator == "" || r.Creator == q.creator)
+}
+
+// page sorts the reservations and returns the page after the cursor
+// Ties are broken by ID, so the order is total and the cursor is stable while reservations change
+func (q *listQuery) page(rs []*reservations.Reservation) *ReservationPage {
+	key := sortKeys[q.sort]
+	less := func(aKey, aID, bKey, bID string) bool {
+		if aKey != bKey {
+			return (aKey < bKey) != q.desc
+		}
+		return aID != bID && (aID < bID) != q.desc
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		return less(key(rs[i]), rs[i].ID.String(), key(rs[j]), rs[j].ID.String())
+	})
+
+	start := 0
+	if q.after != nil {
+		start = sort.Search(len(rs), func(i int) bool {
+			return less(q.after.Key, q.after.ID, key(rs[i]), rs[i].ID.String())
+		})
+	}
+	end := start + q.limit
+	if end > len(rs) {
+		end = len(rs)
+	}
+
+	page := &ReservationPage{Reservations: rs[start:end]}
+	if end < len(rs) {
+		last := rs[end-1]
+		page.NextCursor = encodeCursor(&cursor{Sort: q.sortName(), Key: key(last), ID: last.ID.String()})
+	}
+	return page
+}
+
+func encodeCursor(c *cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s, sortName string) (*cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	c := &cursor{}
+	if err := json.Unmarshal(data, c); err != nil || c.Sort != sortName {
+		return nil, ErrInvalidCursor
+	}
+	return c, nil
+}
@@ -0,0 +1,194 @@
+package query
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/audit"
+	"github.com/MattDevy/CQRS-example/pkg/billing"
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+const (
+	// ReservationsPath lists reservations, ReservationsPath + "/{id}" gets a single reservation
+	ReservationsPath = "/reservations"
+	// HistorySuffix is appended to a reservation's path to get its audit timeline
+	HistorySuffix = "/history"
+	// BillingPath + "{user}" gets a user's billing history, BillingPath + "{user}/months/{yyyy-mm}" gets a single bill
+	// and BillingPath + "{user}/invoices/{yyyy-mm}" gets it as an invoice with its lines in time order
+	BillingPath = "/billing/"
+
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+// Handler serves the read models as JSON, it only reads through eh.ReadRepo so works with any repo
+type Handler struct {
+	reservationRepo eh.ReadRepo
+	billingRepo     eh.ReadRepo
+	timelineRepo    eh.ReadRepo
+}
+
+// NewHandler returns an initialized Handler
+func NewHandler(reservationRepo, billingRepo, timelineRepo eh.ReadRepo) *Handler {
+	return &Handler{reservationRepo: reservationRepo, billingRepo: billingRepo, timelineRepo: timelineRepo}
+}
+
+// Register registers the query endpoints on the mux
+func (h *Handler) Register(mux *http.ServeMux) {
+	mux.Handle(ReservationsPath, readOnly(h.listReservations))
+	mux.Handle(ReservationsPath+"/", readOnly(h.getReservation))
+	mux.Handle(BillingPath, readOnly(h.getBilling))
+}
+
+// readOnly rejects anything but GET and HEAD requests
+func readOnly(f http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "unsupported method: "+r.Method, http.StatusMethodNotAllowed)
+			return
+		}
+		f(w, r)
+	})
+}
+
+func (h *Handler) getReservation(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, ReservationsPath+"/")
+	if strings.HasSuffix(path, HistorySuffix) {
+		h.getTimeline(w, r, strings.TrimSuffix(path, HistorySuffix))
+		return
+	}
+	id, err := uuid.Parse(path)
+	if err != nil {
+		http.Error(w, "invalid reservation id: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	e, err := h.reservationRepo.Find(r.Context(), id)
+	if errors.Is(err, eh.ErrEntityNotFound) {
+		http.Error(w, fmt.Sprintf("reservation %v not found", id), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(w, err)
+		return
+	}
+	reservation, ok := e.(*reservations.Reservation)
+	if !ok {
+		writeError(w, errors.New("incorrect entity type"))
+		return
+	}
+	writeJSON(w, r, versionETag(reservation.Version), reservation)
+}
+
+func (h *Handler) getTimeline(w http.ResponseWriter, r *http.Request, path string) {
+	id, err := uuid.Parse(path)
+	if err != nil {
+		http.Error(w, "invalid reservation id: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	e, err := h.timelineRepo.Find(r.Context(), id)
+	if errors.Is(err, eh.ErrEntityNotFound) {
+		http.Error(w, fmt.Sprintf("no history for reservation %v", id), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(w, err)
+		return
+	}
+	timeline, ok := e.(*audit.Timeline)
+	if !ok {
+		writeError(w, errors.New("incorrect entity type"))
+		return
+	}
+	writeJSON(w, r, versionETag(timeline.Version), timeline)
+}
+
+func (h *Handler) listReservations(w http.ResponseWriter, r *http.Request) {
+	q, err := parseListQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	entities, err := h.reservationRepo.FindAll(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var matched []*reservations.Reservation
+	for _, e := range entities {
+		if reservation, ok := e.(*reservations.Reservation); ok && q.match(reservation) {
+			matched = append(matched, reservation)
+		}
+	}
+	page := q.page(matched)
+	writeJSON(w, r, pageETag(page), page)
+}
+
+func (h *Handler) getBilling(w http.ResponseWriter, r *http.Request) {
+	// Either {user}, {user}/months/{yyyy-mm} or {user}/invoices/{yyyy-mm}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, BillingPath), "/")
+	if parts[0] == "" || (len(parts) != 1 && (len(parts) != 3 || (parts[1] != "months" && parts[1] != "invoices"))) {
+		http.NotFound(w, r)
+		return
+	}
+
+	history, err := billing.FindByUser(r.Context(), h.billingRepo, parts[0])
+	if errors.Is(err, eh.ErrEntityNotFound) {
+		http.Error(w, fmt.Sprintf("no billing history for %q", parts[0]), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(w, err)
+		return
+	}
+	if len(parts) == 1 {
+		writeJSON(w, r, versionETag(history.Version), history)
+		return
+	}
+
+	month, err := time.Parse("2006-01", parts[2])
+	if err != nil {
+		http.Error(w, "invalid month, expected yyyy-mm: "+parts[2], http.StatusBadRequest)
+		return
+	}
+	if parts[1] == "invoices" {
+		invoice, ok := history.Invoice(month)
+		if !ok {
+			http.Error(w, fmt.Sprintf("no invoice for %q in %v", parts[0], parts[2]), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, r, versionETag(invoice.Version), invoice)
+		return
+	}
+	bill, ok := history.Bills[billing.MonthKey(month)]
+	if !ok {
+		http.Error(w, fmt.Sprintf("no bill for %q in %v", parts[0], parts[2]), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, r, versionETag(bill.Version), bill)
+}
+
+// writeJSON writes v with its ETag, or 304 Not Modified if the client already has it
+func writeJSON(w http.ResponseWriter, r *http.Request, etag string, v interface{}) {
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodHead {
+		return
+	}
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Printf("Could not write response: %v\n", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	fmt.Printf("Error: %v\n", err)
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
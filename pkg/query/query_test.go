@@ -0,0 +1,285 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/audit"
+	"github.com/MattDevy/CQRS-example/pkg/billing"
+	"github.com/MattDevy/CQRS-example/pkg/money"
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	memoryRepo "github.com/looplab/eventhorizon/repo/memory"
+)
+
+var start = time.Date(2021, time.July, 1, 9, 0, 0, 0, time.UTC)
+
+func newTestServer(t *testing.T) (*httptest.Server, []*reservations.Reservation) {
+	ctx := context.Background()
+	reservationRepo := memoryRepo.NewRepo()
+	reservationRepo.SetEntityFactory(func() eh.Entity { return &reservations.Reservation{} })
+	billingRepo := memoryRepo.NewRepo()
+	billingRepo.SetEntityFactory(func() eh.Entity { return &billing.BillingHistory{} })
+	timelineRepo := memoryRepo.NewRepo()
+	timelineRepo.SetEntityFactory(func() eh.Entity { return &audit.Timeline{} })
+
+	var rs []*reservations.Reservation
+	for i := 0; i < 5; i++ {
+		r := &reservations.Reservation{
+			ID:        uuid.New(),
+			Version:   1,
+			Name:      "Meeting",
+			Creator:   "Matt",
+			RoomID:    i%2 + 1,
+			StartTime: start.Add(time.Duration(i) * time.Hour),
+			EndTime:   start.Add(time.Duration(i)*time.Hour + 30*time.Minute),
+			Status:    reservations.StatusConfirmed,
+		}
+		if i == 4 {
+			r.Creator = "Alice"
+			r.Status = reservations.StatusPending
+		}
+		if err := reservationRepo.Save(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+		rs = append(rs, r)
+	}
+
+	if err := billingRepo.Save(ctx, &billing.BillingHistory{
+		ID:      uuid.New(),
+		Version: 3,
+		User:    "Matt",
+		Bills: map[string]*billing.Bill{
+			billing.MonthKey(start): {ID: uuid.New(), Version: 2, Minutes: 120, Total: money.New(480, "USD"), Lines: map[string]*billing.Line{
+				rs[1].ID.String(): {ReservationID: rs[1].ID, StartTime: rs[1].StartTime, EndTime: rs[1].EndTime, Minutes: 60, Amount: money.New(240, "USD"), Status: billing.LineCharged},
+				rs[0].ID.String(): {ReservationID: rs[0].ID, StartTime: rs[0].StartTime, EndTime: rs[0].EndTime, Minutes: 60, Amount: money.New(240, "USD"), Status: billing.LineCharged},
+			}},
+		},
+		TotalMinutes: 120,
+		TotalPaid:    money.New(480, "USD"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := timelineRepo.Save(ctx, &audit.Timeline{
+		ID:      rs[0].ID,
+		Version: 1,
+		Entries: []*audit.Entry{{
+			Version:   1,
+			EventType: reservations.ReservationCreatedEvent,
+			Actor:     "Matt",
+			Timestamp: start,
+			After:     &audit.Period{StartTime: rs[0].StartTime, EndTime: rs[0].EndTime},
+		}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	NewHandler(reservationRepo, billingRepo, timelineRepo).Register(mux)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, rs
+}
+
+func get(t *testing.T, url string, header http.Header, v interface{}) *http.Response {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k := range header {
+		req.Header.Set(k, header.Get(k))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK && v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp
+}
+
+func TestHandler_GetReservation(t *testing.T) {
+	srv, rs := newTestServer(t)
+
+	r := &reservations.Reservation{}
+	resp := get(t, srv.URL+"/reservations/"+rs[0].ID.String(), nil, r)
+	if resp.StatusCode != http.StatusOK || r.ID != rs[0].ID {
+		t.Fatalf("GET reservation = %v %+v, want %v", resp.StatusCode, r, rs[0].ID)
+	}
+
+	etag := resp.Header.Get("ETag")
+	resp = get(t, srv.URL+"/reservations/"+rs[0].ID.String(), http.Header{"If-None-Match": {etag}}, nil)
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("GET reservation If-None-Match = %v, want %v", resp.StatusCode, http.StatusNotModified)
+	}
+
+	if resp := get(t, srv.URL+"/reservations/"+uuid.New().String(), nil, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET unknown reservation = %v, want %v", resp.StatusCode, http.StatusNotFound)
+	}
+	if resp := get(t, srv.URL+"/reservations/nope", nil, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("GET invalid reservation = %v, want %v", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestHandler_GetTimeline(t *testing.T) {
+	srv, rs := newTestServer(t)
+
+	timeline := &audit.Timeline{}
+	resp := get(t, srv.URL+"/reservations/"+rs[0].ID.String()+"/history", nil, timeline)
+	if resp.StatusCode != http.StatusOK || len(timeline.Entries) != 1 || timeline.Entries[0].Actor != "Matt" {
+		t.Fatalf("GET history = %v %+v", resp.StatusCode, timeline)
+	}
+	if resp := get(t, srv.URL+"/reservations/"+rs[1].ID.String()+"/history", nil, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET history without events = %v, want %v", resp.StatusCode, http.StatusNotFound)
+	}
+	if resp := get(t, srv.URL+"/reservations/nope/history", nil, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("GET invalid history = %v, want %v", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestHandler_ListReservations(t *testing.T) {
+	srv, rs := newTestServer(t)
+
+	tests := []struct {
+		name  string
+		query url.Values
+		want  []*reservations.Reservation
+	}{
+		{
+			name: "all by start time",
+			want: rs,
+		},
+		{
+			name:  "room",
+			query: url.Values{"room": {"2"}},
+			want:  []*reservations.Reservation{rs[1], rs[3]},
+		},
+		{
+			name:  "overlapping range",
+			query: url.Values{"from": {start.Add(75 * time.Minute).Format(time.RFC3339)}, "to": {start.Add(3 * time.Hour).Format(time.RFC3339)}},
+			want:  []*reservations.Reservation{rs[1], rs[2]},
+		},
+		{
+			name:  "status and creator",
+			query: url.Values{"status": {"pending,declined"}, "creator": {"Alice"}},
+			want:  []*reservations.Reservation{rs[4]},
+		},
+		{
+			name:  "descending",
+			query: url.Values{"sort": {"-start"}, "room": {"1"}},
+			want:  []*reservations.Reservation{rs[4], rs[2], rs[0]},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := &ReservationPage{}
+			resp := get(t, srv.URL+"/reservations?"+tt.query.Encode(), nil, page)
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("GET reservations = %v", resp.StatusCode)
+			}
+			if len(page.Reservations) != len(tt.want) {
+				t.Fatalf("GET reservations = %d reservations, want %d", len(page.Reservations), len(tt.want))
+			}
+			for i, r := range page.Reservations {
+				if r.ID != tt.want[i].ID {
+					t.Errorf("GET reservations [%d] = %v, want %v", i, r.ID, tt.want[i].ID)
+				}
+			}
+		})
+	}
+
+	if resp := get(t, srv.URL+"/reservations?sort=colour", nil, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("GET reservations invalid sort = %v, want %v", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestHandler_ListReservations_Pagination(t *testing.T) {
+	srv, rs := newTestServer(t)
+
+	var got []*reservations.Reservation
+	query := url.Values{"limit": {"2"}, "sort": {"-start"}}
+	for pages := 0; ; pages++ {
+		if pages > len(rs) {
+			t.Fatal("too many pages")
+		}
+		page := &ReservationPage{}
+		if resp := get(t, srv.URL+"/reservations?"+query.Encode(), nil, page); resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET reservations = %v", resp.StatusCode)
+		}
+		got = append(got, page.Reservations...)
+		if page.NextCursor == "" {
+			break
+		}
+		query.Set("cursor", page.NextCursor)
+	}
+
+	if len(got) != len(rs) {
+		t.Fatalf("paged %d reservations, want %d", len(got), len(rs))
+	}
+	for i, r := range got {
+		if want := rs[len(rs)-1-i]; r.ID != want.ID {
+			t.Errorf("reservation [%d] = %v, want %v", i, r.ID, want.ID)
+		}
+	}
+
+	// A cursor is only valid for the sort it was issued for
+	query.Set("sort", "start")
+	if resp := get(t, srv.URL+"/reservations?"+query.Encode(), nil, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("GET reservations with other sort's cursor = %v, want %v", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestHandler_GetBilling(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	h := &billing.BillingHistory{}
+	resp := get(t, srv.URL+"/billing/Matt", nil, h)
+	if resp.StatusCode != http.StatusOK || h.TotalMinutes != 120 {
+		t.Errorf("GET billing = %v %+v, want 120 minutes", resp.StatusCode, h)
+	}
+	if resp.Header.Get("ETag") != `"v3"` {
+		t.Errorf("GET billing ETag = %v, want %v", resp.Header.Get("ETag"), `"v3"`)
+	}
+
+	bill := &billing.Bill{}
+	resp = get(t, srv.URL+"/billing/Matt/months/2021-07", nil, bill)
+	if resp.StatusCode != http.StatusOK || bill.Minutes != 120 {
+		t.Errorf("GET bill = %v %+v, want 120 minutes", resp.StatusCode, bill)
+	}
+
+	invoice := &billing.Invoice{}
+	resp = get(t, srv.URL+"/billing/Matt/invoices/2021-07", nil, invoice)
+	if resp.StatusCode != http.StatusOK || invoice.Month != "2021-07" || invoice.Total != money.New(480, "USD") {
+		t.Errorf("GET invoice = %v %+v, want 2021-07 for USD 4.80", resp.StatusCode, invoice)
+	}
+	if len(invoice.Lines) != 2 || !invoice.Lines[0].StartTime.Before(invoice.Lines[1].StartTime) {
+		t.Errorf("GET invoice lines = %+v, want 2 in time order", invoice.Lines)
+	}
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/billing/Alice", http.StatusNotFound},
+		{"/billing/Matt/months/2021-08", http.StatusNotFound},
+		{"/billing/Matt/months/July", http.StatusBadRequest},
+		{"/billing/Matt/invoices/2021-08", http.StatusNotFound},
+		{"/billing/Matt/years/2021", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if resp := get(t, srv.URL+tt.path, nil, nil); resp.StatusCode != tt.want {
+			t.Errorf("GET %v = %v, want %v", tt.path, resp.StatusCode, tt.want)
+		}
+	}
+}
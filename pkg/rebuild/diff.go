@@ -0,0 +1,102 @@
+package rebuild
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// Diff is how a rebuilt collection differs from the live one, by entity key
+type Diff struct {
+	// Added are only in the rebuilt collection
+	Added []string
+	// Removed are only in the live collection
+	Removed []string
+	Changed []string
+	// Unchanged is the number of entities that are the same in both
+	Unchanged int
+}
+
+// Empty returns true if the collections are the same
+func (d *Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// Compare diffs the projection's live and rebuilt collections
+func Compare(ctx context.Context, projection *Projection, live, shadow eh.ReadRepo) (*Diff, error) {
+	liveDocs, err := documents(ctx, projection, live)
+	if err != nil {
+		return nil, err
+	}
+	shadowDocs, err := documents(ctx, projection, shadow)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Diff{}
+	for key, doc := range shadowDocs {
+		liveDoc, ok := liveDocs[key]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, key)
+		case !bytes.Equal(doc, liveDoc):
+			d.Changed = append(d.Changed, key)
+		default:
+			d.Unchanged++
+		}
+	}
+	for key := range liveDocs {
+		if _, ok := shadowDocs[key]; !ok {
+			d.Removed = append(d.Removed, key)
+		}
+	}
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Strings(d.Changed)
+	return d, nil
+}
+
+// documents returns the normalized JSON of the latest entity with each key
+func documents(ctx context.Context, projection *Projection, repo eh.ReadRepo) (map[string][]byte, error) {
+	entities, err := repo.FindAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	docs := make(map[string][]byte)
+	versions := make(map[string]int)
+	for _, e := range entities {
+		key := e.EntityID().String()
+		if projection.Key != nil {
+			key = projection.Key(e)
+		}
+		version := 0
+		if v, ok := e.(eh.Versionable); ok {
+			version = v.AggregateVersion()
+		}
+		if _, ok := docs[key]; ok && version <= versions[key] {
+			continue
+		}
+
+		// Normalize a copy, so the repo's entities are never changed
+		raw, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		if projection.Normalize != nil {
+			copied := projection.NewEntity()
+			if err := json.Unmarshal(raw, copied); err != nil {
+				return nil, err
+			}
+			projection.Normalize(copied)
+			if raw, err = json.Marshal(copied); err != nil {
+				return nil, err
+			}
+		}
+		docs[key] = raw
+		versions[key] = version
+	}
+	return docs, nil
+}
@@ -0,0 +1,114 @@
+package rebuild
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	// Register uuid.UUID as BSON type
+	_ "github.com/looplab/eventhorizon/codec/bson"
+)
+
+// EventsCollection is the collection the Mongo event store keeps one document per aggregate in
+const EventsCollection = "events"
+
+// record is an event as saved by the Mongo event store
+type record struct {
+	EventType     eh.EventType           `bson:"event_type"`
+	RawData       bson.Raw               `bson:"data,omitempty"`
+	Timestamp     time.Time              `bson:"timestamp"`
+	AggregateType eh.AggregateType       `bson:"aggregate_type"`
+	AggregateID   uuid.UUID              `bson:"_id"`
+	Version       int                    `bson:"version"`
+	Metadata      map[string]interface{} `bson:"metadata"`
+}
+
+// MongoSource streams the events of the Mongo event store
+// The store has no global sequence, so events are ordered by timestamp, then aggregate and version
+type MongoSource struct {
+	events *mongo.Collection
+}
+
+var _ = Source(&MongoSource{})
+
+// NewMongoSource returns a MongoSource for the event store in the database
+func NewMongoSource(client *mongo.Client, db string) *MongoSource {
+	return &MongoSource{events: client.Database(db).Collection(EventsCollection)}
+}
+
+// Count sums the events of every aggregate
+func (s *MongoSource) Count(ctx context.Context) (int, error) {
+	cursor, err := s.events.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": nil, "total": bson.M{"$sum": bson.M{"$size": "$events"}}}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+	var result struct {
+		Total int `bson:"total"`
+	}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&result); err != nil {
+			return 0, err
+		}
+	}
+	return result.Total, cursor.Err()
+}
+
+// Stream unwinds the aggregates' events and sorts them server side
+func (s *MongoSource) Stream(ctx context.Context, f func(eh.Event) error) error {
+	cursor, err := s.events.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$unwind", Value: "$events"}},
+		{{Key: "$replaceRoot", Value: bson.M{"newRoot": "$events"}}},
+		{{Key: "$sort", Value: bson.D{{Key: "timestamp", Value: 1}, {Key: "_id", Value: 1}, {Key: "version", Value: 1}}}},
+	}, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var r record
+		if err := cursor.Decode(&r); err != nil {
+			return err
+		}
+		var data eh.EventData
+		if len(r.RawData) > 0 {
+			if data, err = eh.CreateEventData(r.EventType); err != nil {
+				return fmt.Errorf("could not create event data: %w", err)
+			}
+			if err := bson.Unmarshal(r.RawData, data); err != nil {
+				return fmt.Errorf("could not unmarshal event data: %w", err)
+			}
+		}
+		event := eh.NewEvent(r.EventType, data, r.Timestamp,
+			eh.ForAggregate(r.AggregateType, r.AggregateID, r.Version),
+			eh.WithMetadata(r.Metadata),
+		)
+		if err := f(event); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// Swap atomically replaces the live collection with the shadow collection, dropping the live one
+func Swap(ctx context.Context, client *mongo.Client, db, shadow, live string) error {
+	return client.Database("admin").RunCommand(ctx, bson.D{
+		{Key: "renameCollection", Value: db + "." + shadow},
+		{Key: "to", Value: db + "." + live},
+		{Key: "dropTarget", Value: true},
+	}).Err()
+}
+
+// Drop drops the collection, for shadow collections of dry runs
+func Drop(ctx context.Context, client *mongo.Client, db, collection string) error {
+	return client.Database(db).Collection(collection).Drop(ctx)
+}
@@ -0,0 +1,142 @@
+package rebuild
+
+import (
+	"sort"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/audit"
+	"github.com/MattDevy/CQRS-example/pkg/availability"
+	"github.com/MattDevy/CQRS-example/pkg/billing"
+	"github.com/MattDevy/CQRS-example/pkg/invoicing"
+	"github.com/MattDevy/CQRS-example/pkg/payments"
+	"github.com/MattDevy/CQRS-example/pkg/promotions"
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/eventhandler/projector"
+)
+
+// Projection is a read model that can be rebuilt from the events
+type Projection struct {
+	// Collection is the name of the read model's collection
+	Collection string
+	Events     eh.MatchEvents
+	NewEntity  func() eh.Entity
+	// NewHandler returns a projector with no state, writing into repo
+	NewHandler func(repo eh.ReadWriteRepo) eh.EventHandler
+	// Key identifies the same entity in two collections, for read models with random IDs
+	Key func(eh.Entity) string
+	// Normalize clears the fields that are expected to differ between rebuilds, before diffing
+	Normalize func(eh.Entity)
+}
+
+// BillingLocation is the time zone billing is rebuilt in, it must match the one the example bills in
+var BillingLocation = time.UTC
+
+// BillingPricing is the policy billing is rebuilt with, it must match the one the example prices with
+var BillingPricing = billing.DefaultPricingPolicy
+
+// BillingFees are the fees billing is rebuilt with, they must match the ones the example charges
+var BillingFees = billing.DefaultFeePolicy
+
+// BillingUsage selects the bookings billed by usage when rebuilding, it must match the one the example bills with
+var BillingUsage = billing.DefaultUsagePolicy
+
+// reservationEvents are the events every projection handles
+var reservationEvents = eh.MatchEvents{
+	reservations.ReservationCreatedEvent,
+	reservations.ReservationConfirmedEvent,
+	reservations.ReservationDeclinedEvent,
+	reservations.ReservationTimeChangedEvent,
+	reservations.ReservationCancelledEvent,
+}
+
+// attendanceEvents are the check-ins, check-outs and no-shows of confirmed reservations
+var attendanceEvents = eh.MatchEvents{
+	reservations.ReservationCheckedInEvent,
+	reservations.ReservationCheckedOutEvent,
+	reservations.ReservationNoShowEvent,
+}
+
+// Projections are the projections that can be rebuilt, by collection
+var Projections = map[string]*Projection{
+	"reservations": {
+		Collection: "reservations",
+		Events:     append(append(eh.MatchEvents{reservations.ReservationBookingConflictedEvent}, reservationEvents...), attendanceEvents...),
+		NewEntity:  func() eh.Entity { return &reservations.Reservation{} },
+		NewHandler: func(repo eh.ReadWriteRepo) eh.EventHandler {
+			h := projector.NewEventHandler(reservations.NewReservationProjector(), repo)
+			h.SetEntityFactory(func() eh.Entity { return &reservations.Reservation{} })
+			return h
+		},
+	},
+	"billing": {
+		Collection: "billing",
+		Events: append(append(eh.MatchEvents{
+			payments.PaymentSucceededEvent,
+			payments.PaymentFailedEvent,
+			promotions.PromotionAppliedEvent,
+			promotions.CreditGrantedEvent,
+		}, reservationEvents...), attendanceEvents...),
+		NewEntity: func() eh.Entity { return &billing.BillingHistory{Bills: make(map[string]*billing.Bill)} },
+		NewHandler: func(repo eh.ReadWriteRepo) eh.EventHandler {
+			p := billing.NewBillingHistoryProjector(repo)
+			p.SetLocation(BillingLocation)
+			p.SetPricingPolicy(BillingPricing)
+			p.SetFeePolicy(BillingFees)
+			p.SetUsagePolicy(BillingUsage)
+			return p
+		},
+		// Histories and bills projected before their IDs were derived from the user have random IDs, so compare by user
+		Key: func(e eh.Entity) string {
+			return e.(*billing.BillingHistory).User
+		},
+		Normalize: func(e eh.Entity) {
+			h := e.(*billing.BillingHistory)
+			h.ID = [16]byte{}
+			for _, bill := range h.Bills {
+				bill.ID = [16]byte{}
+			}
+		},
+	},
+	"availability": {
+		Collection: "availability",
+		Events:     reservationEvents,
+		NewEntity:  func() eh.Entity { return &availability.RoomDay{} },
+		NewHandler: func(repo eh.ReadWriteRepo) eh.EventHandler {
+			return availability.NewRoomDayProjector(repo)
+		},
+	},
+	"invoices": {
+		Collection: "invoices",
+		Events: eh.MatchEvents{
+			invoicing.InvoiceIssuedEvent,
+			invoicing.PaymentRecordedEvent,
+			invoicing.InvoiceVoidedEvent,
+			invoicing.CreditNoteIssuedEvent,
+		},
+		NewEntity: func() eh.Entity { return &invoicing.Invoice{} },
+		NewHandler: func(repo eh.ReadWriteRepo) eh.EventHandler {
+			h := projector.NewEventHandler(invoicing.NewInvoiceProjector(), repo)
+			h.SetEntityFactory(func() eh.Entity { return &invoicing.Invoice{} })
+			return h
+		},
+	},
+	"timelines": {
+		Collection: "timelines",
+		Events:     append(append(eh.MatchEvents{}, reservationEvents...), attendanceEvents...),
+		NewEntity:  func() eh.Entity { return &audit.Timeline{} },
+		NewHandler: func(repo eh.ReadWriteRepo) eh.EventHandler {
+			return audit.NewTimelineProjector(repo)
+		},
+	},
+}
+
+// Names returns the collections of the projections that can be rebuilt, sorted
+func Names() []string {
+	names := make([]string, 0, len(Projections))
+	for name := range Projections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
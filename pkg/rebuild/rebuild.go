@@ -0,0 +1,144 @@
+package rebuild
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// DefaultProgressInterval is how often progress is reported when Options.ProgressInterval is unset
+const DefaultProgressInterval = time.Second
+
+// Source streams every event in the event store in global order
+// Events of the same aggregate must be streamed in version order
+type Source interface {
+	// Count returns the number of events that will be streamed
+	Count(ctx context.Context) (int, error)
+	// Stream calls f with each event, stopping at the first error
+	Stream(ctx context.Context, f func(eh.Event) error) error
+}
+
+// Events is a Source of events held in memory, streamed by timestamp
+type Events []eh.Event
+
+var _ = Source(Events{})
+
+func (s Events) Count(ctx context.Context) (int, error) {
+	return len(s), nil
+}
+
+func (s Events) Stream(ctx context.Context, f func(eh.Event) error) error {
+	sorted := append(Events{}, s...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp().Before(sorted[j].Timestamp())
+	})
+	for _, event := range sorted {
+		if err := f(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Target is a projection being rebuilt into a shadow repo
+type Target struct {
+	Projection *Projection
+	Repo       eh.ReadWriteRepo
+	handler    eh.EventHandler
+}
+
+// NewTarget returns a Target rebuilding the projection into repo, which should be empty
+func NewTarget(projection *Projection, repo eh.ReadWriteRepo) *Target {
+	return &Target{
+		Projection: projection,
+		Repo:       repo,
+		handler:    projection.NewHandler(repo),
+	}
+}
+
+// Progress is how far a rebuild has got
+type Progress struct {
+	Processed int
+	Total     int
+	Elapsed   time.Duration
+}
+
+// Rate returns the events processed per second
+func (p Progress) Rate() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Processed) / p.Elapsed.Seconds()
+}
+
+func (p Progress) String() string {
+	percent := 100.0
+	if p.Total > 0 {
+		percent = 100 * float64(p.Processed) / float64(p.Total)
+	}
+	return fmt.Sprintf("%d/%d events (%.1f%%) in %v, %.0f events/s", p.Processed, p.Total, percent, p.Elapsed.Round(time.Millisecond), p.Rate())
+}
+
+// Options of a rebuild
+type Options struct {
+	// Rate limits the events processed per second, 0 is unlimited
+	Rate int
+	// Progress is called every ProgressInterval, and once the rebuild is done
+	Progress         func(Progress)
+	ProgressInterval time.Duration
+}
+
+// Run streams every event from the source through the projectors of the targets
+func Run(ctx context.Context, source Source, targets []*Target, opts Options) (Progress, error) {
+	total, err := source.Count(ctx)
+	if err != nil {
+		return Progress{}, fmt.Errorf("could not count events: %w", err)
+	}
+	if opts.ProgressInterval == 0 {
+		opts.ProgressInterval = DefaultProgressInterval
+	}
+
+	started := time.Now()
+	lastReport := started
+	progress := Progress{Total: total}
+	err = source.Stream(ctx, func(event eh.Event) error {
+		for _, t := range targets {
+			if !t.Projection.Events.Match(event) {
+				continue
+			}
+			if err := t.handler.HandleEvent(ctx, event); err != nil {
+				return fmt.Errorf("%s: could not project %s: %w", t.Projection.Collection, event, err)
+			}
+		}
+		progress.Processed++
+		progress.Elapsed = time.Since(started)
+
+		if opts.Progress != nil && time.Since(lastReport) >= opts.ProgressInterval {
+			lastReport = time.Now()
+			opts.Progress(progress)
+		}
+		// Wait until the rate is back under the limit
+		if opts.Rate > 0 {
+			wait := time.Duration(progress.Processed)*time.Second/time.Duration(opts.Rate) - time.Since(started)
+			if wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+		return nil
+	})
+	progress.Elapsed = time.Since(started)
+	if err != nil {
+		return progress, err
+	}
+	if opts.Progress != nil {
+		opts.Progress(progress)
+	}
+	return progress, nil
+}
@@ -0,0 +1,148 @@
+package rebuild
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/audit"
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	memoryRepo "github.com/looplab/eventhorizon/repo/memory"
+)
+
+var start = time.Date(2021, time.July, 1, 9, 0, 0, 0, time.UTC)
+
+func newTestRepo(p *Projection) *memoryRepo.Repo {
+	repo := memoryRepo.NewRepo()
+	repo.SetEntityFactory(p.NewEntity)
+	return repo
+}
+
+func testEvents(ids ...uuid.UUID) Events {
+	var events Events
+	for i, id := range ids {
+		created := start.Add(time.Duration(i) * time.Minute)
+		events = append(events,
+			eh.NewEvent(reservations.ReservationCreatedEvent, &reservations.ReservationCreatedData{
+				RoomID: i + 1, Name: "Meeting", User: "Matt", StartTime: start, EndTime: start.Add(time.Hour),
+			}, created, eh.ForAggregate(reservations.ReservationAggregateType, id, 1)),
+			eh.NewEvent(reservations.ReservationConfirmedEvent, &reservations.ReservationConfirmedData{User: "saga"},
+				created.Add(time.Hour), eh.ForAggregate(reservations.ReservationAggregateType, id, 2)),
+		)
+	}
+	return events
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	ids := []uuid.UUID{uuid.New(), uuid.New()}
+	reservationsShadow := newTestRepo(Projections["reservations"])
+	timelinesShadow := newTestRepo(Projections["timelines"])
+	targets := []*Target{
+		NewTarget(Projections["reservations"], reservationsShadow),
+		NewTarget(Projections["timelines"], timelinesShadow),
+	}
+
+	var reports []Progress
+	progress, err := Run(ctx, testEvents(ids...), targets, Options{
+		Rate:     200,
+		Progress: func(p Progress) { reports = append(reports, p) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.Processed != 4 || progress.Total != 4 {
+		t.Errorf("Run() = %v, want 4/4 events", progress)
+	}
+	// The last event can only be processed 15ms after the first at 200 events/s
+	if progress.Elapsed < 15*time.Millisecond {
+		t.Errorf("Run() took %v, faster than the rate limit", progress.Elapsed)
+	}
+	if len(reports) == 0 || reports[len(reports)-1].Processed != 4 {
+		t.Errorf("Progress reports = %v, want a final report", reports)
+	}
+
+	for _, id := range ids {
+		e, err := reservationsShadow.Find(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r := e.(*reservations.Reservation); r.Version != 2 || r.Status != reservations.StatusConfirmed {
+			t.Errorf("rebuilt reservation = %+v", r)
+		}
+		e, err = timelinesShadow.Find(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if timeline := e.(*audit.Timeline); len(timeline.Entries) != 2 {
+			t.Errorf("rebuilt timeline = %+v", timeline)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	ctx := context.Background()
+	projection := Projections["reservations"]
+	kept, buggy, missing, stale := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+
+	shadow := newTestRepo(projection)
+	if _, err := Run(ctx, testEvents(kept, buggy, missing), []*Target{NewTarget(projection, shadow)}, Options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The live collection has a bad projection of one reservation, misses one and has one without events
+	live := newTestRepo(projection)
+	for _, id := range []uuid.UUID{kept, buggy} {
+		e, _ := shadow.Find(ctx, id)
+		r := *e.(*reservations.Reservation)
+		if id == buggy {
+			r.Status = reservations.StatusPending
+		}
+		if err := live.Save(ctx, &r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := live.Save(ctx, &reservations.Reservation{ID: stale, Version: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := Compare(ctx, projection, live, shadow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Added) != 1 || d.Added[0] != missing.String() ||
+		len(d.Removed) != 1 || d.Removed[0] != stale.String() ||
+		len(d.Changed) != 1 || d.Changed[0] != buggy.String() ||
+		d.Unchanged != 1 {
+		t.Errorf("Compare() = %+v", d)
+	}
+
+	if d, _ := Compare(ctx, projection, shadow, shadow); !d.Empty() {
+		t.Errorf("Compare() of the same collection = %+v, want empty", d)
+	}
+}
+
+func TestCompare_Billing(t *testing.T) {
+	ctx := context.Background()
+	projection := Projections["billing"]
+	events := testEvents(uuid.New())
+
+	// Rebuilds give the same histories
+	var repos []eh.ReadWriteRepo
+	for i := 0; i < 2; i++ {
+		repo := newTestRepo(projection)
+		if _, err := Run(ctx, events, []*Target{NewTarget(projection, repo)}, Options{}); err != nil {
+			t.Fatal(err)
+		}
+		repos = append(repos, repo)
+	}
+	d, err := Compare(ctx, projection, repos[0], repos[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.Empty() || d.Unchanged != 1 {
+		t.Errorf("Compare() = %+v, want one unchanged history", d)
+	}
+}
@@ -20,6 +20,9 @@ func init() {
 
 const ReservationAggregateType eh.AggregateType = "Reservation"
 
+// MaxDuration is the longest a reservation can be booked for
+const MaxDuration = 7 * 24 * time.Hour
+
 var _ = eh.Aggregate(&ReservationAggregate{})
 
 // ReservationAggregate is the write-model, and is used with event sourcing
@@ -31,6 +34,7 @@ type ReservationAggregate struct {
 	startTime time.Time
 	endTime   time.Time
 	user      string
+	checkedIn time.Time
 
 	created bool
 
@@ -49,6 +53,9 @@ func NewReservationAggregate(id uuid.UUID) *ReservationAggregate {
 				{Name: "declined", Src: []string{"pending"}, Dst: "declined"},
 				{Name: "cancelled", Src: []string{"pending", "confirmed"}, Dst: "cancelled"},
 				{Name: "changed", Src: []string{"pending", "declined", "cancelled", "confirmed"}, Dst: "pending"},
+				{Name: "checked in", Src: []string{"confirmed"}, Dst: "checked in"},
+				{Name: "checked out", Src: []string{"checked in"}, Dst: "checked out"},
+				{Name: "no show", Src: []string{"confirmed"}, Dst: "no show"},
 			},
 			fsm.Callbacks{},
 		),
@@ -57,56 +64,116 @@ func NewReservationAggregate(id uuid.UUID) *ReservationAggregate {
 
 // HandleCommand is called whenever the commandBus recieves a command for which this aggregate is registered
 func (r *ReservationAggregate) HandleCommand(ctx context.Context, cmd eh.Command) error {
+	// Every command other than CreateReservation needs an existing reservation
+	if _, ok := cmd.(*CreateReservation); !ok && !r.created {
+		return ErrReservationNotFound
+	}
+
 	switch cmd := cmd.(type) {
 	case *CreateReservation:
-		if !r.created {
-			r.AppendEvent(ReservationCreatedEvent, &ReservationCreatedData{
-				RoomID:    cmd.RoomID,
-				Name:      cmd.Name,
-				User:      cmd.User,
-				StartTime: cmd.StartTime,
-				EndTime:   cmd.EndTime,
-			}, time.Now())
-		} else {
+		if r.created {
 			// TODO if table already reserved, raise a conflict event
-			// TODO error
+			return ErrReservationExists
 		}
+		if err := checkTimeRange(cmd.StartTime, cmd.EndTime); err != nil {
+			return err
+		}
+		r.AppendEvent(ReservationCreatedEvent, &ReservationCreatedData{
+			RoomID:        cmd.RoomID,
+			Name:          cmd.Name,
+			User:          cmd.User,
+			StartTime:     cmd.StartTime,
+			EndTime:       cmd.EndTime,
+			PromotionCode: cmd.PromotionCode,
+		}, time.Now())
 	case *ConfirmReservation:
-		if r.state.Is("pending") {
-			r.AppendEvent(ReservationConfirmedEvent, &ReservationConfirmedData{
-				User: cmd.User,
-			}, time.Now())
-		} else {
-			// TODO error
+		if !r.state.Is("pending") {
+			return ErrReservationNotPending
 		}
+		r.AppendEvent(ReservationConfirmedEvent, &ReservationConfirmedData{
+			User: cmd.User,
+		}, time.Now())
 	case *DeclineReservation:
-		if r.state.Is("pending") {
-			r.AppendEvent(ReservationDeclinedEvent, &ReservationDeclinedData{
-				User:    cmd.User,
-				Message: cmd.Message,
-			}, time.Now())
-		} else {
-			// TODO error
+		if !r.state.Is("pending") {
+			return ErrReservationNotPending
 		}
+		r.AppendEvent(ReservationDeclinedEvent, &ReservationDeclinedData{
+			User:    cmd.User,
+			Message: cmd.Message,
+		}, time.Now())
 	case *CancelReservation:
-		if r.state.Is("pending") || r.state.Is("confirmed") {
-			r.AppendEvent(ReservationCancelledEvent, &ReservationCancelledData{
-				User: cmd.User,
-			}, time.Now())
-		} else {
-			// TODO error
+		if r.state.Is("no show") {
+			return ErrNoShow
+		}
+		if !r.state.Is("pending") && !r.state.Is("confirmed") {
+			return ErrReservationNotActive
 		}
+		r.AppendEvent(ReservationCancelledEvent, &ReservationCancelledData{
+			User: cmd.User,
+		}, time.Now())
 	case *ChangeReservationTime:
-		if cmd.EndTime.After(cmd.StartTime) {
-			r.AppendEvent(ReservationTimeChangedEvent, &ReservationTimeChangeData{
-				User:      cmd.User,
-				StartTime: cmd.StartTime,
-				EndTime:   cmd.EndTime,
-			}, time.Now())
-		} else {
-			// TODO error
+		if r.state.Is("checked in") || r.state.Is("checked out") {
+			return ErrCheckedIn
+		}
+		if r.state.Is("no show") {
+			return ErrNoShow
+		}
+		if err := checkTimeRange(cmd.StartTime, cmd.EndTime); err != nil {
+			return err
+		}
+		r.AppendEvent(ReservationTimeChangedEvent, &ReservationTimeChangeData{
+			User:      cmd.User,
+			StartTime: cmd.StartTime,
+			EndTime:   cmd.EndTime,
+		}, time.Now())
+	case *CheckIn:
+		if !r.state.Is("confirmed") {
+			return ErrReservationNotConfirmed
+		}
+		at := cmd.At
+		if at.IsZero() {
+			at = time.Now()
+		}
+		r.AppendEvent(ReservationCheckedInEvent, &ReservationCheckedInData{
+			User: cmd.User,
+			At:   at,
+		}, time.Now())
+	case *CheckOut:
+		if !r.state.Is("checked in") {
+			return ErrNotCheckedIn
 		}
+		at := cmd.At
+		if at.IsZero() {
+			at = time.Now()
+		}
+		if !at.After(r.checkedIn) {
+			return ErrInvalidTimeRange
+		}
+		r.AppendEvent(ReservationCheckedOutEvent, &ReservationCheckedOutData{
+			User: cmd.User,
+			At:   at,
+		}, time.Now())
+	case *MarkNoShow:
+		if !r.state.Is("confirmed") {
+			return ErrReservationNotConfirmed
+		}
+		if time.Now().Before(r.endTime) {
+			return ErrReservationNotEnded
+		}
+		r.AppendEvent(ReservationNoShowEvent, &ReservationNoShowData{
+			User: cmd.User,
+		}, time.Now())
+	}
+	return nil
+}
 
+// checkTimeRange checks a reservation ends after it starts, and is not longer than MaxDuration
+func checkTimeRange(start, end time.Time) error {
+	if !end.After(start) {
+		return ErrInvalidTimeRange
+	}
+	if end.Sub(start) > MaxDuration {
+		return ErrReservationTooLong
 	}
 	return nil
 }
@@ -135,6 +202,15 @@ func (r *ReservationAggregate) ApplyEvent(ctx context.Context, event eh.Event) e
 		}
 	case ReservationCancelledEvent:
 		r.state.Event("cancelled")
+	case ReservationCheckedInEvent:
+		r.state.Event("checked in")
+		if data, ok := event.Data().(*ReservationCheckedInData); ok {
+			r.checkedIn = data.At
+		}
+	case ReservationCheckedOutEvent:
+		r.state.Event("checked out")
+	case ReservationNoShowEvent:
+		r.state.Event("no show")
 	case ReservationBookingConflictedEvent:
 		r.err = errors.New("Room already booked")
 	}
@@ -0,0 +1,46 @@
+package reservations
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+func TestReservationAggregate_TimeRange(t *testing.T) {
+	ctx := context.Background()
+	start := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name    string
+		end     time.Time
+		wantErr error
+	}{
+		{"backwards", start.Add(-time.Hour), ErrInvalidTimeRange},
+		{"empty", start, ErrInvalidTimeRange},
+		{"longest", start.Add(MaxDuration), nil},
+		{"too long", start.Add(MaxDuration + time.Minute), ErrReservationTooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReservationAggregate(uuid.New())
+			err := r.HandleCommand(ctx, &CreateReservation{Name: "Offsite", User: "Matt", RoomID: 1, StartTime: start, EndTime: tt.end})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CreateReservation error = %v, want %v", err, tt.wantErr)
+			}
+
+			// Moving a reservation is held to the same range
+			r = NewReservationAggregate(uuid.New())
+			r.ApplyEvent(ctx, eh.NewEvent(ReservationCreatedEvent, &ReservationCreatedData{
+				Name: "Offsite", User: "Matt", RoomID: 1, StartTime: start, EndTime: start.Add(time.Hour),
+			}, time.Now()))
+			err = r.HandleCommand(ctx, &ChangeReservationTime{User: "Matt", StartTime: start, EndTime: tt.end})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ChangeReservationTime error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package reservations
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/signing"
+)
+
+// ErrUnauthenticated is when a command sent over HTTP or gRPC is not signed, or not signed by a trusted key
+var ErrUnauthenticated = errors.New("command is not signed by a trusted key")
+
+// BearerToken signs the payload sent for a command type, and returns it as the value of an Authorization header
+func BearerToken(signer *signing.Signer, subject, commandType string, data []byte) (string, error) {
+	token, err := signer.Sign(signing.Claims{
+		Subject:     subject,
+		IssuedAt:    time.Now().Unix(),
+		CommandType: commandType,
+		Digest:      signing.Digest(data),
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not sign command: %w", err)
+	}
+	return "Bearer " + token, nil
+}
+
+// VerifyBearer checks the Authorization header value was signed by one of the keys for exactly this command type and payload
+func VerifyBearer(keys *signing.KeySet, authorization, commandType string, data []byte) error {
+	token := strings.TrimPrefix(authorization, "Bearer ")
+	if token == "" {
+		return fmt.Errorf("%v: %w", ErrUnsignedCommand, ErrUnauthenticated)
+	}
+	if _, err := keys.VerifyPayload(token, commandType, data); err != nil {
+		return fmt.Errorf("%v: %w", err, ErrUnauthenticated)
+	}
+	return nil
+}
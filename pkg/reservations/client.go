@@ -4,9 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/pubsub"
+	"github.com/MattDevy/CQRS-example/pkg/signing"
 	"github.com/google/uuid"
 	eh "github.com/looplab/eventhorizon"
 	"google.golang.org/api/option"
@@ -17,12 +19,37 @@ const (
 	RoomCommandsTopic = "rooms.commands"
 	// CommandTypeAttributeKey is the Attribute Key that the CommandTypes are sent using
 	CommandTypeAttributeKey = "CommandType"
+	// SignatureAttributeKey is the Attribute Key that the signed command envelope (JWT) is sent using
+	SignatureAttributeKey = "Signature"
+	// DefaultReplyTimeout is how long SendCommandAndWait waits for a result when ctx has no deadline
+	DefaultReplyTimeout = 30 * time.Second
 )
 
+// CommandSender sends commands to the command handler server
+type CommandSender interface {
+	// SendCommand sends the command, without waiting for it to be handled
+	SendCommand(ctx context.Context, command eh.Command) error
+	// SendCommandAndWait sends the command and returns its result once handled
+	SendCommandAndWait(ctx context.Context, command eh.Command) (*CommandResult, error)
+}
+
+var _ = CommandSender(&Client{})
+
 // Client is a pubsub client that will send Commands to the command handler server
 type Client struct {
 	p     *pubsub.Client
 	topic *pubsub.Topic
+
+	signer  *signing.Signer
+	subject string
+
+	replyTimeout time.Duration
+	replyTopic   *pubsub.Topic
+	replySub     *pubsub.Subscription
+	replyCancel  context.CancelFunc
+	replyMu      sync.Mutex
+	waiting      map[string]chan *CommandResult
+	waitingMu    sync.Mutex
 }
 
 // NewClient returns an initialized Client, this will also create any topics needed
@@ -41,15 +68,137 @@ func NewClient(project string, opts ...option.ClientOption) (*Client, error) {
 			return nil, err
 		}
 	}
+	// Commands for the same aggregate are published, and recieved, in the order they were sent
+	topic.EnableMessageOrdering = true
 
-	c := &Client{p: client, topic: topic}
+	c := &Client{
+		p:            client,
+		topic:        topic,
+		replyTimeout: DefaultReplyTimeout,
+		waiting:      make(map[string]chan *CommandResult),
+	}
 
 	return c, nil
 }
 
+// SetSigner makes the Client sign every command it sends, subject is the identity the commands are sent on behalf of
+func (c *Client) SetSigner(subject string, signer *signing.Signer) {
+	c.subject = subject
+	c.signer = signer
+}
+
+// SetReplyTimeout sets how long SendCommandAndWait waits for a result when ctx has no deadline
+func (c *Client) SetReplyTimeout(timeout time.Duration) {
+	c.replyTimeout = timeout
+}
+
 // SendCommand will send any eh.Command to the command handler server
 // Blocks until sent
 func (c *Client) SendCommand(ctx context.Context, command eh.Command) error {
+	return c.publish(ctx, command, map[string]string{})
+}
+
+// SendCommandAndWait will send any eh.Command to the command handler server
+// Blocks until the server replies with the result, the error is a *CommandError if the command was rejected
+func (c *Client) SendCommandAndWait(ctx context.Context, command eh.Command) (*CommandResult, error) {
+	if err := c.listenForReplies(); err != nil {
+		return nil, err
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.replyTimeout)
+		defer cancel()
+	}
+
+	correlationID := uuid.NewString()
+	resultChan := make(chan *CommandResult, 1)
+	c.waitingMu.Lock()
+	c.waiting[correlationID] = resultChan
+	c.waitingMu.Unlock()
+	defer func() {
+		c.waitingMu.Lock()
+		delete(c.waiting, correlationID)
+		c.waitingMu.Unlock()
+	}()
+
+	if err := c.publish(ctx, command, map[string]string{
+		CorrelationIDAttributeKey: correlationID,
+		ReplyToAttributeKey:       c.replyTopic.ID(),
+	}); err != nil {
+		return nil, err
+	}
+
+	select {
+	case result := <-resultChan:
+		return result, result.Err()
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for command result: %w", ctx.Err())
+	}
+}
+
+// listenForReplies creates the Client's reply topic and starts recieving results, on first use only
+func (c *Client) listenForReplies() error {
+	c.replyMu.Lock()
+	defer c.replyMu.Unlock()
+	if c.replySub != nil {
+		return nil
+	}
+
+	name := RoomRepliesTopicPrefix + uuid.NewString()
+	topic, err := c.p.CreateTopic(context.Background(), name)
+	if err != nil {
+		return err
+	}
+	sub, err := c.p.CreateSubscription(context.Background(), name, pubsub.SubscriptionConfig{Topic: topic})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.replyTopic, c.replySub, c.replyCancel = topic, sub, cancel
+	go func() {
+		err := sub.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
+			msg.Ack()
+			result := &CommandResult{}
+			if err := json.Unmarshal(msg.Data, result); err != nil {
+				fmt.Printf("Bad command result: %v\n", err)
+				return
+			}
+			c.waitingMu.Lock()
+			resultChan, ok := c.waiting[result.CorrelationID]
+			c.waitingMu.Unlock()
+			if ok {
+				resultChan <- result
+			}
+		})
+		if err != nil {
+			fmt.Printf("Stopped recieving command results: %v\n", err)
+		}
+	}()
+	return nil
+}
+
+// Close stops recieving command results, deletes the reply topic and closes the pubsub client
+func (c *Client) Close() error {
+	c.replyMu.Lock()
+	defer c.replyMu.Unlock()
+	if c.replySub != nil {
+		c.replyCancel()
+		if err := c.replySub.Delete(context.Background()); err != nil {
+			return err
+		}
+		if err := c.replyTopic.Delete(context.Background()); err != nil {
+			return err
+		}
+		c.replySub = nil
+	}
+	c.topic.Stop()
+	return c.p.Close()
+}
+
+// publish sends the command with any extra attributes set
+func (c *Client) publish(ctx context.Context, command eh.Command, attributes map[string]string) error {
 	data, err := json.Marshal(command)
 	if err != nil {
 		return err
@@ -57,16 +206,34 @@ func (c *Client) SendCommand(ctx context.Context, command eh.Command) error {
 
 	fmt.Printf("Sending command: type: %v, content: %v\n", command.CommandType(), string(data))
 
+	attributes[CommandTypeAttributeKey] = string(command.CommandType())
+	if c.signer != nil {
+		token, err := c.signer.Sign(signing.Claims{
+			Subject:       c.subject,
+			IssuedAt:      time.Now().Unix(),
+			CommandType:   string(command.CommandType()),
+			Digest:        signing.Digest(data),
+			ReplyTo:       attributes[ReplyToAttributeKey],
+			CorrelationID: attributes[CorrelationIDAttributeKey],
+		})
+		if err != nil {
+			return fmt.Errorf("could not sign command: %w", err)
+		}
+		attributes[SignatureAttributeKey] = token
+	}
+
+	orderingKey := command.AggregateID().String()
 	res := c.topic.Publish(ctx, &pubsub.Message{
-		ID:   uuid.NewString(),
-		Data: data,
-		Attributes: map[string]string{
-			CommandTypeAttributeKey: string(command.CommandType()),
-		},
+		ID:          uuid.NewString(),
+		Data:        data,
+		Attributes:  attributes,
 		PublishTime: time.Now(),
+		OrderingKey: orderingKey,
 	})
 	_, err = res.Get(ctx)
 	if err != nil {
+		// Publishing for the ordering key is paused after an error, until it is resumed
+		c.topic.ResumePublish(orderingKey)
 		return err
 	}
 	return nil
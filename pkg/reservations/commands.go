@@ -13,6 +13,9 @@ func init() {
 	eh.RegisterCommand(func() eh.Command { return &DeclineReservation{} })
 	eh.RegisterCommand(func() eh.Command { return &ChangeReservationTime{} })
 	eh.RegisterCommand(func() eh.Command { return &CancelReservation{} })
+	eh.RegisterCommand(func() eh.Command { return &CheckIn{} })
+	eh.RegisterCommand(func() eh.Command { return &CheckOut{} })
+	eh.RegisterCommand(func() eh.Command { return &MarkNoShow{} })
 }
 
 const (
@@ -21,10 +24,13 @@ const (
 	DeclineReservationCommand    eh.CommandType = "DeclineReservation"
 	ChangeReservationTimeCommand eh.CommandType = "ChangeReservationTime"
 	CancelReservationCommand     eh.CommandType = "CancelReservation"
+	CheckInCommand               eh.CommandType = "CheckIn"
+	CheckOutCommand              eh.CommandType = "CheckOut"
+	MarkNoShowCommand            eh.CommandType = "MarkNoShow"
 )
 
 // CreateReservation is the command to create a reservation
-// It contains all the information needed to create a reservation, no field can be empty
+// It contains all the information needed to create a reservation, no field but PromotionCode can be empty
 type CreateReservation struct {
 	ID        uuid.UUID
 	Name      string
@@ -32,6 +38,8 @@ type CreateReservation struct {
 	RoomID    int
 	StartTime time.Time
 	EndTime   time.Time
+	// PromotionCode is a discount code to apply to the booking, if any
+	PromotionCode string `eh:"optional"`
 }
 
 func (c CreateReservation) AggregateID() uuid.UUID          { return c.ID }
@@ -84,3 +92,37 @@ type CancelReservation struct {
 func (c CancelReservation) AggregateID() uuid.UUID          { return c.ID }
 func (c CancelReservation) AggregateType() eh.AggregateType { return ReservationAggregateType }
 func (c CancelReservation) CommandType() eh.CommandType     { return CancelReservationCommand }
+
+// CheckIn is the command to record that a confirmed reservation's room was entered
+type CheckIn struct {
+	ID   uuid.UUID
+	User string
+	// At is when the room was entered, now if it is zero
+	At time.Time `eh:"optional"`
+}
+
+func (c CheckIn) AggregateID() uuid.UUID          { return c.ID }
+func (c CheckIn) AggregateType() eh.AggregateType { return ReservationAggregateType }
+func (c CheckIn) CommandType() eh.CommandType     { return CheckInCommand }
+
+// CheckOut is the command to record that a checked in reservation's room was left
+type CheckOut struct {
+	ID   uuid.UUID
+	User string
+	// At is when the room was left, now if it is zero
+	At time.Time `eh:"optional"`
+}
+
+func (c CheckOut) AggregateID() uuid.UUID          { return c.ID }
+func (c CheckOut) AggregateType() eh.AggregateType { return ReservationAggregateType }
+func (c CheckOut) CommandType() eh.CommandType     { return CheckOutCommand }
+
+// MarkNoShow is the command to record that nobody checked in to a confirmed reservation that has ended
+type MarkNoShow struct {
+	ID   uuid.UUID
+	User string
+}
+
+func (c MarkNoShow) AggregateID() uuid.UUID          { return c.ID }
+func (c MarkNoShow) AggregateType() eh.AggregateType { return ReservationAggregateType }
+func (c MarkNoShow) CommandType() eh.CommandType     { return MarkNoShowCommand }
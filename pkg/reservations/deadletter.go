@@ -0,0 +1,240 @@
+package reservations
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	eh "github.com/looplab/eventhorizon"
+)
+
+const (
+	// RoomCommandsDeadLetterTopic is the PubSub topic (and subscription) poison commands are moved to
+	RoomCommandsDeadLetterTopic = "rooms.commands.deadletter"
+	// DeadLetterReasonAttributeKey is the Attribute Key holding why a command was dead-lettered
+	DeadLetterReasonAttributeKey = "DeadLetterReason"
+	// DeadLetterAttemptsAttributeKey is the Attribute Key holding how many times a command was attempted
+	DeadLetterAttemptsAttributeKey = "DeadLetterAttempts"
+	// DeadLetterMessageIDAttributeKey is the Attribute Key holding the ID of the original message
+	DeadLetterMessageIDAttributeKey = "DeadLetterMessageID"
+	// DeadLetterVerifiedAttributeKey is the Attribute Key set to "true" when the command's signature was verified
+	// and it was decoded before it failed
+	DeadLetterVerifiedAttributeKey = "DeadLetterVerified"
+)
+
+// ErrNotReplayable is returned when replaying a command that was rejected before its signature was verified,
+// replaying it would sign it again
+var ErrNotReplayable = errors.New("command was not verified, it can't be replayed")
+
+// DeadLetter is a command that could not be handled
+type DeadLetter struct {
+	MessageID   string
+	CommandType string
+	Reason      string
+	Attempts    int
+	Data        []byte
+	// Verified is whether the command's signature was verified and it was decoded, only verified commands are replayed
+	Verified bool
+	// Attributes are the attributes of the original message
+	Attributes map[string]string
+}
+
+// DeadLetterQueue stores poison commands so they can be inspected and replayed
+type DeadLetterQueue struct {
+	topic *pubsub.Topic
+	sub   *pubsub.Subscription
+}
+
+// NewDeadLetterQueue returns an initialized DeadLetterQueue, this will also create the topic and subscription needed
+func NewDeadLetterQueue(ctx context.Context, client *pubsub.Client) (*DeadLetterQueue, error) {
+	topic := client.Topic(RoomCommandsDeadLetterTopic)
+	if exists, err := topic.Exists(ctx); err != nil {
+		return nil, err
+	} else if !exists {
+		if topic, err = client.CreateTopic(ctx, RoomCommandsDeadLetterTopic); err != nil {
+			return nil, err
+		}
+	}
+
+	sub := client.Subscription(RoomCommandsDeadLetterTopic)
+	if exists, err := sub.Exists(ctx); err != nil {
+		return nil, err
+	} else if !exists {
+		if sub, err = client.CreateSubscription(ctx, RoomCommandsDeadLetterTopic, pubsub.SubscriptionConfig{
+			Topic: topic,
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	// Messages are held until a drain ends, so there can be as many outstanding as there are dead letters
+	// Pulling synchronously means the held messages' nacks are still sent once the drain stops
+	sub.ReceiveSettings.Synchronous = true
+	sub.ReceiveSettings.MaxOutstandingMessages = -1
+	sub.ReceiveSettings.MaxOutstandingBytes = -1
+	return &DeadLetterQueue{topic: topic, sub: sub}, nil
+}
+
+// Publish moves the message onto the dead-letter topic along with the reason it failed
+// verified is whether the command's signature was verified and it was decoded
+func (q *DeadLetterQueue) Publish(ctx context.Context, msg *pubsub.Message, reason error, attempts int, verified bool) error {
+	attributes := make(map[string]string, len(msg.Attributes)+4)
+	for k, v := range msg.Attributes {
+		attributes[k] = v
+	}
+	attributes[DeadLetterReasonAttributeKey] = reason.Error()
+	attributes[DeadLetterAttemptsAttributeKey] = strconv.Itoa(attempts)
+	attributes[DeadLetterMessageIDAttributeKey] = msg.ID
+	attributes[DeadLetterVerifiedAttributeKey] = strconv.FormatBool(verified)
+
+	res := q.topic.Publish(ctx, &pubsub.Message{
+		Data:       msg.Data,
+		Attributes: attributes,
+	})
+	_, err := res.Get(ctx)
+	return err
+}
+
+// Inspect returns the dead-lettered commands without removing them
+// It stops once every message has been seen or wait passes without a new one
+func (q *DeadLetterQueue) Inspect(ctx context.Context, wait time.Duration) ([]*DeadLetter, error) {
+	return q.receive(ctx, wait, func(*DeadLetter) bool { return false })
+}
+
+// Replay re-sends the dead-lettered commands matching filter through the client and removes them
+// The commands are signed again by the client, as the original signatures may have expired, so commands that were
+// never verified are left on the queue with ErrNotReplayable
+func (q *DeadLetterQueue) Replay(ctx context.Context, client *Client, wait time.Duration, filter func(*DeadLetter) bool) ([]*DeadLetter, error) {
+	var (
+		replayed   []*DeadLetter
+		replayErr  error
+		replayedMu sync.Mutex
+	)
+	_, err := q.receive(ctx, wait, func(d *DeadLetter) bool {
+		if !filter(d) {
+			return false
+		}
+		err := ErrNotReplayable
+		if d.Verified {
+			err = d.send(ctx, client)
+		}
+
+		replayedMu.Lock()
+		defer replayedMu.Unlock()
+		if err != nil {
+			replayErr = fmt.Errorf("could not replay %v: %w", d.MessageID, err)
+			return false
+		}
+		replayed = append(replayed, d)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return replayed, replayErr
+}
+
+// receive pulls every dead-lettered message once, removing it when remove returns true
+// The messages it keeps are held until wait passes without a new message, so they are not redelivered during
+// the drain, and nacked once the subscriber has stopped pulling. It stops early if ctx is done.
+func (q *DeadLetterQueue) receive(ctx context.Context, wait time.Duration, remove func(*DeadLetter) bool) ([]*DeadLetter, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		letters   []*DeadLetter
+		seen      = make(map[string]bool)
+		lettersMu sync.Mutex
+		received  = make(chan struct{}, 1)
+		drained   = make(chan struct{})
+	)
+	go func() {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		for {
+			select {
+			case <-received:
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(wait)
+			case <-timer.C:
+				close(drained)
+				cancel()
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	err := q.sub.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
+		lettersMu.Lock()
+		if seen[msg.ID] {
+			// Only redelivered if its lease was lost
+			lettersMu.Unlock()
+			msg.Nack()
+			return
+		}
+		seen[msg.ID] = true
+		d := newDeadLetter(msg)
+		letters = append(letters, d)
+		lettersMu.Unlock()
+
+		select {
+		case received <- struct{}{}:
+		default:
+		}
+		if remove(d) {
+			msg.Ack()
+			return
+		}
+		// The nack is still sent once the subscriber stops, but it can no longer be redelivered to it
+		<-ctx.Done()
+		msg.Nack()
+	})
+	select {
+	case <-drained:
+		// Messages pulled as the subscriber stops fail with "draining", they are redelivered later
+		return letters, nil
+	default:
+	}
+	return letters, err
+}
+
+func newDeadLetter(msg *pubsub.Message) *DeadLetter {
+	d := &DeadLetter{
+		MessageID:   msg.Attributes[DeadLetterMessageIDAttributeKey],
+		CommandType: msg.Attributes[CommandTypeAttributeKey],
+		Reason:      msg.Attributes[DeadLetterReasonAttributeKey],
+		Data:        msg.Data,
+		Attributes:  make(map[string]string),
+	}
+	d.Attempts, _ = strconv.Atoi(msg.Attributes[DeadLetterAttemptsAttributeKey])
+	d.Verified, _ = strconv.ParseBool(msg.Attributes[DeadLetterVerifiedAttributeKey])
+	for k, v := range msg.Attributes {
+		switch k {
+		case DeadLetterReasonAttributeKey, DeadLetterAttemptsAttributeKey, DeadLetterMessageIDAttributeKey, DeadLetterVerifiedAttributeKey:
+		default:
+			d.Attributes[k] = v
+		}
+	}
+	return d
+}
+
+// send decodes the dead-lettered command and sends it through the client
+func (d *DeadLetter) send(ctx context.Context, client *Client) error {
+	cmd, err := eh.CreateCommand(eh.CommandType(d.CommandType))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(d.Data, cmd); err != nil {
+		return err
+	}
+	return client.SendCommand(ctx, cmd)
+}
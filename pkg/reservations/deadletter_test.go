@@ -0,0 +1,126 @@
+package reservations
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+func TestReceiver_DeadLetters(t *testing.T) {
+	srv, opts := newTestPubSub(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	client, err := NewClient("test", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	sub, err := client.p.CreateSubscription(ctx, "receiver", pubsub.SubscriptionConfig{Topic: client.topic, EnableMessageOrdering: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadLetters, err := NewDeadLetterQueue(ctx, client.p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receiver := NewReceiver(sub, nil)
+	receiver.SetDeadLetterQueue(deadLetters, 3)
+	receiver.retryDelay = time.Millisecond
+	deliveries := make(chan *Delivery, 10)
+	go receiver.Receive(ctx, deliveries)
+
+	// Poison message
+	srv.Publish("projects/test/topics/"+RoomCommandsTopic, []byte("{bad json"), map[string]string{
+		CommandTypeAttributeKey: string(CancelReservationCommand),
+	})
+
+	// Domain errors are final, they are acked and not dead-lettered
+	if err := client.SendCommand(ctx, &ConfirmReservation{ID: uuid.New(), User: "Matt"}); err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	handler := func(err error) eh.CommandHandler {
+		return eh.CommandHandlerFunc(func(ctx context.Context, cmd eh.Command) error {
+			calls++
+			return err
+		})
+	}
+	d := <-deliveries
+	if redelivered, err := d.Handle(ctx, handler(ErrReservationNotFound)); redelivered || err != ErrReservationNotFound || calls != 1 {
+		t.Fatalf("Handle() of a domain error = %v, %v after %v calls, want it handled once", redelivered, err, calls)
+	}
+
+	// Transient errors are retried in place until they run out of attempts
+	transient := &CancelReservation{ID: uuid.New(), User: "Matt"}
+	if err := client.SendCommand(ctx, transient); err != nil {
+		t.Fatal(err)
+	}
+	calls = 0
+	select {
+	case d = <-deliveries:
+	case <-ctx.Done():
+		t.Fatal("command was not received")
+	}
+	if redelivered, err := d.Handle(ctx, handler(errors.New("database unavailable"))); redelivered || err == nil || calls != 3 {
+		t.Fatalf("Handle() of a transient error = %v, %v after %v calls, want 3 attempts then dead-lettered", redelivered, err, calls)
+	}
+
+	letters, err := deadLetters.Inspect(ctx, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reasons := map[string]DeadLetter{}
+	for _, l := range letters {
+		reasons[l.Reason] = *l
+	}
+	if len(letters) != 2 {
+		t.Fatalf("Inspect() got %v dead letters, want 2: %+v", len(letters), reasons)
+	}
+	if l, ok := reasons["database unavailable"]; !ok || l.Attempts != 3 {
+		t.Errorf("Inspect() transient dead letter = %+v, want 3 attempts", l)
+	}
+
+	if l := reasons["database unavailable"]; !l.Verified {
+		t.Errorf("Inspect() transient dead letter = %+v, want verified", l)
+	}
+
+	// The poison message was never verified, replaying it would sign it
+	replayed, err := deadLetters.Replay(ctx, client, time.Second, func(l *DeadLetter) bool {
+		return l.Reason != "database unavailable"
+	})
+	if !errors.Is(err, ErrNotReplayable) || len(replayed) != 0 {
+		t.Fatalf("Replay() of the poison message = %v, %v, want %v", len(replayed), err, ErrNotReplayable)
+	}
+
+	// Replay the transient failure, the poison message stays
+	replayed, err = deadLetters.Replay(ctx, client, time.Second, func(l *DeadLetter) bool {
+		return l.Reason == "database unavailable"
+	})
+	if err != nil || len(replayed) != 1 {
+		t.Fatalf("Replay() = %v, %v, want 1 replayed", len(replayed), err)
+	}
+	select {
+	case d := <-deliveries:
+		if c, ok := d.Command.(*CancelReservation); !ok || *c != *transient {
+			t.Fatalf("replayed command = %#v, want %#v", d.Command, transient)
+		}
+		d.Handle(ctx, handler(nil))
+	case <-ctx.Done():
+		t.Fatal("replayed command was not received")
+	}
+
+	letters, err = deadLetters.Inspect(ctx, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 1 || letters[0].CommandType != string(CancelReservationCommand) {
+		t.Errorf("Inspect() after replay = %+v, want only the poison message", letters)
+	}
+}
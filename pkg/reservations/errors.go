@@ -0,0 +1,112 @@
+package reservations
+
+import (
+	"errors"
+)
+
+// Domain errors returned when a command can not be applied to a reservation
+var (
+	ErrReservationNotFound     = errors.New("reservation not found")
+	ErrReservationExists       = errors.New("reservation already exists")
+	ErrReservationNotPending   = errors.New("reservation is not pending")
+	ErrReservationNotActive    = errors.New("reservation is not pending or confirmed")
+	ErrInvalidTimeRange        = errors.New("end time must be after start time")
+	ErrReservationTooLong      = errors.New("reservation is longer than the maximum duration")
+	ErrReservationNotConfirmed = errors.New("reservation is not confirmed")
+	ErrNotCheckedIn            = errors.New("reservation is not checked in")
+	ErrCheckedIn               = errors.New("reservation is already checked in")
+	ErrReservationNotEnded     = errors.New("reservation has not ended")
+	ErrNoShow                  = errors.New("reservation was a no-show")
+)
+
+// InternalErrorCode is the error code used for any error that is not a domain error
+const InternalErrorCode = "Internal"
+
+// errorCodes are the stable codes the domain errors are sent between services with
+var errorCodes = map[string]error{
+	"NotFound":         ErrReservationNotFound,
+	"AlreadyExists":    ErrReservationExists,
+	"NotPending":       ErrReservationNotPending,
+	"NotActive":        ErrReservationNotActive,
+	"InvalidTimeRange": ErrInvalidTimeRange,
+	"TooLong":          ErrReservationTooLong,
+	"NotConfirmed":     ErrReservationNotConfirmed,
+	"NotCheckedIn":     ErrNotCheckedIn,
+	"CheckedIn":        ErrCheckedIn,
+	"NotEnded":         ErrReservationNotEnded,
+	"NoShow":           ErrNoShow,
+	"Unauthenticated":  ErrUnauthenticated,
+}
+
+// ErrorKind is what kind of failure an error code is, each transport maps kinds onto its own status codes
+type ErrorKind int
+
+const (
+	// ErrorRejected is a command the aggregate rejected in its current state, the kind of any unlisted domain error
+	ErrorRejected ErrorKind = iota
+	// ErrorInvalid is a command that could never be handled as sent
+	ErrorInvalid
+	// ErrorNotFound is a command for an aggregate, or of a type, that does not exist
+	ErrorNotFound
+	// ErrorConflict is a command creating an aggregate that already exists
+	ErrorConflict
+	// ErrorUnauthenticated is a command that was not signed by a trusted key
+	ErrorUnauthenticated
+	// ErrorInternal is any error that is not a domain error
+	ErrorInternal
+)
+
+// errorKinds are the kinds of the error codes that are not ErrorRejected
+var errorKinds = map[string]ErrorKind{
+	"InvalidTimeRange": ErrorInvalid,
+	"TooLong":          ErrorInvalid,
+	"NotFound":         ErrorNotFound,
+	"AlreadyExists":    ErrorConflict,
+	"Unauthenticated":  ErrorUnauthenticated,
+	InternalErrorCode:  ErrorInternal,
+}
+
+// RegisterErrorCode registers a domain error from another package so it survives being sent between services
+// It is not safe for concurrent use and should be called from init
+func RegisterErrorCode(code string, err error) {
+	errorCodes[code] = err
+}
+
+// RegisterErrorKind sets the kind of an error code, codes are ErrorRejected unless registered
+// It is not safe for concurrent use and should be called from init
+func RegisterErrorKind(code string, kind ErrorKind) {
+	errorKinds[code] = kind
+}
+
+// ErrorKindOf returns the kind of the error code, ErrorRejected for "" and unknown codes
+func ErrorKindOf(code string) ErrorKind {
+	return errorKinds[code]
+}
+
+// ErrorCode returns the code of the domain error wrapped by err, InternalErrorCode if there is none and "" for nil
+func ErrorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	for code, domainErr := range errorCodes {
+		if errors.Is(err, domainErr) {
+			return code
+		}
+	}
+	return InternalErrorCode
+}
+
+// CommandError is an error returned by a remote command handler
+// errors.Is matches it against the domain error with the same code
+type CommandError struct {
+	Code    string
+	Message string
+}
+
+func (e *CommandError) Error() string {
+	return e.Message
+}
+
+func (e *CommandError) Unwrap() error {
+	return errorCodes[e.Code]
+}
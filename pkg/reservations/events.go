@@ -22,6 +22,15 @@ func init() {
 	eh.RegisterEventData(ReservationCancelledEvent, func() eh.EventData {
 		return &ReservationCancelledData{}
 	})
+	eh.RegisterEventData(ReservationCheckedInEvent, func() eh.EventData {
+		return &ReservationCheckedInData{}
+	})
+	eh.RegisterEventData(ReservationCheckedOutEvent, func() eh.EventData {
+		return &ReservationCheckedOutData{}
+	})
+	eh.RegisterEventData(ReservationNoShowEvent, func() eh.EventData {
+		return &ReservationNoShowData{}
+	})
 }
 
 const (
@@ -31,14 +40,18 @@ const (
 	ReservationTimeChangedEvent       eh.EventType = "ReservationTimeChanged"
 	ReservationCancelledEvent         eh.EventType = "ReservationCancelled"
 	ReservationBookingConflictedEvent eh.EventType = "ReservationBookingConflicted"
+	ReservationCheckedInEvent         eh.EventType = "ReservationCheckedIn"
+	ReservationCheckedOutEvent        eh.EventType = "ReservationCheckedOut"
+	ReservationNoShowEvent            eh.EventType = "ReservationNoShow"
 )
 
 type ReservationCreatedData struct {
-	RoomID    int
-	Name      string
-	User      string
-	StartTime time.Time
-	EndTime   time.Time
+	RoomID        int
+	Name          string
+	User          string
+	StartTime     time.Time
+	EndTime       time.Time
+	PromotionCode string
 }
 
 type ReservationConfirmedData struct {
@@ -59,3 +72,17 @@ type ReservationTimeChangeData struct {
 type ReservationCancelledData struct {
 	User string
 }
+
+type ReservationCheckedInData struct {
+	User string
+	At   time.Time
+}
+
+type ReservationCheckedOutData struct {
+	User string
+	At   time.Time
+}
+
+type ReservationNoShowData struct {
+	User string
+}
@@ -0,0 +1,72 @@
+package reservations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// NoShowUser is the user no-shows are marked by
+const NoShowUser = "no-show sweeper"
+
+// NoShows marks the confirmed reservations nobody checked in to as no-shows once they have ended
+type NoShows struct {
+	commandHandler eh.CommandHandler
+	repo           eh.ReadRepo
+}
+
+// NewNoShows returns NoShows finding the ended reservations in the reservation read model
+func NewNoShows(commandHandler eh.CommandHandler, repo eh.ReadRepo) *NoShows {
+	return &NoShows{
+		commandHandler: commandHandler,
+		repo:           repo,
+	}
+}
+
+// Mark marks the reservations that ended by now without a check-in, returning their IDs
+// Reservations checked in to since the read model was projected are skipped
+func (n *NoShows) Mark(ctx context.Context, now time.Time) ([]uuid.UUID, error) {
+	entities, err := n.repo.FindAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var marked []uuid.UUID
+	for _, e := range entities {
+		r, ok := e.(*Reservation)
+		if !ok || r.Status != StatusConfirmed || !r.CheckedIn.IsZero() || r.NoShow || r.EndTime.After(now) {
+			continue
+		}
+		err := n.commandHandler.HandleCommand(ctx, &MarkNoShow{ID: r.ID, User: NoShowUser})
+		if errors.Is(err, ErrReservationNotConfirmed) {
+			continue
+		} else if err != nil {
+			return marked, fmt.Errorf("could not mark %v: %w", r.ID, err)
+		}
+		marked = append(marked, r.ID)
+	}
+	return marked, nil
+}
+
+// Run marks no-shows every interval until ctx is done
+func (n *NoShows) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		marked, err := n.Mark(ctx, time.Now())
+		if err != nil {
+			fmt.Printf("Error: could not mark no-shows: %v\n", err)
+		}
+		for _, id := range marked {
+			fmt.Printf("Reservation %v: no-show\n", id)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
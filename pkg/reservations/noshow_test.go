@@ -0,0 +1,147 @@
+package reservations
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	memoryRepo "github.com/looplab/eventhorizon/repo/memory"
+)
+
+func TestReservationAggregate_Attendance(t *testing.T) {
+	ctx := context.Background()
+	start := time.Now().Add(-2 * time.Hour)
+	checkedIn := start.Add(10 * time.Minute)
+
+	tests := []struct {
+		name    string
+		cmd     eh.Command
+		wantErr error
+	}{
+		{"check in pending", &CheckIn{User: "Matt", At: checkedIn}, ErrReservationNotConfirmed},
+		{"confirm", &ConfirmReservation{User: "saga"}, nil},
+		{"check out while not checked in", &CheckOut{User: "Matt"}, ErrNotCheckedIn},
+		{"check in", &CheckIn{User: "Matt", At: checkedIn}, nil},
+		{"no show once checked in", &MarkNoShow{User: NoShowUser}, ErrReservationNotConfirmed},
+		{"move once checked in", &ChangeReservationTime{User: "Matt", StartTime: start, EndTime: start.Add(time.Hour)}, ErrCheckedIn},
+		{"cancel once checked in", &CancelReservation{User: "Matt"}, ErrReservationNotActive},
+		{"check out before the check-in time", &CheckOut{User: "Matt", At: checkedIn.Add(-time.Minute)}, ErrInvalidTimeRange},
+		{"check out", &CheckOut{User: "Matt", At: checkedIn.Add(40 * time.Minute)}, nil},
+		{"check in again", &CheckIn{User: "Matt"}, ErrReservationNotConfirmed},
+	}
+
+	// At is optional, it defaults to now
+	for _, cmd := range []eh.Command{&CheckIn{ID: uuid.New(), User: "Matt"}, &CheckOut{ID: uuid.New(), User: "Matt"}} {
+		if err := eh.CheckCommand(cmd); err != nil {
+			t.Errorf("CheckCommand(%v) error = %v", cmd.CommandType(), err)
+		}
+	}
+
+	r := NewReservationAggregate(uuid.New())
+	apply := func() {
+		for _, event := range r.UncommittedEvents() {
+			if err := r.ApplyEvent(ctx, event); err != nil {
+				t.Fatal(err)
+			}
+		}
+		r.ClearUncommittedEvents()
+	}
+	if err := r.HandleCommand(ctx, &CreateReservation{Name: "Standup", User: "Matt", RoomID: 1, StartTime: start, EndTime: start.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	apply()
+	for _, tt := range tests {
+		if err := r.HandleCommand(ctx, tt.cmd); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%v: HandleCommand() error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+		apply()
+	}
+}
+
+func TestReservationAggregate_NoShow(t *testing.T) {
+	ctx := context.Background()
+	r := NewReservationAggregate(uuid.New())
+	start := time.Now().Add(30 * time.Minute)
+	for _, cmd := range []eh.Command{
+		&CreateReservation{Name: "Standup", User: "Matt", RoomID: 1, StartTime: start, EndTime: start.Add(time.Hour)},
+		&ConfirmReservation{User: "saga"},
+	} {
+		if err := r.HandleCommand(ctx, cmd); err != nil {
+			t.Fatal(err)
+		}
+		for _, event := range r.UncommittedEvents() {
+			r.ApplyEvent(ctx, event)
+		}
+		r.ClearUncommittedEvents()
+	}
+	if err := r.HandleCommand(ctx, &MarkNoShow{User: NoShowUser}); !errors.Is(err, ErrReservationNotEnded) {
+		t.Errorf("HandleCommand() before the end error = %v, want %v", err, ErrReservationNotEnded)
+	}
+
+	// Once marked, a no-show is final
+	r.ApplyEvent(ctx, eh.NewEvent(ReservationNoShowEvent, &ReservationNoShowData{User: NoShowUser}, time.Now()))
+	tests := []struct {
+		name    string
+		cmd     eh.Command
+		wantErr error
+	}{
+		{"move", &ChangeReservationTime{User: "Matt", StartTime: start, EndTime: start.Add(time.Hour)}, ErrNoShow},
+		{"cancel", &CancelReservation{User: "Matt"}, ErrNoShow},
+		{"confirm", &ConfirmReservation{User: "saga"}, ErrReservationNotPending},
+		{"check in", &CheckIn{User: "Matt"}, ErrReservationNotConfirmed},
+	}
+	for _, tt := range tests {
+		if err := r.HandleCommand(ctx, tt.cmd); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%v after a no-show: HandleCommand() error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+	if events := r.UncommittedEvents(); len(events) != 0 {
+		t.Errorf("HandleCommand() after a no-show appended %v events", len(events))
+	}
+}
+
+func TestNoShows_Mark(t *testing.T) {
+	ctx := context.Background()
+	repo := memoryRepo.NewRepo()
+	repo.SetEntityFactory(func() eh.Entity { return &Reservation{} })
+	now := time.Date(2021, time.July, 1, 12, 0, 0, 0, time.UTC)
+
+	noShow := &Reservation{ID: uuid.New(), Status: StatusConfirmed, EndTime: now.Add(-time.Minute)}
+	for _, r := range []*Reservation{
+		noShow,
+		{ID: uuid.New(), Status: StatusConfirmed, EndTime: now.Add(time.Minute)},
+		{ID: uuid.New(), Status: StatusConfirmed, EndTime: now.Add(-time.Minute), CheckedIn: now.Add(-time.Hour)},
+		{ID: uuid.New(), Status: StatusConfirmed, EndTime: now.Add(-time.Minute), NoShow: true},
+		{ID: uuid.New(), Status: StatusCancelled, EndTime: now.Add(-time.Minute)},
+		// Checked in to since the read model was projected
+		{ID: uuid.New(), Status: StatusConfirmed, EndTime: now.Add(-time.Minute), Name: "late"},
+	} {
+		if err := repo.Save(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var handled []uuid.UUID
+	handler := eh.CommandHandlerFunc(func(ctx context.Context, cmd eh.Command) error {
+		e, err := repo.Find(ctx, cmd.AggregateID())
+		if err != nil {
+			return err
+		}
+		if e.(*Reservation).Name == "late" {
+			return ErrReservationNotConfirmed
+		}
+		handled = append(handled, cmd.AggregateID())
+		return nil
+	})
+	marked, err := NewNoShows(handler, repo).Mark(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []uuid.UUID{noShow.ID}; !reflect.DeepEqual(marked, want) || !reflect.DeepEqual(handled, want) {
+		t.Errorf("Mark() = %v, handled %v, want %v", marked, handled, want)
+	}
+}
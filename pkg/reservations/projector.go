@@ -30,6 +30,11 @@ type Reservation struct {
 	StartTime time.Time
 	EndTime   time.Time
 	Status    ReservationStatus
+	// CheckedIn and CheckedOut are when the room was entered and left, zero until then
+	CheckedIn  time.Time
+	CheckedOut time.Time
+	// NoShow is true if nobody checked in before the reservation ended
+	NoShow bool
 }
 
 func (r *Reservation) EntityID() uuid.UUID {
@@ -88,6 +93,20 @@ func (p *ReservationProjector) Project(ctx context.Context, event eh.Event, enti
 		r.Status = StatusCancelled
 	case ReservationBookingConflictedEvent:
 		r.Status = StatusDeclined
+	case ReservationCheckedInEvent:
+		data, ok := event.Data().(*ReservationCheckedInData)
+		if !ok {
+			return nil, fmt.Errorf("projector: invalid event data type: %v", event.Data())
+		}
+		r.CheckedIn = data.At
+	case ReservationCheckedOutEvent:
+		data, ok := event.Data().(*ReservationCheckedOutData)
+		if !ok {
+			return nil, fmt.Errorf("projector: invalid event data type: %v", event.Data())
+		}
+		r.CheckedOut = data.At
+	case ReservationNoShowEvent:
+		r.NoShow = true
 	default:
 		return nil, fmt.Errorf("could not handle event: %s", event)
 	}
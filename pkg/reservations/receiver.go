@@ -0,0 +1,219 @@
+package reservations
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/MattDevy/CQRS-example/pkg/signing"
+	eh "github.com/looplab/eventhorizon"
+)
+
+var (
+	// ErrMissingCommandType is when a message has no CommandType attribute
+	ErrMissingCommandType = errors.New("no command type set")
+	// ErrUnsignedCommand is when a message has no signed command envelope
+	ErrUnsignedCommand = errors.New("command is not signed")
+	// ErrUnorderedSubscription is when commands are received from a subscription without message ordering
+	ErrUnorderedSubscription = errors.New("subscription does not have message ordering enabled")
+)
+
+const (
+	// DefaultMaxAttempts is how many times a command failing with a transient error is attempted before it is dead-lettered
+	DefaultMaxAttempts = 5
+	// DefaultRetryDelay is how long to wait before the first retry of a command, it doubles with every attempt
+	DefaultRetryDelay = 200 * time.Millisecond
+)
+
+// Delivery is a command received from a Client along with where to send its result
+type Delivery struct {
+	Command       eh.Command
+	CorrelationID string
+	ReplyTo       string
+	// Version is the version of the aggregate once the command was handled
+	Version int
+
+	msg      *pubsub.Message
+	receiver *Receiver
+}
+
+// Handle runs the command and acknowledges the delivery once the command handler has completed
+// Commands failing with a transient (non domain) error are retried in place, so no later command for the
+// aggregate can overtake them, until they run out of attempts and are dead-lettered.
+// It returns true if the command will be redelivered, when it could not be dead-lettered.
+func (d *Delivery) Handle(ctx context.Context, h eh.CommandHandler) (bool, error) {
+	if d.msg == nil {
+		var err error
+		d.Version, err = HandleVersioned(ctx, h, d.Command)
+		return false, err
+	}
+	attempts := d.receiver.attempts(d.msg)
+	delay := d.receiver.retryDelay
+	for {
+		var err error
+		d.Version, err = HandleVersioned(ctx, h, d.Command)
+		if err == nil || ErrorCode(err) != InternalErrorCode {
+			d.receiver.ack(d.msg)
+			return false, err
+		}
+		if attempts >= d.receiver.maxAttempts {
+			return !d.receiver.reject(ctx, d.msg, err, attempts, true), err
+		}
+		fmt.Printf("Retrying message %v after attempt %v: %v\n", d.msg.ID, attempts, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			d.msg.Nack()
+			return true, err
+		}
+		attempts++
+		delay *= 2
+	}
+}
+
+// Requeue nacks the delivery so it is redelivered later, without using up one of its attempts
+// Only use it when the delivery can't be handled at all, such as while shutting down
+func (d *Delivery) Requeue() {
+	if d.msg != nil {
+		d.msg.Nack()
+	}
+}
+
+// Receiver pulls commands sent by a Client from a pubsub subscription
+type Receiver struct {
+	sub         *pubsub.Subscription
+	keys        *signing.KeySet
+	deadLetters *DeadLetterQueue
+	maxAttempts int
+	retryDelay  time.Duration
+
+	// attempted counts deliveries of each message, as PubSub only does when the subscription has a dead letter policy
+	attempted   map[string]int
+	attemptedMu sync.Mutex
+}
+
+// NewReceiver returns an initialized Receiver, if keys is not nil every command must be signed by one of them
+func NewReceiver(sub *pubsub.Subscription, keys *signing.KeySet) *Receiver {
+	return &Receiver{
+		sub:         sub,
+		keys:        keys,
+		maxAttempts: DefaultMaxAttempts,
+		retryDelay:  DefaultRetryDelay,
+		attempted:   make(map[string]int),
+	}
+}
+
+// SetDeadLetterQueue moves poison commands, and commands still failing after maxAttempts, onto the queue
+// Without a queue they are logged and dropped
+func (r *Receiver) SetDeadLetterQueue(q *DeadLetterQueue, maxAttempts int) {
+	r.deadLetters = q
+	r.maxAttempts = maxAttempts
+}
+
+// Receive forwards all valid commands to deliveries, each must be handled with Delivery.Handle
+// Messages that can not be decoded or verified are dead-lettered straight away
+// Blocks until ctx is cancelled
+func (r *Receiver) Receive(ctx context.Context, deliveries chan<- *Delivery) error {
+	return r.ReceiveFunc(ctx, func(ctx context.Context, d *Delivery) {
+		deliveries <- d
+	})
+}
+
+// ReceiveFunc calls f with every valid command, commands for the same aggregate are passed one at a time
+// in the order they were sent. f should queue the delivery and return, ctx is cancelled when receiving stops.
+// Returns ErrUnorderedSubscription straight away if the subscription does not keep commands in order.
+func (r *Receiver) ReceiveFunc(ctx context.Context, f func(ctx context.Context, d *Delivery)) error {
+	config, err := r.sub.Config(ctx)
+	if err != nil {
+		return err
+	}
+	if !config.EnableMessageOrdering {
+		return fmt.Errorf("%w: %v", ErrUnorderedSubscription, r.sub.ID())
+	}
+
+	return r.sub.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
+		cmd, err := r.decode(msg)
+		if err != nil {
+			r.reject(ctx, msg, err, 1, false)
+			return
+		}
+
+		f(ctx, &Delivery{
+			Command:       cmd,
+			CorrelationID: msg.Attributes[CorrelationIDAttributeKey],
+			ReplyTo:       msg.Attributes[ReplyToAttributeKey],
+			msg:           msg,
+			receiver:      r,
+		})
+	})
+}
+
+// attempts records another delivery of the message and returns how many there have been
+func (r *Receiver) attempts(msg *pubsub.Message) int {
+	if msg.DeliveryAttempt != nil {
+		return *msg.DeliveryAttempt
+	}
+	r.attemptedMu.Lock()
+	defer r.attemptedMu.Unlock()
+	r.attempted[msg.ID]++
+	return r.attempted[msg.ID]
+}
+
+func (r *Receiver) ack(msg *pubsub.Message) {
+	r.attemptedMu.Lock()
+	delete(r.attempted, msg.ID)
+	r.attemptedMu.Unlock()
+	msg.Ack()
+}
+
+// reject dead-letters the message, it is only acked once it is safely on the dead-letter topic
+// verified is whether the message was verified and decoded, only those can be replayed
+// Returns false if the message could not be dead-lettered and was nacked instead
+func (r *Receiver) reject(ctx context.Context, msg *pubsub.Message, reason error, attempts int, verified bool) bool {
+	fmt.Printf("Rejected message %v: %v\n", msg.ID, reason)
+	if r.deadLetters != nil {
+		if err := r.deadLetters.Publish(ctx, msg, reason, attempts, verified); err != nil {
+			fmt.Printf("Could not dead-letter message %v: %v\n", msg.ID, err)
+			msg.Nack()
+			return false
+		}
+	}
+	r.ack(msg)
+	return true
+}
+
+// decode verifies the message envelope and unmarshals the command it carries
+func (r *Receiver) decode(msg *pubsub.Message) (eh.Command, error) {
+	commandType, ok := msg.Attributes[CommandTypeAttributeKey]
+	if !ok {
+		return nil, ErrMissingCommandType
+	}
+
+	if r.keys != nil {
+		token, ok := msg.Attributes[SignatureAttributeKey]
+		if !ok {
+			return nil, ErrUnsignedCommand
+		}
+		claims, err := r.keys.VerifyPayload(token, commandType, msg.Data)
+		if err != nil {
+			return nil, err
+		}
+		// The reply must go where the sender asked, not to a topic added or swapped in on the way
+		if claims.ReplyTo != msg.Attributes[ReplyToAttributeKey] || claims.CorrelationID != msg.Attributes[CorrelationIDAttributeKey] {
+			return nil, fmt.Errorf("reply attributes were not signed: %w", signing.ErrDigestMismatch)
+		}
+	}
+
+	cmd, err := eh.CreateCommand(eh.CommandType(commandType))
+	if err != nil {
+		return nil, fmt.Errorf("unknown command type %q: %w", commandType, err)
+	}
+	if err := json.Unmarshal(msg.Data, cmd); err != nil {
+		return nil, fmt.Errorf("bad command: %w", err)
+	}
+	return cmd, nil
+}
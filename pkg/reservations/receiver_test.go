@@ -0,0 +1,143 @@
+package reservations
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
+	"github.com/MattDevy/CQRS-example/pkg/signing"
+	"github.com/google/uuid"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+)
+
+// newTestPubSub starts a fake PubSub server and returns client options pointing at it
+func newTestPubSub(t *testing.T) (*pstest.Server, []option.ClientOption) {
+	srv := pstest.NewServer()
+	t.Cleanup(func() { srv.Close() })
+	return srv, []option.ClientOption{
+		option.WithEndpoint(srv.Addr),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithInsecure()),
+	}
+}
+
+func TestReceiver_Receive(t *testing.T) {
+	srv, opts := newTestPubSub(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := NewClient("test", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetSigner("writer", signing.NewHS256Signer("dev", []byte("secret")))
+
+	sub, err := client.p.CreateSubscription(ctx, "receiver", pubsub.SubscriptionConfig{Topic: client.topic, EnableMessageOrdering: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := signing.NewKeySet(time.Minute)
+	keys.AddHS256("dev", []byte("secret"))
+
+	deliveries := make(chan *Delivery, 10)
+	go NewReceiver(sub, keys).Receive(ctx, deliveries)
+
+	// Unsigned
+	cmd := &CancelReservation{ID: uuid.New(), User: "Mallory"}
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsignedID := srv.Publish("projects/test/topics/"+RoomCommandsTopic, data, map[string]string{
+		CommandTypeAttributeKey: string(CancelReservationCommand),
+	})
+
+	// Tampered, signed for a different command
+	token, err := signing.NewHS256Signer("dev", []byte("secret")).Sign(signing.Claims{
+		Subject:     "writer",
+		IssuedAt:    time.Now().Unix(),
+		CommandType: string(CancelReservationCommand),
+		Digest:      signing.Digest([]byte(`{}`)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tamperedID := srv.Publish("projects/test/topics/"+RoomCommandsTopic, data, map[string]string{
+		CommandTypeAttributeKey: string(CancelReservationCommand),
+		SignatureAttributeKey:   token,
+	})
+
+	// Signed, with a reply topic added after signing
+	token, err = signing.NewHS256Signer("dev", []byte("secret")).Sign(signing.Claims{
+		Subject:     "writer",
+		IssuedAt:    time.Now().Unix(),
+		CommandType: string(CancelReservationCommand),
+		Digest:      signing.Digest(data),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	redirectedID := srv.Publish("projects/test/topics/"+RoomCommandsTopic, data, map[string]string{
+		CommandTypeAttributeKey:   string(CancelReservationCommand),
+		SignatureAttributeKey:     token,
+		ReplyToAttributeKey:       "eavesdropper",
+		CorrelationIDAttributeKey: "1",
+	})
+
+	// Signed
+	want := &CancelReservation{ID: uuid.New(), User: "Matt"}
+	if err := client.SendCommand(ctx, want); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case got := <-deliveries:
+		if c, ok := got.Command.(*CancelReservation); !ok || *c != *want {
+			t.Fatalf("Receive() got %#v, want %#v", got.Command, want)
+		}
+	case <-ctx.Done():
+		t.Fatal("signed command was not received")
+	}
+
+	// Rejected messages are acked so they are never redelivered
+	for _, id := range []string{unsignedID, tamperedID, redirectedID} {
+		for srv.Message(id).Acks == 0 {
+			select {
+			case <-ctx.Done():
+				t.Fatalf("message %v was not rejected", id)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+	select {
+	case got := <-deliveries:
+		t.Fatalf("Receive() forwarded rejected command %#v", got.Command)
+	default:
+	}
+}
+
+func TestReceiver_Unordered(t *testing.T) {
+	_, opts := newTestPubSub(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := NewClient("test", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	sub, err := client.p.CreateSubscription(ctx, "unordered", pubsub.SubscriptionConfig{Topic: client.topic})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = NewReceiver(sub, nil).Receive(ctx, make(chan *Delivery))
+	if !errors.Is(err, ErrUnorderedSubscription) {
+		t.Errorf("Receive() error = %v, want %v", err, ErrUnorderedSubscription)
+	}
+}
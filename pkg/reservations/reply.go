@@ -0,0 +1,109 @@
+package reservations
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+const (
+	// RoomRepliesTopicPrefix is the prefix of the PubSub topic each Client recieves command results on
+	RoomRepliesTopicPrefix = "rooms.replies."
+	// CorrelationIDAttributeKey is the Attribute Key that matches a command result to its command
+	CorrelationIDAttributeKey = "CorrelationID"
+	// ReplyToAttributeKey is the Attribute Key holding the topic the command result should be sent to
+	ReplyToAttributeKey = "ReplyTo"
+)
+
+// CommandResult is sent back to the Client once the command handler server has handled a command
+type CommandResult struct {
+	CorrelationID string
+	CommandType   eh.CommandType
+	AggregateID   uuid.UUID
+	Success       bool
+	ErrorCode     string `json:",omitempty"`
+	Error         string `json:",omitempty"`
+	// Version is the version of the aggregate after the command was applied
+	Version int
+}
+
+// Err returns the typed error of a failed command, nil if it succeeded
+func (r *CommandResult) Err() error {
+	if r.Success {
+		return nil
+	}
+	return &CommandError{Code: r.ErrorCode, Message: r.Error}
+}
+
+// Responder publishes command results to the topic each Delivery asks for
+type Responder struct {
+	client *pubsub.Client
+
+	topics   map[string]*pubsub.Topic
+	topicsMu sync.Mutex
+}
+
+// NewResponder returns an initialized Responder
+func NewResponder(client *pubsub.Client) *Responder {
+	return &Responder{
+		client: client,
+		topics: make(map[string]*pubsub.Topic),
+	}
+}
+
+// Reply sends the outcome of handling the delivery, deliveries without a ReplyTo are ignored
+func (r *Responder) Reply(ctx context.Context, d *Delivery, handleErr error) error {
+	if d.ReplyTo == "" {
+		return nil
+	}
+
+	result := &CommandResult{
+		CorrelationID: d.CorrelationID,
+		CommandType:   d.Command.CommandType(),
+		AggregateID:   d.Command.AggregateID(),
+		Success:       handleErr == nil,
+		ErrorCode:     ErrorCode(handleErr),
+		Version:       d.Version,
+	}
+	if handleErr != nil {
+		result.Error = handleErr.Error()
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	res := r.topic(d.ReplyTo).Publish(ctx, &pubsub.Message{
+		Data: data,
+		Attributes: map[string]string{
+			CorrelationIDAttributeKey: d.CorrelationID,
+		},
+	})
+	_, err = res.Get(ctx)
+	return err
+}
+
+// topic returns a cached handle to the topic, so its publishing goroutines are shared between replies
+func (r *Responder) topic(name string) *pubsub.Topic {
+	r.topicsMu.Lock()
+	defer r.topicsMu.Unlock()
+	topic, ok := r.topics[name]
+	if !ok {
+		topic = r.client.Topic(name)
+		r.topics[name] = topic
+	}
+	return topic
+}
+
+// Stop flushes and stops all the reply topics
+func (r *Responder) Stop() {
+	r.topicsMu.Lock()
+	defer r.topicsMu.Unlock()
+	for _, topic := range r.topics {
+		topic.Stop()
+	}
+}
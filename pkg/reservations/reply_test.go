@@ -0,0 +1,80 @@
+package reservations
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/google/uuid"
+	"github.com/looplab/eventhorizon/aggregatestore/events"
+	"github.com/looplab/eventhorizon/commandhandler/aggregate"
+	"github.com/looplab/eventhorizon/eventstore/memory"
+)
+
+func TestClient_SendCommandAndWait(t *testing.T) {
+	_, opts := newTestPubSub(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := NewClient("test", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// Command handler server
+	eventStore, err := memory.NewEventStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	aggregateStore, err := events.NewAggregateStore(NewVersionStore(eventStore))
+	if err != nil {
+		t.Fatal(err)
+	}
+	commandHandler, err := aggregate.NewCommandHandler(ReservationAggregateType, aggregateStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := client.p.CreateSubscription(ctx, "server", pubsub.SubscriptionConfig{Topic: client.topic, EnableMessageOrdering: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	responder := NewResponder(client.p)
+	defer responder.Stop()
+	deliveries := make(chan *Delivery, 10)
+	go NewReceiver(sub, nil).Receive(ctx, deliveries)
+	go func() {
+		for d := range deliveries {
+			_, err := d.Handle(ctx, commandHandler)
+			if err := responder.Reply(ctx, d, err); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	id := uuid.New()
+	result, err := client.SendCommandAndWait(ctx, &CreateReservation{
+		ID:        id,
+		Name:      "Standup",
+		User:      "Matt",
+		RoomID:    1,
+		StartTime: time.Now(),
+		EndTime:   time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("SendCommandAndWait() error = %v", err)
+	}
+	if !result.Success || result.AggregateID != id || result.Version != 1 {
+		t.Errorf("SendCommandAndWait() = %+v, want success at version 1", result)
+	}
+
+	result, err = client.SendCommandAndWait(ctx, &ConfirmReservation{ID: uuid.New(), User: "Matt"})
+	if !errors.Is(err, ErrReservationNotFound) {
+		t.Errorf("SendCommandAndWait() error = %v, want %v", err, ErrReservationNotFound)
+	}
+	if result == nil || result.Success || result.ErrorCode != "NotFound" {
+		t.Errorf("SendCommandAndWait() = %+v, want NotFound failure", result)
+	}
+}
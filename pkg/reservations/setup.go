@@ -41,6 +41,9 @@ func Setup(
 		ReservationTimeChangedEvent,
 		ReservationCancelledEvent,
 		ReservationBookingConflictedEvent,
+		ReservationCheckedInEvent,
+		ReservationCheckedOutEvent,
+		ReservationNoShowEvent,
 	}, reservationProjector)
 
 	// Create aggregate store
@@ -62,6 +65,9 @@ func Setup(
 		DeclineReservationCommand,
 		ChangeReservationTimeCommand,
 		CancelReservationCommand,
+		CheckInCommand,
+		CheckOutCommand,
+		MarkNoShowCommand,
 	}
 	for _, cmdType := range commands {
 		if err := commandBus.SetHandler(commandHandler, cmdType); err != nil {
@@ -0,0 +1,77 @@
+package reservations
+
+import (
+	"context"
+	"sync"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+var _ = eh.EventStore(&VersionStore{})
+
+// versionKey is the context key of the version captured while a command is handled
+type versionKey struct{}
+
+// capturedVersion is the version of the handled aggregate, kept up to date by the VersionStore
+type capturedVersion struct {
+	id      uuid.UUID
+	version int
+	mu      sync.Mutex
+}
+
+// HandleVersioned handles the command and returns the version of its aggregate once handled
+// The version is that of the aggregate the handler loaded and saved, so commands handled concurrently for the
+// same aggregate can't change it. The handler's event store must be wrapped with NewVersionStore.
+func HandleVersioned(ctx context.Context, h eh.CommandHandler, cmd eh.Command) (int, error) {
+	captured := &capturedVersion{id: cmd.AggregateID()}
+	err := h.HandleCommand(context.WithValue(ctx, versionKey{}, captured), cmd)
+	captured.mu.Lock()
+	defer captured.mu.Unlock()
+	return captured.version, err
+}
+
+// VersionStore is an event store recording the version of the aggregate being handled by HandleVersioned
+// as it is loaded, and again once its new events are saved
+type VersionStore struct {
+	eh.EventStore
+}
+
+// NewVersionStore wraps the event store the command handlers load and save aggregates with
+func NewVersionStore(store eh.EventStore) *VersionStore {
+	return &VersionStore{EventStore: store}
+}
+
+// Save saves the events, recording their version if they are of the handled aggregate
+func (s *VersionStore) Save(ctx context.Context, events []eh.Event, originalVersion int) error {
+	if err := s.EventStore.Save(ctx, events, originalVersion); err != nil {
+		return err
+	}
+	if len(events) > 0 {
+		capture(ctx, events[0].AggregateID(), events[len(events)-1].Version())
+	}
+	return nil
+}
+
+// Load loads the events of the aggregate, recording its version if it is the handled aggregate
+func (s *VersionStore) Load(ctx context.Context, id uuid.UUID) ([]eh.Event, error) {
+	events, err := s.EventStore.Load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(events) > 0 {
+		capture(ctx, id, events[len(events)-1].Version())
+	}
+	return events, nil
+}
+
+// capture records the version if ctx is handling a command for the aggregate
+func capture(ctx context.Context, id uuid.UUID, version int) {
+	captured, ok := ctx.Value(versionKey{}).(*capturedVersion)
+	if !ok || captured.id != id {
+		return
+	}
+	captured.mu.Lock()
+	captured.version = version
+	captured.mu.Unlock()
+}
@@ -0,0 +1,58 @@
+package reservations
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/aggregatestore/events"
+	"github.com/looplab/eventhorizon/commandhandler/aggregate"
+	"github.com/looplab/eventhorizon/eventstore/memory"
+)
+
+func TestHandleVersioned(t *testing.T) {
+	ctx := context.Background()
+	eventStore, err := memory.NewEventStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	aggregateStore, err := events.NewAggregateStore(NewVersionStore(eventStore))
+	if err != nil {
+		t.Fatal(err)
+	}
+	commandHandler, err := aggregate.NewCommandHandler(ReservationAggregateType, aggregateStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := uuid.New()
+	create := &CreateReservation{ID: id, Name: "Standup", User: "Matt", RoomID: 1, StartTime: time.Now(), EndTime: time.Now().Add(time.Hour)}
+	if version, err := HandleVersioned(ctx, commandHandler, create); err != nil || version != 1 {
+		t.Fatalf("HandleVersioned() = %v, %v, want version 1", version, err)
+	}
+
+	// A command handled concurrently, once this one has saved, does not change its version
+	concurrent := eh.CommandHandlerFunc(func(ctx context.Context, cmd eh.Command) error {
+		if err := commandHandler.HandleCommand(ctx, cmd); err != nil {
+			return err
+		}
+		return commandHandler.HandleCommand(context.Background(), &ChangeReservationTime{
+			ID: id, User: "Matt", StartTime: time.Now(), EndTime: time.Now().Add(2 * time.Hour),
+		})
+	})
+	if version, err := HandleVersioned(ctx, concurrent, &ConfirmReservation{ID: id, User: "saga"}); err != nil || version != 2 {
+		t.Errorf("HandleVersioned() = %v, %v, want version 2", version, err)
+	}
+
+	if version, err := HandleVersioned(ctx, commandHandler, &DeclineReservation{ID: id, User: "saga", Message: "busy"}); err != nil || version != 4 {
+		t.Errorf("HandleVersioned() = %v, %v, want version 4", version, err)
+	}
+
+	// A rejected command has the version it was rejected at
+	if version, err := HandleVersioned(ctx, commandHandler, create); !errors.Is(err, ErrReservationExists) || version != 4 {
+		t.Errorf("HandleVersioned() = %v, %v, want %v at version 4", version, err, ErrReservationExists)
+	}
+}
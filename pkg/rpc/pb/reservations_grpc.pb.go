@@ -0,0 +1,579 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// ReservationCommandServiceClient is the client API for ReservationCommandService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ReservationCommandServiceClient interface {
+	CreateReservation(ctx context.Context, in *CreateReservationRequest, opts ...grpc.CallOption) (*CommandResult, error)
+	ConfirmReservation(ctx context.Context, in *ConfirmReservationRequest, opts ...grpc.CallOption) (*CommandResult, error)
+	DeclineReservation(ctx context.Context, in *DeclineReservationRequest, opts ...grpc.CallOption) (*CommandResult, error)
+	ChangeReservationTime(ctx context.Context, in *ChangeReservationTimeRequest, opts ...grpc.CallOption) (*CommandResult, error)
+	CancelReservation(ctx context.Context, in *CancelReservationRequest, opts ...grpc.CallOption) (*CommandResult, error)
+	CheckIn(ctx context.Context, in *CheckInRequest, opts ...grpc.CallOption) (*CommandResult, error)
+	CheckOut(ctx context.Context, in *CheckOutRequest, opts ...grpc.CallOption) (*CommandResult, error)
+	MarkNoShow(ctx context.Context, in *MarkNoShowRequest, opts ...grpc.CallOption) (*CommandResult, error)
+}
+
+type reservationCommandServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewReservationCommandServiceClient(cc grpc.ClientConnInterface) ReservationCommandServiceClient {
+	return &reservationCommandServiceClient{cc}
+}
+
+func (c *reservationCommandServiceClient) CreateReservation(ctx context.Context, in *CreateReservationRequest, opts ...grpc.CallOption) (*CommandResult, error) {
+	out := new(CommandResult)
+	err := c.cc.Invoke(ctx, "/reservations.v1.ReservationCommandService/CreateReservation", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *reservationCommandServiceClient) ConfirmReservation(ctx context.Context, in *ConfirmReservationRequest, opts ...grpc.CallOption) (*CommandResult, error) {
+	out := new(CommandResult)
+	err := c.cc.Invoke(ctx, "/reservations.v1.ReservationCommandService/ConfirmReservation", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *reservationCommandServiceClient) DeclineReservation(ctx context.Context, in *DeclineReservationRequest, opts ...grpc.CallOption) (*CommandResult, error) {
+	out := new(CommandResult)
+	err := c.cc.Invoke(ctx, "/reservations.v1.ReservationCommandService/DeclineReservation", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *reservationCommandServiceClient) ChangeReservationTime(ctx context.Context, in *ChangeReservationTimeRequest, opts ...grpc.CallOption) (*CommandResult, error) {
+	out := new(CommandResult)
+	err := c.cc.Invoke(ctx, "/reservations.v1.ReservationCommandService/ChangeReservationTime", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *reservationCommandServiceClient) CancelReservation(ctx context.Context, in *CancelReservationRequest, opts ...grpc.CallOption) (*CommandResult, error) {
+	out := new(CommandResult)
+	err := c.cc.Invoke(ctx, "/reservations.v1.ReservationCommandService/CancelReservation", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *reservationCommandServiceClient) CheckIn(ctx context.Context, in *CheckInRequest, opts ...grpc.CallOption) (*CommandResult, error) {
+	out := new(CommandResult)
+	err := c.cc.Invoke(ctx, "/reservations.v1.ReservationCommandService/CheckIn", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *reservationCommandServiceClient) CheckOut(ctx context.Context, in *CheckOutRequest, opts ...grpc.CallOption) (*CommandResult, error) {
+	out := new(CommandResult)
+	err := c.cc.Invoke(ctx, "/reservations.v1.ReservationCommandService/CheckOut", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *reservationCommandServiceClient) MarkNoShow(ctx context.Context, in *MarkNoShowRequest, opts ...grpc.CallOption) (*CommandResult, error) {
+	out := new(CommandResult)
+	err := c.cc.Invoke(ctx, "/reservations.v1.ReservationCommandService/MarkNoShow", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ReservationCommandServiceServer is the server API for ReservationCommandService service.
+// All implementations must embed UnimplementedReservationCommandServiceServer
+// for forward compatibility
+type ReservationCommandServiceServer interface {
+	CreateReservation(context.Context, *CreateReservationRequest) (*CommandResult, error)
+	ConfirmReservation(context.Context, *ConfirmReservationRequest) (*CommandResult, error)
+	DeclineReservation(context.Context, *DeclineReservationRequest) (*CommandResult, error)
+	ChangeReservationTime(context.Context, *ChangeReservationTimeRequest) (*CommandResult, error)
+	CancelReservation(context.Context, *CancelReservationRequest) (*CommandResult, error)
+	CheckIn(context.Context, *CheckInRequest) (*CommandResult, error)
+	CheckOut(context.Context, *CheckOutRequest) (*CommandResult, error)
+	MarkNoShow(context.Context, *MarkNoShowRequest) (*CommandResult, error)
+	mustEmbedUnimplementedReservationCommandServiceServer()
+}
+
+// UnimplementedReservationCommandServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedReservationCommandServiceServer struct {
+}
+
+func (UnimplementedReservationCommandServiceServer) CreateReservation(context.Context, *CreateReservationRequest) (*CommandResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateReservation not implemented")
+}
+func (UnimplementedReservationCommandServiceServer) ConfirmReservation(context.Context, *ConfirmReservationRequest) (*CommandResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ConfirmReservation not implemented")
+}
+func (UnimplementedReservationCommandServiceServer) DeclineReservation(context.Context, *DeclineReservationRequest) (*CommandResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeclineReservation not implemented")
+}
+func (UnimplementedReservationCommandServiceServer) ChangeReservationTime(context.Context, *ChangeReservationTimeRequest) (*CommandResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ChangeReservationTime not implemented")
+}
+func (UnimplementedReservationCommandServiceServer) CancelReservation(context.Context, *CancelReservationRequest) (*CommandResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelReservation not implemented")
+}
+func (UnimplementedReservationCommandServiceServer) CheckIn(context.Context, *CheckInRequest) (*CommandResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CheckIn not implemented")
+}
+func (UnimplementedReservationCommandServiceServer) CheckOut(context.Context, *CheckOutRequest) (*CommandResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CheckOut not implemented")
+}
+func (UnimplementedReservationCommandServiceServer) MarkNoShow(context.Context, *MarkNoShowRequest) (*CommandResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MarkNoShow not implemented")
+}
+func (UnimplementedReservationCommandServiceServer) mustEmbedUnimplementedReservationCommandServiceServer() {
+}
+
+// UnsafeReservationCommandServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ReservationCommandServiceServer will
+// result in compilation errors.
+type UnsafeReservationCommandServiceServer interface {
+	mustEmbedUnimplementedReservationCommandServiceServer()
+}
+
+func RegisterReservationCommandServiceServer(s grpc.ServiceRegistrar, srv ReservationCommandServiceServer) {
+	s.RegisterService(&ReservationCommandService_ServiceDesc, srv)
+}
+
+func _ReservationCommandService_CreateReservation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateReservationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReservationCommandServiceServer).CreateReservation(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/reservations.v1.ReservationCommandService/CreateReservation",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReservationCommandServiceServer).CreateReservation(ctx, req.(*CreateReservationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ReservationCommandService_ConfirmReservation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ConfirmReservationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReservationCommandServiceServer).ConfirmReservation(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/reservations.v1.ReservationCommandService/ConfirmReservation",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReservationCommandServiceServer).ConfirmReservation(ctx, req.(*ConfirmReservationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ReservationCommandService_DeclineReservation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeclineReservationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReservationCommandServiceServer).DeclineReservation(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/reservations.v1.ReservationCommandService/DeclineReservation",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReservationCommandServiceServer).DeclineReservation(ctx, req.(*DeclineReservationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ReservationCommandService_ChangeReservationTime_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ChangeReservationTimeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReservationCommandServiceServer).ChangeReservationTime(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/reservations.v1.ReservationCommandService/ChangeReservationTime",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReservationCommandServiceServer).ChangeReservationTime(ctx, req.(*ChangeReservationTimeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ReservationCommandService_CancelReservation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelReservationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReservationCommandServiceServer).CancelReservation(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/reservations.v1.ReservationCommandService/CancelReservation",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReservationCommandServiceServer).CancelReservation(ctx, req.(*CancelReservationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ReservationCommandService_CheckIn_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CheckInRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReservationCommandServiceServer).CheckIn(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/reservations.v1.ReservationCommandService/CheckIn",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReservationCommandServiceServer).CheckIn(ctx, req.(*CheckInRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ReservationCommandService_CheckOut_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CheckOutRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReservationCommandServiceServer).CheckOut(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/reservations.v1.ReservationCommandService/CheckOut",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReservationCommandServiceServer).CheckOut(ctx, req.(*CheckOutRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ReservationCommandService_MarkNoShow_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MarkNoShowRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReservationCommandServiceServer).MarkNoShow(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/reservations.v1.ReservationCommandService/MarkNoShow",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReservationCommandServiceServer).MarkNoShow(ctx, req.(*MarkNoShowRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// ReservationCommandService_ServiceDesc is the grpc.ServiceDesc for ReservationCommandService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ReservationCommandService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "reservations.v1.ReservationCommandService",
+	HandlerType: (*ReservationCommandServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateReservation",
+			Handler:    _ReservationCommandService_CreateReservation_Handler,
+		},
+		{
+			MethodName: "ConfirmReservation",
+			Handler:    _ReservationCommandService_ConfirmReservation_Handler,
+		},
+		{
+			MethodName: "DeclineReservation",
+			Handler:    _ReservationCommandService_DeclineReservation_Handler,
+		},
+		{
+			MethodName: "ChangeReservationTime",
+			Handler:    _ReservationCommandService_ChangeReservationTime_Handler,
+		},
+		{
+			MethodName: "CancelReservation",
+			Handler:    _ReservationCommandService_CancelReservation_Handler,
+		},
+		{
+			MethodName: "CheckIn",
+			Handler:    _ReservationCommandService_CheckIn_Handler,
+		},
+		{
+			MethodName: "CheckOut",
+			Handler:    _ReservationCommandService_CheckOut_Handler,
+		},
+		{
+			MethodName: "MarkNoShow",
+			Handler:    _ReservationCommandService_MarkNoShow_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "reservations.proto",
+}
+
+// ReservationQueryServiceClient is the client API for ReservationQueryService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ReservationQueryServiceClient interface {
+	GetReservation(ctx context.Context, in *GetReservationRequest, opts ...grpc.CallOption) (*Reservation, error)
+	ListReservations(ctx context.Context, in *ListReservationsRequest, opts ...grpc.CallOption) (*ListReservationsResponse, error)
+	GetBillingHistory(ctx context.Context, in *GetBillingHistoryRequest, opts ...grpc.CallOption) (*BillingHistory, error)
+	// WatchReservation streams the reservation now, and again every time it is projected
+	WatchReservation(ctx context.Context, in *WatchReservationRequest, opts ...grpc.CallOption) (ReservationQueryService_WatchReservationClient, error)
+}
+
+type reservationQueryServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewReservationQueryServiceClient(cc grpc.ClientConnInterface) ReservationQueryServiceClient {
+	return &reservationQueryServiceClient{cc}
+}
+
+func (c *reservationQueryServiceClient) GetReservation(ctx context.Context, in *GetReservationRequest, opts ...grpc.CallOption) (*Reservation, error) {
+	out := new(Reservation)
+	err := c.cc.Invoke(ctx, "/reservations.v1.ReservationQueryService/GetReservation", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *reservationQueryServiceClient) ListReservations(ctx context.Context, in *ListReservationsRequest, opts ...grpc.CallOption) (*ListReservationsResponse, error) {
+	out := new(ListReservationsResponse)
+	err := c.cc.Invoke(ctx, "/reservations.v1.ReservationQueryService/ListReservations", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *reservationQueryServiceClient) GetBillingHistory(ctx context.Context, in *GetBillingHistoryRequest, opts ...grpc.CallOption) (*BillingHistory, error) {
+	out := new(BillingHistory)
+	err := c.cc.Invoke(ctx, "/reservations.v1.ReservationQueryService/GetBillingHistory", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *reservationQueryServiceClient) WatchReservation(ctx context.Context, in *WatchReservationRequest, opts ...grpc.CallOption) (ReservationQueryService_WatchReservationClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ReservationQueryService_ServiceDesc.Streams[0], "/reservations.v1.ReservationQueryService/WatchReservation", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &reservationQueryServiceWatchReservationClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type ReservationQueryService_WatchReservationClient interface {
+	Recv() (*Reservation, error)
+	grpc.ClientStream
+}
+
+type reservationQueryServiceWatchReservationClient struct {
+	grpc.ClientStream
+}
+
+func (x *reservationQueryServiceWatchReservationClient) Recv() (*Reservation, error) {
+	m := new(Reservation)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ReservationQueryServiceServer is the server API for ReservationQueryService service.
+// All implementations must embed UnimplementedReservationQueryServiceServer
+// for forward compatibility
+type ReservationQueryServiceServer interface {
+	GetReservation(context.Context, *GetReservationRequest) (*Reservation, error)
+	ListReservations(context.Context, *ListReservationsRequest) (*ListReservationsResponse, error)
+	GetBillingHistory(context.Context, *GetBillingHistoryRequest) (*BillingHistory, error)
+	// WatchReservation streams the reservation now, and again every time it is projected
+	WatchReservation(*WatchReservationRequest, ReservationQueryService_WatchReservationServer) error
+	mustEmbedUnimplementedReservationQueryServiceServer()
+}
+
+// UnimplementedReservationQueryServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedReservationQueryServiceServer struct {
+}
+
+func (UnimplementedReservationQueryServiceServer) GetReservation(context.Context, *GetReservationRequest) (*Reservation, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetReservation not implemented")
+}
+func (UnimplementedReservationQueryServiceServer) ListReservations(context.Context, *ListReservationsRequest) (*ListReservationsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListReservations not implemented")
+}
+func (UnimplementedReservationQueryServiceServer) GetBillingHistory(context.Context, *GetBillingHistoryRequest) (*BillingHistory, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBillingHistory not implemented")
+}
+func (UnimplementedReservationQueryServiceServer) WatchReservation(*WatchReservationRequest, ReservationQueryService_WatchReservationServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchReservation not implemented")
+}
+func (UnimplementedReservationQueryServiceServer) mustEmbedUnimplementedReservationQueryServiceServer() {
+}
+
+// UnsafeReservationQueryServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ReservationQueryServiceServer will
+// result in compilation errors.
+type UnsafeReservationQueryServiceServer interface {
+	mustEmbedUnimplementedReservationQueryServiceServer()
+}
+
+func RegisterReservationQueryServiceServer(s grpc.ServiceRegistrar, srv ReservationQueryServiceServer) {
+	s.RegisterService(&ReservationQueryService_ServiceDesc, srv)
+}
+
+func _ReservationQueryService_GetReservation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetReservationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReservationQueryServiceServer).GetReservation(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/reservations.v1.ReservationQueryService/GetReservation",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReservationQueryServiceServer).GetReservation(ctx, req.(*GetReservationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ReservationQueryService_ListReservations_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListReservationsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReservationQueryServiceServer).ListReservations(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/reservations.v1.ReservationQueryService/ListReservations",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReservationQueryServiceServer).ListReservations(ctx, req.(*ListReservationsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ReservationQueryService_GetBillingHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetBillingHistoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReservationQueryServiceServer).GetBillingHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/reservations.v1.ReservationQueryService/GetBillingHistory",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReservationQueryServiceServer).GetBillingHistory(ctx, req.(*GetBillingHistoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ReservationQueryService_WatchReservation_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchReservationRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ReservationQueryServiceServer).WatchReservation(m, &reservationQueryServiceWatchReservationServer{stream})
+}
+
+type ReservationQueryService_WatchReservationServer interface {
+	Send(*Reservation) error
+	grpc.ServerStream
+}
+
+type reservationQueryServiceWatchReservationServer struct {
+	grpc.ServerStream
+}
+
+func (x *reservationQueryServiceWatchReservationServer) Send(m *Reservation) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// ReservationQueryService_ServiceDesc is the grpc.ServiceDesc for ReservationQueryService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ReservationQueryService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "reservations.v1.ReservationQueryService",
+	HandlerType: (*ReservationQueryServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetReservation",
+			Handler:    _ReservationQueryService_GetReservation_Handler,
+		},
+		{
+			MethodName: "ListReservations",
+			Handler:    _ReservationQueryService_ListReservations_Handler,
+		},
+		{
+			MethodName: "GetBillingHistory",
+			Handler:    _ReservationQueryService_GetBillingHistory_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchReservation",
+			Handler:       _ReservationQueryService_WatchReservation_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "reservations.proto",
+}
@@ -0,0 +1,372 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/billing"
+	"github.com/MattDevy/CQRS-example/pkg/money"
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	"github.com/MattDevy/CQRS-example/pkg/rpc/pb"
+	"github.com/MattDevy/CQRS-example/pkg/signing"
+	"github.com/MattDevy/CQRS-example/pkg/watch"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ErrorDomain is the domain of the ErrorInfo detail attached to rejected commands
+const ErrorDomain = "reservations"
+
+// statusCodes maps error kinds onto gRPC status codes
+var statusCodes = map[reservations.ErrorKind]codes.Code{
+	reservations.ErrorRejected:        codes.FailedPrecondition,
+	reservations.ErrorInvalid:         codes.InvalidArgument,
+	reservations.ErrorNotFound:        codes.NotFound,
+	reservations.ErrorConflict:        codes.AlreadyExists,
+	reservations.ErrorUnauthenticated: codes.Unauthenticated,
+	reservations.ErrorInternal:        codes.Internal,
+}
+
+// Register registers the command and query services on the gRPC server
+func Register(s *grpc.Server, commands *CommandServer, queries *QueryServer) {
+	pb.RegisterReservationCommandServiceServer(s, commands)
+	pb.RegisterReservationQueryServiceServer(s, queries)
+}
+
+// CommandServer implements ReservationCommandService by dispatching into the command bus
+type CommandServer struct {
+	pb.UnimplementedReservationCommandServiceServer
+
+	commandHandler eh.CommandHandler
+	keys           *signing.KeySet
+}
+
+// NewCommandServer returns an initialized CommandServer
+// If keys is not nil every command must be signed, see SignCommands.
+// The command handler's event store must be a reservations.VersionStore for the results to carry the new versions.
+func NewCommandServer(commandHandler eh.CommandHandler, keys *signing.KeySet) *CommandServer {
+	return &CommandServer{commandHandler: commandHandler, keys: keys}
+}
+
+func (s *CommandServer) CreateReservation(ctx context.Context, req *pb.CreateReservationRequest) (*pb.CommandResult, error) {
+	id, err := parseID(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return s.handle(ctx, req, &reservations.CreateReservation{
+		ID:            id,
+		Name:          req.Name,
+		User:          req.User,
+		RoomID:        int(req.RoomId),
+		StartTime:     req.StartTime.AsTime(),
+		EndTime:       req.EndTime.AsTime(),
+		PromotionCode: req.PromotionCode,
+	})
+}
+
+func (s *CommandServer) ConfirmReservation(ctx context.Context, req *pb.ConfirmReservationRequest) (*pb.CommandResult, error) {
+	id, err := parseID(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return s.handle(ctx, req, &reservations.ConfirmReservation{
+		ID:   id,
+		User: req.User,
+	})
+}
+
+func (s *CommandServer) DeclineReservation(ctx context.Context, req *pb.DeclineReservationRequest) (*pb.CommandResult, error) {
+	id, err := parseID(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return s.handle(ctx, req, &reservations.DeclineReservation{
+		ID:      id,
+		User:    req.User,
+		Message: req.Message,
+	})
+}
+
+func (s *CommandServer) ChangeReservationTime(ctx context.Context, req *pb.ChangeReservationTimeRequest) (*pb.CommandResult, error) {
+	id, err := parseID(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return s.handle(ctx, req, &reservations.ChangeReservationTime{
+		ID:        id,
+		User:      req.User,
+		StartTime: req.StartTime.AsTime(),
+		EndTime:   req.EndTime.AsTime(),
+	})
+}
+
+func (s *CommandServer) CancelReservation(ctx context.Context, req *pb.CancelReservationRequest) (*pb.CommandResult, error) {
+	id, err := parseID(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return s.handle(ctx, req, &reservations.CancelReservation{
+		ID:   id,
+		User: req.User,
+	})
+}
+
+func (s *CommandServer) CheckIn(ctx context.Context, req *pb.CheckInRequest) (*pb.CommandResult, error) {
+	id, err := parseID(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return s.handle(ctx, req, &reservations.CheckIn{
+		ID:   id,
+		User: req.User,
+		At:   optionalTime(req.At),
+	})
+}
+
+func (s *CommandServer) CheckOut(ctx context.Context, req *pb.CheckOutRequest) (*pb.CommandResult, error) {
+	id, err := parseID(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return s.handle(ctx, req, &reservations.CheckOut{
+		ID:   id,
+		User: req.User,
+		At:   optionalTime(req.At),
+	})
+}
+
+func (s *CommandServer) MarkNoShow(ctx context.Context, req *pb.MarkNoShowRequest) (*pb.CommandResult, error) {
+	id, err := parseID(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return s.handle(ctx, req, &reservations.MarkNoShow{
+		ID:   id,
+		User: req.User,
+	})
+}
+
+// handle verifies the request was signed for the command, then validates and handles the command,
+// returning the new aggregate version
+func (s *CommandServer) handle(ctx context.Context, req proto.Message, cmd eh.Command) (*pb.CommandResult, error) {
+	if s.keys != nil {
+		if err := verify(ctx, s.keys, cmd.CommandType(), req); err != nil {
+			return nil, commandStatus(err)
+		}
+	}
+	if err := eh.CheckCommand(cmd); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	version, err := reservations.HandleVersioned(ctx, s.commandHandler, cmd)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return nil, commandStatus(err)
+	}
+	return &pb.CommandResult{
+		AggregateId: cmd.AggregateID().String(),
+		Version:     int32(version),
+	}, nil
+}
+
+// commandStatus converts a command error into a status carrying the domain error code
+func commandStatus(err error) error {
+	errorCode := reservations.ErrorCode(err)
+	code := statusCodes[reservations.ErrorKindOf(errorCode)]
+	st, detailsErr := status.New(code, err.Error()).WithDetails(&errdetails.ErrorInfo{
+		Reason: errorCode,
+		Domain: ErrorDomain,
+	})
+	if detailsErr != nil {
+		return status.Error(code, err.Error())
+	}
+	return st.Err()
+}
+
+// QueryServer implements ReservationQueryService by reading the read models
+type QueryServer struct {
+	pb.UnimplementedReservationQueryServiceServer
+
+	reservationRepo eh.ReadRepo
+	billingRepo     eh.ReadRepo
+}
+
+// NewQueryServer returns an initialized QueryServer
+// WatchReservation is only supported when the reservationRepo is wrapped by a watch.Repo
+func NewQueryServer(reservationRepo, billingRepo eh.ReadRepo) *QueryServer {
+	return &QueryServer{reservationRepo: reservationRepo, billingRepo: billingRepo}
+}
+
+func (s *QueryServer) GetReservation(ctx context.Context, req *pb.GetReservationRequest) (*pb.Reservation, error) {
+	id, err := parseID(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	r, err := s.findReservation(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return reservationToProto(r), nil
+}
+
+func (s *QueryServer) ListReservations(ctx context.Context, req *pb.ListReservationsRequest) (*pb.ListReservationsResponse, error) {
+	entities, err := s.reservationRepo.FindAll(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := &pb.ListReservationsResponse{}
+	for _, e := range entities {
+		if r, ok := e.(*reservations.Reservation); ok {
+			resp.Reservations = append(resp.Reservations, reservationToProto(r))
+		}
+	}
+	return resp, nil
+}
+
+func (s *QueryServer) GetBillingHistory(ctx context.Context, req *pb.GetBillingHistoryRequest) (*pb.BillingHistory, error) {
+	h, err := billing.FindByUser(ctx, s.billingRepo, req.User)
+	if errors.Is(err, eh.ErrEntityNotFound) {
+		return nil, status.Errorf(codes.NotFound, "no billing history for %q", req.User)
+	} else if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return billingHistoryToProto(h), nil
+}
+
+func (s *QueryServer) WatchReservation(req *pb.WatchReservationRequest, stream pb.ReservationQueryService_WatchReservationServer) error {
+	id, err := parseID(req.Id)
+	if err != nil {
+		return err
+	}
+	repo := watch.IntoRepo(stream.Context(), s.reservationRepo)
+	if repo == nil {
+		return status.Error(codes.Unimplemented, "reservations can not be watched")
+	}
+
+	// Start watching before reading the current state, so no save is missed in between
+	updates := repo.Watch(stream.Context(), watch.MatchID(id))
+	version := 0
+	if r, err := s.findReservation(stream.Context(), id); err == nil {
+		if err := stream.Send(reservationToProto(r)); err != nil {
+			return err
+		}
+		version = r.Version
+	} else if status.Code(err) != codes.NotFound {
+		return err
+	}
+
+	for e := range updates {
+		r, ok := e.(*reservations.Reservation)
+		if !ok || r.Version <= version {
+			continue
+		}
+		if err := stream.Send(reservationToProto(r)); err != nil {
+			return err
+		}
+		version = r.Version
+	}
+	return nil
+}
+
+func (s *QueryServer) findReservation(ctx context.Context, id uuid.UUID) (*reservations.Reservation, error) {
+	e, err := s.reservationRepo.Find(ctx, id)
+	if errors.Is(err, eh.ErrEntityNotFound) {
+		return nil, status.Errorf(codes.NotFound, "reservation %v not found", id)
+	} else if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	r, ok := e.(*reservations.Reservation)
+	if !ok {
+		return nil, status.Error(codes.Internal, "incorrect entity type")
+	}
+	return r, nil
+}
+
+func parseID(id string) (uuid.UUID, error) {
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		return uuid.Nil, status.Errorf(codes.InvalidArgument, "invalid id %q: %v", id, err)
+	}
+	return parsed, nil
+}
+
+// optionalTime is the zero time for an unset timestamp, as AsTime would return the unix epoch
+func optionalTime(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return ts.AsTime()
+}
+
+var statuses = map[reservations.ReservationStatus]pb.ReservationStatus{
+	reservations.StatusPending:   pb.ReservationStatus_RESERVATION_STATUS_PENDING,
+	reservations.StatusDeclined:  pb.ReservationStatus_RESERVATION_STATUS_DECLINED,
+	reservations.StatusConfirmed: pb.ReservationStatus_RESERVATION_STATUS_CONFIRMED,
+	reservations.StatusCancelled: pb.ReservationStatus_RESERVATION_STATUS_CANCELLED,
+}
+
+// reservationStatus is the status of the reservation, a confirmed reservation goes on to be checked in and out,
+// or a no show, which the read-model keeps apart from its status
+func reservationStatus(r *reservations.Reservation) pb.ReservationStatus {
+	switch {
+	case r.Status != reservations.StatusConfirmed:
+		return statuses[r.Status]
+	case r.NoShow:
+		return pb.ReservationStatus_RESERVATION_STATUS_NO_SHOW
+	case !r.CheckedOut.IsZero():
+		return pb.ReservationStatus_RESERVATION_STATUS_CHECKED_OUT
+	case !r.CheckedIn.IsZero():
+		return pb.ReservationStatus_RESERVATION_STATUS_CHECKED_IN
+	}
+	return pb.ReservationStatus_RESERVATION_STATUS_CONFIRMED
+}
+
+func reservationToProto(r *reservations.Reservation) *pb.Reservation {
+	return &pb.Reservation{
+		Id:        r.ID.String(),
+		Version:   int32(r.Version),
+		Name:      r.Name,
+		Creator:   r.Creator,
+		RoomId:    int32(r.RoomID),
+		StartTime: timestamppb.New(r.StartTime),
+		EndTime:   timestamppb.New(r.EndTime),
+		Status:    reservationStatus(r),
+	}
+}
+
+func billingHistoryToProto(h *billing.BillingHistory) *pb.BillingHistory {
+	bills := make(map[string]*pb.Bill, len(h.Bills))
+	for month, b := range h.Bills {
+		bills[month] = &pb.Bill{
+			Id:          b.ID.String(),
+			Version:     int32(b.Version),
+			Minutes:     int32(b.Minutes),
+			Total:       float32(b.Total.Float64()),
+			TotalAmount: moneyToProto(b.Total),
+		}
+	}
+	return &pb.BillingHistory{
+		Id:              h.ID.String(),
+		Version:         int32(h.Version),
+		User:            h.User,
+		Bills:           bills,
+		TotalMinutes:    int32(h.TotalMinutes),
+		TotalPaid:       float32(h.TotalPaid.Float64()),
+		TotalPaidAmount: moneyToProto(h.TotalPaid),
+	}
+}
+
+func moneyToProto(m money.Money) *pb.Money {
+	return &pb.Money{
+		MinorUnits: m.Amount,
+		Currency:   m.Currency,
+	}
+}
@@ -0,0 +1,296 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/billing"
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	"github.com/MattDevy/CQRS-example/pkg/rpc/pb"
+	"github.com/MattDevy/CQRS-example/pkg/signing"
+	"github.com/MattDevy/CQRS-example/pkg/watch"
+	"github.com/google/uuid"
+	"github.com/looplab/eventhorizon/commandhandler/bus"
+	"github.com/looplab/eventhorizon/eventbus/local"
+	"github.com/looplab/eventhorizon/eventstore/memory"
+	memoryRepo "github.com/looplab/eventhorizon/repo/memory"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// testSecret is the secret commands must be signed with
+var testSecret = []byte("test-secret")
+
+// newTestConn serves both services over an in-memory connection, backed by in-memory stores
+// The connection signs commands with the signer, if it is not nil.
+func newTestConn(t *testing.T, signer *signing.Signer) *grpc.ClientConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	eventBus := local.NewEventBus()
+	store, err := memory.NewEventStore(memory.WithEventHandler(eventBus))
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventStore := reservations.NewVersionStore(store)
+	commandBus := bus.NewCommandHandler()
+	reservationRepo := watch.NewRepo(memoryRepo.NewRepo())
+	billingRepo := memoryRepo.NewRepo()
+	billing.Setup(ctx, eventStore, eventBus, commandBus, billingRepo, time.UTC, billing.DefaultPricingPolicy, billing.DefaultFeePolicy, billing.DefaultUsagePolicy)
+	reservations.Setup(ctx, eventStore, eventBus, commandBus, reservationRepo)
+
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	keys := signing.NewKeySet(time.Minute)
+	keys.AddHS256("test", testSecret)
+	Register(s, NewCommandServer(commandBus, keys), NewQueryServer(reservationRepo, billingRepo))
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	opts := []grpc.DialOption{
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure(),
+	}
+	if signer != nil {
+		opts = append(opts, grpc.WithUnaryInterceptor(SignCommands("test", signer)))
+	}
+	conn, err := grpc.DialContext(ctx, "bufnet", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestServices(t *testing.T) {
+	conn := newTestConn(t, signing.NewHS256Signer("test", testSecret))
+	commands := pb.NewReservationCommandServiceClient(conn)
+	queries := pb.NewReservationQueryServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	id := uuid.New().String()
+	start := time.Now().Add(time.Hour)
+	watchStream, err := queries.WatchReservation(ctx, &pb.WatchReservationRequest{Id: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := commands.CreateReservation(ctx, &pb.CreateReservationRequest{
+		Id:        id,
+		Name:      "Standup",
+		User:      "Matt",
+		RoomId:    3,
+		StartTime: timestamppb.New(start),
+		EndTime:   timestamppb.New(start.Add(time.Hour)),
+	})
+	if err != nil {
+		t.Fatalf("CreateReservation() error = %v", err)
+	}
+	if result.AggregateId != id || result.Version != 1 {
+		t.Errorf("CreateReservation() = %v, want version 1", result)
+	}
+
+	// The conflict saga confirms the reservation, which is streamed once projected
+	for {
+		r, err := watchStream.Recv()
+		if err != nil {
+			t.Fatalf("WatchReservation() error = %v", err)
+		}
+		if r.Status == pb.ReservationStatus_RESERVATION_STATUS_CONFIRMED {
+			break
+		}
+	}
+
+	r, err := queries.GetReservation(ctx, &pb.GetReservationRequest{Id: id})
+	if err != nil {
+		t.Fatalf("GetReservation() error = %v", err)
+	}
+	if r.Name != "Standup" || r.RoomId != 3 || !r.StartTime.AsTime().Equal(start) {
+		t.Errorf("GetReservation() = %v", r)
+	}
+
+	list, err := queries.ListReservations(ctx, &pb.ListReservationsRequest{})
+	if err != nil || len(list.Reservations) != 1 {
+		t.Errorf("ListReservations() = %v, %v, want 1 reservation", list, err)
+	}
+
+	// Rejected commands carry the domain error code
+	_, err = commands.ConfirmReservation(ctx, &pb.ConfirmReservationRequest{Id: id, User: "Matt"})
+	st := status.Convert(err)
+	if st.Code() != codes.FailedPrecondition {
+		t.Fatalf("ConfirmReservation() code = %v, want %v", st.Code(), codes.FailedPrecondition)
+	}
+	if len(st.Details()) != 1 || st.Details()[0].(*errdetails.ErrorInfo).Reason != "NotPending" {
+		t.Errorf("ConfirmReservation() details = %v, want NotPending", st.Details())
+	}
+
+	_, err = commands.ChangeReservationTime(ctx, &pb.ChangeReservationTimeRequest{
+		Id: id, User: "Matt", StartTime: timestamppb.New(start.Add(time.Hour)), EndTime: timestamppb.New(start),
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("ChangeReservationTime() backwards code = %v, want %v", status.Code(err), codes.InvalidArgument)
+	}
+	_, err = commands.CancelReservation(ctx, &pb.CancelReservationRequest{Id: id})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("CancelReservation() without user code = %v, want %v", status.Code(err), codes.InvalidArgument)
+	}
+	_, err = queries.GetReservation(ctx, &pb.GetReservationRequest{Id: uuid.New().String()})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("GetReservation() unknown code = %v, want %v", status.Code(err), codes.NotFound)
+	}
+
+	// Billing is projected asynchronously
+	for {
+		h, err := queries.GetBillingHistory(ctx, &pb.GetBillingHistoryRequest{User: "Matt"})
+		if err == nil && h.TotalMinutes == 60 {
+			break
+		} else if ctx.Err() != nil {
+			t.Fatalf("GetBillingHistory() = %v, %v, want 60 minutes", h, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCommandServer_CheckInOut(t *testing.T) {
+	conn := newTestConn(t, signing.NewHS256Signer("test", testSecret))
+	commands := pb.NewReservationCommandServiceClient(conn)
+	queries := pb.NewReservationQueryServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	id := uuid.New().String()
+	start := time.Now().Add(time.Hour)
+	watchStream, err := queries.WatchReservation(ctx, &pb.WatchReservationRequest{Id: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor := func(want pb.ReservationStatus) {
+		t.Helper()
+		for {
+			r, err := watchStream.Recv()
+			if err != nil {
+				t.Fatalf("WatchReservation() error = %v, waiting for %v", err, want)
+			}
+			if r.Status == want {
+				return
+			}
+		}
+	}
+
+	if _, err := commands.CreateReservation(ctx, &pb.CreateReservationRequest{
+		Id:            id,
+		Name:          "Standup",
+		User:          "Matt",
+		RoomId:        3,
+		StartTime:     timestamppb.New(start),
+		EndTime:       timestamppb.New(start.Add(time.Hour)),
+		PromotionCode: "SPRING",
+	}); err != nil {
+		t.Fatalf("CreateReservation() error = %v", err)
+	}
+	waitFor(pb.ReservationStatus_RESERVATION_STATUS_CONFIRMED)
+
+	_, err = commands.MarkNoShow(ctx, &pb.MarkNoShowRequest{Id: id, User: "Matt"})
+	if st := status.Convert(err); st.Code() != codes.FailedPrecondition || len(st.Details()) != 1 ||
+		st.Details()[0].(*errdetails.ErrorInfo).Reason != "NotEnded" {
+		t.Errorf("MarkNoShow() before the end = %v, want NotEnded", err)
+	}
+
+	if _, err := commands.CheckIn(ctx, &pb.CheckInRequest{Id: id, User: "Matt", At: timestamppb.New(start)}); err != nil {
+		t.Fatalf("CheckIn() error = %v", err)
+	}
+	waitFor(pb.ReservationStatus_RESERVATION_STATUS_CHECKED_IN)
+
+	// Without a time the check out is now, which is before the check in
+	_, err = commands.CheckOut(ctx, &pb.CheckOutRequest{Id: id, User: "Matt"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("CheckOut() before the check in code = %v, want %v", status.Code(err), codes.InvalidArgument)
+	}
+	result, err := commands.CheckOut(ctx, &pb.CheckOutRequest{Id: id, User: "Matt", At: timestamppb.New(start.Add(time.Hour))})
+	if err != nil {
+		t.Fatalf("CheckOut() error = %v", err)
+	}
+	if result.AggregateId != id || result.Version != 4 {
+		t.Errorf("CheckOut() = %v, want version 4", result)
+	}
+	waitFor(pb.ReservationStatus_RESERVATION_STATUS_CHECKED_OUT)
+}
+
+func TestReservationStatus(t *testing.T) {
+	at := time.Date(2021, time.July, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		r    reservations.Reservation
+		want pb.ReservationStatus
+	}{
+		{"pending", reservations.Reservation{Status: reservations.StatusPending}, pb.ReservationStatus_RESERVATION_STATUS_PENDING},
+		{"confirmed", reservations.Reservation{Status: reservations.StatusConfirmed}, pb.ReservationStatus_RESERVATION_STATUS_CONFIRMED},
+		{"checked in", reservations.Reservation{Status: reservations.StatusConfirmed, CheckedIn: at}, pb.ReservationStatus_RESERVATION_STATUS_CHECKED_IN},
+		{"checked out", reservations.Reservation{Status: reservations.StatusConfirmed, CheckedIn: at, CheckedOut: at.Add(time.Hour)}, pb.ReservationStatus_RESERVATION_STATUS_CHECKED_OUT},
+		{"no show", reservations.Reservation{Status: reservations.StatusConfirmed, NoShow: true}, pb.ReservationStatus_RESERVATION_STATUS_NO_SHOW},
+		{"cancelled", reservations.Reservation{Status: reservations.StatusCancelled}, pb.ReservationStatus_RESERVATION_STATUS_CANCELLED},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := reservationToProto(&tt.r).Status; got != tt.want {
+				t.Errorf("status = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandServer_Unauthenticated(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	start := time.Now().Add(time.Hour)
+	req := &pb.CreateReservationRequest{
+		Id:        uuid.New().String(),
+		Name:      "Standup",
+		User:      "Matt",
+		RoomId:    3,
+		StartTime: timestamppb.New(start),
+		EndTime:   timestamppb.New(start.Add(time.Hour)),
+	}
+
+	tests := []struct {
+		name   string
+		signer *signing.Signer
+	}{
+		{"unsigned", nil},
+		{"untrusted key", signing.NewHS256Signer("test", []byte("another-secret"))},
+		{"unknown key", signing.NewHS256Signer("other", testSecret)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commands := pb.NewReservationCommandServiceClient(newTestConn(t, tt.signer))
+			_, err := commands.CreateReservation(ctx, req)
+			if status.Code(err) != codes.Unauthenticated {
+				t.Errorf("CreateReservation() code = %v, want %v", status.Code(err), codes.Unauthenticated)
+			}
+		})
+	}
+
+	// A token is only good for the request it was signed for
+	signer := signing.NewHS256Signer("test", testSecret)
+	other := proto.Clone(req).(*pb.CreateReservationRequest)
+	other.RoomId = 4
+	data, _ := commandPayload(other)
+	authorization, err := reservations.BearerToken(signer, "test", "CreateReservation", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands := pb.NewReservationCommandServiceClient(newTestConn(t, nil))
+	_, err = commands.CreateReservation(metadata.AppendToOutgoingContext(ctx, AuthorizationKey, authorization), req)
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("CreateReservation() signed for another request code = %v, want %v", status.Code(err), codes.Unauthenticated)
+	}
+}
@@ -0,0 +1,56 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	"github.com/MattDevy/CQRS-example/pkg/signing"
+	eh "github.com/looplab/eventhorizon"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// AuthorizationKey is the metadata key commands are signed in, with a Bearer JWT like the HTTP gateway
+// The JWT's command type is the name of the RPC, and its digest is of the deterministically marshalled request.
+const AuthorizationKey = "authorization"
+
+// SignCommands returns a client interceptor signing every command sent to the ReservationCommandService,
+// subject is the identity the commands are sent on behalf of
+func SignCommands(subject string, signer *signing.Signer) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		msg, ok := req.(proto.Message)
+		if !ok {
+			return fmt.Errorf("could not sign %v: request is not a protobuf message", method)
+		}
+		data, err := commandPayload(msg)
+		if err != nil {
+			return err
+		}
+		authorization, err := reservations.BearerToken(signer, subject, path.Base(method), data)
+		if err != nil {
+			return err
+		}
+		return invoker(metadata.AppendToOutgoingContext(ctx, AuthorizationKey, authorization), method, req, reply, cc, opts...)
+	}
+}
+
+// verify checks the call's Bearer token was signed by one of the keys for exactly this request
+func verify(ctx context.Context, keys *signing.KeySet, commandType eh.CommandType, req proto.Message) error {
+	var authorization string
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get(AuthorizationKey)) > 0 {
+		authorization = md.Get(AuthorizationKey)[0]
+	}
+	data, err := commandPayload(req)
+	if err != nil {
+		return err
+	}
+	return reservations.VerifyBearer(keys, authorization, string(commandType), data)
+}
+
+// commandPayload is the request as signed, marshalled deterministically so both sides get the same bytes
+func commandPayload(req proto.Message) ([]byte, error) {
+	return proto.MarshalOptions{Deterministic: true}.Marshal(req)
+}
@@ -0,0 +1,253 @@
+package signing
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Supported JWT signing algorithms
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+)
+
+// MaxClockSkew is how far in the future a token can be issued, to allow for the signer's clock being ahead
+const MaxClockSkew = time.Minute
+
+var (
+	// ErrMalformedToken is returned when a token is not a valid compact JWT
+	ErrMalformedToken = errors.New("signing: malformed token")
+	// ErrUnknownKey is returned when the token's key ID is not in the key set
+	ErrUnknownKey = errors.New("signing: unknown key")
+	// ErrInvalidSignature is returned when the token signature does not verify
+	ErrInvalidSignature = errors.New("signing: invalid signature")
+	// ErrDigestMismatch is returned when the signed digest does not match the payload
+	ErrDigestMismatch = errors.New("signing: digest mismatch")
+	// ErrTokenExpired is returned when the token was issued outside of the allowed window
+	ErrTokenExpired = errors.New("signing: token expired")
+	// ErrTokenNotYetValid is returned when the token was issued further in the future than MaxClockSkew
+	ErrTokenNotYetValid = errors.New("signing: token issued in the future")
+)
+
+// Claims are the claims carried by a signed command envelope
+type Claims struct {
+	Subject     string `json:"sub"`
+	IssuedAt    int64  `json:"iat"`
+	CommandType string `json:"cmd"`
+	Digest      string `json:"cmd_digest"`
+	// ReplyTo and CorrelationID are where the result of the command is sent, so they can't be redirected
+	ReplyTo       string `json:"reply_to,omitempty"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+var encoding = base64.RawURLEncoding
+
+// Digest returns the base64url encoded SHA-256 digest of data, this is the value stored in Claims.Digest
+func Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return encoding.EncodeToString(sum[:])
+}
+
+// Signer signs Claims into a compact JWT using a single key
+type Signer struct {
+	keyID     string
+	algorithm string
+	secret    []byte
+	rsaKey    *rsa.PrivateKey
+	edKey     ed25519.PrivateKey
+}
+
+// NewHS256Signer returns a Signer using HMAC SHA-256 with the shared secret
+func NewHS256Signer(keyID string, secret []byte) *Signer {
+	return &Signer{keyID: keyID, algorithm: HS256, secret: secret}
+}
+
+// NewRS256Signer returns a Signer using RSASSA-PKCS1-v1_5 SHA-256 with the private key
+func NewRS256Signer(keyID string, key *rsa.PrivateKey) *Signer {
+	return &Signer{keyID: keyID, algorithm: RS256, rsaKey: key}
+}
+
+// NewEd25519Signer returns a Signer using EdDSA with the Ed25519 private key
+func NewEd25519Signer(keyID string, key ed25519.PrivateKey) *Signer {
+	return &Signer{keyID: keyID, algorithm: EdDSA, edKey: key}
+}
+
+// Sign returns the claims as a signed compact JWT
+func (s *Signer) Sign(claims Claims) (string, error) {
+	h, err := json.Marshal(header{Algorithm: s.algorithm, Type: "JWT", KeyID: s.keyID})
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := encoding.EncodeToString(h) + "." + encoding.EncodeToString(c)
+
+	var sig []byte
+	switch s.algorithm {
+	case HS256:
+		mac := hmac.New(sha256.New, s.secret)
+		mac.Write([]byte(signingInput))
+		sig = mac.Sum(nil)
+	case RS256:
+		sum := sha256.Sum256([]byte(signingInput))
+		sig, err = rsa.SignPKCS1v15(rand.Reader, s.rsaKey, crypto.SHA256, sum[:])
+		if err != nil {
+			return "", err
+		}
+	case EdDSA:
+		sig = ed25519.Sign(s.edKey, []byte(signingInput))
+	default:
+		return "", fmt.Errorf("signing: unsupported algorithm %q", s.algorithm)
+	}
+
+	return signingInput + "." + encoding.EncodeToString(sig), nil
+}
+
+type verificationKey struct {
+	algorithm string
+	secret    []byte
+	rsaKey    *rsa.PublicKey
+	edKey     ed25519.PublicKey
+}
+
+// KeySet holds the keys trusted to sign command envelopes, indexed by key ID
+type KeySet struct {
+	keys   map[string]verificationKey
+	keysMu sync.RWMutex
+	maxAge time.Duration
+}
+
+// NewKeySet returns an empty KeySet, tokens older than maxAge, or issued more than MaxClockSkew in the future,
+// are rejected (zero disables both checks)
+func NewKeySet(maxAge time.Duration) *KeySet {
+	return &KeySet{
+		keys:   make(map[string]verificationKey),
+		maxAge: maxAge,
+	}
+}
+
+// AddHS256 trusts the shared secret for the key ID
+func (k *KeySet) AddHS256(keyID string, secret []byte) {
+	k.add(keyID, verificationKey{algorithm: HS256, secret: secret})
+}
+
+// AddRS256 trusts the RSA public key for the key ID
+func (k *KeySet) AddRS256(keyID string, key *rsa.PublicKey) {
+	k.add(keyID, verificationKey{algorithm: RS256, rsaKey: key})
+}
+
+// AddEd25519 trusts the Ed25519 public key for the key ID
+func (k *KeySet) AddEd25519(keyID string, key ed25519.PublicKey) {
+	k.add(keyID, verificationKey{algorithm: EdDSA, edKey: key})
+}
+
+func (k *KeySet) add(keyID string, key verificationKey) {
+	k.keysMu.Lock()
+	defer k.keysMu.Unlock()
+	k.keys[keyID] = key
+}
+
+// Verify checks the token signature against the key set and returns its claims
+func (k *KeySet) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformedToken
+	}
+
+	rawHeader, err := encoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+	var h header
+	if err := json.Unmarshal(rawHeader, &h); err != nil {
+		return nil, ErrMalformedToken
+	}
+	sig, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+
+	k.keysMu.RLock()
+	key, ok := k.keys[h.KeyID]
+	k.keysMu.RUnlock()
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	// Never let the token pick the algorithm for a key
+	if key.algorithm != h.Algorithm {
+		return nil, ErrInvalidSignature
+	}
+
+	signingInput := []byte(parts[0] + "." + parts[1])
+	switch key.algorithm {
+	case HS256:
+		mac := hmac.New(sha256.New, key.secret)
+		mac.Write(signingInput)
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return nil, ErrInvalidSignature
+		}
+	case RS256:
+		sum := sha256.Sum256(signingInput)
+		if err := rsa.VerifyPKCS1v15(key.rsaKey, crypto.SHA256, sum[:], sig); err != nil {
+			return nil, ErrInvalidSignature
+		}
+	case EdDSA:
+		if !ed25519.Verify(key.edKey, signingInput, sig) {
+			return nil, ErrInvalidSignature
+		}
+	}
+
+	rawClaims, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+	var claims Claims
+	if err := json.Unmarshal(rawClaims, &claims); err != nil {
+		return nil, ErrMalformedToken
+	}
+
+	if k.maxAge > 0 {
+		age := time.Since(time.Unix(claims.IssuedAt, 0))
+		if age > k.maxAge {
+			return nil, ErrTokenExpired
+		}
+		// A token issued in the future would stay valid for longer than maxAge
+		if age < -MaxClockSkew {
+			return nil, ErrTokenNotYetValid
+		}
+	}
+
+	return &claims, nil
+}
+
+// VerifyPayload verifies the token and checks that it was issued for the command type and data
+func (k *KeySet) VerifyPayload(token, commandType string, data []byte) (*Claims, error) {
+	claims, err := k.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	if claims.CommandType != commandType || claims.Digest != Digest(data) {
+		return nil, ErrDigestMismatch
+	}
+	return claims, nil
+}
@@ -0,0 +1,157 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeySet_VerifyPayload(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := NewKeySet(time.Minute)
+	keys.AddHS256("hs", []byte("secret"))
+	keys.AddRS256("rs", &rsaKey.PublicKey)
+	keys.AddEd25519("ed", edPub)
+
+	data := []byte(`{"ID":"1"}`)
+	claims := Claims{
+		Subject:     "writer",
+		IssuedAt:    time.Now().Unix(),
+		CommandType: "CreateReservation",
+		Digest:      Digest(data),
+	}
+	oldClaims := claims
+	oldClaims.IssuedAt = time.Now().Add(-time.Hour).Unix()
+	futureClaims := claims
+	futureClaims.IssuedAt = time.Now().Add(time.Hour).Unix()
+	skewedClaims := claims
+	skewedClaims.IssuedAt = time.Now().Add(MaxClockSkew / 2).Unix()
+
+	tests := []struct {
+		name    string
+		signer  *Signer
+		claims  Claims
+		data    []byte
+		tamper  func(string) string
+		wantErr error
+	}{
+		{
+			name:   "HS256",
+			signer: NewHS256Signer("hs", []byte("secret")),
+			claims: claims,
+			data:   data,
+		},
+		{
+			name:   "RS256",
+			signer: NewRS256Signer("rs", rsaKey),
+			claims: claims,
+			data:   data,
+		},
+		{
+			name:   "EdDSA",
+			signer: NewEd25519Signer("ed", edKey),
+			claims: claims,
+			data:   data,
+		},
+		{
+			name:    "wrong secret",
+			signer:  NewHS256Signer("hs", []byte("guess")),
+			claims:  claims,
+			data:    data,
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:    "unknown key",
+			signer:  NewHS256Signer("other", []byte("secret")),
+			claims:  claims,
+			data:    data,
+			wantErr: ErrUnknownKey,
+		},
+		{
+			name:    "algorithm confusion",
+			signer:  NewHS256Signer("ed", []byte("secret")),
+			claims:  claims,
+			data:    data,
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:    "tampered data",
+			signer:  NewEd25519Signer("ed", edKey),
+			claims:  claims,
+			data:    []byte(`{"ID":"2"}`),
+			wantErr: ErrDigestMismatch,
+		},
+		{
+			name:   "tampered claims",
+			signer: NewHS256Signer("hs", []byte("secret")),
+			claims: claims,
+			data:   data,
+			tamper: func(token string) string {
+				parts := strings.Split(token, ".")
+				parts[1] = encoding.EncodeToString([]byte(`{"sub":"admin"}`))
+				return strings.Join(parts, ".")
+			},
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:    "expired",
+			signer:  NewHS256Signer("hs", []byte("secret")),
+			claims:  oldClaims,
+			data:    data,
+			wantErr: ErrTokenExpired,
+		},
+		{
+			name:    "issued in the future",
+			signer:  NewHS256Signer("hs", []byte("secret")),
+			claims:  futureClaims,
+			data:    data,
+			wantErr: ErrTokenNotYetValid,
+		},
+		{
+			name:   "signer's clock ahead",
+			signer: NewHS256Signer("hs", []byte("secret")),
+			claims: skewedClaims,
+			data:   data,
+		},
+		{
+			name:   "malformed",
+			signer: NewHS256Signer("hs", []byte("secret")),
+			claims: claims,
+			data:   data,
+			tamper: func(token string) string {
+				return "not-a-token"
+			},
+			wantErr: ErrMalformedToken,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := tt.signer.Sign(tt.claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.tamper != nil {
+				token = tt.tamper(token)
+			}
+			got, err := keys.VerifyPayload(token, "CreateReservation", tt.data)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("VerifyPayload() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && got.Subject != "writer" {
+				t.Errorf("VerifyPayload() subject = %v, want writer", got.Subject)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package signing
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+)
+
+// DevSecret is the well-known secret used in place of unset secrets in development, it must never be used in production
+const DevSecret = "insecure-dev-secret"
+
+// ErrNoSecret is returned when a secret is not set and the development secret isn't allowed
+var ErrNoSecret = errors.New("signing: secret not set")
+
+// SecretFromEnv returns the secret set in the environment variable
+// When it is unset DevSecret is returned, with a warning, only if dev is true.
+func SecretFromEnv(name string, dev bool) ([]byte, error) {
+	if secret := os.Getenv(name); secret != "" {
+		return []byte(secret), nil
+	}
+	if !dev {
+		return nil, fmt.Errorf("%w: set %v, or run with -dev to use the insecure development secret", ErrNoSecret, name)
+	}
+	log.Printf("Warning: %v is not set, using the insecure development secret", name)
+	return []byte(DevSecret), nil
+}
@@ -0,0 +1,33 @@
+package signing
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestSecretFromEnv(t *testing.T) {
+	const name = "SIGNING_TEST_SECRET"
+	tests := []struct {
+		name    string
+		value   string
+		dev     bool
+		want    string
+		wantErr error
+	}{
+		{"set", "s3cret", false, "s3cret", nil},
+		{"set in dev", "s3cret", true, "s3cret", nil},
+		{"unset", "", false, "", ErrNoSecret},
+		{"unset in dev", "", true, DevSecret, nil},
+	}
+	defer os.Unsetenv(name)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv(name, tt.value)
+			got, err := SecretFromEnv(name, tt.dev)
+			if !errors.Is(err, tt.wantErr) || string(got) != tt.want {
+				t.Errorf("SecretFromEnv() = %q, %v, want %q, %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package temporal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// Path + "reservations/{id}" gets a reservation as it was, Path + "rooms/{id}" gets a room's schedule as it was
+const Path = "/asof/"
+
+// Handler serves the temporal queries, the live read models are never written to
+// Reservations take at (RFC 3339) and/or version, rooms take at and optionally from and to
+func Handler(eventStore eh.EventStore, reservationRepo eh.ReadRepo) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "unsupported method: "+r.Method, http.StatusMethodNotAllowed)
+			return
+		}
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, Path), "/")
+		if len(parts) != 2 {
+			http.NotFound(w, r)
+			return
+		}
+		at, err := parsePoint(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var resp interface{}
+		switch parts[0] {
+		case "reservations":
+			id, err := uuid.Parse(parts[1])
+			if err != nil {
+				http.Error(w, "invalid reservation id: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if at.Time.IsZero() && at.Version == 0 {
+				http.Error(w, "at or version is required", http.StatusBadRequest)
+				return
+			}
+			resp, err = Reservation(r.Context(), eventStore, id, at)
+			if errors.Is(err, eh.ErrEntityNotFound) {
+				http.Error(w, fmt.Sprintf("reservation %v did not exist yet", id), http.StatusNotFound)
+				return
+			} else if err != nil {
+				writeError(w, err)
+				return
+			}
+		case "rooms":
+			roomID, err := strconv.Atoi(parts[1])
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid room %q", parts[1]), http.StatusBadRequest)
+				return
+			}
+			if at.Time.IsZero() || at.Version != 0 {
+				http.Error(w, "at is required, and version is only for reservations", http.StatusBadRequest)
+				return
+			}
+			from, to, err := parseWindow(r.URL.Query())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if resp, err = RoomSchedule(r.Context(), eventStore, reservationRepo, roomID, at.Time, from, to); err != nil {
+				writeError(w, err)
+				return
+			}
+		default:
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			fmt.Printf("Could not write response: %v\n", err)
+		}
+	})
+}
+
+func parsePoint(values url.Values) (Point, error) {
+	var at Point
+	var err error
+	if t := values.Get("at"); t != "" {
+		if at.Time, err = time.Parse(time.RFC3339, t); err != nil {
+			return at, fmt.Errorf("invalid at %q, expected RFC 3339", t)
+		}
+	}
+	if v := values.Get("version"); v != "" {
+		if at.Version, err = strconv.Atoi(v); err != nil || at.Version < 1 {
+			return at, fmt.Errorf("invalid version %q", v)
+		}
+	}
+	return at, nil
+}
+
+func parseWindow(values url.Values) (time.Time, time.Time, error) {
+	var from, to time.Time
+	var err error
+	if f := values.Get("from"); f != "" {
+		if from, err = time.Parse(time.RFC3339, f); err != nil {
+			return from, to, fmt.Errorf("invalid from %q, expected RFC 3339", f)
+		}
+	}
+	if t := values.Get("to"); t != "" {
+		if to, err = time.Parse(time.RFC3339, t); err != nil {
+			return from, to, fmt.Errorf("invalid to %q, expected RFC 3339", t)
+		}
+	}
+	return from, to, nil
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	fmt.Printf("Error: %v\n", err)
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
@@ -0,0 +1,97 @@
+package temporal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// Point is a point in a reservation's history, events after either bound are left out
+// A zero Time or Version is unbounded
+type Point struct {
+	Time    time.Time
+	Version int
+}
+
+// includes returns true if the event happened at or before the point
+func (p Point) includes(event eh.Event) bool {
+	return (p.Time.IsZero() || !event.Timestamp().After(p.Time)) &&
+		(p.Version == 0 || event.Version() <= p.Version)
+}
+
+// Events loads the aggregate's events up to the point
+func Events(ctx context.Context, eventStore eh.EventStore, id uuid.UUID, at Point) ([]eh.Event, error) {
+	events, err := eventStore.Load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	var until []eh.Event
+	for _, event := range events {
+		if !at.includes(event) {
+			break
+		}
+		until = append(until, event)
+	}
+	return until, nil
+}
+
+// Reservation rebuilds the reservation as it was at the point, with the same projector as the read model
+// Returns eh.ErrEntityNotFound if the reservation did not exist yet
+func Reservation(ctx context.Context, eventStore eh.EventStore, id uuid.UUID, at Point) (*reservations.Reservation, error) {
+	events, err := Events(ctx, eventStore, id, at)
+	if err != nil {
+		return nil, err
+	}
+	if len(events) == 0 {
+		return nil, eh.ErrEntityNotFound
+	}
+
+	projector := reservations.NewReservationProjector()
+	var entity eh.Entity = &reservations.Reservation{}
+	for _, event := range events {
+		if entity, err = projector.Project(ctx, event, entity); err != nil {
+			return nil, fmt.Errorf("could not project version %d: %w", event.Version(), err)
+		}
+	}
+	return entity.(*reservations.Reservation), nil
+}
+
+// RoomSchedule rebuilds the room's reservations overlapping [from, to) as they were at the time
+// The reservations are found through reservationRepo, which is only read, as a reservation never changes room.
+// Zero from or to are unbounded.
+func RoomSchedule(ctx context.Context, eventStore eh.EventStore, reservationRepo eh.ReadRepo, roomID int, at time.Time, from, to time.Time) ([]*reservations.Reservation, error) {
+	entities, err := reservationRepo.FindAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var schedule []*reservations.Reservation
+	for _, e := range entities {
+		current, ok := e.(*reservations.Reservation)
+		if !ok || current.RoomID != roomID {
+			continue
+		}
+		r, err := Reservation(ctx, eventStore, current.ID, Point{Time: at})
+		if errors.Is(err, eh.ErrEntityNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if (from.IsZero() || r.EndTime.After(from)) && (to.IsZero() || r.StartTime.Before(to)) {
+			schedule = append(schedule, r)
+		}
+	}
+	sort.Slice(schedule, func(i, j int) bool {
+		if !schedule[i].StartTime.Equal(schedule[j].StartTime) {
+			return schedule[i].StartTime.Before(schedule[j].StartTime)
+		}
+		return schedule[i].ID.String() < schedule[j].ID.String()
+	})
+	return schedule, nil
+}
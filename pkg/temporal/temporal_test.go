@@ -0,0 +1,151 @@
+package temporal
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/MattDevy/CQRS-example/pkg/reservations"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/eventstore/memory"
+	memoryRepo "github.com/looplab/eventhorizon/repo/memory"
+)
+
+var start = time.Date(2021, time.July, 1, 9, 0, 0, 0, time.UTC)
+
+// at returns the time minutes after the first event
+func at(minutes int) time.Time {
+	return start.Add(-24 * time.Hour).Add(time.Duration(minutes) * time.Minute)
+}
+
+type testEvent struct {
+	minutes   int
+	eventType eh.EventType
+	data      eh.EventData
+}
+
+// newTestStore saves the events of each reservation, and its current state in the read model
+func newTestStore(t *testing.T, histories map[uuid.UUID][]testEvent) (eh.EventStore, eh.ReadWriteRepo) {
+	ctx := context.Background()
+	eventStore, err := memory.NewEventStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := memoryRepo.NewRepo()
+	repo.SetEntityFactory(func() eh.Entity { return &reservations.Reservation{} })
+	for id, history := range histories {
+		var events []eh.Event
+		for i, e := range history {
+			events = append(events, eh.NewEvent(e.eventType, e.data, at(e.minutes), eh.ForAggregate(reservations.ReservationAggregateType, id, i+1)))
+		}
+		if err := eventStore.Save(ctx, events, 0); err != nil {
+			t.Fatal(err)
+		}
+		r, err := Reservation(ctx, eventStore, id, Point{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.Save(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return eventStore, repo
+}
+
+func created(roomID int, offset time.Duration) *reservations.ReservationCreatedData {
+	return &reservations.ReservationCreatedData{RoomID: roomID, Name: "Meeting", User: "Matt", StartTime: start.Add(offset), EndTime: start.Add(offset + time.Hour)}
+}
+
+func TestReservation(t *testing.T) {
+	id := uuid.New()
+	eventStore, _ := newTestStore(t, map[uuid.UUID][]testEvent{id: {
+		{0, reservations.ReservationCreatedEvent, created(1, 0)},
+		{1, reservations.ReservationConfirmedEvent, &reservations.ReservationConfirmedData{User: "saga"}},
+		{10, reservations.ReservationTimeChangedEvent, &reservations.ReservationTimeChangeData{User: "Matt", StartTime: start.Add(2 * time.Hour), EndTime: start.Add(3 * time.Hour)}},
+		{20, reservations.ReservationCancelledEvent, &reservations.ReservationCancelledData{User: "Matt"}},
+	}})
+
+	tests := []struct {
+		name    string
+		at      Point
+		version int
+		status  reservations.ReservationStatus
+		start   time.Time
+	}{
+		{"at creation", Point{Time: at(0)}, 1, reservations.StatusPending, start},
+		{"between events", Point{Time: at(5)}, 2, reservations.StatusConfirmed, start},
+		{"after the move", Point{Time: at(15)}, 3, reservations.StatusPending, start.Add(2 * time.Hour)},
+		{"version", Point{Version: 2}, 2, reservations.StatusConfirmed, start},
+		{"earliest of both", Point{Time: at(30), Version: 3}, 3, reservations.StatusPending, start.Add(2 * time.Hour)},
+		{"now", Point{}, 4, reservations.StatusCancelled, start.Add(2 * time.Hour)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := Reservation(context.Background(), eventStore, id, tt.at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Version != tt.version || r.Status != tt.status || !r.StartTime.Equal(tt.start) {
+				t.Errorf("Reservation() = version %d %v %v, want version %d %v %v", r.Version, r.Status, r.StartTime, tt.version, tt.status, tt.start)
+			}
+		})
+	}
+
+	if _, err := Reservation(context.Background(), eventStore, id, Point{Time: at(-1)}); err != eh.ErrEntityNotFound {
+		t.Errorf("Reservation() before creation error = %v, want %v", err, eh.ErrEntityNotFound)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	early, late, other := uuid.New(), uuid.New(), uuid.New()
+	eventStore, repo := newTestStore(t, map[uuid.UUID][]testEvent{
+		early: {
+			{0, reservations.ReservationCreatedEvent, created(1, 0)},
+			{20, reservations.ReservationCancelledEvent, &reservations.ReservationCancelledData{User: "Matt"}},
+		},
+		late:  {{10, reservations.ReservationCreatedEvent, created(1, 2*time.Hour)}},
+		other: {{0, reservations.ReservationCreatedEvent, created(2, 0)}},
+	})
+	srv := httptest.NewServer(Handler(eventStore, repo))
+	defer srv.Close()
+
+	var schedule []*reservations.Reservation
+	get(t, srv.URL+Path+"rooms/1?at="+at(15).Format(time.RFC3339), http.StatusOK, &schedule)
+	if len(schedule) != 2 || schedule[0].ID != early || schedule[0].Status != reservations.StatusPending || schedule[1].ID != late {
+		t.Errorf("GET room schedule = %+v", schedule)
+	}
+	get(t, srv.URL+Path+"rooms/1?at="+at(5).Format(time.RFC3339)+"&from="+start.Add(time.Hour).Format(time.RFC3339), http.StatusOK, &schedule)
+	if len(schedule) != 0 {
+		t.Errorf("GET room schedule outside window = %+v", schedule)
+	}
+
+	r := &reservations.Reservation{}
+	get(t, srv.URL+Path+"reservations/"+early.String()+"?version=1", http.StatusOK, r)
+	if r.ID != early || r.Status != reservations.StatusPending {
+		t.Errorf("GET reservation = %+v", r)
+	}
+	get(t, srv.URL+Path+"reservations/"+late.String()+"?at="+at(5).Format(time.RFC3339), http.StatusNotFound, nil)
+	get(t, srv.URL+Path+"reservations/"+early.String(), http.StatusBadRequest, nil)
+	get(t, srv.URL+Path+"rooms/1?version=1", http.StatusBadRequest, nil)
+}
+
+func get(t *testing.T, url string, want int, v interface{}) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != want {
+		t.Fatalf("GET %v = %v, want %v", url, resp.StatusCode, want)
+	}
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package watch
+
+import (
+	"context"
+	"sync"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// Repo is a read-write repo that notifies watchers every time an entity is saved,
+// so readers can be pushed changes once the projector has saved them
+type Repo struct {
+	eh.ReadWriteRepo
+
+	watchers   map[*watcher]struct{}
+	watchersMu sync.RWMutex
+}
+
+type watcher struct {
+	filter   func(eh.Entity) bool
+	entities chan eh.Entity
+}
+
+var _ = eh.ReadWriteRepo(&Repo{})
+
+// NewRepo wraps the repo so saves can be watched
+func NewRepo(repo eh.ReadWriteRepo) *Repo {
+	return &Repo{
+		ReadWriteRepo: repo,
+		watchers:      make(map[*watcher]struct{}),
+	}
+}
+
+// InnerRepo implements the InnerRepo method of the eventhorizon.ReadRepo interface.
+func (r *Repo) InnerRepo(ctx context.Context) eh.ReadRepo {
+	return r.ReadWriteRepo
+}
+
+// IntoRepo tries to convert a eh.ReadRepo into a Repo by recursively looking at
+// inner repos. Returns nil if none was found.
+func IntoRepo(ctx context.Context, repo eh.ReadRepo) *Repo {
+	if repo == nil {
+		return nil
+	}
+	if r, ok := repo.(*Repo); ok {
+		return r
+	}
+	return IntoRepo(ctx, repo.InnerRepo(ctx))
+}
+
+// Save saves the entity and then notifies the watchers
+func (r *Repo) Save(ctx context.Context, entity eh.Entity) error {
+	if err := r.ReadWriteRepo.Save(ctx, entity); err != nil {
+		return err
+	}
+
+	r.watchersMu.RLock()
+	defer r.watchersMu.RUnlock()
+	for w := range r.watchers {
+		if w.filter != nil && !w.filter(entity) {
+			continue
+		}
+		// Never block the projector on a slow watcher, it will see the next save
+		select {
+		case w.entities <- entity:
+		default:
+		}
+	}
+	return nil
+}
+
+// Watch returns a channel of every saved entity matching filter (nil matches all)
+// The channel is closed once ctx is done
+func (r *Repo) Watch(ctx context.Context, filter func(eh.Entity) bool) <-chan eh.Entity {
+	w := &watcher{
+		filter:   filter,
+		entities: make(chan eh.Entity, 16),
+	}
+	r.watchersMu.Lock()
+	r.watchers[w] = struct{}{}
+	r.watchersMu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		r.watchersMu.Lock()
+		delete(r.watchers, w)
+		close(w.entities)
+		r.watchersMu.Unlock()
+	}()
+	return w.entities
+}
+
+// MatchID returns a Watch filter for a single entity
+func MatchID(id uuid.UUID) func(eh.Entity) bool {
+	return func(e eh.Entity) bool {
+		return e.EntityID() == id
+	}
+}